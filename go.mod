@@ -12,6 +12,7 @@ require (
 	github.com/diamondburned/gotrix v0.1.2-0.20220411211558-ddbed452e46f
 	github.com/dustin/go-humanize v1.0.0
 	github.com/enescakir/emoji v1.0.0
+	github.com/godbus/dbus/v5 v5.0.3
 	github.com/pkg/errors v0.9.1
 	github.com/sahilm/fuzzy v0.1.0
 	github.com/yuin/goldmark v1.4.0
@@ -43,7 +44,6 @@ require (
 	github.com/danwakefield/fnmatch v0.0.0-20160403171240-cbb64ac3d964 // indirect
 	github.com/dlclark/regexp2 v1.4.0 // indirect
 	github.com/fatih/color v1.10.0 // indirect
-	github.com/godbus/dbus/v5 v5.0.3 // indirect
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/google/btree v1.0.1 // indirect
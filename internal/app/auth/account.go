@@ -150,3 +150,90 @@ func listAccountIDs(driver secret.Driver) ([]matrix.UserID, error) {
 
 	return ids, nil
 }
+
+// moveAccounts moves all accounts and their tokens from one secret driver to
+// another. Accounts that are already in the destination are kept. The secrets
+// are found using the list of accounts rather than by listing the driver's
+// keys, since some drivers can't list keys that were stored by older versions.
+func moveAccounts(from, to secret.Driver) error {
+	fromIDs, err := listAccountIDs(from)
+	if err != nil {
+		return err
+	}
+
+	toIDs, err := listAccountIDs(to)
+	if err != nil {
+		return err
+	}
+
+	ids := toIDs
+
+	// Copy everything before deleting anything, so no account is lost if
+	// copying fails halfway.
+addLoop:
+	for _, id := range fromIDs {
+		k := "account:" + string(id)
+
+		b, err := from.Get(k)
+		if err != nil {
+			if errors.Is(err, secret.ErrNotFound) {
+				continue
+			}
+			return errors.Wrapf(err, "failed to get account %s", id)
+		}
+
+		if err := to.Set(k, b); err != nil {
+			return errors.Wrapf(err, "failed to set account %s", id)
+		}
+
+		for _, existing := range ids {
+			if existing == id {
+				continue addLoop
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	if err := saveAccountIDs(to, ids); err != nil {
+		return errors.Wrap(err, "failed to save account IDs")
+	}
+
+	for _, id := range fromIDs {
+		err := from.Delete("account:" + string(id))
+		if err != nil && !errors.Is(err, secret.ErrNotFound) {
+			return errors.Wrapf(err, "failed to delete account %s", id)
+		}
+	}
+
+	if err := from.Delete("accounts"); err != nil && !errors.Is(err, secret.ErrNotFound) {
+		return errors.Wrap(err, "failed to delete account IDs")
+	}
+
+	return nil
+}
+
+// removeAccount removes the account from the driver's list of accounts and
+// erases its token.
+func removeAccount(driver secret.Driver, userID matrix.UserID) error {
+	accIDs, err := listAccountIDs(driver)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range accIDs {
+		if id == userID {
+			accIDs = append(accIDs[:i], accIDs[i+1:]...)
+			break
+		}
+	}
+
+	if err := saveAccountIDs(driver, accIDs); err != nil {
+		return errors.Wrap(err, "failed to save account IDs")
+	}
+
+	if err := driver.Delete("account:" + string(userID)); err != nil && !errors.Is(err, secret.ErrNotFound) {
+		return errors.Wrap(err, "failed to delete account secret")
+	}
+
+	return nil
+}
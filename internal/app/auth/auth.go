@@ -28,8 +28,11 @@ type Assistant struct {
 	// states, can be nil depending on the steps
 	accounts      []assistantAccount
 	currentClient *gotktrix.ClientAuth
+	// reauth is the account that is logging in again after its token was
+	// revoked.
+	reauth *assistantAccount
 
-	keyring     *secret.Keyring
+	keyring     secret.Driver
 	encrypt     *secret.EncryptedFile
 	encryptPath string
 
@@ -59,7 +62,7 @@ func ShowWithClient(ctx context.Context, client httputil.Client) *Assistant {
 		Assistant:   ass,
 		ctx:         ctx,
 		client:      client,
-		keyring:     secret.KeyringDriver(app.IDDot("secrets")),
+		keyring:     secret.SystemDriver(app.IDDot("secrets")),
 		encryptPath: app.ConfigPath("secrets"),
 	}
 
@@ -81,6 +84,8 @@ func (a *Assistant) OnConnect(f func(*gotktrix.Client, *Account)) {
 
 // step 1 activate
 func (a *Assistant) signinPage() {
+	a.reauth = nil
+
 	step2 := homeserverStep(a)
 	a.AddStep(step2)
 	a.SetStep(step2)
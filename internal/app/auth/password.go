@@ -0,0 +1,62 @@
+package auth
+
+import (
+	_ "embed"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+)
+
+//go:embed styles/auth-remember-me-password.css
+var passwordPromptStyle string
+var passwordPromptCSS = cssutil.Applier("auth-remember-me-password", passwordPromptStyle)
+
+// PromptPassword shows a modal dialog that asks for a password. done is called
+// with the password, or with an empty string if the dialog is cancelled.
+func PromptPassword(parent *gtk.Window, title, label, accept string, done func(string)) {
+	passEntry := gtk.NewEntry()
+	passEntry.SetInputPurpose(gtk.InputPurposePassword)
+	passEntry.SetVisibility(false)
+
+	passLabel := gtk.NewLabel(label)
+	passLabel.SetAttributes(inputLabelAttrs)
+	passLabel.SetXAlign(0)
+
+	passBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	passBox.Append(passLabel)
+	passBox.Append(passEntry)
+
+	passPrompt := gtk.NewDialog()
+	passPrompt.SetTitle(title)
+	passPrompt.SetDefaultSize(250, 80)
+	passPrompt.SetTransientFor(parent)
+	passPrompt.SetModal(true)
+	passPrompt.AddButton("Cancel", int(gtk.ResponseCancel))
+	passPrompt.AddButton(accept, int(gtk.ResponseAccept))
+	passPrompt.SetDefaultResponse(int(gtk.ResponseAccept))
+
+	passInner := passPrompt.ContentArea()
+	passInner.Append(passBox)
+	passInner.SetVExpand(true)
+	passInner.SetHExpand(true)
+	passInner.SetVAlign(gtk.AlignCenter)
+	passInner.SetHAlign(gtk.AlignCenter)
+	passwordPromptCSS(passInner)
+
+	passEntry.ConnectActivate(func() {
+		// Enter key activates.
+		passPrompt.Response(int(gtk.ResponseAccept))
+	})
+
+	passPrompt.ConnectResponse(func(id int) {
+		defer passPrompt.Close()
+
+		if id != int(gtk.ResponseAccept) {
+			done("")
+			return
+		}
+
+		done(passEntry.Text())
+	})
+	passPrompt.Show()
+}
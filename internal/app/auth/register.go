@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// supportedRegisterStages are the registration stages that the assistant can
+// complete. The reCAPTCHA stage is done on its fallback web page.
+var supportedRegisterStages = []matrix.LoginMethod{
+	matrix.LoginDummy,
+	gotktrix.LoginTerms,
+	matrix.LoginEmail,
+	matrix.LoginRecaptcha,
+}
+
+// step 3 activate
+func (a *Assistant) register() {
+	step := registerStep(a)
+	a.AddStep(step)
+	a.SetStep(step)
+}
+
+// registration is a registration in progress.
+type registration struct {
+	*gotktrix.Registration
+	a          *Assistant
+	rememberMe *rememberMeBox
+}
+
+// next moves to the step of the next stage, or finishes once the registration
+// is done. onError is called with the error of the current step. The assistant
+// must be busy.
+func (r *registration) next(onError func(error)) {
+	if r.Done() {
+		go func() {
+			c, err := r.Client()
+			if err != nil {
+				glib.IdleAdd(func() { onError(err) })
+				return
+			}
+
+			acc, err := copyAccount(c)
+			if err != nil {
+				glib.IdleAdd(func() { onError(err) })
+				return
+			}
+
+			glib.IdleAdd(func() {
+				// Assistant is still busy at this point.
+				r.rememberMe.saveAndFinish(c, r.a, acc)
+			})
+		}()
+		return
+	}
+
+	stages := r.NextStages(supportedRegisterStages...)
+	if len(stages) == 0 {
+		onError(errors.New("homeserver requires an unsupported registration step"))
+		return
+	}
+
+	var step *assistant.Step
+
+	switch stages[0] {
+	case matrix.LoginDummy:
+		r.auth(r.AuthDummy, onError)
+		return
+	case gotktrix.LoginTerms:
+		step = termsStep(r)
+	case matrix.LoginEmail:
+		step = emailStep(r)
+	default:
+		step = fallbackStep(r, stages[0])
+	}
+
+	r.a.AddStep(step)
+	r.a.SetStep(step)
+}
+
+// auth completes a stage in the background, then moves on to the next one.
+func (r *registration) auth(f func() error, onError func(error)) {
+	go func() {
+		err := f()
+		glib.IdleAdd(func() {
+			if err != nil {
+				onError(err)
+				return
+			}
+			r.next(onError)
+		})
+	}()
+}
+
+// newStageStep creates a step containing the given widgets and an error label.
+// The returned function shows an error and brings the assistant out of busy
+// mode.
+func newStageStep(title, okLabel string, widgets ...gtk.Widgetter) (*assistant.Step, func(error)) {
+	errLabel := makeErrorLabel()
+	errLabel.Hide()
+
+	step := assistant.NewStep(title, okLabel)
+
+	content := step.ContentArea()
+	content.SetOrientation(gtk.OrientationVertical)
+	content.SetSpacing(6)
+	for _, w := range widgets {
+		content.Append(w)
+	}
+	content.Append(errLabel)
+
+	onError := func(err error) {
+		errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+		errLabel.Show()
+
+		if a := step.Assistant(); a != nil {
+			a.Continue()
+		}
+	}
+
+	return step, onError
+}
+
+func newStageLabel(text string) *gtk.Label {
+	label := gtk.NewLabel(text)
+	label.SetXAlign(0)
+	label.SetWrap(true)
+	label.SetWrapMode(pango.WrapWordChar)
+	return label
+}
+
+func registerStep(a *Assistant) *assistant.Step {
+	inputBox, inputs := a.makeInputs("Username", "Password", "Confirm Password")
+	inputs[1].SetInputPurpose(gtk.InputPurposePassword)
+	inputs[1].SetVisibility(false)
+	inputs[2].SetInputPurpose(gtk.InputPurposePassword)
+	inputs[2].SetVisibility(false)
+
+	rememberMe := newRememberMeBox(a)
+
+	step, onError := newStageStep("Register", "Register", inputBox, rememberMe)
+	step.CanBack = true
+
+	step.Done = func(step *assistant.Step) {
+		username := inputs[0].Text()
+		password := inputs[1].Text()
+
+		if password == "" || password != inputs[2].Text() {
+			onError(errors.New("passwords do not match"))
+			return
+		}
+
+		a.Busy()
+
+		go func() {
+			reg, err := a.currentClient.Register(username, password)
+			if err != nil {
+				glib.IdleAdd(func() { onError(err) })
+				return
+			}
+
+			glib.IdleAdd(func() {
+				r := registration{
+					Registration: reg,
+					a:            a,
+					rememberMe:   rememberMe,
+				}
+				r.next(onError)
+			})
+		}()
+	}
+
+	return step
+}
+
+// policyLanguage returns the user's language for choosing the translation of
+// the homeserver's policies.
+func policyLanguage() string {
+	lang := os.Getenv("LANG")
+	if i := strings.IndexAny(lang, "_.@"); i > -1 {
+		lang = lang[:i]
+	}
+	return lang
+}
+
+func termsStep(r *registration) *assistant.Step {
+	widgets := []gtk.Widgetter{
+		newStageLabel("The homeserver requires you to accept these policies:"),
+	}
+
+	for _, policy := range r.Policies(policyLanguage()) {
+		link := gtk.NewLinkButtonWithLabel(policy.URL, policy.Name)
+		link.SetHAlign(gtk.AlignStart)
+		widgets = append(widgets, link)
+	}
+
+	step, onError := newStageStep("Policies", "Accept", widgets...)
+	step.Done = func(step *assistant.Step) {
+		r.a.Busy()
+		r.auth(r.AuthTerms, onError)
+	}
+
+	return step
+}
+
+func emailStep(r *registration) *assistant.Step {
+	inputBox, inputs := r.a.makeInputs("Email")
+	inputs[0].SetInputPurpose(gtk.InputPurposeEmail)
+
+	step, onError := newStageStep("Email", "Send",
+		newStageLabel("The homeserver requires an email address to register."),
+		inputBox,
+	)
+
+	step.Done = func(step *assistant.Step) {
+		email := inputs[0].Text()
+
+		r.a.Busy()
+
+		go func() {
+			v, err := r.RequestEmailToken(email)
+			if err != nil {
+				glib.IdleAdd(func() { onError(err) })
+				return
+			}
+
+			glib.IdleAdd(func() {
+				step := emailConfirmStep(r, v)
+				r.a.AddStep(step)
+				r.a.SetStep(step)
+			})
+		}()
+	}
+
+	return step
+}
+
+func emailConfirmStep(r *registration, v *gotktrix.EmailValidation) *assistant.Step {
+	step, onError := newStageStep("Confirm Email", "Continue",
+		newStageLabel(fmt.Sprintf(
+			"A link has been sent to %s. Open it, then click Continue.", v.Email,
+		)),
+	)
+
+	step.Done = func(step *assistant.Step) {
+		r.a.Busy()
+		r.auth(func() error { return r.AuthEmail(v) }, onError)
+	}
+
+	return step
+}
+
+func fallbackStep(r *registration, stage matrix.LoginMethod) *assistant.Step {
+	uri := r.FallbackURL(stage)
+
+	desc := "Complete the next step in your web browser, then click Continue."
+	if stage == matrix.LoginRecaptcha {
+		desc = "Prove that you're not a robot in your web browser, then click Continue."
+	}
+
+	link := gtk.NewLinkButtonWithLabel(uri, "Reopen the browser")
+	link.SetHAlign(gtk.AlignCenter)
+
+	step, onError := newStageStep("Verification", "Continue", newStageLabel(desc), link)
+	step.SwitchedTo = func(*assistant.Step) {
+		app.OpenURI(r.a.ctx, uri)
+	}
+	step.Done = func(step *assistant.Step) {
+		r.a.Busy()
+		r.auth(r.AuthFallback, onError)
+	}
+
+	return step
+}
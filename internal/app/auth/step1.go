@@ -17,6 +17,7 @@ import (
 	"github.com/diamondburned/gotktrix/internal/components/assistant"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotktrix/internal/secret"
+	"github.com/diamondburned/gotrix/matrix"
 	"github.com/pkg/errors"
 )
 
@@ -55,7 +56,7 @@ var serverAttrs = textutil.Attrs(
 var avatarStyle string
 var avatarCSS = cssutil.Applier("auth-avatar", avatarStyle)
 
-func newAccountEntry(ctx context.Context, account *Account) *gtk.ListBoxRow {
+func newAccountEntry(ctx context.Context, account *Account, onRemove func()) *gtk.ListBoxRow {
 	avatar := onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, avatarSize)
 	avatar.SetInitials(account.Username)
 	avatar.SetFromURL(account.AvatarURL)
@@ -73,11 +74,27 @@ func newAccountEntry(ctx context.Context, account *Account) *gtk.ListBoxRow {
 	server.SetHExpand(true)
 	server.SetAttributes(serverAttrs)
 
+	remove := gtk.NewButtonFromIconName("system-log-out-symbolic")
+	remove.SetHasFrame(false)
+	remove.SetVAlign(gtk.AlignCenter)
+	remove.SetTooltipText("Log Out")
+	remove.ConnectClicked(func() {
+		// Require a second click to confirm.
+		if !remove.HasCSSClass("destructive-action") {
+			remove.AddCSSClass("destructive-action")
+			remove.SetTooltipText("Click again to log out and remove this account")
+			return
+		}
+		remove.SetSensitive(false)
+		onRemove()
+	})
+
 	grid := gtk.NewGrid()
 	grid.SetColumnSpacing(2)
 	grid.Attach(avatar, 0, 0, 1, 2)
 	grid.Attach(name, 1, 0, 1, 1)
 	grid.Attach(server, 1, 1, 1, 1)
+	grid.Attach(remove, 2, 0, 1, 2)
 
 	row := gtk.NewListBoxRow()
 	row.SetChild(grid)
@@ -119,6 +136,28 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 		}
 	}
 
+	errLabel := makeErrorLabel()
+	errLabel.Hide()
+
+	onError := func(err error) {
+		errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+		errLabel.Show()
+		a.Continue()
+	}
+
+	storage := newStorageBox(a)
+
+	logout := func(account *Account) {
+		errLabel.Hide()
+		logoutAccount(a, accountList, account, func(err error) {
+			if err != nil {
+				errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+				errLabel.Show()
+			}
+		})
+		storage.update()
+	}
+
 	keyringStatus := gtk.NewLabel("Loading accounts from keyring...")
 	keyringStatus.SetWrap(true)
 	keyringStatus.SetWrapMode(pango.WrapWordChar)
@@ -138,6 +177,7 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 				a.keyring = nil
 
 				tailbox.Remove(keyringStatus)
+				storage.update()
 				return
 			}
 
@@ -148,7 +188,8 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 			}
 
 			tailbox.Remove(keyringStatus)
-			addAccounts(a, accountList, a.keyring, accounts)
+			addAccounts(a, accountList, a.keyring, accounts, logout)
+			storage.update()
 		})
 	}()
 
@@ -207,22 +248,14 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 
 						tailbox.Remove(errLabel)
 						tailbox.Remove(box)
-						addAccounts(a, accountList, a.encrypt, accounts)
+						addAccounts(a, accountList, a.encrypt, accounts, logout)
+						storage.update()
 					})
 				}()
 			})
 		})
 	}()
 
-	errLabel := makeErrorLabel()
-	errLabel.Hide()
-
-	onError := func(err error) {
-		errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
-		errLabel.Show()
-		a.Continue()
-	}
-
 	useExistingAccount := func(row *gtk.ListBoxRow) {
 		acc := a.accounts[row.Index()]
 		ctx := a.CancellableBusy(a.ctx)
@@ -232,6 +265,11 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 				Client:     a.client.WithContext(ctx),
 				ConfigPath: app.FromContext(ctx),
 			})
+			if unknown, soft := gotktrix.IsUnknownToken(err); unknown {
+				// The token was revoked, so log in again.
+				glib.IdleAdd(func() { a.reauthenticate(acc, soft, onError) })
+				return
+			}
 			if err != nil {
 				err = errors.Wrap(err, "server error")
 				glib.IdleAdd(func() {
@@ -259,6 +297,7 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 	box.Append(accountList)
 	box.Append(errLabel)
 	box.Append(tailbox)
+	box.Append(storage)
 	accountChooserCSS(box)
 
 	step := assistant.NewStep("Choose an Account", "")
@@ -300,7 +339,7 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 	return step
 }
 
-func addAccounts(a *Assistant, accountList *gtk.ListBox, src secret.Driver, accounts []Account) {
+func addAccounts(a *Assistant, accountList *gtk.ListBox, src secret.Driver, accounts []Account, onRemove func(*Account)) {
 	hasAccount := func(has *Account) bool {
 		for _, acc := range a.accounts {
 			if acc.UserID == has.UserID {
@@ -310,8 +349,9 @@ func addAccounts(a *Assistant, accountList *gtk.ListBox, src secret.Driver, acco
 		return false
 	}
 
-	// Use list prepend, so iterate backwards.
-	for i := len(accounts) - 1; i >= 0; i-- {
+	added := make([]assistantAccount, 0, len(accounts))
+
+	for i := range accounts {
 		account := &accounts[i]
 
 		if hasAccount(account) {
@@ -319,10 +359,281 @@ func addAccounts(a *Assistant, accountList *gtk.ListBox, src secret.Driver, acco
 			continue
 		}
 
-		a.accounts = append(a.accounts, assistantAccount{
+		added = append(added, assistantAccount{
 			Account: account,
 			src:     src,
 		})
-		accountList.Prepend(newAccountEntry(a.ctx, account))
 	}
+
+	// Use list prepend, so iterate backwards. This keeps the rows in the same
+	// order as a.accounts.
+	for i := len(added) - 1; i >= 0; i-- {
+		account := added[i].Account
+		accountList.Prepend(newAccountEntry(a.ctx, account, func() {
+			onRemove(account)
+		}))
+	}
+
+	a.accounts = append(added, a.accounts...)
+}
+
+// logoutAccount removes the account and its row, then logs it out on the
+// server and erases its secrets and cached data in the background. The account
+// is erased even if the server can't be reached. done is called afterwards
+// with the first error, if any.
+func logoutAccount(a *Assistant, accountList *gtk.ListBox, account *Account, done func(error)) {
+	for i, acc := range a.accounts {
+		if acc.Account != account {
+			continue
+		}
+
+		accountList.Remove(accountList.RowAtIndex(i))
+		a.accounts = append(a.accounts[:i], a.accounts[i+1:]...)
+
+		opts := gotktrix.Opts{
+			Client:     a.client.WithContext(a.ctx),
+			ConfigPath: app.FromContext(a.ctx),
+		}
+
+		go func() {
+			var errs []error
+
+			if err := gotktrix.Logout(acc.Server, acc.Token, opts); err != nil {
+				errs = append(errs, errors.Wrap(err, "cannot log out on the server"))
+			}
+
+			userID := matrix.UserID(acc.UserID)
+
+			if err := removeAccount(acc.src, userID); err != nil {
+				errs = append(errs, errors.Wrap(err, "cannot remove account"))
+			}
+
+			if err := gotktrix.RemoveUserData(userID, opts); err != nil {
+				errs = append(errs, errors.Wrap(err, "cannot remove account data"))
+			}
+
+			for _, err := range errs {
+				log.Println("error logging out:", err)
+			}
+
+			glib.IdleAdd(func() {
+				if len(errs) > 0 {
+					done(errs[0])
+				} else {
+					done(nil)
+				}
+			})
+		}()
+
+		return
+	}
+}
+
+// reauthenticate logs the account in again after its token was revoked. The
+// new token replaces the old one in the same secret driver once logged in.
+func (a *Assistant) reauthenticate(acc assistantAccount, soft bool, onError func(error)) {
+	ctx := a.CancellableBusy(a.ctx)
+
+	opts := gotktrix.Opts{
+		Client:     a.client.WithContext(ctx),
+		ConfigPath: app.FromContext(ctx),
+	}
+
+	go func() {
+		if !soft {
+			// The server wants the cached data gone before logging back in.
+			if err := gotktrix.RemoveUserData(matrix.UserID(acc.UserID), opts); err != nil {
+				log.Println("cannot remove data of logged out account:", err)
+			}
+		}
+
+		c, err := gotktrix.NewAuth(acc.Server, opts)
+		if err != nil {
+			glib.IdleAdd(func() { onError(explainHomeserverError(err)) })
+			return
+		}
+
+		methods, err := c.LoginMethods()
+		if err != nil {
+			glib.IdleAdd(func() { onError(errors.Wrap(err, "server error")) })
+			return
+		}
+
+		glib.IdleAdd(func() {
+			a.reauth = &acc
+			a.chooseHomeserver(c.WithContext(context.Background()), methods)
+		})
+	}()
+}
+
+// storageBox contains the buttons to move accounts between the keyring and the
+// encrypted file, and to change the password of the encrypted file.
+type storageBox struct {
+	*gtk.Box
+	a *Assistant
+
+	toKeyring *gtk.Button
+	toFile    *gtk.Button
+	rekey     *gtk.Button
+
+	password *gtk.Entry
+	passBox  *gtk.Box
+	errLabel *gtk.Label
+}
+
+func newStorageBox(a *Assistant) *storageBox {
+	s := storageBox{a: a}
+
+	s.toKeyring = gtk.NewButtonWithLabel("Move Local Accounts to Keyring")
+	s.toKeyring.ConnectClicked(func() {
+		s.move(a.encrypt, a.keyring)
+	})
+
+	s.toFile = gtk.NewButtonWithLabel("Move Keyring Accounts to Local File")
+	s.toFile.ConnectClicked(func() {
+		if a.encrypt != nil {
+			s.move(a.keyring, a.encrypt)
+			return
+		}
+
+		// There's no encrypted file yet, so ask for a new password.
+		s.passBox.Show()
+		s.password.GrabFocus()
+	})
+
+	s.rekey = gtk.NewButtonWithLabel("Change Local Password")
+	s.rekey.ConnectClicked(s.changePassword)
+
+	moveButton := gtk.NewButtonWithLabel("Move")
+
+	s.password = gtk.NewEntry()
+	s.password.SetPlaceholderText("New password")
+	s.password.SetHExpand(true)
+	s.password.SetVisibility(false)
+	s.password.SetInputPurpose(gtk.InputPurposePassword)
+	s.password.ConnectActivate(func() { moveButton.Activate() })
+
+	moveButton.ConnectClicked(func() {
+		password := s.password.Text()
+		if password == "" {
+			return
+		}
+
+		a.encrypt = secret.EncryptedFileDriver(password, a.encryptPath)
+		s.password.SetText("")
+		s.passBox.Hide()
+		s.move(a.keyring, a.encrypt)
+	})
+
+	s.passBox = gtk.NewBox(gtk.OrientationHorizontal, 2)
+	s.passBox.Append(s.password)
+	s.passBox.Append(moveButton)
+	s.passBox.Hide()
+
+	s.errLabel = makeErrorLabel()
+	s.errLabel.Hide()
+
+	s.Box = gtk.NewBox(gtk.OrientationVertical, 2)
+	s.Box.SetHAlign(gtk.AlignCenter)
+	s.Box.Append(s.errLabel)
+	s.Box.Append(s.toKeyring)
+	s.Box.Append(s.toFile)
+	s.Box.Append(s.rekey)
+	s.Box.Append(s.passBox)
+	s.update()
+
+	return &s
+}
+
+// update shows the buttons that can move any of the loaded accounts.
+func (s *storageBox) update() {
+	a := s.a
+
+	// The encrypted file must be decrypted before anything can be moved into
+	// it.
+	canEncrypt := a.encrypt != nil || !secret.PathIsEncrypted(a.encryptPath)
+
+	s.toKeyring.SetVisible(a.keyring != nil && a.encrypt != nil && s.hasAccounts(a.encrypt))
+	s.toFile.SetVisible(a.keyring != nil && canEncrypt && s.hasAccounts(a.keyring))
+	// Only allow changing the password once the file is decrypted, which
+	// proves that the user knows it.
+	s.rekey.SetVisible(a.encrypt != nil && secret.PathIsEncrypted(a.encryptPath))
+
+	if !s.toFile.Visible() {
+		s.passBox.Hide()
+	}
+}
+
+func (s *storageBox) hasAccounts(src secret.Driver) bool {
+	for _, acc := range s.a.accounts {
+		if acc.src == src {
+			return true
+		}
+	}
+	return false
+}
+
+// changePassword asks for the current and the new password of the encrypted
+// file, then re-encrypts it in the background.
+func (s *storageBox) changePassword() {
+	a := s.a
+	s.errLabel.Hide()
+
+	PromptPassword(a.Window, "Change Password", "Enter current password:", "Next", func(oldPass string) {
+		if oldPass == "" {
+			return
+		}
+
+		PromptPassword(a.Window, "Change Password", "Enter new password:", "Change", func(newPass string) {
+			if newPass == "" {
+				return
+			}
+
+			a.Busy()
+			encrypt := a.encrypt
+
+			go func() {
+				err := encrypt.Rekey(oldPass, newPass)
+
+				glib.IdleAdd(func() {
+					a.Continue()
+
+					if err != nil {
+						err = errors.Wrap(err, "failed to change password")
+						s.errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+						s.errLabel.Show()
+					}
+				})
+			}()
+		})
+	})
+}
+
+// move moves all accounts from one driver to another in the background.
+func (s *storageBox) move(from, to secret.Driver) {
+	a := s.a
+	a.Busy()
+	s.errLabel.Hide()
+
+	go func() {
+		err := moveAccounts(from, to)
+
+		glib.IdleAdd(func() {
+			a.Continue()
+
+			if err != nil {
+				s.errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+				s.errLabel.Show()
+				return
+			}
+
+			for i, acc := range a.accounts {
+				if acc.src == from {
+					a.accounts[i].src = to
+				}
+			}
+
+			s.update()
+		})
+	}()
 }
@@ -52,7 +52,7 @@ func homeserverStep(a *Assistant) *assistant.Step {
 				ConfigPath: app.FromContext(ctx),
 			})
 			if err != nil {
-				onErr(err)
+				onErr(explainHomeserverError(err))
 				return
 			}
 
@@ -84,3 +84,16 @@ func homeserverStep(a *Assistant) *assistant.Step {
 
 	return step
 }
+
+// explainHomeserverError adds a hint on what the user can do to errors from
+// discovering a homeserver.
+func explainHomeserverError(err error) error {
+	switch {
+	case errors.Is(err, gotktrix.ErrUnreachable):
+		return errors.Errorf("%v. Check the address and your internet connection.", err)
+	case errors.Is(err, gotktrix.ErrNotMatrix):
+		return errors.Errorf("%v. Check that the address is correct.", err)
+	default:
+		return err
+	}
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"fmt"
+
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotk4/pkg/pango"
 	"github.com/diamondburned/gotkit/gtkutil/textutil"
@@ -26,6 +28,16 @@ func chooseLoginStep(a *Assistant, methods []matrix.LoginMethod) *assistant.Step
 	content.SetOrientation(gtk.OrientationVertical)
 	content.SetSpacing(6)
 
+	if a.reauth != nil {
+		expired := gtk.NewLabel(fmt.Sprintf(
+			"Your session for %s has expired. Log in again to continue.", a.reauth.UserID,
+		))
+		expired.SetXAlign(0)
+		expired.SetWrap(true)
+		expired.SetWrapMode(pango.WrapWordChar)
+		content.Append(expired)
+	}
+
 	if hasLoginMethod(methods, matrix.LoginPassword) {
 		content.Append(loginMethodButton(a, matrix.LoginPassword,
 			"Username/Email",
@@ -47,6 +59,18 @@ func chooseLoginStep(a *Assistant, methods []matrix.LoginMethod) *assistant.Step
 		))
 	}
 
+	if a.reauth == nil {
+		register := gtk.NewButton()
+		register.SetChild(bigSmallTitleBox(
+			"Create an Account",
+			"Register a new account on this homeserver.",
+		))
+		register.ConnectClicked(a.register)
+
+		content.Append(gtk.NewSeparator(gtk.OrientationHorizontal))
+		content.Append(register)
+	}
+
 	return step
 }
 
@@ -49,6 +49,10 @@ func loginStepForm(a *Assistant, method matrix.LoginMethod) *assistant.Step {
 		inputs[1].SetInputPurpose(gtk.InputPurposePassword)
 		inputs[1].SetVisibility(false)
 
+		if a.reauth != nil {
+			inputs[0].SetText(a.reauth.UserID)
+		}
+
 		data.InputBox = inputBox
 		data.Login = func(client *gotktrix.ClientAuth) (*gotktrix.Client, error) {
 			return client.LoginPassword(inputs[0].Text(), inputs[1].Text())
@@ -221,10 +225,6 @@ type rememberMeBox struct {
 var rememberMeStyle string
 var rememberMeCSS = cssutil.Applier("auth-remember-me", rememberMeStyle)
 
-//go:embed styles/auth-remember-me-password.css
-var rememberMePasswordStyle string
-var rememberMePasswordCSS = cssutil.Applier("auth-remember-me-password", rememberMePasswordStyle)
-
 func newRememberMeBox(a *Assistant) *rememberMeBox {
 	var state rememberMeBox
 
@@ -253,58 +253,16 @@ func newRememberMeBox(a *Assistant) *rememberMeBox {
 			return
 		}
 
-		passEntry := gtk.NewEntry()
-		passEntry.SetInputPurpose(gtk.InputPurposePassword)
-		passEntry.SetVisibility(false)
-
-		passLabel := gtk.NewLabel("Enter new password:")
-		passLabel.SetAttributes(inputLabelAttrs)
-		passLabel.SetXAlign(0)
-
-		passBox := gtk.NewBox(gtk.OrientationVertical, 0)
-		passBox.Append(passLabel)
-		passBox.Append(passEntry)
-
-		// Ask for encryption.
-		passPrompt := gtk.NewDialog()
-		passPrompt.SetTitle("Encrypt File")
-		passPrompt.SetDefaultSize(250, 80)
-		passPrompt.SetTransientFor(a.Window)
-		passPrompt.SetModal(true)
-		passPrompt.AddButton("Cancel", int(gtk.ResponseCancel))
-		passPrompt.AddButton("Encrypt", int(gtk.ResponseAccept))
-		passPrompt.SetDefaultResponse(int(gtk.ResponseAccept))
-
-		passInner := passPrompt.ContentArea()
-		passInner.Append(passBox)
-		passInner.SetVExpand(true)
-		passInner.SetHExpand(true)
-		passInner.SetVAlign(gtk.AlignCenter)
-		passInner.SetHAlign(gtk.AlignCenter)
-		rememberMePasswordCSS(passInner)
-
-		passEntry.ConnectActivate(func() {
-			// Enter key activates.
-			passPrompt.Response(int(gtk.ResponseAccept))
-		})
-
-		passPrompt.ConnectResponse(func(id int) {
-			defer passPrompt.Close()
-
-			password := passEntry.Text()
-			if id == int(gtk.ResponseCancel) || password == "" {
+		PromptPassword(a.Window, "Encrypt File", "Enter new password:", "Encrypt", func(password string) {
+			if password == "" {
 				encryptFile.SetActive(false)
 				state.encrypt = false
 				return
 			}
 
-			if id == int(gtk.ResponseAccept) {
-				a.encrypt = secret.EncryptedFileDriver(password, a.encryptPath)
-				state.encrypt = true
-				return
-			}
+			a.encrypt = secret.EncryptedFileDriver(password, a.encryptPath)
+			state.encrypt = true
 		})
-		passPrompt.Show()
 	})
 
 	box.Append(encryptFile)
@@ -340,9 +298,18 @@ func newRememberMeBox(a *Assistant) *rememberMeBox {
 }
 
 func (r *rememberMeBox) saveAndFinish(c *gotktrix.Client, a *Assistant, acc *Account) {
+	reauth := a.reauth
+
 	go func() {
 		var errors []error
 
+		if reauth != nil && reauth.UserID == acc.UserID {
+			// Replace the revoked token.
+			if err := saveAccount(reauth.src, acc); err != nil {
+				errors = append(errors, err)
+			}
+		}
+
 		if r.keyring && a.keyring != nil {
 			if err := saveAccount(a.keyring, acc); err != nil {
 				errors = append(errors, err)
@@ -0,0 +1,423 @@
+// Package createroom provides an assistant for creating rooms, spaces and
+// direct messages.
+package createroom
+
+import (
+	"context"
+	_ "embed"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/matrix"
+	"golang.org/x/text/message"
+)
+
+//go:embed styles/createroom.css
+var createStyle string
+var createCSS = cssutil.Applier("createroom", createStyle)
+
+type kind uint8
+
+const (
+	roomKind kind = iota
+	spaceKind
+	directKind
+)
+
+type preset struct {
+	preset api.RoomPreset
+	name   message.Reference
+}
+
+var presets = []preset{
+	{api.PresetPrivateChat, "Private, invite only"},
+	{api.PresetPublicChat, "Public, anyone can join"},
+	{api.PresetTrustedPrivateChat, "Private, everyone is an admin"},
+}
+
+var hintAttrs = textutil.Attrs(
+	pango.NewAttrScale(0.9),
+	pango.NewAttrForegroundAlpha(65535*75/100), // 75%
+)
+
+// Assistant is the room creation assistant.
+type Assistant struct {
+	*assistant.Assistant
+	ctx  context.Context
+	done func(matrix.RoomID)
+
+	kind kind
+
+	name      *gtk.Entry
+	topic     *gtk.Entry
+	alias     *gtk.Entry
+	preset    *gtk.DropDown
+	public    *gtk.CheckButton
+	encrypted *gtk.CheckButton
+	parent    *gtk.DropDown
+	spaces    []matrix.RoomID
+
+	// roomOnly contains the widgets that are hidden for direct messages.
+	roomOnly []gtk.Widgetter
+
+	inviteEntry *gtk.Entry
+	inviteList  *gtk.ListBox
+	invites     []matrix.UserID
+}
+
+// Show shows a new room creation assistant. done is called with the new room
+// once it's created.
+func Show(ctx context.Context, done func(matrix.RoomID)) *Assistant {
+	a := New(ctx, done)
+	a.Show()
+	return a
+}
+
+// New creates a new room creation assistant.
+func New(ctx context.Context, done func(matrix.RoomID)) *Assistant {
+	a := &Assistant{
+		ctx:  ctx,
+		done: done,
+	}
+
+	steps := []*assistant.Step{
+		a.kindStep(),
+		a.detailsStep(),
+		a.inviteStep(),
+	}
+
+	a.Assistant = assistant.New(app.GTKWindowFromContext(ctx), steps)
+	a.Assistant.SetTitle(locale.S(ctx, "Create a Room"))
+	createCSS(a.Assistant)
+
+	return a
+}
+
+func (a *Assistant) kindStep() *assistant.Step {
+	step := assistant.NewStep(locale.S(a.ctx, "Type"), "")
+
+	content := step.ContentArea()
+	content.SetSpacing(6)
+	content.Append(a.kindButton(roomKind,
+		"Room", "A place to talk with a group of people."))
+	content.Append(a.kindButton(spaceKind,
+		"Space", "A group of rooms that can be joined together."))
+	content.Append(a.kindButton(directKind,
+		"Direct Message", "A private conversation with someone."))
+
+	return step
+}
+
+func (a *Assistant) kindButton(k kind, big, small message.Reference) *gtk.Button {
+	bigLabel := gtk.NewLabel(locale.S(a.ctx, big))
+	bigLabel.SetXAlign(0)
+	bigLabel.AddCSSClass("createroom-kind-title")
+
+	smallLabel := gtk.NewLabel(locale.S(a.ctx, small))
+	smallLabel.SetXAlign(0)
+	smallLabel.SetWrap(true)
+	smallLabel.SetWrapMode(pango.WrapWordChar)
+	smallLabel.SetAttributes(hintAttrs)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(bigLabel)
+	box.Append(smallLabel)
+
+	button := gtk.NewButton()
+	button.SetChild(box)
+	button.ConnectClicked(func() {
+		a.kind = k
+		a.NextStep()
+	})
+
+	return button
+}
+
+func (a *Assistant) detailsStep() *assistant.Step {
+	step := assistant.NewStep(locale.S(a.ctx, "Details"), locale.S(a.ctx, "Next"))
+	step.SwitchedTo = func(*assistant.Step) {
+		a.invalidateAlias()
+		for _, w := range a.roomOnly {
+			gtk.BaseWidget(w).SetVisible(a.kind != directKind)
+		}
+	}
+
+	a.name = gtk.NewEntry()
+	a.name.SetHExpand(true)
+
+	a.topic = gtk.NewEntry()
+
+	a.alias = gtk.NewEntry()
+	a.alias.SetObjectProperty("placeholder-text", locale.S(a.ctx, "my-room"))
+	a.alias.ConnectChanged(a.invalidateAlias)
+
+	presetNames := make([]string, len(presets))
+	for i, preset := range presets {
+		presetNames[i] = locale.S(a.ctx, preset.name)
+	}
+
+	a.preset = gtk.NewDropDownFromStrings(presetNames)
+
+	a.public = gtk.NewCheckButtonWithLabel(locale.S(a.ctx, "Publish in the room directory"))
+
+	a.encrypted = gtk.NewCheckButtonWithLabel(locale.S(a.ctx, "Encrypt messages"))
+
+	encryptHint := gtk.NewLabel(locale.S(a.ctx,
+		"Encryption can't be disabled later, and gotktrix can't read encrypted messages yet."))
+	encryptHint.SetXAlign(0)
+	encryptHint.SetWrap(true)
+	encryptHint.SetWrapMode(pango.WrapWordChar)
+	encryptHint.SetAttributes(hintAttrs)
+
+	client := gotktrix.FromContext(a.ctx).Offline()
+	parentNames := []string{locale.S(a.ctx, "None")}
+
+	rooms, _ := client.Rooms()
+	for _, roomID := range rooms {
+		if !client.RoomIsSpace(roomID) {
+			continue
+		}
+		if !client.CanSendEvent(roomID, m.SpaceChildEventType, true) {
+			continue
+		}
+
+		name, _ := client.RoomName(roomID)
+		parentNames = append(parentNames, name)
+		a.spaces = append(a.spaces, roomID)
+	}
+
+	a.parent = gtk.NewDropDownFromStrings(parentNames)
+
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(6)
+	grid.SetColumnSpacing(8)
+
+	fields := []struct {
+		label    message.Reference
+		w        gtk.Widgetter
+		roomOnly bool
+	}{
+		{"Name", a.name, false},
+		{"Topic", a.topic, false},
+		{"Alias", a.alias, true},
+		{"Access", a.preset, true},
+		{"Space", a.parent, true},
+	}
+
+	for i, field := range fields {
+		label := gtk.NewLabel(locale.S(a.ctx, field.label))
+		label.SetXAlign(1)
+
+		grid.Attach(label, 0, i, 1, 1)
+		grid.Attach(field.w, 1, i, 1, 1)
+
+		if field.roomOnly {
+			a.roomOnly = append(a.roomOnly, label, field.w)
+		}
+	}
+
+	if len(a.spaces) == 0 {
+		// Don't show the parent space if there's none to choose.
+		last := a.roomOnly[len(a.roomOnly)-2:]
+		a.roomOnly = a.roomOnly[:len(a.roomOnly)-2]
+		for _, w := range last {
+			gtk.BaseWidget(w).Hide()
+		}
+	}
+
+	a.roomOnly = append(a.roomOnly, a.public)
+
+	content := step.ContentArea()
+	content.SetSpacing(6)
+	content.Append(grid)
+	content.Append(a.public)
+	content.Append(a.encrypted)
+	content.Append(encryptHint)
+
+	return step
+}
+
+func (a *Assistant) inviteStep() *assistant.Step {
+	step := assistant.NewStep(locale.S(a.ctx, "Invite"), locale.S(a.ctx, "Create"))
+	step.CanBack = true
+	step.Done = func(*assistant.Step) { a.create() }
+	step.SwitchedTo = func(*assistant.Step) { a.invalidateOK() }
+
+	a.inviteEntry = gtk.NewEntry()
+	a.inviteEntry.SetHExpand(true)
+	a.inviteEntry.SetObjectProperty("placeholder-text", "@user:server")
+
+	add := gtk.NewButtonFromIconName("list-add-symbolic")
+	add.SetTooltipText(locale.S(a.ctx, "Add"))
+	add.ConnectClicked(a.addInvite)
+	a.inviteEntry.ConnectActivate(a.addInvite)
+
+	entryBox := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	entryBox.AddCSSClass("linked")
+	entryBox.Append(a.inviteEntry)
+	entryBox.Append(add)
+
+	a.inviteList = gtk.NewListBox()
+	a.inviteList.AddCSSClass("createroom-invites")
+	a.inviteList.SetSelectionMode(gtk.SelectionNone)
+
+	content := step.ContentArea()
+	content.SetSpacing(6)
+	content.Append(entryBox)
+	content.Append(a.inviteList)
+
+	return step
+}
+
+func (a *Assistant) addInvite() {
+	userID := matrix.UserID(strings.TrimSpace(a.inviteEntry.Text()))
+	if _, _, err := userID.Parse(); err != nil || !strings.HasPrefix(string(userID), "@") {
+		a.inviteEntry.AddCSSClass("error")
+		return
+	}
+
+	a.inviteEntry.RemoveCSSClass("error")
+	a.inviteEntry.SetText("")
+
+	for _, invite := range a.invites {
+		if invite == userID {
+			return
+		}
+	}
+
+	a.invites = append(a.invites, userID)
+
+	label := gtk.NewLabel(string(userID))
+	label.SetXAlign(0)
+	label.SetHExpand(true)
+	label.SetEllipsize(pango.EllipsizeMiddle)
+
+	remove := gtk.NewButtonFromIconName("list-remove-symbolic")
+	remove.SetHasFrame(false)
+	remove.SetTooltipText(locale.S(a.ctx, "Remove"))
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.Append(label)
+	box.Append(remove)
+
+	row := gtk.NewListBoxRow()
+	row.SetChild(box)
+	a.inviteList.Append(row)
+
+	remove.ConnectClicked(func() {
+		for i, invite := range a.invites {
+			if invite == userID {
+				a.invites = append(a.invites[:i], a.invites[i+1:]...)
+				break
+			}
+		}
+		a.inviteList.Remove(row)
+		a.invalidateOK()
+	})
+
+	a.invalidateOK()
+}
+
+// invalidateAlias marks the alias entry if the alias is invalid, and only
+// allows continuing once it's fixed. Direct messages have no alias.
+func (a *Assistant) invalidateAlias() {
+	_, ok := aliasLocalpart(a.alias.Text())
+	ok = ok || a.kind == directKind
+
+	if ok {
+		a.alias.RemoveCSSClass("error")
+	} else {
+		a.alias.AddCSSClass("error")
+	}
+
+	a.OKButton().SetSensitive(ok)
+}
+
+// aliasLocalpart returns the localpart of the given alias. The user may type
+// the whole alias, such as "#my-room:example.com", so the sigil and the server
+// name are stripped. False is returned if the localpart is invalid.
+func aliasLocalpart(alias string) (string, bool) {
+	alias = strings.TrimPrefix(strings.TrimSpace(alias), "#")
+	if i := strings.IndexByte(alias, ':'); i != -1 {
+		alias = alias[:i]
+	}
+
+	return alias, !strings.ContainsAny(alias, "# \t\n")
+}
+
+// invalidateOK only allows creating a direct message once there's someone to
+// message.
+func (a *Assistant) invalidateOK() {
+	a.OKButton().SetSensitive(a.kind != directKind || len(a.invites) > 0)
+}
+
+func (a *Assistant) opts() gotktrix.CreateRoomOpts {
+	opts := gotktrix.CreateRoomOpts{
+		Name:      strings.TrimSpace(a.name.Text()),
+		Topic:     strings.TrimSpace(a.topic.Text()),
+		Encrypted: a.encrypted.Active(),
+		Invite:    a.invites,
+	}
+
+	switch a.kind {
+	case directKind:
+		opts.Direct = true
+		opts.Preset = api.PresetTrustedPrivateChat
+		return opts
+	case spaceKind:
+		opts.Space = true
+	}
+
+	opts.Alias, _ = aliasLocalpart(a.alias.Text())
+	opts.Public = a.public.Active()
+
+	if ix := a.preset.Selected(); ix < uint(len(presets)) {
+		opts.Preset = presets[ix].preset
+	}
+
+	if ix := a.parent.Selected(); ix > 0 && ix <= uint(len(a.spaces)) {
+		opts.Parent = a.spaces[ix-1]
+	}
+
+	return opts
+}
+
+func (a *Assistant) create() {
+	opts := a.opts()
+	client := gotktrix.FromContext(a.ctx)
+
+	a.Busy()
+
+	gtkutil.Async(a.ctx, func() func() {
+		roomID, err := client.CreateRoom(opts)
+		if err != nil && roomID == "" {
+			return func() {
+				a.Continue()
+				app.Error(a.ctx, err)
+			}
+		}
+
+		return func() {
+			// The room may still be created even if the follow-up requests
+			// failed, so it's still opened.
+			if err != nil {
+				app.Error(a.ctx, err)
+			}
+
+			a.Close()
+			if a.done != nil {
+				a.done(roomID)
+			}
+		}
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/events/emojis"
 	"github.com/diamondburned/gotktrix/internal/sortutil"
+	"github.com/diamondburned/gotrix/api"
 	"github.com/diamondburned/gotrix/matrix"
 	"github.com/pkg/errors"
 )
@@ -139,9 +140,21 @@ func new(ctx context.Context, roomID matrix.RoomID) *View {
 	box.Append(scroll)
 	boxCSS(box)
 
+	client := gotktrix.FromContext(ctx)
+
+	// Room emojis are a state event, so only users with enough power may edit
+	// them.
+	canEdit := roomID == "" ||
+		client.Offline().CanSendEvent(roomID, emojis.RoomEmotesEventType, true)
+
+	if !canEdit {
+		addButton.SetSensitive(false)
+		rightBox.SetTooltipText("You don't have permission to edit this room's emojis.")
+	}
+
 	list.ConnectSelectedRowsChanged(func() {
 		// Allow pressing the delete button if we have selected rows.
-		selected := len(list.SelectedRows()) > 0
+		selected := canEdit && len(list.SelectedRows()) > 0
 		delButton.SetSensitive(selected)
 		renameButton.SetSensitive(selected)
 	})
@@ -156,7 +169,7 @@ func new(ctx context.Context, roomID matrix.RoomID) *View {
 		roomID: roomID,
 
 		ctx:    gtkutil.WithCanceller(ctx),
-		client: gotktrix.FromContext(ctx),
+		client: client,
 	}
 
 	view.InvalidateName()
@@ -305,7 +318,10 @@ func (v *View) syncEmojis(busy *gtk.Spinner) {
 
 		var err error
 		if v.roomID != "" {
-			err = client.ClientConfigRoomSet(v.roomID, string(emojis.RoomEmotesEventType), ev)
+			_, err = client.RoomStateSend(v.roomID, api.RoomStateSendArg{
+				Type:    emojis.RoomEmotesEventType,
+				Content: ev,
+			})
 		} else {
 			err = client.ClientConfigSet(string(emojis.UserEmotesEventType), ev)
 		}
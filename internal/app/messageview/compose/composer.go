@@ -34,6 +34,7 @@ type Composer struct {
 		current func()
 	}
 	editing bool
+	canSend bool
 }
 
 // Controller describes the parent component that the Composer controls.
@@ -150,17 +151,34 @@ func New(ctx context.Context, ctrl Controller, roomID matrix.RoomID) *Composer {
 
 	c.action.ConnectClicked(func() { c.action.current() })
 	c.resetAction()
-	c.SetPlaceholder("")
+	c.InvalidatePermissions()
 
 	return &c
 }
 
+// InvalidatePermissions checks whether the user can still send messages into
+// the room and disables the composer if they can't.
+func (c *Composer) InvalidatePermissions() {
+	client := gotktrix.FromContext(c.ctx).Offline()
+	c.canSend = client.CanSendEvent(c.roomID, event.TypeRoomMessage, false)
+
+	c.SetSensitive(c.canSend)
+
+	if !c.editing && c.input.replyingTo == "" {
+		c.SetPlaceholder("")
+	}
+}
+
 // SetPlaceholder sets the composer's placeholder. The default is used if an
 // empty string is given.
 func (c *Composer) SetPlaceholder(markup string) {
 	if markup == "" {
-		roomName, _ := gotktrix.FromContext(c.ctx).Offline().RoomName(c.roomID)
-		markup = locale.Sprintf(c.ctx, "Message %s", html.EscapeString(roomName))
+		if c.canSend {
+			roomName, _ := gotktrix.FromContext(c.ctx).Offline().RoomName(c.roomID)
+			markup = locale.Sprintf(c.ctx, "Message %s", html.EscapeString(roomName))
+		} else {
+			markup = locale.S(c.ctx, "You can't send messages in this room.")
+		}
 	}
 	c.placeholder.SetMarkup(markup)
 }
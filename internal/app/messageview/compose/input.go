@@ -308,10 +308,11 @@ func (i *Input) Send() bool {
 	go func() {
 		client := gotktrix.FromContext(ctx)
 		roomEv := dt.put(client)
+		// Set the transaction ID beforehand, so the message view can match
+		// the sending message with its outbox status.
+		roomEv.Unsigned.TransactionID = gotktrix.NewTransactionID()
 
-		var eventID matrix.EventID
-		var err error
-
+		var mark interface{}
 		// Only push a new message if we're not editing.
 		if dt.editing == "" {
 			rowCh := make(chan interface{}, 1)
@@ -320,18 +321,17 @@ func (i *Input) Send() bool {
 				// private type.
 				rowCh <- i.ctrl.AddSendingMessage(&roomEv.RoomMessageEvent)
 			})
-
-			defer func() {
-				row := <-rowCh
-				glib.IdleAdd(func() {
-					i.ctrl.BindSendingMessage(row, eventID)
-				})
-			}()
+			mark = <-rowCh
 		}
 
-		eventID, err = client.RoomEventSend(roomEv.RoomID, roomEv.Type, roomEv)
-		if err != nil {
+		// Queue the message into the outbox, which will keep retrying it
+		// until it's sent.
+		if _, err := client.QueueRoomEvent(roomEv.RoomID, roomEv); err != nil {
 			app.Error(i.ctx, errors.Wrap(err, "failed to send message"))
+
+			if mark != nil {
+				glib.IdleAdd(func() { i.ctrl.StopSendingMessage(mark) })
+			}
 		}
 	}()
 
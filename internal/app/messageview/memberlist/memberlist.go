@@ -0,0 +1,559 @@
+// Package memberlist provides a panel that lists the members of a room.
+package memberlist
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/sortutil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+const (
+	avatarSize = 32
+	// pageSize is the number of rows that are added each time the user scrolls
+	// to the bottom. Rooms can have thousands of members, so creating all rows
+	// at once would freeze the UI.
+	pageSize = 50
+	// searchLimit is the maximum number of search results.
+	searchLimit = 50
+	// invalidateDelay is the delay before the members are reloaded after a
+	// member or power level change, so that a burst of changes, such as many
+	// users joining at once, only reloads them once.
+	invalidateDelay = 500 // ms
+)
+
+// Group is a group of members in the list.
+type Group uint8
+
+const (
+	Admins Group = iota
+	Moderators
+	Members
+	Invited
+	groupLen
+)
+
+// Title returns the localized title of the group.
+func (g Group) Title(ctx context.Context) string {
+	switch g {
+	case Admins:
+		return locale.S(ctx, "Admins")
+	case Moderators:
+		return locale.S(ctx, "Moderators")
+	case Members:
+		return locale.S(ctx, "Members")
+	case Invited:
+		return locale.S(ctx, "Invited")
+	default:
+		return ""
+	}
+}
+
+// memberGroup returns the group of a member with the given membership and
+// power level.
+func memberGroup(state event.MemberType, level int) Group {
+	switch {
+	case state == event.MemberInvited:
+		return Invited
+	case level >= 100:
+		return Admins
+	case level >= 50:
+		return Moderators
+	default:
+		return Members
+	}
+}
+
+type member struct {
+	ID     matrix.UserID
+	Name   string
+	Avatar matrix.URL
+	Level  int
+	Group  Group
+}
+
+//go:embed styles/memberlist.css
+var memberListStyle string
+var memberListCSS = cssutil.Applier("memberlist", memberListStyle)
+
+// List is a panel that lists the members of a room, grouped by their power
+// levels. Members are loaded when the list is first shown.
+type List struct {
+	*gtk.Box
+	ctx    gtkutil.Canceller
+	roomID matrix.RoomID
+
+	search  *gtk.SearchEntry
+	scroll  *gtk.ScrolledWindow
+	stack   *gtk.Stack
+	groups  *gtk.Box
+	group   [groupLen]groupBox
+	results *gtk.ListBox
+	loading *gtk.Spinner
+
+	members []member
+	shown   int
+	rows    map[matrix.UserID]*memberRow
+
+	// gen is incremented on every Invalidate, so that the results of older
+	// ones are dropped.
+	gen          uint64
+	invalidating glib.SourceHandle
+	searchCancel context.CancelFunc
+}
+
+type groupBox struct {
+	*gtk.Box
+	header *gtk.Label
+	list   *gtk.ListBox
+}
+
+// New creates a new member list for the given room.
+func New(ctx context.Context, roomID matrix.RoomID) *List {
+	l := List{
+		roomID: roomID,
+		rows:   make(map[matrix.UserID]*memberRow),
+	}
+
+	l.search = gtk.NewSearchEntry()
+	l.search.AddCSSClass("memberlist-search")
+	l.search.SetObjectProperty("placeholder-text", locale.S(ctx, "Search Members..."))
+	l.search.ConnectSearchChanged(func() { l.Search(l.search.Text()) })
+
+	l.groups = gtk.NewBox(gtk.OrientationVertical, 0)
+
+	for i := range l.group {
+		g := &l.group[i]
+
+		g.header = gtk.NewLabel("")
+		g.header.AddCSSClass("memberlist-header")
+		g.header.SetXAlign(0)
+
+		g.list = gtk.NewListBox()
+		g.list.SetSelectionMode(gtk.SelectionNone)
+
+		g.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+		g.Box.Append(g.header)
+		g.Box.Append(g.list)
+		g.Box.Hide()
+
+		l.groups.Append(g)
+	}
+
+	l.results = gtk.NewListBox()
+	l.results.SetSelectionMode(gtk.SelectionNone)
+
+	l.loading = gtk.NewSpinner()
+	l.loading.SetSizeRequest(24, 24)
+	l.loading.SetHAlign(gtk.AlignCenter)
+	l.loading.SetVAlign(gtk.AlignCenter)
+
+	l.stack = gtk.NewStack()
+	l.stack.SetVAlign(gtk.AlignStart)
+	l.stack.AddNamed(l.loading, "loading")
+	l.stack.AddNamed(l.groups, "groups")
+	l.stack.AddNamed(l.results, "results")
+
+	l.scroll = gtk.NewScrolledWindow()
+	l.scroll.SetVExpand(true)
+	l.scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	l.scroll.SetChild(l.stack)
+	l.scroll.ConnectEdgeReached(func(pos gtk.PositionType) {
+		if pos == gtk.PosBottom {
+			l.loadMore()
+		}
+	})
+
+	l.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	l.Box.Append(l.search)
+	l.Box.Append(l.scroll)
+	memberListCSS(l)
+
+	l.ctx = gtkutil.WithVisibility(ctx, l)
+
+	l.ctx.OnRenew(func(ctx context.Context) func() {
+		client := gotktrix.FromContext(ctx)
+		l.Invalidate()
+
+		events := []event.Type{event.TypeRoomMember, event.TypeRoomPowerLevels}
+		return gtkutil.FuncBatcher(
+			client.SubscribeRoomEvents(roomID, events, func(event.Event) {
+				glib.IdleAdd(l.invalidateLater)
+			}),
+			client.SubscribeUser(event.TypePresence, func(e event.Event) {
+				ev := e.(*event.PresenceEvent)
+				glib.IdleAdd(func() { l.setPresence(ev) })
+			}),
+			l.stopInvalidating,
+		)
+	})
+
+	return &l
+}
+
+// invalidateLater calls Invalidate after invalidateDelay. Calling it again
+// before then restarts the delay.
+func (l *List) invalidateLater() {
+	l.stopInvalidating()
+	l.invalidating = glib.TimeoutAdd(invalidateDelay, func() {
+		l.invalidating = 0
+		l.Invalidate()
+	})
+}
+
+func (l *List) stopInvalidating() {
+	if l.invalidating != 0 {
+		glib.SourceRemove(l.invalidating)
+		l.invalidating = 0
+	}
+}
+
+// Invalidate reloads the members of the room.
+func (l *List) Invalidate() {
+	l.stopInvalidating()
+
+	if l.members == nil {
+		l.loading.Start()
+		l.stack.SetVisibleChild(l.loading)
+	}
+
+	l.gen++
+	gen := l.gen
+
+	ctx := l.ctx.Take()
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		if err := client.RoomEnsureMembers(l.roomID); err != nil {
+			log.Printf("cannot fetch members of room %q: %v", l.roomID, err)
+		}
+
+		events, err := client.RoomMembers(l.roomID)
+		if err != nil {
+			log.Printf("cannot list members of room %q: %v", l.roomID, err)
+		}
+
+		members := sortMembers(client, l.roomID, events)
+		return func() {
+			if gen == l.gen {
+				l.setMembers(members)
+			}
+		}
+	})
+}
+
+// sortMembers converts the given member events into members sorted by their
+// groups, power levels and names. Members that left or are banned are skipped.
+func sortMembers(client *gotktrix.Client, roomID matrix.RoomID, events []event.RoomMemberEvent) []member {
+	pl := client.Offline().RoomPowerLevels(roomID)
+	members := make([]member, 0, len(events))
+
+	for _, ev := range events {
+		if ev.NewState != event.MemberJoined && ev.NewState != event.MemberInvited {
+			continue
+		}
+
+		m := member{
+			ID:     ev.UserID,
+			Avatar: ev.AvatarURL,
+			Level:  pl.UserLevel(ev.UserID),
+		}
+
+		if ev.DisplayName != nil && *ev.DisplayName != "" {
+			m.Name = *ev.DisplayName
+		} else {
+			m.Name, _, _ = ev.UserID.Parse()
+		}
+
+		m.Group = memberGroup(ev.NewState, m.Level)
+		members = append(members, m)
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Group != members[j].Group {
+			return members[i].Group < members[j].Group
+		}
+		if members[i].Level != members[j].Level {
+			return members[i].Level > members[j].Level
+		}
+		return sortutil.LessFold(members[i].Name, members[j].Name)
+	})
+
+	return members
+}
+
+// setMembers replaces the members in the list. As many members as before are
+// shown, and the rows of members that haven't changed are reused, so reloading
+// doesn't lose the user's place in the list.
+func (l *List) setMembers(members []member) {
+	shown := l.shown
+	if shown < pageSize {
+		shown = pageSize
+	}
+
+	reuse := l.rows
+	scroll := l.scroll.VAdjustment().Value()
+
+	l.loading.Stop()
+	l.members = members
+	l.shown = 0
+	l.rows = make(map[matrix.UserID]*memberRow, shown)
+
+	var counts [groupLen]int
+	for _, m := range members {
+		counts[m.Group]++
+	}
+
+	ctx := l.ctx.Take()
+
+	for i := range l.group {
+		g := &l.group[i]
+		g.header.SetLabel(fmt.Sprintf("%s — %d", Group(i).Title(ctx), counts[i]))
+		g.Box.Hide()
+		removeAll(g.list)
+	}
+
+	l.load(shown, reuse)
+	l.scroll.VAdjustment().SetValue(scroll)
+
+	if l.search.Text() == "" {
+		l.stack.SetVisibleChild(l.groups)
+	}
+}
+
+// loadMore adds the next page of members into their groups.
+func (l *List) loadMore() {
+	if l.search.Text() != "" {
+		return
+	}
+
+	l.load(l.shown+pageSize, nil)
+}
+
+// load adds the members up to end into their groups. Rows in reuse are used
+// for members that haven't changed.
+func (l *List) load(end int, reuse map[matrix.UserID]*memberRow) {
+	if end > len(l.members) {
+		end = len(l.members)
+	}
+
+	if l.shown >= end {
+		return
+	}
+
+	ctx := l.ctx.Take()
+
+	for _, m := range l.members[l.shown:end] {
+		row, ok := reuse[m.ID]
+		if !ok || row.member != m {
+			row = l.newRow(ctx, m)
+		}
+		l.rows[m.ID] = row
+
+		g := &l.group[m.Group]
+		g.Box.Show()
+		g.list.Append(row)
+	}
+
+	l.shown = end
+}
+
+// Search filters the list to show only members matching the given string. An
+// empty string shows all members again.
+func (l *List) Search(str string) {
+	if l.searchCancel != nil {
+		l.searchCancel()
+		l.searchCancel = nil
+	}
+
+	removeAll(l.results)
+
+	str = strings.TrimSpace(str)
+	if str == "" {
+		l.stack.SetVisibleChild(l.groups)
+		return
+	}
+
+	l.stack.SetVisibleChild(l.results)
+
+	ctx, cancel := context.WithCancel(l.ctx.Take())
+	l.searchCancel = cancel
+
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		searcher := client.Index.SearchRoomMember(l.roomID, searchLimit)
+		results := searcher.Search(ctx, str)
+
+		ids := make([]matrix.UserID, len(results))
+		for i, result := range results {
+			ids[i] = result.ID
+		}
+
+		return func() {
+			members := make(map[matrix.UserID]member, len(l.members))
+			for _, m := range l.members {
+				members[m.ID] = m
+			}
+
+			for _, id := range ids {
+				m, ok := members[id]
+				if !ok {
+					// The index may be more up-to-date than the list, since the
+					// list is only reloaded when it's visible.
+					m = member{ID: id, Group: Members}
+					m.Name, _, _ = id.Parse()
+				}
+				l.results.Append(l.newRow(ctx, m))
+			}
+		}
+	})
+}
+
+func (l *List) setPresence(ev *event.PresenceEvent) {
+	if row, ok := l.rows[ev.User]; ok {
+		row.setPresence(ev)
+	}
+}
+
+func removeAll(list *gtk.ListBox) {
+	for child := list.FirstChild(); child != nil; child = list.FirstChild() {
+		list.Remove(child)
+	}
+}
+
+var subtitleAttrs = textutil.Attrs(
+	pango.NewAttrScale(0.85),
+	pango.NewAttrForegroundAlpha(75*65535/100), // 75%
+)
+
+type memberRow struct {
+	*gtk.ListBoxRow
+	presence *gtk.Box
+	status   *gtk.Label
+	member   member
+}
+
+func (l *List) newRow(ctx context.Context, m member) *memberRow {
+	client := gotktrix.FromContext(ctx).Offline()
+
+	name := gtk.NewLabel("")
+	name.AddCSSClass("memberlist-name")
+	name.SetXAlign(0)
+	name.SetHExpand(true)
+	name.SetEllipsize(pango.EllipsizeEnd)
+	name.SetMarkup(mauthor.Markup(client, l.roomID, m.ID,
+		mauthor.WithWidgetColor(),
+		mauthor.WithMinimal(),
+		mauthor.WithName(m.Name),
+	))
+
+	avatar := onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, avatarSize)
+	avatar.SetInitials(m.Name)
+	avatar.SetTooltipText(string(m.ID))
+	if m.Avatar != "" {
+		avatar.SetFromURL(string(m.Avatar))
+	}
+
+	row := memberRow{member: m}
+
+	row.presence = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	row.presence.AddCSSClass("memberlist-presence")
+	row.presence.SetVAlign(gtk.AlignCenter)
+	row.presence.Hide()
+
+	row.status = gtk.NewLabel(string(m.ID))
+	row.status.AddCSSClass("memberlist-status")
+	row.status.SetXAlign(0)
+	row.status.SetEllipsize(pango.EllipsizeEnd)
+	row.status.SetAttributes(subtitleAttrs)
+
+	nameBox := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	nameBox.Append(name)
+	nameBox.Append(row.presence)
+
+	if badge := powerBadge(ctx, m.Level); badge != "" {
+		label := gtk.NewLabel(badge)
+		label.AddCSSClass("memberlist-badge")
+		label.SetVAlign(gtk.AlignCenter)
+		label.SetTooltipText(locale.Sprintf(ctx, "Power level %d", m.Level))
+		nameBox.Append(label)
+	}
+
+	rightBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	rightBox.SetVAlign(gtk.AlignCenter)
+	rightBox.Append(nameBox)
+	rightBox.Append(row.status)
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.Append(avatar)
+	box.Append(rightBox)
+
+	row.ListBoxRow = gtk.NewListBoxRow()
+	row.AddCSSClass("memberlist-member")
+	row.SetChild(box)
+
+	if m.Group == Invited {
+		row.AddCSSClass("memberlist-invited")
+	}
+
+	if ev, err := client.State.UserPresence(m.ID); err == nil {
+		row.setPresence(ev)
+	}
+
+	return &row
+}
+
+// powerBadge returns the badge shown next to members with the given power
+// level, or an empty string if they shouldn't have one.
+func powerBadge(ctx context.Context, level int) string {
+	switch {
+	case level >= 100:
+		return locale.S(ctx, "Admin")
+	case level >= 50:
+		return locale.S(ctx, "Mod")
+	case level > 0:
+		return fmt.Sprint(level)
+	default:
+		return ""
+	}
+}
+
+var presenceClasses = map[matrix.Presence]string{
+	matrix.PresenceOnline:  "memberlist-online",
+	matrix.PresenceIdle:    "memberlist-idle",
+	matrix.PresenceOffline: "memberlist-offline",
+}
+
+func (r *memberRow) setPresence(ev *event.PresenceEvent) {
+	for _, class := range presenceClasses {
+		r.RemoveCSSClass(class)
+	}
+
+	if class, ok := presenceClasses[ev.Presence]; ok {
+		r.AddCSSClass(class)
+		r.presence.Show()
+	} else {
+		r.presence.Hide()
+	}
+
+	if ev.Status != nil && *ev.Status != "" {
+		r.status.SetText(*ev.Status)
+	}
+}
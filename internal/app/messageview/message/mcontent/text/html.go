@@ -16,6 +16,7 @@ import (
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotktrix/internal/md"
+	"github.com/diamondburned/gotktrix/internal/md/tex"
 	"github.com/diamondburned/gotrix/matrix"
 	"golang.org/x/net/html"
 )
@@ -82,6 +83,14 @@ func (b htmlBox) SetExtraMenu(model gio.MenuModeller) {
 			box.SetExtraMenu(model)
 		case *codeBlock:
 			block.text.SetExtraMenu(model)
+		case *detailsBlock:
+			box := htmlBox{block.state.parent, block.state.list}
+			box.SetExtraMenu(model)
+		case *tableBlock:
+			for _, cell := range block.cells {
+				box := htmlBox{cell.parent, cell.list}
+				box.SetExtraMenu(model)
+			}
 		}
 	}
 }
@@ -151,6 +160,14 @@ func autolinkBlock(l *list.List) []string {
 			case *quoteBlock:
 				each(block.state.list)
 				continue
+			case *detailsBlock:
+				each(block.state.list)
+				continue
+			case *tableBlock:
+				for _, cell := range block.cells {
+					each(cell.list)
+				}
+				continue
 			default:
 				continue
 			}
@@ -259,7 +276,20 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 			return traverseOK
 
 		// Inline.
-		case "font", "span": // data-mx-bg-color, data-mx-color
+		case "font", "span": // data-mx-bg-color, data-mx-color, data-mx-spoiler, data-mx-maths
+			if nodeHasAttr(n, "data-mx-maths") {
+				s.renderMaths(n, false)
+				return traverseSkipChildren
+			}
+
+			if nodeHasAttr(n, "data-mx-spoiler") {
+				text := s.block.text()
+				text.spoiler(nodeAttr(n, "data-mx-spoiler"), func() {
+					s.traverseSiblings(n.FirstChild)
+				})
+				return traverseSkipChildren
+			}
+
 			tag := textutil.HashTag(s.block.table, textutil.TextTag{
 				"foreground": nodeAttr(n, "data-mx-color", "color"),
 				"background": nodeAttr(n, "data-mx-bg-color"),
@@ -269,7 +299,7 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 
 		// Inline.
 		case "h1", "h2", "h3", "h4", "h5", "h6",
-			"em", "i", "strong", "b", "u", "strike", "del", "sup", "sub", "caption":
+			"em", "i", "strong", "b", "u", "s", "strike", "del", "sup", "sub", "caption":
 			s.renderChildren(n)
 			return traverseSkipChildren
 
@@ -297,6 +327,42 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 			s.block.finalizeBlock()
 			return traverseSkipChildren
 
+		// Block Elements.
+		case "table":
+			table := s.block.grid()
+			s.renderTable(table, n)
+			table.finalize()
+
+			s.block.finalizeBlock()
+			return traverseSkipChildren
+
+		// Block Elements.
+		case "details": // open
+			var summary *html.Node
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if nodeIsData(c, nodeFlagTag|nodeFlagNoRecursive, "summary") {
+					summary = c
+					break
+				}
+			}
+
+			label := strings.TrimSpace(nodeAllText(summary))
+			if label == "" {
+				label = "Details"
+			}
+
+			details := s.block.details(label, nodeHasAttr(n, "open"))
+
+			state := s.withBlock(details.state)
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c != summary && state.renderNode(c) == traverseOK {
+					state.traverseChildren(c)
+				}
+			}
+
+			s.block.finalizeBlock()
+			return traverseSkipChildren
+
 		// Block Elements.
 		case "pre":
 			s.block.code()
@@ -304,7 +370,12 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 			s.block.finalizeBlock()
 			return traverseSkipChildren
 
-		case "p", "div":
+		case "p", "div": // data-mx-maths
+			if n.Data == "div" && nodeHasAttr(n, "data-mx-maths") {
+				s.renderMaths(n, true)
+				return traverseSkipChildren
+			}
+
 			// Only start and stop a new block if we're not already in a
 			// blockquote, since we're not nesting anything, so doing this will
 			// mess up the blockquote.
@@ -497,6 +568,70 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 	return traverseOK
 }
 
+// renderMaths renders the TeX inside the node's data-mx-maths attribute. The
+// TeX source is shown instead if it can't be rendered. The node's children are
+// only a fallback for other clients, so they're ignored.
+func (s *renderState) renderMaths(n *html.Node, display bool) {
+	src := nodeAttr(n, "data-mx-maths")
+
+	text := s.block.richText()
+	if display && !text.isNewLine() {
+		text.insertNewLines(1)
+	}
+
+	start := text.iter.Offset()
+
+	if markup, err := tex.Markup(src); err == nil {
+		text.buf.InsertMarkup(text.iter, markup)
+	} else {
+		text.tagNameBounded("code", func() { text.buf.Insert(text.iter, src) })
+	}
+
+	if display {
+		tag := textutil.HashTag(text.table, textutil.TextTag{
+			"justification": gtk.JustifyCenter,
+			"scale":         1.2,
+		})
+		text.buf.ApplyTag(tag, text.buf.IterAtOffset(start), text.iter)
+		text.endLine(n, 1)
+	}
+}
+
+// renderTable renders the rows inside the given table node into the table
+// block.
+func (s *renderState) renderTable(table *tableBlock, n *html.Node) {
+	for n := n.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != html.ElementNode {
+			continue
+		}
+
+		switch n.Data {
+		case "thead", "tbody", "tfoot":
+			s.renderTable(table, n)
+
+		case "caption":
+			// The caption is rendered after the table, since the table block
+			// is already in place.
+			s.renderChildren(n)
+
+		case "tr":
+			table.addRow()
+
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != html.ElementNode || (c.Data != "td" && c.Data != "th") {
+					continue
+				}
+
+				span := parseIntOr(nodeAttr(c, "colspan"), 1)
+				cell := table.addCell(c.Data == "th", span)
+
+				state := s.withBlock(cell)
+				state.traverseChildren(c)
+			}
+		}
+	}
+}
+
 func parseIntOr(intv string, or int) int {
 	v, _ := strconv.Atoi(intv)
 	if v <= 0 {
@@ -727,6 +862,25 @@ func nodeText(n *html.Node) string {
 	return ""
 }
 
+// nodeAllText returns all the text inside the node concatenated together.
+func nodeAllText(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		b.WriteString(nodeText(n))
+		for n := n.FirstChild; n != nil; n = n.NextSibling {
+			walk(n)
+		}
+	}
+	walk(n)
+
+	return b.String()
+}
+
 func nodeHasText(n *html.Node) bool {
 	if strings.TrimSpace(nodeText(n)) != "" {
 		return true
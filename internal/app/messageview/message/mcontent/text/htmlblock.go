@@ -159,6 +159,24 @@ func (s *currentBlockState) separator() *separatorBlock {
 	return block
 }
 
+func (s *currentBlockState) grid() *tableBlock {
+	block := newTableBlock(s)
+
+	s.element = s.list.PushBack(block)
+	s.parent.Append(block)
+
+	return block
+}
+
+func (s *currentBlockState) details(summary string, open bool) *detailsBlock {
+	block := newDetailsBlock(s, summary, open)
+
+	s.element = s.list.PushBack(block)
+	s.parent.Append(block)
+
+	return block
+}
+
 // TODO: turn quoteBlock into a Box, and implement descend+ascend for it.
 // func (s *currentBlockState) descend() {}
 // func (s *currentBlockState) ascend()  {}
@@ -173,6 +191,7 @@ type textBlock struct {
 
 	state struct {
 		hyperlink bool
+		spoiler   bool
 	}
 }
 
@@ -578,3 +597,163 @@ func newSeparatorBlock() *separatorBlock {
 	sep.AddCSSClass("mcontent-separator-block")
 	return &separatorBlock{sep}
 }
+
+// spoilerTag hides the text by drawing it in the same color as its background.
+var spoilerTag = textutil.TextTag{
+	"foreground": "#808080",
+	"background": "#808080",
+}
+
+// spoiler calls f, expecting it to write into the text block using b.iter,
+// and hides everything written until the user clicks on it. The reason, if
+// any, is written before the hidden text.
+func (b *textBlock) spoiler(reason string, f func()) {
+	if reason != "" {
+		b.tagNameBounded("caption", func() {
+			b.buf.Insert(b.iter, "("+reason+") ")
+		})
+	}
+
+	tag := spoilerTag.FromTable(b.table, "spoiler")
+	// Always draw the spoiler over everything else, including links.
+	tag.SetPriority(b.table.Size() - 1)
+
+	b.tagBounded(tag, f)
+	b.hasSpoiler()
+}
+
+// hasSpoiler binds the handler that reveals a spoiler when it's clicked.
+func (b *textBlock) hasSpoiler() {
+	if !b.flip(&b.state.spoiler) {
+		return
+	}
+
+	click := gtk.NewGestureClick()
+	click.SetButton(gdk.BUTTON_PRIMARY)
+	click.ConnectReleased(func(n int, x, y float64) {
+		bx, by := b.WindowToBufferCoords(gtk.TextWindowWidget, int(x), int(y))
+		iter, ok := b.IterAtLocation(bx, by)
+		if !ok {
+			return
+		}
+
+		tag := b.table.Lookup("spoiler")
+		if tag == nil || !iter.HasTag(tag) {
+			return
+		}
+
+		start := iter.Copy()
+		if !start.StartsTag(tag) {
+			start.BackwardToTagToggle(tag)
+		}
+
+		end := iter.Copy()
+		end.ForwardToTagToggle(tag)
+
+		b.buf.RemoveTag(tag, start, end)
+		// Keep the revealed text marked as a spoiler.
+		b.buf.ApplyTag(md.TextTags.FromTable(b.table, "_spoiler"), start, end)
+	})
+
+	b.AddController(click)
+}
+
+type tableBlock struct {
+	*gtk.ScrolledWindow
+	grid  *gtk.Grid
+	state *currentBlockState
+
+	// cells contains the states of all cells, one per cell.
+	cells []*currentBlockState
+
+	row int
+	col int
+}
+
+//go:embed styles/mcontent-table-block.css
+var tableBlockStyle string
+var tableBlockCSS = cssutil.Applier("mcontent-table-block", tableBlockStyle)
+
+func newTableBlock(s *currentBlockState) *tableBlock {
+	grid := gtk.NewGrid()
+	grid.SetHAlign(gtk.AlignStart)
+
+	// Tables are usually wider than the message column, so let them scroll
+	// horizontally instead of squishing the cells.
+	sw := gtk.NewScrolledWindow()
+	sw.SetPolicy(gtk.PolicyAutomatic, gtk.PolicyNever)
+	sw.SetPropagateNaturalHeight(true)
+	sw.SetChild(grid)
+	tableBlockCSS(sw)
+
+	return &tableBlock{
+		ScrolledWindow: sw,
+		grid:           grid,
+		state:          s,
+		row:            -1,
+	}
+}
+
+// addRow starts a new row. Cells added after this will be on that row.
+func (b *tableBlock) addRow() {
+	b.row++
+	b.col = 0
+}
+
+// addCell adds a new cell spanning the given number of columns into the
+// current row. The returned state is used to render the cell's content.
+func (b *tableBlock) addCell(header bool, span int) *currentBlockState {
+	if b.row < 0 {
+		b.addRow()
+	}
+	if span < 1 {
+		span = 1
+	}
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.AddCSSClass("mcontent-table-cell")
+	if header {
+		box.AddCSSClass("mcontent-table-header")
+	}
+
+	b.grid.Attach(box, b.col, b.row, span, 1)
+	b.col += span
+
+	cell := b.state.clone(box)
+	b.cells = append(b.cells, cell)
+
+	return cell
+}
+
+// finalize stops the text inside the cells from wrapping, since the table
+// already scrolls.
+func (b *tableBlock) finalize() {
+	for _, cell := range b.cells {
+		for n := cell.list.Front(); n != nil; n = n.Next() {
+			if text, ok := n.Value.(*textBlock); ok {
+				text.SetWrapMode(gtk.WrapNone)
+				text.SetHExpand(false)
+			}
+		}
+	}
+}
+
+type detailsBlock struct {
+	*gtk.Expander
+	state *currentBlockState
+}
+
+func newDetailsBlock(s *currentBlockState, summary string, open bool) *detailsBlock {
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.AddCSSClass("mcontent-details-content")
+
+	expander := gtk.NewExpander(summary)
+	expander.AddCSSClass("mcontent-details-block")
+	expander.SetExpanded(open)
+	expander.SetChild(box)
+
+	return &detailsBlock{
+		Expander: expander,
+		state:    s.clone(box),
+	}
+}
@@ -46,7 +46,7 @@ func bindParent(
 		actions["message.edit"] = func() { v.MessageViewer.Edit(roomEv.ID) }
 	}
 
-	canRedact := isSelf || client.HasPower(roomEv.RoomID, gotktrix.RedactAction)
+	canRedact := client.CanRedact(roomEv.RoomID, roomEv.Sender)
 	if canRedact {
 		actions["message.delete"] = func() { redactMessage(v) }
 	}
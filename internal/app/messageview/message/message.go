@@ -42,8 +42,9 @@ type MessageViewer interface {
 	ReplyTo(matrix.EventID)
 	// Edit starts the editing for given message ID.
 	Edit(matrix.EventID)
-	// ScrollTo scrolls to the given event, or if it doesn't exist, then false
-	// is returned.
+	// ScrollTo scrolls to the given event. If the event isn't shown, then the
+	// viewer may load the messages around it first. False is returned if the
+	// viewer cannot scroll to it at all.
 	ScrollTo(matrix.EventID) bool
 }
 
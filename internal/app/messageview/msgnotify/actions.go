@@ -0,0 +1,118 @@
+package msgnotify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/dialogs"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// MarkRead marks the room as read up to the message in the command and
+// withdraws the room's notification. The given context must have the client of
+// the command's user.
+func MarkRead(ctx context.Context, cmd MessageCommand) {
+	withdraw(ctx, cmd.UserID, cmd.RoomID)
+
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		if err := client.MarkRoomAsRead(cmd.RoomID, cmd.EventID); err != nil {
+			err = errors.Wrap(err, "failed to mark room as read")
+			return func() { app.Error(ctx, err) }
+		}
+		return nil
+	})
+}
+
+// Reply shows a dialog for replying to the message in the command. Once the
+// reply is sent, the room is marked as read. The given context must have the
+// client of the command's user.
+//
+// Notifications can only have buttons, so the reply is typed into a small
+// dialog instead of the notification itself.
+func Reply(ctx context.Context, cmd MessageCommand) {
+	client := gotktrix.FromContext(ctx)
+
+	entry := gtk.NewEntry()
+	entry.SetHExpand(true)
+	entry.SetVAlign(gtk.AlignCenter)
+	entry.SetPlaceholderText(locale.S(ctx, "Message"))
+
+	name, _ := client.RoomName(cmd.RoomID)
+
+	d := dialogs.NewLocalize(ctx, "Cancel", "Reply")
+	d.SetDefaultSize(350, -1)
+	d.SetTitle(locale.Sprintf(ctx, "Reply to %s", name))
+	d.SetChild(entry)
+	d.BindEnterOK()
+	d.BindCancelClose()
+
+	d.OK.ConnectClicked(func() {
+		body := entry.Text()
+		if body == "" {
+			return
+		}
+
+		d.SetSensitive(false)
+
+		gtkutil.Async(ctx, func() func() {
+			ev := newReply(client, cmd, body)
+
+			if err := client.SendRoomEvent(cmd.RoomID, ev); err != nil {
+				err = errors.Wrap(err, "failed to send reply")
+				return func() {
+					d.SetSensitive(true)
+					app.Error(ctx, err)
+				}
+			}
+
+			return func() {
+				d.Close()
+				MarkRead(ctx, cmd)
+			}
+		})
+	})
+
+	d.Show()
+}
+
+func newReply(client *gotktrix.Client, cmd MessageCommand, body string) *event.RoomMessageEvent {
+	type inReplyTo struct {
+		EventID matrix.EventID `json:"event_id"`
+	}
+
+	var relatesTo struct {
+		InReplyTo inReplyTo `json:"m.in_reply_to"`
+	}
+
+	relatesTo.InReplyTo.EventID = cmd.EventID
+
+	b, err := json.Marshal(relatesTo)
+	if err != nil {
+		log.Panicf("error marshaling relatesTo: %v", err) // bug
+	}
+
+	return &event.RoomMessageEvent{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo: event.EventInfo{
+				Type: event.TypeRoomMessage,
+			},
+			RoomID:           cmd.RoomID,
+			Sender:           client.UserID,
+			OriginServerTime: matrix.Timestamp(time.Now().UnixMilli()),
+		},
+		Body:        body,
+		MessageType: event.RoomMessageText,
+		RelatesTo:   b,
+	}
+}
@@ -2,11 +2,19 @@ package msgnotify
 
 import (
 	"context"
+	"log"
 	"strings"
+	"sync"
 
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	gioglib "github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
 	"github.com/diamondburned/gotkit/app/notify"
+	"github.com/diamondburned/gotkit/app/sounds"
 	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/imgutil"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/event"
@@ -19,56 +27,233 @@ type OpenRoomCommand struct {
 	RoomID matrix.RoomID `json:"room_id"`
 }
 
+// MessageCommand is the command structure for the actions on the latest
+// notified message of a room, such as marking it as read or replying to it.
+type MessageCommand struct {
+	UserID  matrix.UserID  `json:"user_id"`
+	RoomID  matrix.RoomID  `json:"room_id"`
+	EventID matrix.EventID `json:"event_id"`
+}
+
+// Actions contains the action IDs that notifications activate. They must be
+// application-scoped and therefore have the "app." prefix.
+type Actions struct {
+	// OpenRoom takes an OpenRoomCommand.
+	OpenRoom string
+	// MarkRead takes a MessageCommand. See MarkRead.
+	MarkRead string
+	// Reply takes a MessageCommand. See Reply.
+	Reply string
+}
+
+// maxMessages is the maximum number of messages that a room's notification
+// shows.
+const maxMessages = 5
+
+const unreadIcon = "unread-mail"
+
+// notificationID returns the ID of the notification of a room.
+func notificationID(userID matrix.UserID, roomID matrix.RoomID) notify.ID {
+	return notify.HashID("new_message", userID, roomID)
+}
+
+// roomMessages is the list of messages that a room's notification shows.
+type roomMessages struct {
+	lines  []string
+	latest matrix.EventID
+	sender matrix.UserID
+}
+
+type notifier struct {
+	ctx     context.Context
+	client  *gotktrix.Client
+	actions Actions
+
+	mu    sync.Mutex
+	rooms map[matrix.RoomID]*roomMessages
+	// sendMu serializes sending notifications, so that a notification whose
+	// icon took longer to fetch never replaces a newer one.
+	sendMu sync.Mutex
+}
+
 // StartNotify starts notifying the user for any new messages that mentions the
-// user. A stop callback is returned. actionID must be application-scoped and
-// therefore have the "app." prefix.
-func StartNotify(ctx context.Context, actionID string) (stop func()) {
-	if !strings.HasPrefix(actionID, "app.") {
-		panic("actionID does not have the app prefix")
+// user. Messages in the same room are accumulated into a single notification
+// until the room is read. A stop callback is returned.
+func StartNotify(ctx context.Context, actions Actions) (stop func()) {
+	for _, actionID := range []string{actions.OpenRoom, actions.MarkRead, actions.Reply} {
+		if !strings.HasPrefix(actionID, "app.") {
+			panic("actionID does not have the app prefix")
+		}
 	}
 
-	client := gotktrix.FromContext(ctx)
-	return client.SubscribeAllTimeline(func(ev event.RoomEvent) {
+	n := notifier{
+		ctx:     ctx,
+		client:  gotktrix.FromContext(ctx),
+		actions: actions,
+		rooms:   make(map[matrix.RoomID]*roomMessages),
+	}
+
+	return n.client.SubscribeAllTimeline(func(ev event.RoomEvent) {
 		message, ok := ev.(*event.RoomMessageEvent)
 		if !ok {
 			return
 		}
 
-		// TODO: NotifySoundMessage?
-		action := client.NotifyMessage(message, gotktrix.NotifyMessage)
-		if action == 0 {
+		if message.Sender == n.client.UserID {
+			// The user is active in the room, so they've read everything.
+			n.clear(message.RoomID)
 			return
 		}
 
-		const unreadIcon = notify.IconName("unread-mail")
-		icon := notify.Icon(unreadIcon)
-
-		avatar, _ := client.MemberAvatar(message.RoomID, message.Sender)
-		if avatar != nil {
-			avatarURL, _ := client.SquareThumbnail(*avatar, notify.MaxIconSize, 1)
-			icon = notify.IconURL{
-				Context:      ctx,
-				URL:          avatarURL,
-				FallbackIcon: unreadIcon,
-			}
+		action := n.client.NotifyMessage(message,
+			gotktrix.NotifyMessage|gotktrix.NotifySoundMessage)
+		if action == 0 {
+			return
 		}
 
-		notification := notify.Notification{
-			ID:    notify.HashID("new_message", client.UserID, message.RoomID),
-			Title: mauthor.Name(client, message.RoomID, message.Sender),
-			Body:  message.Body,
-			Icon:  icon,
-			Sound: notify.MessageSound,
-			Action: notify.Action{
-				ActionID: actionID,
-				Argument: gtkutil.NewJSONVariant(OpenRoomCommand{
-					UserID: client.UserID,
-					RoomID: message.RoomID,
-				}),
-			},
-		}
+		n.notify(message, action&gotktrix.NotifySoundMessage != 0)
+	})
+}
+
+// clear forgets the messages of the room and withdraws its notification.
+func (n *notifier) clear(roomID matrix.RoomID) {
+	n.mu.Lock()
+	_, ok := n.rooms[roomID]
+	delete(n.rooms, roomID)
+	n.mu.Unlock()
+
+	if ok {
+		withdraw(n.ctx, n.client.UserID, roomID)
+	}
+}
+
+func withdraw(ctx context.Context, userID matrix.UserID, roomID matrix.RoomID) {
+	a := app.FromContext(ctx)
+	id := string(notificationID(userID, roomID))
+	glib.IdleAdd(func() { a.WithdrawNotification(id) })
+}
+
+// add adds the message into the room's messages and returns a copy of them.
+func (n *notifier) add(message *event.RoomMessageEvent) roomMessages {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	room, ok := n.rooms[message.RoomID]
+	// Start over if the user has read the room since the last notification.
+	if !ok || n.client.RoomLatestReadEvent(message.RoomID) == room.latest {
+		room = &roomMessages{}
+		n.rooms[message.RoomID] = room
+	}
+
+	name := mauthor.Name(n.client, message.RoomID, message.Sender)
+
+	room.lines = append(room.lines, name+": "+message.Body)
+	if len(room.lines) > maxMessages {
+		room.lines = room.lines[len(room.lines)-maxMessages:]
+	}
+
+	room.latest = message.ID
+	room.sender = message.Sender
 
-		a := app.FromContext(ctx)
-		notification.Send(a)
+	cpy := *room
+	cpy.lines = append([]string(nil), room.lines...)
+	return cpy
+}
+
+func (n *notifier) notify(message *event.RoomMessageEvent, sound bool) {
+	if !notify.ShowNotification.Value() {
+		return
+	}
+
+	room := n.add(message)
+
+	var title, body string
+	if len(room.lines) == 1 {
+		title = mauthor.Name(n.client, message.RoomID, message.Sender)
+		body = message.Body
+	} else {
+		title, _ = n.client.RoomName(message.RoomID)
+		body = strings.Join(room.lines, "\n")
+	}
+
+	notification := gio.NewNotification(title)
+	notification.SetBody(body)
+
+	openRoom := gtkutil.NewJSONVariant(OpenRoomCommand{
+		UserID: n.client.UserID,
+		RoomID: message.RoomID,
 	})
+	notification.SetDefaultActionAndTarget(n.actions.OpenRoom, openRoom)
+
+	command := gtkutil.NewJSONVariant(MessageCommand{
+		UserID:  n.client.UserID,
+		RoomID:  message.RoomID,
+		EventID: room.latest,
+	})
+	notification.AddButtonWithTarget(locale.S(n.ctx, "Mark as Read"), n.actions.MarkRead, command)
+	notification.AddButtonWithTarget(locale.S(n.ctx, "Reply"), n.actions.Reply, command)
+
+	a := app.FromContext(n.ctx)
+	id := string(notificationID(n.client.UserID, message.RoomID))
+
+	if sound && notify.PlayNotificationSound.Value() {
+		glib.IdleAdd(func() { sounds.Play(a, sounds.Message) })
+	}
+
+	go func() {
+		notification.SetIcon(n.senderIcon(message.RoomID, room.sender))
+
+		n.sendMu.Lock()
+		defer n.sendMu.Unlock()
+
+		// Drop the notification if there's a newer one for the room, or if the
+		// room has been read since.
+		if !n.isLatest(message.RoomID, room.latest) {
+			return
+		}
+
+		a.SendNotification(id, notification)
+	}()
+}
+
+// isLatest returns true if the given message is still the latest one in the
+// room's notification.
+func (n *notifier) isLatest(roomID matrix.RoomID, eventID matrix.EventID) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	room, ok := n.rooms[roomID]
+	return ok && room.latest == eventID
+}
+
+// senderIcon returns the avatar of the sender, or the unread icon if there's
+// none. It fetches the avatar, so it must not be called in the main thread.
+func (n *notifier) senderIcon(roomID matrix.RoomID, sender matrix.UserID) gio.Iconner {
+	fallback := gio.NewThemedIcon(unreadIcon)
+
+	avatar, _ := n.client.MemberAvatar(roomID, sender)
+	if avatar == nil {
+		return fallback
+	}
+
+	avatarURL, _ := n.client.SquareThumbnail(*avatar, notify.MaxIconSize, 1)
+	if avatarURL == "" {
+		return fallback
+	}
+
+	ctx := imgutil.WithOpts(n.ctx, imgutil.WithRescale(notify.MaxIconSize, notify.MaxIconSize))
+
+	p, err := imgutil.GETPixbuf(ctx, avatarURL)
+	if err != nil {
+		log.Println("cannot GET notification icon URL:", err)
+		return fallback
+	}
+
+	b, err := p.SaveToBufferv("png", []string{"compression"}, []string{"0"})
+	if err != nil {
+		log.Println("cannot save notification icon URL as PNG:", err)
+		return fallback
+	}
+
+	return gio.NewBytesIcon(gioglib.NewBytesWithGo(b))
 }
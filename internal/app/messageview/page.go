@@ -19,6 +19,7 @@ import (
 	"github.com/diamondburned/gotkit/gtkutil"
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/compose"
+	"github.com/diamondburned/gotktrix/internal/app/messageview/memberlist"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
@@ -103,6 +104,11 @@ type Page struct {
 	main *adaptive.LoadablePage
 	box  *gtk.Box
 
+	// members is the member list on the right, which is only created once
+	// it's revealed.
+	members       *memberlist.List
+	membersReveal *gtk.Revealer
+
 	// moreMsgBar is the bar on top that pops up when there are new unread
 	// messages in the current room.
 	moreMsgBar  *moreMessageBar
@@ -110,12 +116,23 @@ type Page struct {
 
 	scroll *autoscroll.Window
 	list   *gtk.ListBox
+	// newer is revealed at the bottom of the list when the page is detached
+	// from the latest messages, such as after jumping to an old message.
+	newer *gtk.Revealer
 	// TODO: it might be better to refactor these maps into a map of only an
 	// event object that simultaneously has a linked anchor. This way, there's
 	// no need to keep two separate maps, and there's no need to handle small
 	// pieces of events in separate places.
 	messages map[messageKey]messageRow
 	mrelated map[matrix.EventID]matrix.EventID // keep track of reactions
+	// outbox maps transaction IDs of sending messages to their local keys.
+	outbox map[string]messageKey
+	// readers maps messages to the users who have read up to them.
+	readers map[messageKey][]matrix.UserID
+	// fullyRead is the event that the user had fully read up to when the page
+	// was opened. The new messages divider stays below it while the page is
+	// open, even after the room is marked as read.
+	fullyRead matrix.EventID
 
 	// extra is the bottom popup for typing indicators and etc.
 	extra *extraRevealer
@@ -131,7 +148,12 @@ type Page struct {
 	editing    matrix.EventID
 	replyingTo matrix.EventID
 
-	loaded bool
+	loaded   bool
+	detached bool
+	// pending holds the live events that arrived while the page is detached.
+	// They're shown once the page catches up, since they may have arrived
+	// after the last page was fetched.
+	pending []event.RoomEvent
 }
 
 type messageRow struct {
@@ -141,6 +163,7 @@ type messageRow struct {
 	custom bool
 	// these fields are changed depending on the above fields.
 	body message.Message
+	box  *messageBox
 	// before tracks the event before so we can invalidate it if we insert a new
 	// one before.
 	before matrix.EventID
@@ -162,6 +185,8 @@ const maxFetch = 40
 
 var messageviewEvents = []event.Type{
 	event.TypeTyping,
+	event.TypeRoomPowerLevels,
+	event.TypeReceipt,
 	m.FullyReadEventType,
 }
 
@@ -172,6 +197,8 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 	p := Page{
 		messages: make(map[messageKey]messageRow),
 		mrelated: make(map[matrix.EventID]matrix.EventID),
+		outbox:   make(map[string]messageKey),
+		readers:  make(map[messageKey][]matrix.UserID),
 
 		onTitle: func(string) {},
 		name:    name,
@@ -181,6 +208,10 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 		roomID: roomID,
 	}
 
+	if e, err := parent.client.Offline().RoomEvent(roomID, m.FullyReadEventType); err == nil {
+		p.fullyRead = e.(*m.FullyReadEvent).EventID
+	}
+
 	p.list = gtk.NewListBox()
 	p.list.SetSelectionMode(gtk.SelectionNone)
 	msgListCSS(p.list)
@@ -222,9 +253,28 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 		return 1 // t1 > t2
 	})
 
+	newerButton := newLoadMore(p.loadNewer)
+	newerButton.button.SetLabel(locale.S(ctx, "Newer"))
+
+	latestButton := gtk.NewButtonWithLabel(locale.S(ctx, "Jump to Latest"))
+	latestButton.AddCSSClass("messageview-loadmore-button")
+	latestButton.SetHAlign(gtk.AlignCenter)
+	latestButton.SetHasFrame(false)
+	latestButton.ConnectClicked(p.JumpToLatest)
+
+	newerBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	newerBox.Append(newerButton)
+	newerBox.Append(latestButton)
+
+	p.newer = gtk.NewRevealer()
+	p.newer.SetTransitionType(gtk.RevealerTransitionTypeSlideUp)
+	p.newer.SetRevealChild(false)
+	p.newer.SetChild(newerBox)
+
 	innerBox := gtk.NewBox(gtk.OrientationVertical, 0)
 	innerBox.Append(newLoadMore(p.loadMore))
 	innerBox.Append(p.list)
+	innerBox.Append(p.newer)
 	innerBox.SetFocusChild(p.list)
 
 	p.scroll = autoscroll.NewWindow()
@@ -263,8 +313,17 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 	p.box.SetFocusChild(p.Composer)
 	p.box.AddCSSClass("messageview-box")
 
+	p.membersReveal = gtk.NewRevealer()
+	p.membersReveal.SetTransitionType(gtk.RevealerTransitionTypeSlideLeft)
+	p.membersReveal.SetRevealChild(false)
+
+	outerBox := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	outerBox.Append(p.box)
+	outerBox.Append(p.membersReveal)
+	p.box.SetHExpand(true)
+
 	p.main = adaptive.NewLoadablePage()
-	p.main.SetChild(p.box)
+	p.main.SetChild(outerBox)
 	rhsCSS(p.main)
 
 	// main widget
@@ -283,6 +342,10 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 				switch e := e.(type) {
 				case *event.TypingEvent:
 					p.onTypingEvent(e)
+				case *event.RoomPowerLevelsEvent:
+					p.Composer.InvalidatePermissions()
+				case *event.ReceiptEvent:
+					p.invalidateReceipts()
 				case *m.FullyReadEvent:
 					p.moreMsgBar.Invalidate()
 				}
@@ -290,6 +353,14 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 		})
 	})
 
+	p.ctx.OnRenew(func(context.Context) func() {
+		return parent.client.OnOutbox(func(ev gotktrix.OutboxEvent) {
+			if ev.RoomID == roomID {
+				glib.IdleAdd(func() { p.onOutbox(ev) })
+			}
+		})
+	})
+
 	// Mark the latest message as read everytime the user scrolls down to the
 	// bottom.
 	p.scroll.OnBottomed(p.OnScrollBottomed)
@@ -318,6 +389,21 @@ func (p *Page) OnTitle(f func(string)) {
 	f(p.RoomName())
 }
 
+// SetMembersRevealed shows or hides the member list of the room.
+func (p *Page) SetMembersRevealed(revealed bool) {
+	if revealed && p.members == nil {
+		p.members = memberlist.New(p.ctx.Take(), p.roomID)
+		p.membersReveal.SetChild(p.members)
+	}
+
+	p.membersReveal.SetRevealChild(revealed)
+}
+
+// MembersRevealed returns true if the member list is shown.
+func (p *Page) MembersRevealed() bool {
+	return p.membersReveal.RevealChild()
+}
+
 // RoomID returns this room's ID.
 func (p *Page) RoomID() matrix.RoomID {
 	return p.roomID
@@ -529,6 +615,12 @@ func (p *Page) AddSendingMessage(ev event.RoomEvent) interface{} {
 	})
 
 	p.messages[key].body.SetBlur(true)
+
+	if txnID := ev.RoomInfo().Unsigned.TransactionID; txnID != "" {
+		p.outbox[txnID] = key
+		p.bindOutboxRow(row, txnID)
+	}
+
 	return key
 }
 
@@ -569,9 +661,94 @@ func (p *Page) StopSendingMessage(mark interface{}) bool {
 	delete(p.messages, key)
 	p.list.Remove(msg.row)
 
+	if txnID := msg.ev.RoomInfo().Unsigned.TransactionID; txnID != "" {
+		delete(p.outbox, txnID)
+	}
+
 	return true
 }
 
+// onOutbox updates the sending message that belongs to the given outbox event.
+func (p *Page) onOutbox(ev gotktrix.OutboxEvent) {
+	key, ok := p.outbox[ev.TxnID]
+	if !ok {
+		return
+	}
+
+	msg, ok := p.messages[key]
+	if !ok {
+		delete(p.outbox, ev.TxnID)
+		return
+	}
+
+	switch ev.Status {
+	case gotktrix.OutboxSent:
+		delete(p.outbox, ev.TxnID)
+		msg.row.RemoveCSSClass("messageview-sendfailed")
+		msg.row.SetTooltipText("")
+		p.BindSendingMessage(key, ev.EventID)
+	case gotktrix.OutboxFailed:
+		msg.row.AddCSSClass("messageview-sendfailed")
+		msg.row.SetTooltipText(locale.Sprintf(p.ctx.Take(), "Failed to send: %s", ev.Error))
+	default:
+		msg.row.RemoveCSSClass("messageview-sendfailed")
+		if ev.Error != "" {
+			msg.row.SetTooltipText(locale.Sprintf(p.ctx.Take(), "Retrying: %s", ev.Error))
+		} else {
+			msg.row.SetTooltipText("")
+		}
+	}
+}
+
+// bindOutboxRow binds the row of a sending message so that the user can either
+// retry or discard it by clicking on it if it fails to send.
+func (p *Page) bindOutboxRow(row *gtk.ListBoxRow, txnID string) {
+	client := gotktrix.FromContext(p.ctx.Take())
+
+	gtkutil.BindActionMap(row, map[string]func(){
+		"outbox.retry": func() {
+			if err := client.RetryOutbox(txnID); err != nil {
+				app.Error(p.ctx.Take(), err)
+			}
+		},
+		"outbox.discard": func() {
+			if err := client.CancelOutbox(txnID); err != nil {
+				app.Error(p.ctx.Take(), err)
+				return
+			}
+			p.StopSendingMessage(p.outbox[txnID])
+		},
+	})
+
+	click := gtk.NewGestureClick()
+	click.ConnectReleased(func(n int, x, y float64) {
+		if !row.HasCSSClass("messageview-sendfailed") {
+			return
+		}
+		gtkutil.ShowPopoverMenu(row, gtk.PosBottom, [][2]string{
+			{locale.S(p.ctx.Take(), "_Retry"), "outbox.retry"},
+			{locale.S(p.ctx.Take(), "_Discard"), "outbox.discard"},
+		})
+	})
+	row.AddController(click)
+}
+
+// loadOutbox restores the messages in this room's outbox that haven't been
+// sent yet, such as ones queued while offline before a restart.
+func (p *Page) loadOutbox(client *gotktrix.Client) {
+	for _, ev := range client.Outbox(p.roomID) {
+		if _, ok := p.outbox[ev.TxnID]; ok {
+			continue
+		}
+		if ev.Type != event.TypeRoomMessage {
+			continue
+		}
+
+		p.AddSendingMessage(client.OutboxRoomEvent(ev))
+		p.onOutbox(ev)
+	}
+}
+
 // BindSendingMessage is used after the sending message has been sent through
 // the backend, and that an event ID is returned. The page will try to match the
 // message up with an existing event.
@@ -635,6 +812,17 @@ func (p *Page) OnRoomEvent(ev event.RoomEvent) {
 		return
 	}
 
+	if p.detached {
+		// The new event isn't adjacent to what we're showing. It'll be loaded
+		// once the user pages down to it.
+		p.pending = append(p.pending, ev)
+		if len(p.pending) > maxFetch {
+			p.pending = p.pending[len(p.pending)-maxFetch:]
+		}
+		p.moreMsgBar.Invalidate()
+		return
+	}
+
 	key := p.onRoomEvent(ev)
 
 	r, ok := p.messages[key]
@@ -715,6 +903,8 @@ func (p *Page) setMessage(key messageKey, msg messageRow) {
 		// Set default focus to the last row.
 		p.list.SetFocusChild(msg.row)
 	}
+
+	p.invalidateDivider()
 }
 
 func (p *Page) resetMessageIx(ix int) bool {
@@ -752,8 +942,14 @@ func (p *Page) resetMessage(key messageKey, before messageRow) bool {
 			msg.before = beforeInfo.ID
 		}
 
+		if msg.box == nil {
+			msg.box = newMessageBox(p.parent.ctx, p.roomID)
+			msg.box.receipts.SetUsers(p.readers[key])
+		}
+		msg.box.setBody(msg.body)
+
 		p.messages[key] = msg
-		msg.row.SetChild(msg.body)
+		msg.row.SetChild(msg.box)
 	}
 
 	return true
@@ -790,6 +986,41 @@ func relatesTo(ev event.RoomEvent) matrix.EventID {
 	}
 }
 
+// invalidateReceipts reloads the read receipts of the room and shows them under
+// the messages that they point to. Receipts on related events, such as
+// reactions, are shown under the message that they relate to.
+func (p *Page) invalidateReceipts() {
+	client := gotktrix.FromContext(p.ctx.Take()).Offline()
+
+	readers := make(map[messageKey][]matrix.UserID)
+	for eventID, userIDs := range client.RoomReadReceipts(p.roomID) {
+		key := messageKeyEventID(eventID)
+		if r, ok := p.relatedEvent(eventID); ok {
+			key = messageKeyRow(r.row)
+		}
+		readers[key] = append(readers[key], userIDs...)
+	}
+
+	p.readers = readers
+
+	for key, msg := range p.messages {
+		if msg.box != nil {
+			msg.box.receipts.SetUsers(readers[key])
+		}
+	}
+}
+
+// invalidateDivider shows the new messages divider below the fully read message
+// if there are newer messages after it.
+func (p *Page) invalidateDivider() {
+	r, ok := p.relatedEvent(p.fullyRead)
+	if !ok || r.box == nil {
+		return
+	}
+
+	r.box.setDivider(r.row.Index() < p.lastRow().Index())
+}
+
 // rowAtIndex gets the messageRow at the given index. A zero-value is returned
 // if it's not found.
 func (p *Page) rowAtIndex(i int) messageRow {
@@ -816,6 +1047,8 @@ func (p *Page) Load() {
 	fetchName := p.name == ""
 
 	load := func(events []event.RoomEvent) {
+		p.invalidateReceipts()
+
 		p.main.SetChild(p.box)
 		p.list.GrabFocus()
 		p.scroll.ScrollToBottom()
@@ -846,6 +1079,10 @@ func (p *Page) Load() {
 				glib.TimeoutAddPriority(time, glib.PriorityHighIdle, load)
 			}
 		}
+
+		// Restore the messages that are still in the outbox. The list is
+		// sorted by time, so they'll end up in the right place.
+		p.loadOutbox(client)
 	}
 
 	// We can rely on this comparison to directly call Paginate on the main
@@ -888,14 +1125,21 @@ func (p *Page) Load() {
 
 func (p *Page) loadMore(done paginateDoneFunc) {
 	ctx := p.ctx.Take()
+	pager := p.pager
 
 	gtkutil.Async(ctx, func() func() {
-		events, err := p.pager.Paginate(ctx)
+		events, err := pager.Paginate(ctx)
 		if err != nil {
 			return func() { done(true, err) }
 		}
 
 		return func() {
+			if pager != p.pager {
+				// The page has jumped elsewhere.
+				done(true, nil)
+				return
+			}
+
 			keys := make([]messageKey, len(events))
 			// Require old messages first, so cozy mode works properly.
 			for i, ev := range events {
@@ -928,13 +1172,154 @@ func (p *Page) loadMore(done paginateDoneFunc) {
 	})
 }
 
-// ScrollTo implements message.MessageViewer.
+// ScrollTo implements message.MessageViewer. If the event isn't loaded, then
+// the messages around it are loaded, and the page is scrolled to it once
+// they're loaded.
 func (p *Page) ScrollTo(eventID matrix.EventID) bool {
 	m, ok := p.relatedEvent(eventID)
 	if ok {
 		return m.row.GrabFocus()
 	}
-	return false
+
+	p.jumpTo(eventID)
+	return true
+}
+
+// jumpTo replaces the page's messages with the ones around the given event.
+// The page stays detached from the latest messages until the user pages down
+// to them or jumps back to them.
+func (p *Page) jumpTo(eventID matrix.EventID) {
+	ctx := p.ctx.Take()
+	client := p.parent.client.WithContext(ctx)
+
+	p.main.SetLoading()
+
+	gtkutil.Async(ctx, func() func() {
+		ec, err := client.RoomEventContext(p.roomID, eventID, maxFetch)
+		if err != nil {
+			app.Error(ctx, err)
+			return func() { p.main.SetChild(p.box) }
+		}
+
+		return func() {
+			p.reset()
+			p.pager = p.parent.client.RoomPaginatorContext(ec, maxFetch)
+			p.setDetached(true)
+
+			for _, ev := range ec.Events() {
+				k := p.onRoomEvent(ev)
+
+				r, ok := p.messages[k]
+				if ok {
+					r.body.LoadMore()
+				}
+			}
+
+			p.main.SetChild(p.box)
+
+			if r, ok := p.relatedEvent(eventID); ok {
+				glib.IdleAdd(func() { r.row.GrabFocus() })
+			}
+		}
+	})
+}
+
+// JumpToLatest reloads the page with the latest messages if it's detached from
+// them.
+func (p *Page) JumpToLatest() {
+	if !p.detached {
+		p.scroll.ScrollToBottom()
+		return
+	}
+
+	p.reset()
+	p.pager = p.parent.client.RoomPaginator(p.roomID, maxFetch)
+	p.setDetached(false)
+
+	p.loaded = false
+	p.Load()
+}
+
+// loadNewer loads the messages after the latest shown one when the page is
+// detached.
+func (p *Page) loadNewer(done paginateDoneFunc) {
+	ctx := p.ctx.Take()
+	client := p.parent.client.WithContext(ctx)
+	pager := p.pager
+
+	gtkutil.Async(ctx, func() func() {
+		events, err := pager.PaginateForward(ctx)
+		if err != nil {
+			return func() { done(true, err) }
+		}
+
+		return func() {
+			if pager != p.pager {
+				done(true, nil)
+				return
+			}
+
+			for _, ev := range events {
+				k := p.onRoomEvent(ev)
+
+				r, ok := p.messages[k]
+				if ok {
+					r.body.LoadMore()
+				}
+			}
+
+			if pager.AtLatest() {
+				// We've caught up, so live events can be shown again. Show the
+				// ones that arrived after the last page was fetched, too.
+				pending := p.pending
+				p.setDetached(false)
+
+				for _, ev := range pending {
+					if p.hasEvent(ev.RoomInfo().ID) {
+						continue
+					}
+
+					k := p.onRoomEvent(ev)
+
+					r, ok := p.messages[k]
+					if ok {
+						r.body.LoadMore()
+					}
+				}
+
+				p.loadOutbox(client)
+			}
+
+			done(!pager.AtLatest(), nil)
+		}
+	})
+}
+
+func (p *Page) setDetached(detached bool) {
+	p.detached = detached
+	p.pending = nil
+	p.newer.SetRevealChild(detached)
+}
+
+// hasEvent returns true if the event with the given ID is already shown, either
+// as a message or as a related event.
+func (p *Page) hasEvent(eventID matrix.EventID) bool {
+	if _, ok := p.messages[messageKeyEventID(eventID)]; ok {
+		return true
+	}
+	_, ok := p.mrelated[eventID]
+	return ok
+}
+
+// reset removes all messages from the page. The composer's state is kept.
+func (p *Page) reset() {
+	for k, msg := range p.messages {
+		p.list.Remove(msg.row)
+		delete(p.messages, k)
+	}
+
+	p.mrelated = make(map[matrix.EventID]matrix.EventID)
+	p.outbox = make(map[string]messageKey)
 }
 
 // Edit triggers the input composer to edit an existing message.
@@ -0,0 +1,168 @@
+package messageview
+
+import (
+	"context"
+	_ "embed"
+	"strconv"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/app/messageview/message"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+//go:embed styles/messageview-receipts.css
+var receiptsStyle string
+var receiptsCSS = cssutil.Applier("messageview-receipts", receiptsStyle)
+
+const (
+	receiptAvatarSize = 16
+	// maxReceiptAvatars is the number of avatars shown under a message before
+	// the rest are collapsed into a counter.
+	maxReceiptAvatars = 3
+)
+
+// messageBox is the child of each message row. It holds the message along with
+// the users who have read up to it and the new messages divider.
+type messageBox struct {
+	*gtk.Box
+	body     message.Message
+	receipts *receiptStack
+	divider  *gtk.Box
+}
+
+func newMessageBox(ctx context.Context, roomID matrix.RoomID) *messageBox {
+	b := messageBox{
+		receipts: newReceiptStack(ctx, roomID),
+	}
+
+	label := gtk.NewLabel(locale.S(ctx, "New Messages"))
+	label.AddCSSClass("messageview-divider-label")
+
+	left := gtk.NewSeparator(gtk.OrientationHorizontal)
+	left.SetHExpand(true)
+	left.SetVAlign(gtk.AlignCenter)
+
+	right := gtk.NewSeparator(gtk.OrientationHorizontal)
+	right.SetHExpand(true)
+	right.SetVAlign(gtk.AlignCenter)
+
+	b.divider = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	b.divider.AddCSSClass("messageview-divider")
+	b.divider.Append(left)
+	b.divider.Append(label)
+	b.divider.Append(right)
+	b.divider.Hide()
+
+	b.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	b.Box.Append(b.receipts)
+	b.Box.Append(b.divider)
+	receiptsCSS(b)
+
+	return &b
+}
+
+// setBody replaces the message inside the box.
+func (b *messageBox) setBody(body message.Message) {
+	if b.body == body {
+		return
+	}
+	if b.body != nil {
+		b.Box.Remove(b.body)
+	}
+	b.body = body
+	b.Box.Prepend(body)
+}
+
+// setDivider shows or hides the new messages divider below the message.
+func (b *messageBox) setDivider(show bool) {
+	b.divider.SetVisible(show)
+}
+
+// receiptStack shows the avatars of the users who have read up to a message.
+type receiptStack struct {
+	*gtk.Box
+	ctx    context.Context
+	roomID matrix.RoomID
+	users  []matrix.UserID
+}
+
+func newReceiptStack(ctx context.Context, roomID matrix.RoomID) *receiptStack {
+	s := receiptStack{
+		ctx:    ctx,
+		roomID: roomID,
+	}
+
+	s.Box = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	s.Box.AddCSSClass("messageview-receipts")
+	s.Box.SetHAlign(gtk.AlignEnd)
+	s.Box.Hide()
+
+	return &s
+}
+
+// SetUsers sets the users who have read up to the message.
+func (s *receiptStack) SetUsers(users []matrix.UserID) {
+	if userIDsEq(s.users, users) {
+		return
+	}
+	s.users = users
+
+	for child := s.FirstChild(); child != nil; child = s.FirstChild() {
+		s.Remove(child)
+	}
+
+	s.SetVisible(len(users) > 0)
+	if len(users) == 0 {
+		return
+	}
+
+	client := gotktrix.FromContext(s.ctx).Offline()
+	names := make([]string, len(users))
+
+	for i, userID := range users {
+		names[i] = string(userID)
+		if name, err := client.MemberName(s.roomID, userID, false); err == nil {
+			names[i] = name.Name
+		}
+
+		if i >= maxReceiptAvatars {
+			continue
+		}
+
+		avatar := onlineimage.NewAvatar(s.ctx, gotktrix.AvatarProvider, receiptAvatarSize)
+		avatar.AddCSSClass("messageview-receipt")
+		avatar.SetInitials(names[i])
+
+		mxc, _ := client.MemberAvatar(s.roomID, userID)
+		if mxc != nil {
+			avatar.SetFromURL(string(*mxc))
+		}
+
+		s.Append(avatar)
+	}
+
+	if more := len(users) - maxReceiptAvatars; more > 0 {
+		label := gtk.NewLabel("+" + strconv.Itoa(more))
+		label.AddCSSClass("messageview-receipts-more")
+		s.Append(label)
+	}
+
+	s.SetTooltipText(locale.Sprintf(s.ctx, "Read by %s", strings.Join(names, ", ")))
+}
+
+func userIDsEq(a, b []matrix.UserID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
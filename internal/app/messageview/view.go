@@ -18,6 +18,9 @@ type View struct {
 	client *gotktrix.Client
 
 	current *Page
+	// members is true if the member list should be shown. It's kept across
+	// rooms.
+	members bool
 }
 
 // This is used for tabs, but we're not implementing tabs for now.
@@ -82,6 +85,7 @@ func (v *View) openRoom(id matrix.RoomID, newTab bool) *Page {
 	}
 
 	page := NewPage(v.ctx, v, id)
+	page.SetMembersRevealed(v.members)
 	page.Load()
 
 	gtk.BaseWidget(page).SetName(string(id))
@@ -97,6 +101,15 @@ func (v *View) openRoom(id matrix.RoomID, newTab bool) *Page {
 	return page
 }
 
+// SetMembersRevealed shows or hides the member list of the current and future
+// pages.
+func (v *View) SetMembersRevealed(revealed bool) {
+	v.members = revealed
+	if v.current != nil {
+		v.current.SetMembersRevealed(revealed)
+	}
+}
+
 // Current returns the current page or nil if none.
 func (v *View) Current() *Page {
 	return v.current
@@ -7,7 +7,9 @@ import (
 
 	"github.com/diamondburned/gotk4/pkg/core/glib"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/gtkutil"
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/app/roomlist/invites"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist/room"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist/space"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
@@ -26,7 +28,8 @@ type Browser struct {
 		buttons map[matrix.RoomID]spaceButton
 	}
 
-	ctx context.Context
+	ctx  context.Context
+	ctrl space.Controller
 }
 
 //go:embed styles/roomlist-spaces.css
@@ -40,9 +43,10 @@ var spacesRevealerCSS = cssutil.Applier("roomlist-spaces-revealer", spacesReveal
 
 // New creates a new spaces browser.
 func New(ctx context.Context, ctrl space.Controller) *Browser {
-	b := Browser{ctx: ctx}
+	b := Browser{ctx: ctx, ctrl: ctrl}
 	b.list = space.New(ctx, ctrl)
 	b.list.SetVExpand(true)
+	b.list.AddHeader(invites.New(ctx, &b))
 
 	allRooms := NewAllRoomsButton(ctx)
 	allRooms.SetActive(true)
@@ -129,6 +133,25 @@ func (b *Browser) InvalidateRooms() {
 	}()
 }
 
+// JoinedRoom adds the room that the user just joined through an invite and
+// opens it.
+func (b *Browser) JoinedRoom(roomID matrix.RoomID) {
+	client := gotktrix.FromContext(b.ctx)
+
+	gtkutil.Async(b.ctx, func() func() {
+		typ := client.RoomType(roomID)
+
+		return func() {
+			b.addRoom(roomID, typ)
+			b.list.InvalidateSections()
+
+			if typ == "" {
+				b.ctrl.OpenRoom(roomID)
+			}
+		}
+	})
+}
+
 func (b *Browser) addRoom(roomID matrix.RoomID, typ string) {
 	switch typ {
 	case "":
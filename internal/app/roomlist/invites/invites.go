@@ -0,0 +1,286 @@
+// Package invites provides the section of the room list that shows the rooms
+// that the user is invited to.
+package invites
+
+import (
+	"context"
+	_ "embed"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// AvatarSize is the size of the room avatars.
+const AvatarSize = 32
+
+// Controller describes the parent widget that the section controls.
+type Controller interface {
+	// JoinedRoom is called after the user accepts the invite to the room.
+	JoinedRoom(matrix.RoomID)
+}
+
+//go:embed styles/invites.css
+var invitesStyle string
+var invitesCSS = cssutil.Applier("invites", invitesStyle)
+
+// Section is the section of invited rooms. It's hidden when there are none.
+type Section struct {
+	*gtk.Box
+	ctx  context.Context
+	ctrl Controller
+
+	// inner holds the header and the list. It's hidden instead of the
+	// section itself, so that the section stays mapped and subscribed.
+	inner  *gtk.Box
+	header *gtk.Label
+	list   *gtk.ListBox
+	rows   map[matrix.RoomID]*inviteRow
+}
+
+// New creates a new invites section.
+func New(ctx context.Context, ctrl Controller) *Section {
+	s := Section{
+		ctx:  ctx,
+		ctrl: ctrl,
+		rows: make(map[matrix.RoomID]*inviteRow),
+	}
+
+	s.header = gtk.NewLabel(locale.S(ctx, "Invites"))
+	s.header.AddCSSClass("invites-header")
+	s.header.SetXAlign(0)
+
+	s.list = gtk.NewListBox()
+	s.list.SetSelectionMode(gtk.SelectionNone)
+
+	s.inner = gtk.NewBox(gtk.OrientationVertical, 0)
+	s.inner.Append(s.header)
+	s.inner.Append(s.list)
+	s.inner.Hide()
+	invitesCSS(s.inner)
+
+	s.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	s.Box.Append(s.inner)
+
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.BindSubscribe(s.Box, func() func() {
+		// Syncs may have been missed while unmapped.
+		s.Invalidate()
+
+		return client.OnSync(func(sync *api.SyncResponse) {
+			if len(sync.Rooms.Invited) > 0 || len(sync.Rooms.Joined) > 0 || len(sync.Rooms.Left) > 0 {
+				glib.IdleAdd(s.Invalidate)
+			}
+		})
+	})
+
+	return &s
+}
+
+type invite struct {
+	roomID  matrix.RoomID
+	name    string
+	topic   string
+	avatar  *matrix.URL
+	inviter string
+}
+
+// Invalidate reloads the invites from the state.
+func (s *Section) Invalidate() {
+	client := gotktrix.FromContext(s.ctx).Offline()
+
+	gtkutil.Async(s.ctx, func() func() {
+		roomIDs := client.Invites()
+		invites := make([]invite, len(roomIDs))
+
+		for i, roomID := range roomIDs {
+			inv := invite{roomID: roomID}
+			inv.name, _ = client.RoomName(roomID)
+			inv.avatar, _ = client.RoomAvatar(roomID)
+
+			if e, err := client.RoomState(roomID, event.TypeRoomTopic, ""); err == nil {
+				inv.topic = e.(*event.RoomTopicEvent).Topic
+			}
+
+			if inviter := client.RoomInviter(roomID); inviter != "" {
+				name, err := client.MemberName(roomID, inviter, false)
+				if err == nil && name.Name != "" {
+					inv.inviter = name.Name
+				} else {
+					inv.inviter = string(inviter)
+				}
+			}
+
+			invites[i] = inv
+		}
+
+		return func() { s.setInvites(invites) }
+	})
+}
+
+func (s *Section) setInvites(invites []invite) {
+	known := make(map[matrix.RoomID]bool, len(invites))
+
+	for _, inv := range invites {
+		known[inv.roomID] = true
+
+		row, ok := s.rows[inv.roomID]
+		if !ok {
+			row = s.newRow(inv.roomID)
+			s.rows[inv.roomID] = row
+			s.list.Append(row)
+		}
+
+		row.update(inv)
+	}
+
+	for id, row := range s.rows {
+		if !known[id] {
+			s.list.Remove(row)
+			delete(s.rows, id)
+		}
+	}
+
+	s.inner.SetVisible(len(s.rows) > 0)
+}
+
+func (s *Section) remove(roomID matrix.RoomID) {
+	row, ok := s.rows[roomID]
+	if !ok {
+		return
+	}
+
+	s.list.Remove(row)
+	delete(s.rows, roomID)
+
+	s.inner.SetVisible(len(s.rows) > 0)
+}
+
+var inviterAttrs = textutil.Attrs(
+	pango.NewAttrScale(0.85),
+	pango.NewAttrForegroundAlpha(75*65535/100), // 75%
+)
+
+type inviteRow struct {
+	*gtk.ListBoxRow
+	ctx     context.Context
+	avatar  *onlineimage.Avatar
+	name    *gtk.Label
+	inviter *gtk.Label
+	actions *gtk.Box
+}
+
+func (s *Section) newRow(roomID matrix.RoomID) *inviteRow {
+	ctx := s.ctx
+	row := inviteRow{ctx: ctx}
+
+	row.name = gtk.NewLabel(string(roomID))
+	row.name.AddCSSClass("invites-name")
+	row.name.SetXAlign(0)
+	row.name.SetEllipsize(pango.EllipsizeEnd)
+
+	row.inviter = gtk.NewLabel("")
+	row.inviter.AddCSSClass("invites-inviter")
+	row.inviter.SetXAlign(0)
+	row.inviter.SetEllipsize(pango.EllipsizeEnd)
+	row.inviter.SetAttributes(inviterAttrs)
+
+	row.avatar = onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, AvatarSize)
+	row.avatar.ConnectLabel(row.name)
+
+	accept := gtk.NewButtonFromIconName("object-select-symbolic")
+	accept.AddCSSClass("suggested-action")
+	accept.SetTooltipText(locale.S(ctx, "Accept"))
+	accept.ConnectClicked(func() { s.respond(roomID, row.actions, true) })
+
+	decline := gtk.NewButtonFromIconName("window-close-symbolic")
+	decline.AddCSSClass("destructive-action")
+	decline.SetTooltipText(locale.S(ctx, "Decline"))
+	decline.ConnectClicked(func() { s.respond(roomID, row.actions, false) })
+
+	row.actions = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	row.actions.AddCSSClass("invites-actions")
+	row.actions.SetVAlign(gtk.AlignCenter)
+	row.actions.Append(accept)
+	row.actions.Append(decline)
+
+	textBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	textBox.SetHExpand(true)
+	textBox.SetVAlign(gtk.AlignCenter)
+	textBox.Append(row.name)
+	textBox.Append(row.inviter)
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.Append(row.avatar)
+	box.Append(textBox)
+	box.Append(row.actions)
+
+	row.ListBoxRow = gtk.NewListBoxRow()
+	row.AddCSSClass("invites-room")
+	row.SetChild(box)
+
+	return &row
+}
+
+func (r *inviteRow) update(inv invite) {
+	r.name.SetLabel(inv.name)
+	r.avatar.SetInitials(inv.name)
+
+	if inv.avatar != nil {
+		r.avatar.SetFromURL(string(*inv.avatar))
+	}
+
+	if inv.inviter != "" {
+		r.inviter.SetLabel(locale.Sprintf(r.ctx, "Invited by %s", inv.inviter))
+		r.inviter.Show()
+	} else {
+		r.inviter.Hide()
+	}
+
+	if inv.topic != "" {
+		r.SetTooltipText(inv.name + "\n" + inv.topic)
+	} else {
+		r.SetTooltipText(inv.name)
+	}
+}
+
+// respond accepts or declines the invite to the given room.
+func (s *Section) respond(roomID matrix.RoomID, actions *gtk.Box, accept bool) {
+	actions.SetSensitive(false)
+
+	ctx := s.ctx
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		var err error
+		if accept {
+			err = client.AcceptInvite(roomID)
+		} else {
+			err = client.DeclineInvite(roomID)
+		}
+
+		return func() {
+			if err != nil {
+				actions.SetSensitive(true)
+				app.Error(ctx, err)
+				return
+			}
+
+			s.remove(roomID)
+			if accept {
+				s.ctrl.JoinedRoom(roomID)
+			}
+		}
+	})
+}
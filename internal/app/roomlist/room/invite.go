@@ -0,0 +1,212 @@
+package room
+
+import (
+	"context"
+	_ "embed"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/dialogs"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+//go:embed styles/room-invite.css
+var inviteStyle string
+var inviteCSS = cssutil.Applier("room-invite", inviteStyle)
+
+const (
+	inviteSearchLimit = 20
+	// inviteSearchDelay is the delay after typing before the user directory
+	// is searched, so that the server isn't asked for every keystroke.
+	inviteSearchDelay = 350 // ms
+)
+
+var inviteIDAttrs = textutil.Attrs(
+	pango.NewAttrScale(0.85),
+	pango.NewAttrForegroundAlpha(75*65535/100), // 75%
+)
+
+// promptInvite shows a dialog for inviting a user into the room.
+func (r *Room) promptInvite() {
+	ctx := r.ctx.Take()
+	client := gotktrix.FromContext(ctx)
+
+	entry := gtk.NewSearchEntry()
+	entry.SetObjectProperty("placeholder-text", locale.S(ctx, "Search for a name or @user:server..."))
+
+	results := gtk.NewListBox()
+	results.SetSelectionMode(gtk.SelectionSingle)
+	results.SetActivateOnSingleClick(false)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetVExpand(true)
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetChild(results)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(entry)
+	box.Append(scroll)
+	inviteCSS(box)
+
+	dialog := dialogs.NewLocalize(ctx, "Cancel", "Invite")
+	dialog.SetDefaultSize(400, 450)
+	dialog.SetChild(box)
+	dialog.SetTitle(locale.Sprintf(ctx, "Invite to %s", r.Name))
+	dialog.OK.SetSensitive(false)
+
+	var users []api.User
+	var searchCancel context.CancelFunc
+	var searchSource glib.SourceHandle
+
+	setUsers := func(newUsers []api.User) {
+		for child := results.FirstChild(); child != nil; child = results.FirstChild() {
+			results.Remove(child)
+		}
+
+		users = newUsers
+		for _, user := range users {
+			results.Append(newInviteRow(ctx, user))
+		}
+
+		dialog.OK.SetSensitive(false)
+	}
+
+	search := func(query string) {
+		// Show a raw user ID immediately, since the directory usually only has
+		// users that share a room with us.
+		var list []api.User
+		if strings.HasPrefix(query, "@") {
+			if _, _, err := matrix.UserID(query).Parse(); err == nil {
+				list = []api.User{{ID: matrix.UserID(query)}}
+			}
+		}
+		setUsers(list)
+
+		if query == "" {
+			return
+		}
+
+		searchCtx, cancel := context.WithCancel(ctx)
+		searchCancel = cancel
+
+		gtkutil.Async(searchCtx, func() func() {
+			found, _, err := client.WithContext(searchCtx).UserSearch(query, inviteSearchLimit)
+			if err != nil {
+				return func() { app.Error(ctx, err) }
+			}
+
+			return func() {
+				for _, user := range found {
+					if len(list) == 0 || user.ID != list[0].ID {
+						list = append(list, user)
+					}
+				}
+				setUsers(list)
+			}
+		})
+	}
+
+	entry.ConnectSearchChanged(func() {
+		if searchCancel != nil {
+			searchCancel()
+			searchCancel = nil
+		}
+		if searchSource != 0 {
+			glib.SourceRemove(searchSource)
+			searchSource = 0
+		}
+
+		query := strings.TrimSpace(entry.Text())
+		searchSource = glib.TimeoutAdd(inviteSearchDelay, func() {
+			searchSource = 0
+			search(query)
+		})
+	})
+
+	results.ConnectRowSelected(func(row *gtk.ListBoxRow) {
+		dialog.OK.SetSensitive(row != nil)
+	})
+
+	invite := func() {
+		row := results.SelectedRow()
+		if row == nil {
+			return
+		}
+
+		userID := users[row.Index()].ID
+		dialog.Close()
+
+		gtkutil.Async(ctx, func() func() {
+			if err := client.InviteUser(r.ID, userID); err != nil {
+				return func() { app.Error(ctx, err) }
+			}
+			return nil
+		})
+	}
+
+	results.ConnectRowActivated(func(*gtk.ListBoxRow) { invite() })
+	dialog.OK.ConnectClicked(invite)
+
+	dialog.Cancel.ConnectClicked(func() {
+		dialog.Close()
+	})
+
+	dialog.ConnectCloseRequest(func() bool {
+		if searchCancel != nil {
+			searchCancel()
+		}
+		if searchSource != 0 {
+			glib.SourceRemove(searchSource)
+		}
+		return false
+	})
+
+	dialog.Show()
+}
+
+func newInviteRow(ctx context.Context, user api.User) *gtk.ListBoxRow {
+	name, _, _ := user.ID.Parse()
+	if user.DisplayName != nil && *user.DisplayName != "" {
+		name = *user.DisplayName
+	}
+
+	nameLabel := gtk.NewLabel(name)
+	nameLabel.SetXAlign(0)
+	nameLabel.SetEllipsize(pango.EllipsizeEnd)
+
+	idLabel := gtk.NewLabel(string(user.ID))
+	idLabel.SetXAlign(0)
+	idLabel.SetEllipsize(pango.EllipsizeEnd)
+	idLabel.SetAttributes(inviteIDAttrs)
+
+	avatar := onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, AvatarSize)
+	avatar.SetInitials(name)
+	if user.AvatarURL != nil {
+		avatar.SetFromURL(string(*user.AvatarURL))
+	}
+
+	textBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	textBox.SetVAlign(gtk.AlignCenter)
+	textBox.Append(nameLabel)
+	textBox.Append(idLabel)
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.Append(avatar)
+	box.Append(textBox)
+
+	row := gtk.NewListBoxRow()
+	row.AddCSSClass("room-invite-user")
+	row.SetChild(box)
+
+	return row
+}
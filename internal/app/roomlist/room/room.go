@@ -183,23 +183,37 @@ func AddTo(ctx context.Context, section Section, roomID matrix.RoomID) *Room {
 		"room.open-in-tab":     func() { section.OpenRoomInTab(roomID) },
 		"room.prompt-reorder":  func() { r.promptReorder() },
 		"room.move-to-section": nil,
+		"room.notify-level":    nil,
+		"room.invite":          func() { r.promptInvite() },
 		"room.add-emojis":      func() { emojiview.ForRoom(r.ctx.Take(), r.ID) },
 	})
 
 	gtkutil.BindRightClick(r, func() {
 		s := locale.SFunc(ctx)
 
-		p := gtkutil.NewPopoverMenuCustom(r, gtk.PosBottom, []gtkutil.PopoverMenuItem{
+		items := []gtkutil.PopoverMenuItem{
 			gtkutil.MenuItem(s("Open"), "room.open"),
 			gtkutil.MenuItem(s("Open in New Tab"), "room.open-in-tab"),
+		}
+
+		if gotktrix.FromContext(ctx).Offline().HasPower(roomID, gotktrix.InviteAction) {
+			items = append(items, gtkutil.MenuItem(s("Invite People..."), "room.invite"))
+		}
+
+		items = append(items,
 			gtkutil.MenuSeparator(s("Section")),
 			gtkutil.MenuItem(s("Reorder Room..."), "room.prompt-reorder"),
 			gtkutil.Submenu(s("Move to Section..."), []gtkutil.PopoverMenuItem{
 				gtkutil.MenuWidget("room.move-to-section", r.moveToSectionBox()),
 			}),
+			gtkutil.Submenu(s("Notifications..."), []gtkutil.PopoverMenuItem{
+				gtkutil.MenuWidget("room.notify-level", r.notifyLevelBox()),
+			}),
 			gtkutil.MenuSeparator(s("Emojis")),
 			gtkutil.MenuItem(s("Add Emojis..."), "room.add-emojis"),
-		})
+		)
+
+		p := gtkutil.NewPopoverMenuCustom(r, gtk.PosBottom, items)
 		p.SetAutohide(true)
 		p.SetCascadePopdown(true)
 		gtkutil.PopupFinally(p)
@@ -307,8 +321,15 @@ func (r *Room) invalidatePreview(ctx context.Context) func() {
 
 	unread, _ := client.RoomCountUnread(r.ID)
 	notifications := client.State.RoomNotificationCount(r.ID)
+	muted := client.RoomNotifyLevel(r.ID) == gotktrix.RoomNotifyMute
 
 	return func() {
+		if muted {
+			r.AddCSSClass("room-muted")
+		} else {
+			r.RemoveCSSClass("room-muted")
+		}
+
 		// Only show the unread bar if we have unread messages, not unread
 		// any other events. We can do this by a comparison check: if there
 		// are less events than unread messages, then there's an unread
@@ -535,3 +556,76 @@ func (r *Room) moveToSectionBox() gtk.Widgetter {
 
 	return box
 }
+
+//go:embed styles/room-notifylevel.css
+var notifyLevelStyle string
+var notifyLevelCSS = cssutil.Applier("room-notifylevel", notifyLevelStyle)
+
+// notifyLevels are the notification levels in the order that they're shown.
+var notifyLevels = []gotktrix.RoomNotifyLevel{
+	gotktrix.RoomNotifyDefault,
+	gotktrix.RoomNotifyAll,
+	gotktrix.RoomNotifyMentions,
+	gotktrix.RoomNotifyMute,
+}
+
+func (r *Room) notifyLevelBox() gtk.Widgetter {
+	ctx := r.ctx.Take()
+	client := gotktrix.FromContext(ctx).Offline()
+
+	header := gtk.NewLabel(locale.S(ctx, "Notify for"))
+	header.SetXAlign(0)
+	header.SetAttributes(textutil.Attrs(
+		pango.NewAttrWeight(pango.WeightBold),
+	))
+
+	current := client.RoomNotifyLevel(r.ID)
+
+	radio := gtkutil.RadioData{
+		Options: []string{
+			locale.S(ctx, "Default"),
+			locale.S(ctx, "All Messages"),
+			locale.S(ctx, "Mentions and Keywords"),
+			locale.S(ctx, "Nothing (Mute)"),
+		},
+	}
+
+	for i, level := range notifyLevels {
+		if level == current {
+			radio.Current = i
+		}
+	}
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(header)
+	box.Append(gtkutil.NewRadioButtons(radio, func(i int) {
+		// The current level is also toggled when the buttons are made.
+		if notifyLevels[i] == current {
+			return
+		}
+		current = notifyLevels[i]
+		r.SetNotifyLevel(current)
+	}))
+	notifyLevelCSS(box)
+
+	return box
+}
+
+// SetNotifyLevel sets the room's notification level.
+func (r *Room) SetNotifyLevel(level gotktrix.RoomNotifyLevel) {
+	ctx := r.ctx.Take()
+	if ctx.Err() != nil {
+		return
+	}
+
+	gtkutil.Async(ctx, func() func() {
+		client := gotktrix.FromContext(ctx)
+
+		if err := client.SetRoomNotifyLevel(r.ID, level); err != nil {
+			app.Error(ctx, errors.Wrap(err, "failed to set notification level"))
+			return nil
+		}
+
+		return func() { r.InvalidatePreview(ctx) }
+	})
+}
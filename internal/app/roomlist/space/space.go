@@ -32,6 +32,7 @@ type List struct {
 	search    string
 
 	scroll *gtk.ScrolledWindow
+	top    *gtk.Box // contains headers and outer
 	outer  *adaptive.Bin
 	inner  *gtk.Box // contains sections
 
@@ -71,12 +72,14 @@ func New(ctx context.Context, ctrl Controller) *List {
 		sections: make([]*section.Section, 0, 10),
 	}
 
-	listCSS(l.outer)
+	l.top = gtk.NewBox(gtk.OrientationVertical, 0)
+	l.top.Append(l.outer)
+	listCSS(l.top)
 
 	l.scroll = gtk.NewScrolledWindow()
 	l.scroll.SetVExpand(true)
 	l.scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
-	l.scroll.SetChild(l.outer)
+	l.scroll.SetChild(l.top)
 
 	searchEntry := gtk.NewSearchEntry()
 	searchEntry.SetHExpand(true)
@@ -107,6 +110,12 @@ func New(ctx context.Context, ctrl Controller) *List {
 	return &l
 }
 
+// AddHeader adds the given widget above the room sections. It's used for
+// sections that aren't made of joined rooms, such as invites.
+func (l *List) AddHeader(w gtk.Widgetter) {
+	l.top.InsertChildAfter(w, gtk.BaseWidget(l.outer).PrevSibling())
+}
+
 // SpaceID returns the ID of the currently displayed space. If it's empty, then
 // the list will show all rooms, i.e. no filtering is done.
 func (l *List) SpaceID() matrix.RoomID {
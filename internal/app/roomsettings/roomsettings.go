@@ -0,0 +1,417 @@
+// Package roomsettings provides a dialog for changing a room's name, topic,
+// avatar, join rules and history visibility.
+package roomsettings
+
+import (
+	"context"
+	_ "embed"
+	"os"
+	"path/filepath"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/dialogs"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/components/filepick"
+	"github.com/diamondburned/gotktrix/internal/components/uploadutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+	"golang.org/x/text/message"
+)
+
+// AvatarSize is the size of the room avatar in the dialog.
+const AvatarSize = 64
+
+//go:embed styles/roomsettings.css
+var settingsStyle string
+var settingsCSS = cssutil.Applier("roomsettings", settingsStyle)
+
+type joinRule struct {
+	rule event.JoinRule
+	name message.Reference
+}
+
+var joinRules = []joinRule{
+	{event.JoinPublic, "Anyone can join"},
+	{event.JoinInvite, "Invite only"},
+	{event.JoinKnock, "Ask to join"},
+}
+
+type historyVisibility struct {
+	visibility event.HistoryVisibility
+	name       message.Reference
+}
+
+var historyVisibilities = []historyVisibility{
+	{event.VisibilityWorldReadable, "Anyone"},
+	{event.VisibilityShared, "Members only"},
+	{event.VisibilityInvited, "Members only, since they were invited"},
+	{event.VisibilityJoined, "Members only, since they joined"},
+}
+
+// settings is the part of the room state that the dialog can change.
+type settings struct {
+	name    string
+	topic   string
+	avatar  matrix.URL
+	join    event.JoinRule
+	history event.HistoryVisibility
+}
+
+func loadSettings(client *gotktrix.Client, roomID matrix.RoomID) settings {
+	s := settings{
+		join:    event.JoinInvite,
+		history: event.VisibilityShared,
+	}
+
+	if e, err := client.RoomState(roomID, event.TypeRoomName, ""); err == nil {
+		s.name = e.(*event.RoomNameEvent).Name
+	}
+	if e, err := client.RoomState(roomID, event.TypeRoomTopic, ""); err == nil {
+		s.topic = e.(*event.RoomTopicEvent).Topic
+	}
+	if e, err := client.RoomState(roomID, event.TypeRoomAvatar, ""); err == nil {
+		s.avatar = e.(*event.RoomAvatarEvent).URL
+	}
+	if e, err := client.RoomState(roomID, event.TypeRoomJoinRules, ""); err == nil {
+		s.join = e.(*event.RoomJoinRulesEvent).JoinRule
+	}
+	if e, err := client.RoomState(roomID, event.TypeRoomHistoryVisibility, ""); err == nil {
+		s.history = e.(*event.RoomHistoryVisibilityEvent).Visibility
+	}
+
+	return s
+}
+
+func stateInfo(typ event.Type) event.StateEventInfo {
+	return event.StateEventInfo{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo: event.EventInfo{Type: typ},
+		},
+	}
+}
+
+// changes returns the state events that change old into s.
+func (s settings) changes(old settings) []event.StateEvent {
+	var events []event.StateEvent
+
+	if s.name != old.name {
+		events = append(events, &event.RoomNameEvent{
+			StateEventInfo: stateInfo(event.TypeRoomName),
+			Name:           s.name,
+		})
+	}
+	if s.topic != old.topic {
+		events = append(events, &event.RoomTopicEvent{
+			StateEventInfo: stateInfo(event.TypeRoomTopic),
+			Topic:          s.topic,
+		})
+	}
+	if s.avatar != old.avatar {
+		events = append(events, &event.RoomAvatarEvent{
+			StateEventInfo: stateInfo(event.TypeRoomAvatar),
+			URL:            s.avatar,
+		})
+	}
+	if s.join != old.join {
+		events = append(events, &event.RoomJoinRulesEvent{
+			StateEventInfo: stateInfo(event.TypeRoomJoinRules),
+			JoinRule:       s.join,
+		})
+	}
+	if s.history != old.history {
+		events = append(events, &event.RoomHistoryVisibilityEvent{
+			StateEventInfo: stateInfo(event.TypeRoomHistoryVisibility),
+			Visibility:     s.history,
+		})
+	}
+
+	return events
+}
+
+// Dialog is the room settings dialog.
+type Dialog struct {
+	*dialogs.Dialog
+	ctx    context.Context
+	roomID matrix.RoomID
+	old    settings
+
+	avatar   *onlineimage.Avatar
+	upload   *gtk.Button
+	progress *uploadutil.ProgressBar
+	name     *gtk.Entry
+	topic    *gtk.TextView
+	join     *gtk.DropDown
+	history  *gtk.DropDown
+
+	// joinRules and histories are the choices of the dropdowns. They contain
+	// the room's current values even if the dialog doesn't know them.
+	joinRules []joinRule
+	histories []historyVisibility
+
+	avatarURL matrix.URL
+}
+
+// Show shows a new settings dialog for the given room.
+func Show(ctx context.Context, roomID matrix.RoomID) *Dialog {
+	d := New(ctx, roomID)
+	d.Show()
+	return d
+}
+
+// New creates a new settings dialog for the given room. Fields that the user
+// doesn't have the power to change are made insensitive.
+func New(ctx context.Context, roomID matrix.RoomID) *Dialog {
+	client := gotktrix.FromContext(ctx).Offline()
+
+	d := Dialog{
+		ctx:    ctx,
+		roomID: roomID,
+		old:    loadSettings(client, roomID),
+	}
+	d.avatarURL = d.old.avatar
+
+	canSend := func(typ event.Type) bool {
+		return client.CanSendEvent(roomID, typ, true)
+	}
+
+	d.avatar = onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, AvatarSize)
+	d.avatar.AddCSSClass("roomsettings-avatar")
+	d.avatar.SetHAlign(gtk.AlignCenter)
+	d.avatar.SetInitials(d.old.name)
+	if d.old.avatar != "" {
+		d.avatar.SetFromURL(string(d.old.avatar))
+	}
+
+	d.upload = gtk.NewButtonWithLabel(locale.S(ctx, "Change Avatar..."))
+	d.upload.SetHAlign(gtk.AlignCenter)
+	d.upload.SetSensitive(canSend(event.TypeRoomAvatar))
+	d.upload.ConnectClicked(d.chooseAvatar)
+
+	d.progress = uploadutil.NewProgressBar()
+	d.progress.Hide()
+
+	d.name = gtk.NewEntry()
+	d.name.SetText(d.old.name)
+	d.name.SetSensitive(canSend(event.TypeRoomName))
+	d.name.ConnectChanged(func() { d.avatar.SetInitials(d.name.Text()) })
+
+	d.topic = gtk.NewTextView()
+	d.topic.AddCSSClass("roomsettings-topic")
+	d.topic.SetWrapMode(gtk.WrapWordChar)
+	d.topic.SetAcceptsTab(false)
+	d.topic.Buffer().SetText(d.old.topic)
+	d.topic.SetSensitive(canSend(event.TypeRoomTopic))
+
+	joinIx := -1
+	d.joinRules = append([]joinRule(nil), joinRules...)
+	for i, rule := range d.joinRules {
+		if rule.rule == d.old.join {
+			joinIx = i
+		}
+	}
+	if joinIx == -1 {
+		// Keep rules that we don't know about, such as restricted, so that
+		// saving doesn't change them.
+		joinIx = len(d.joinRules)
+		d.joinRules = append(d.joinRules, joinRule{d.old.join, string(d.old.join)})
+	}
+
+	joinNames := make([]string, len(d.joinRules))
+	for i, rule := range d.joinRules {
+		joinNames[i] = locale.S(ctx, rule.name)
+	}
+
+	d.join = gtk.NewDropDownFromStrings(joinNames)
+	d.join.SetSelected(uint(joinIx))
+	d.join.SetSensitive(canSend(event.TypeRoomJoinRules))
+
+	historyIx := -1
+	d.histories = append([]historyVisibility(nil), historyVisibilities...)
+	for i, vis := range d.histories {
+		if vis.visibility == d.old.history {
+			historyIx = i
+		}
+	}
+	if historyIx == -1 {
+		historyIx = len(d.histories)
+		d.histories = append(d.histories, historyVisibility{d.old.history, string(d.old.history)})
+	}
+
+	historyNames := make([]string, len(d.histories))
+	for i, vis := range d.histories {
+		historyNames[i] = locale.S(ctx, vis.name)
+	}
+
+	d.history = gtk.NewDropDownFromStrings(historyNames)
+	d.history.SetSelected(uint(historyIx))
+	d.history.SetSensitive(canSend(event.TypeRoomHistoryVisibility))
+
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(6)
+	grid.SetColumnSpacing(8)
+
+	for i, field := range []struct {
+		label message.Reference
+		w     gtk.Widgetter
+	}{
+		{"Name", d.name},
+		{"Topic", d.topic},
+		{"Who can join", d.join},
+		{"Who can read history", d.history},
+	} {
+		label := gtk.NewLabel(locale.S(ctx, field.label))
+		label.SetXAlign(1)
+		label.SetVAlign(gtk.AlignStart)
+		label.AddCSSClass("roomsettings-label")
+
+		gtk.BaseWidget(field.w).SetHExpand(true)
+		grid.Attach(label, 0, i, 1, 1)
+		grid.Attach(field.w, 1, i, 1, 1)
+	}
+
+	box := gtk.NewBox(gtk.OrientationVertical, 6)
+	box.Append(d.avatar)
+	box.Append(d.upload)
+	box.Append(d.progress)
+	box.Append(grid)
+	settingsCSS(box)
+
+	name, _ := client.RoomName(roomID)
+
+	d.Dialog = dialogs.NewLocalize(ctx, "Cancel", "Save")
+	d.Dialog.SetDefaultSize(450, -1)
+	d.Dialog.SetTitle(locale.Sprintf(ctx, "%s Settings", name))
+	d.Dialog.SetChild(box)
+	d.Dialog.BindCancelClose()
+	d.Dialog.OK.ConnectClicked(d.save)
+
+	return &d
+}
+
+func (d *Dialog) current() settings {
+	buf := d.topic.Buffer()
+	start, end := buf.Bounds()
+
+	s := settings{
+		name:    d.name.Text(),
+		topic:   buf.Text(start, end, false),
+		avatar:  d.avatarURL,
+		join:    d.old.join,
+		history: d.old.history,
+	}
+
+	if ix := d.join.Selected(); ix < uint(len(d.joinRules)) {
+		s.join = d.joinRules[ix].rule
+	}
+	if ix := d.history.Selected(); ix < uint(len(d.histories)) {
+		s.history = d.histories[ix].visibility
+	}
+
+	return s
+}
+
+func (d *Dialog) chooseAvatar() {
+	filter := gtk.NewFileFilter()
+	filter.AddMIMEType("image/*")
+
+	chooser := filepick.NewLocalize(
+		d.ctx, "Choose Avatar", gtk.FileChooserActionOpen, "Upload", "Cancel")
+	chooser.AddFilter(filter)
+	chooser.ConnectAccept(func() {
+		list := chooser.Files()
+		if list.NItems() == 0 {
+			return
+		}
+
+		f := gio.File{Object: list.Item(0)}
+		d.uploadAvatar(f.Path())
+	})
+	chooser.Show()
+}
+
+func (d *Dialog) setUploading(uploading bool) {
+	d.upload.SetSensitive(!uploading)
+	d.OK.SetSensitive(!uploading)
+}
+
+// uploadAvatar uploads the file at the given path. The room's avatar is only
+// changed once the user saves.
+func (d *Dialog) uploadAvatar(path string) {
+	ctx := d.ctx
+	client := gotktrix.FromContext(ctx)
+
+	d.setUploading(true)
+	d.progress.Reset()
+	d.progress.Show()
+
+	onError := func(err error) {
+		d.progress.Error()
+		d.setUploading(false)
+		app.Error(ctx, errors.Wrap(err, "failed to upload avatar"))
+	}
+
+	go func() {
+		f, err := os.Open(path)
+		if err != nil {
+			glib.IdleAdd(func() { onError(err) })
+			return
+		}
+		defer f.Close()
+
+		if s, _ := f.Stat(); s != nil {
+			d.progress.SetTotal(s.Size())
+		}
+
+		r := uploadutil.WrapProgressReader(d.progress, f)
+		defer r.Close()
+
+		u, err := uploadutil.Upload(client.WithContext(ctx), r, filepath.Base(path))
+		if err != nil {
+			glib.IdleAdd(func() { onError(err) })
+			return
+		}
+
+		glib.IdleAdd(func() {
+			d.avatarURL = u
+			d.avatar.SetFromURL(string(u))
+			d.progress.Hide()
+			d.setUploading(false)
+		})
+	}()
+}
+
+func (d *Dialog) save() {
+	events := d.current().changes(d.old)
+	if len(events) == 0 {
+		d.Close()
+		return
+	}
+
+	ctx := d.ctx
+	client := gotktrix.FromContext(ctx)
+	roomID := d.roomID
+
+	d.Dialog.SetSensitive(false)
+
+	gtkutil.Async(ctx, func() func() {
+		for _, ev := range events {
+			if err := client.SendRoomEvent(roomID, ev); err != nil {
+				err = errors.Wrapf(err, "failed to set %s", ev.Info().Type)
+				return func() {
+					d.Dialog.SetSensitive(true)
+					app.Error(ctx, err)
+				}
+			}
+		}
+
+		return func() { d.Close() }
+	})
+}
@@ -0,0 +1,287 @@
+// Package sessions provides a dialog that lists the devices that the user is
+// logged in on. Devices can be renamed and signed out from there.
+package sessions
+
+import (
+	"context"
+	_ "embed"
+	"sort"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/dialogs"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/app/auth"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+//go:embed styles/sessions.css
+var sessionsStyle string
+var sessionsCSS = cssutil.Applier("sessions", sessionsStyle)
+
+var infoAttrs = textutil.Attrs(
+	pango.NewAttrScale(0.9),
+	pango.NewAttrForegroundAlpha(65535*75/100), // 75%
+)
+
+// errCancelled is returned when the user cancels authenticating.
+var errCancelled = errors.New("cancelled")
+
+// Dialog is the sessions dialog.
+type Dialog struct {
+	*dialogs.Dialog
+	ctx context.Context
+
+	list   *gtk.ListBox
+	status *gtk.Label
+}
+
+// Show shows a new sessions dialog.
+func Show(ctx context.Context) *Dialog {
+	d := New(ctx)
+	d.Show()
+	return d
+}
+
+// New creates a new sessions dialog. The devices are loaded asynchronously.
+func New(ctx context.Context) *Dialog {
+	d := Dialog{ctx: ctx}
+
+	d.status = gtk.NewLabel("")
+	d.status.SetXAlign(0)
+	d.status.SetWrap(true)
+	d.status.SetWrapMode(pango.WrapWordChar)
+	d.status.AddCSSClass("sessions-error")
+	d.status.Hide()
+
+	d.list = gtk.NewListBox()
+	d.list.SetSelectionMode(gtk.SelectionNone)
+	d.list.AddCSSClass("sessions-list")
+
+	box := gtk.NewBox(gtk.OrientationVertical, 6)
+	box.Append(d.status)
+	box.Append(d.list)
+	sessionsCSS(box)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetVExpand(true)
+	scroll.SetChild(box)
+
+	d.Dialog = dialogs.NewLocalize(ctx, "Close", "Refresh")
+	d.Dialog.SetDefaultSize(400, 450)
+	d.Dialog.SetTitle(locale.S(ctx, "Sessions"))
+	d.Dialog.SetChild(scroll)
+	d.Dialog.BindCancelClose()
+	d.Dialog.OK.ConnectClicked(d.refresh)
+
+	d.refresh()
+	return &d
+}
+
+// refresh reloads the list of devices.
+func (d *Dialog) refresh() {
+	client := gotktrix.FromContext(d.ctx)
+
+	d.OK.SetSensitive(false)
+
+	gtkutil.Async(d.ctx, func() func() {
+		devices, err := client.Devices()
+
+		return func() {
+			d.OK.SetSensitive(true)
+
+			if err != nil {
+				d.status.SetText(err.Error())
+				d.status.Show()
+				return
+			}
+
+			d.status.Hide()
+			d.setDevices(devices, client.DeviceID)
+		}
+	})
+}
+
+func (d *Dialog) setDevices(devices []gotktrix.Device, current matrix.DeviceID) {
+	for child := d.list.FirstChild(); child != nil; child = d.list.FirstChild() {
+		d.list.Remove(child)
+	}
+
+	// Show the current device first, then the most recently seen ones.
+	sort.SliceStable(devices, func(i, j int) bool {
+		if (devices[i].ID == current) != (devices[j].ID == current) {
+			return devices[i].ID == current
+		}
+		return devices[i].LastSeenTS > devices[j].LastSeenTS
+	})
+
+	for _, device := range devices {
+		d.list.Append(d.newRow(device, device.ID == current))
+	}
+}
+
+func (d *Dialog) newRow(device gotktrix.Device, current bool) *gtk.ListBoxRow {
+	committed := device.DisplayName
+
+	name := gtk.NewEditableLabel(committed)
+	name.SetHExpand(true)
+	name.SetTooltipText(locale.S(d.ctx, "Click to rename"))
+	name.NotifyProperty("editing", func() {
+		if name.Editing() || name.Text() == committed {
+			return
+		}
+
+		newName := name.Text()
+		d.rename(device.ID, newName, func(err error) {
+			if err != nil {
+				name.SetText(committed)
+				app.Error(d.ctx, err)
+				return
+			}
+			committed = newName
+		})
+	})
+
+	info := gtk.NewLabel(deviceInfo(d.ctx, device))
+	info.SetXAlign(0)
+	info.SetEllipsize(pango.EllipsizeEnd)
+	info.SetAttributes(infoAttrs)
+
+	grid := gtk.NewGrid()
+	grid.SetColumnSpacing(6)
+	grid.Attach(name, 0, 0, 1, 1)
+	grid.Attach(info, 0, 1, 1, 1)
+
+	row := gtk.NewListBoxRow()
+	row.SetActivatable(false)
+	row.SetChild(grid)
+
+	if current {
+		this := gtk.NewLabel(locale.S(d.ctx, "This device"))
+		this.SetVAlign(gtk.AlignCenter)
+		this.AddCSSClass("sessions-current")
+		grid.Attach(this, 1, 0, 1, 2)
+		return row
+	}
+
+	signOut := gtk.NewButtonFromIconName("system-log-out-symbolic")
+	signOut.SetHasFrame(false)
+	signOut.SetVAlign(gtk.AlignCenter)
+	signOut.SetTooltipText(locale.S(d.ctx, "Sign Out"))
+	signOut.ConnectClicked(func() {
+		// Require a second click to confirm.
+		if !signOut.HasCSSClass("destructive-action") {
+			signOut.AddCSSClass("destructive-action")
+			signOut.SetTooltipText(locale.S(d.ctx, "Click again to sign out this device"))
+			return
+		}
+
+		signOut.SetSensitive(false)
+		d.signOut(device.ID, func(err error) {
+			if err == nil {
+				d.list.Remove(row)
+				return
+			}
+
+			signOut.SetSensitive(true)
+			signOut.RemoveCSSClass("destructive-action")
+			signOut.SetTooltipText(locale.S(d.ctx, "Sign Out"))
+
+			if !errors.Is(err, errCancelled) {
+				app.Error(d.ctx, err)
+			}
+		})
+	})
+	grid.Attach(signOut, 1, 0, 1, 2)
+
+	return row
+}
+
+// deviceInfo describes the device ID, and where and when it was last seen.
+func deviceInfo(ctx context.Context, device gotktrix.Device) string {
+	parts := []string{string(device.ID)}
+	if device.LastSeenIP != "" {
+		parts = append(parts, device.LastSeenIP)
+	}
+	if t := device.LastSeen(); !t.IsZero() {
+		parts = append(parts, locale.Sprintf(ctx, "last seen %s", locale.TimeAgo(ctx, t)))
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+func (d *Dialog) rename(id matrix.DeviceID, name string, done func(error)) {
+	client := gotktrix.FromContext(d.ctx)
+
+	gtkutil.Async(d.ctx, func() func() {
+		err := client.RenameDevice(id, name)
+		return func() { done(err) }
+	})
+}
+
+func (d *Dialog) signOut(id matrix.DeviceID, done func(error)) {
+	client := gotktrix.FromContext(d.ctx)
+
+	gtkutil.Async(d.ctx, func() func() {
+		uia, err := client.DeleteDevices([]matrix.DeviceID{id})
+		return func() { d.authenticate(uia, err, done) }
+	})
+}
+
+// authenticate completes the stages of the interactive auth until the server
+// accepts the request, then calls done. Only the password and dummy stages are
+// supported.
+func (d *Dialog) authenticate(uia *gotktrix.InteractiveAuth, err error, done func(error)) {
+	if err != nil {
+		done(err)
+		return
+	}
+
+	if uia.Done() {
+		done(nil)
+		return
+	}
+
+	stages := uia.NextStages(matrix.LoginPassword, matrix.LoginDummy)
+	if len(stages) == 0 {
+		done(errors.New("server requires an unsupported authentication method"))
+		return
+	}
+
+	client := gotktrix.FromContext(d.ctx)
+
+	switch stages[0] {
+	case matrix.LoginDummy:
+		gtkutil.Async(d.ctx, func() func() {
+			err := uia.AuthDummy()
+			return func() { d.authenticate(uia, err, done) }
+		})
+
+	case matrix.LoginPassword:
+		auth.PromptPassword(
+			&d.Dialog.Dialog.Window,
+			locale.S(d.ctx, "Sign Out"),
+			locale.S(d.ctx, "Enter your password to confirm:"),
+			locale.S(d.ctx, "Sign Out"),
+			func(password string) {
+				if password == "" {
+					done(errCancelled)
+					return
+				}
+
+				gtkutil.Async(d.ctx, func() func() {
+					err := uia.AuthPassword(client.UserID, password)
+					return func() { d.authenticate(uia, err, done) }
+				})
+			},
+		)
+	}
+}
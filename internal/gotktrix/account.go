@@ -0,0 +1,59 @@
+package gotktrix
+
+import (
+	"os"
+
+	"github.com/diamondburned/gotrix"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// IsUnknownToken returns true if the error is an M_UNKNOWN_TOKEN error, which
+// the server returns once the access token is revoked, such as when the session
+// is logged out elsewhere. soft is true if the server allows logging back in
+// while keeping the cached data.
+func IsUnknownToken(err error) (unknown, soft bool) {
+	var apiErr matrix.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != matrix.CodeUnknownToken {
+		return false, false
+	}
+	return true, apiErr.SoftLogout
+}
+
+// Logout invalidates the given access token on the server without loading the
+// user's state. Tokens that are already invalid are not an error.
+func Logout(serverName, token string, opts Opts) error {
+	opts.init()
+
+	c, err := gotrix.NewWithClient(opts.Client, serverName)
+	if err != nil {
+		return err
+	}
+
+	c.AccessToken = token
+
+	if err := c.Logout(); err != nil {
+		if unknown, _ := IsUnknownToken(err); unknown {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RemoveUserData deletes the state and search index of the given user. It
+// must not be called while a client for the user is open.
+func RemoveUserData(userID matrix.UserID, opts Opts) error {
+	opts.init()
+
+	b64Username := Base64UserID(userID)
+
+	for _, dir := range []string{"matrix-state", "matrix-index"} {
+		if err := os.RemoveAll(opts.ConfigPath.ConfigPath(dir, b64Username)); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", dir)
+		}
+	}
+
+	return nil
+}
@@ -3,22 +3,69 @@ package gotktrix
 import (
 	"context"
 
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/discovery"
 	"github.com/diamondburned/gotrix"
 	"github.com/diamondburned/gotrix/api"
 	"github.com/diamondburned/gotrix/matrix"
 )
 
+// Versions describes the spec versions and unstable features that a homeserver
+// supports.
+type Versions = discovery.Versions
+
+// HomeserverError is returned when a homeserver cannot be used. It unwraps to
+// either ErrUnreachable or ErrNotMatrix.
+type HomeserverError = discovery.HomeserverError
+
+// Errors that HomeserverError unwraps to.
+var (
+	ErrUnreachable = discovery.ErrUnreachable
+	ErrNotMatrix   = discovery.ErrNotMatrix
+)
+
 // ClientAuth holds a partial client.
 type ClientAuth struct {
 	c *gotrix.Client
 	o Opts
+	v *Versions
 }
 
-// Discover wraps around gotrix.DiscoverWithClienT.
+// Discover resolves the server name into its homeserver using
+// /.well-known/matrix/client, then checks that the homeserver is reachable and
+// speaks Matrix using /versions. The server name may also be the address of
+// the homeserver itself.
 func Discover(serverName string, opts Opts) (*ClientAuth, error) {
 	opts.init()
 
-	c, err := gotrix.DiscoverWithClient(opts.Client, serverName)
+	baseURL, v, err := discovery.Resolve(opts.Client, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAuth(baseURL.String(), v, opts)
+}
+
+// NewAuth creates a ClientAuth for a homeserver whose base URL is already
+// known, such as one from a previous login, skipping discovery. The homeserver
+// is still checked using /versions.
+func NewAuth(serverURL string, opts Opts) (*ClientAuth, error) {
+	opts.init()
+
+	baseURL, err := discovery.ParseServerName(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := discovery.FetchVersions(opts.Client, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAuth(baseURL.String(), v, opts)
+}
+
+func newAuth(baseURL string, v *Versions, opts Opts) (*ClientAuth, error) {
+	c, err := gotrix.NewWithClient(opts.Client, baseURL)
 	if err != nil {
 		return nil, err
 	}
@@ -26,14 +73,22 @@ func Discover(serverName string, opts Opts) (*ClientAuth, error) {
 	return &ClientAuth{
 		c: c,
 		o: opts,
+		v: v,
 	}, nil
 }
 
+// Versions returns the spec versions and unstable features that the
+// homeserver supports.
+func (a *ClientAuth) Versions() *Versions {
+	return a.v
+}
+
 // WithContext creates a copy of ClientAuth that uses the provided context.
 func (a *ClientAuth) WithContext(ctx context.Context) *ClientAuth {
 	return &ClientAuth{
 		c: a.c.WithContext(ctx),
 		o: a.o,
+		v: a.v,
 	}
 }
 
@@ -52,7 +107,7 @@ func (a *ClientAuth) LoginPassword(username, password string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return wrapClient(a.c, a.o)
+	return wrapClient(a.c, a.o, a.v)
 }
 
 // LoginToken authenticates the client using the provided token.
@@ -65,7 +120,7 @@ func (a *ClientAuth) LoginToken(token string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return wrapClient(a.c, a.o)
+	return wrapClient(a.c, a.o, a.v)
 }
 
 // LoginSSO returns the HTTP address for logging in as SSO and the channel
@@ -82,7 +137,7 @@ func (a *ClientAuth) LoginSSO(done func(*Client, error)) (string, error) {
 			return
 		}
 
-		done(wrapClient(a.c, a.o))
+		done(wrapClient(a.c, a.o, a.v))
 	}()
 
 	return address, nil
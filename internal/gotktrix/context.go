@@ -0,0 +1,177 @@
+package gotktrix
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// EventContext is a piece of a room's timeline around an event.
+type EventContext struct {
+	RoomID matrix.RoomID
+	// Event is the event that the context is around.
+	Event event.RoomEvent
+	// Before and After are the events around Event, latest last.
+	Before []event.RoomEvent
+	After  []event.RoomEvent
+
+	start  string
+	end    string
+	stored bool
+}
+
+// Events returns all events in the context, latest last.
+func (ec *EventContext) Events() []event.RoomEvent {
+	events := make([]event.RoomEvent, 0, len(ec.Before)+len(ec.After)+1)
+	events = append(events, ec.Before...)
+	events = append(events, ec.Event)
+	events = append(events, ec.After...)
+	return events
+}
+
+// RoomEventContext returns the events around the given event. Limit is the
+// maximum number of events around it, which is split between both sides.
+// Events in the stored timeline are used if possible. Otherwise, the API is
+// queried, and the result is stored as a detached timeline segment, which is
+// used if the API can't be reached later.
+//
+// Use RoomPaginatorContext to paginate further from the returned context.
+func (c *Client) RoomEventContext(
+	roomID matrix.RoomID, eventID matrix.EventID, limit int) (*EventContext, error) {
+
+	if ev := c.storedTimelineEvent(roomID, eventID); ev != nil {
+		before, _ := c.State.RoomTimelineBefore(roomID, ev, limit/2)
+		after, _ := c.State.RoomTimelineAfter(roomID, ev, limit-limit/2)
+
+		// Flip the events before, since they're latest first.
+		for i, j := 0, len(before)-1; i < j; i, j = i+1, j-1 {
+			before[i], before[j] = before[j], before[i]
+		}
+
+		return &EventContext{
+			RoomID: roomID,
+			Event:  ev,
+			Before: before,
+			After:  after,
+			stored: true,
+		}, nil
+	}
+
+	r, err := c.roomContext(roomID, eventID, limit)
+	if err != nil {
+		seg, segErr := c.State.RoomSegment(roomID, eventID)
+		if segErr != nil {
+			return nil, err
+		}
+		return segmentContext(roomID, eventID, seg), nil
+	}
+
+	// events_before is latest first, so flip it.
+	before := r.EventsBefore
+	for i, j := 0, len(before)-1; i < j; i, j = i+1, j-1 {
+		before[i], before[j] = before[j], before[i]
+	}
+
+	seg := state.TimelineSegment{
+		EventID: eventID,
+		Event:   r.Event,
+		Before:  before,
+		After:   r.EventsAfter,
+		Start:   r.Start,
+		End:     r.End,
+	}
+
+	if err := c.State.AddRoomSegment(roomID, seg); err != nil {
+		return nil, errors.Wrap(err, "failed to save context")
+	}
+
+	c.State.AddRoomEvents(roomID, r.State)
+
+	ec := segmentContext(roomID, eventID, seg)
+
+	// Index the fetched events so that they're searchable.
+	batch := c.Index.Begin()
+	batch.IndexTimeline(ec.Events())
+	batch.Commit()
+
+	return ec, nil
+}
+
+// segmentContext returns the context around the given event within the
+// segment. The segment may have been fetched around a different event, so its
+// events are split again around eventID.
+func segmentContext(roomID matrix.RoomID, eventID matrix.EventID, seg state.TimelineSegment) *EventContext {
+	events := make([]event.RoomEvent, 0, len(seg.Before)+len(seg.After)+1)
+	events = append(events, sys.ParseAllTimeline(seg.Before, roomID)...)
+	events = append(events, sys.ParseTimeline(seg.Event, roomID))
+	events = append(events, sys.ParseAllTimeline(seg.After, roomID)...)
+
+	// Fall back to the event that the segment was fetched around.
+	at := len(seg.Before)
+	for i, ev := range events {
+		if ev.RoomInfo().ID == eventID {
+			at = i
+			break
+		}
+	}
+
+	return &EventContext{
+		RoomID: roomID,
+		Event:  events[at],
+		Before: events[:at:at],
+		After:  events[at+1:],
+		start:  seg.Start,
+		end:    seg.End,
+	}
+}
+
+// storedTimelineEvent returns the event with the given ID from the stored
+// timeline, or nil if it's not stored.
+func (c *Client) storedTimelineEvent(roomID matrix.RoomID, eventID matrix.EventID) event.RoomEvent {
+	var found event.RoomEvent
+
+	c.State.EachTimelineReverse(roomID, func(ev event.RoomEvent) error {
+		if ev.RoomInfo().ID == eventID {
+			found = ev
+			return EachBreak
+		}
+		return nil
+	})
+
+	return found
+}
+
+// roomContextResponse is the response of the /context endpoint.
+type roomContextResponse struct {
+	Start        string           `json:"start"`
+	End          string           `json:"end"`
+	Event        event.RawEvent   `json:"event"`
+	EventsBefore []event.RawEvent `json:"events_before"`
+	EventsAfter  []event.RawEvent `json:"events_after"`
+	State        []event.RawEvent `json:"state"`
+}
+
+// roomContext queries the events around the given event. Limit may be 0, in
+// which case only the event and the pagination tokens around it are returned.
+func (c *Client) roomContext(
+	roomID matrix.RoomID, eventID matrix.EventID, limit int) (roomContextResponse, error) {
+
+	var resp roomContextResponse
+
+	err := c.Request(
+		"GET", c.Endpoints.Room(roomID)+"/context/"+url.PathEscape(string(eventID)), &resp,
+		httputil.WithToken(),
+		httputil.WithQuery(map[string]string{"limit": strconv.Itoa(limit)}),
+	)
+	if err != nil {
+		return resp, errors.Wrapf(err, "failed to get context of event %q", eventID)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,65 @@
+package gotktrix
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func TestSegmentContext(t *testing.T) {
+	const roomID matrix.RoomID = "!room:example.com"
+
+	raw := func(id matrix.EventID) event.RawEvent {
+		return event.RawEvent(fmt.Sprintf(`{
+			"type": "m.room.message",
+			"event_id": %q,
+			"room_id": %q,
+			"sender": "@alice:example.com",
+			"content": {"msgtype": "m.text", "body": "hello"}
+		}`, id, roomID))
+	}
+
+	seg := state.TimelineSegment{
+		EventID: "$3",
+		Event:   raw("$3"),
+		Before:  []event.RawEvent{raw("$1"), raw("$2")},
+		After:   []event.RawEvent{raw("$4"), raw("$5")},
+	}
+
+	ids := func(events []event.RoomEvent) []matrix.EventID {
+		ids := make([]matrix.EventID, len(events))
+		for i, ev := range events {
+			ids[i] = ev.RoomInfo().ID
+		}
+		return ids
+	}
+
+	tests := []struct {
+		eventID matrix.EventID
+		before  []matrix.EventID
+		after   []matrix.EventID
+	}{
+		{"$3", []matrix.EventID{"$1", "$2"}, []matrix.EventID{"$4", "$5"}},
+		{"$1", []matrix.EventID{}, []matrix.EventID{"$2", "$3", "$4", "$5"}},
+		{"$4", []matrix.EventID{"$1", "$2", "$3"}, []matrix.EventID{"$5"}},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.eventID), func(t *testing.T) {
+			ec := segmentContext(roomID, test.eventID, seg)
+
+			if id := ec.Event.RoomInfo().ID; id != test.eventID {
+				t.Errorf("expected context around %s, got %s", test.eventID, id)
+			}
+			if got := ids(ec.Before); fmt.Sprint(got) != fmt.Sprint(test.before) {
+				t.Errorf("expected before %v, got %v", test.before, got)
+			}
+			if got := ids(ec.After); fmt.Sprint(got) != fmt.Sprint(test.after) {
+				t.Errorf("expected after %v, got %v", test.after, got)
+			}
+		})
+	}
+}
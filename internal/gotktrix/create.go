@@ -0,0 +1,173 @@
+package gotktrix
+
+import (
+	"net/http"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// CreateRoomOpts describes a room to be created using CreateRoom.
+type CreateRoomOpts struct {
+	Name  string
+	Topic string
+	// Alias is the local part of the room's alias, which is the part between
+	// the # and the server name. The room has no alias if it's empty.
+	Alias string
+	// Public publishes the room in the server's room directory.
+	Public bool
+	// Preset sets the join rules, history visibility and power levels of the
+	// room. The server picks one if it's empty.
+	Preset api.RoomPreset
+	// Encrypted enables end-to-end encryption in the room. It can never be
+	// disabled afterwards.
+	Encrypted bool
+	// Space creates a space instead of a room.
+	Space bool
+	// Direct marks the room as a direct message with the first invited user.
+	Direct bool
+	// Invite is the list of users to invite into the room.
+	Invite []matrix.UserID
+	// Parent is the space that the room is added to, if any.
+	Parent matrix.RoomID
+}
+
+// createRoomStateEvent is an initial state event. gotrix's RoomCreateArg only
+// marshals the content of these events, so it can't be used.
+type createRoomStateEvent struct {
+	Type     event.Type  `json:"type"`
+	StateKey string      `json:"state_key"`
+	Content  interface{} `json:"content"`
+}
+
+type createRoomRequest struct {
+	Visibility      api.RoomVisibility     `json:"visibility,omitempty"`
+	AliasName       string                 `json:"room_alias_name,omitempty"`
+	Name            string                 `json:"name,omitempty"`
+	Topic           string                 `json:"topic,omitempty"`
+	Invite          []matrix.UserID        `json:"invite,omitempty"`
+	InitialState    []createRoomStateEvent `json:"initial_state,omitempty"`
+	Preset          api.RoomPreset         `json:"preset,omitempty"`
+	IsDirect        bool                   `json:"is_direct,omitempty"`
+	CreationContent map[string]interface{} `json:"creation_content,omitempty"`
+}
+
+const (
+	// roomEncryptionEventType is the type of the m.room.encryption state
+	// event, which gotrix doesn't have.
+	roomEncryptionEventType event.Type = "m.room.encryption"
+	// megolmAlgorithm is the encryption algorithm used for new encrypted
+	// rooms.
+	megolmAlgorithm = "m.megolm.v1.aes-sha2"
+)
+
+// CreateRoom creates a new room using the given options. If the room has a
+// parent space, then it's added into the space as well.
+func (c *Client) CreateRoom(opts CreateRoomOpts) (matrix.RoomID, error) {
+	_, server, err := c.UserID.Parse()
+	if err != nil {
+		return "", errors.Wrap(err, "invalid user ID")
+	}
+
+	req := createRoomRequest{
+		Visibility: api.RoomPrivate,
+		AliasName:  opts.Alias,
+		Name:       opts.Name,
+		Topic:      opts.Topic,
+		Invite:     opts.Invite,
+		Preset:     opts.Preset,
+		IsDirect:   opts.Direct,
+	}
+
+	if opts.Public {
+		req.Visibility = api.RoomPublic
+	}
+
+	if opts.Space {
+		req.CreationContent = map[string]interface{}{"type": "m.space"}
+	}
+
+	if opts.Encrypted {
+		req.InitialState = append(req.InitialState, createRoomStateEvent{
+			Type:    roomEncryptionEventType,
+			Content: map[string]string{"algorithm": megolmAlgorithm},
+		})
+	}
+
+	if opts.Parent != "" {
+		req.InitialState = append(req.InitialState, createRoomStateEvent{
+			Type:     m.SpaceParentEventType,
+			StateKey: string(opts.Parent),
+			Content: m.SpaceParentEvent{
+				Via:       []string{server},
+				Canonical: true,
+			},
+		})
+	}
+
+	var resp struct {
+		RoomID matrix.RoomID `json:"room_id"`
+	}
+
+	err = c.Request(
+		"POST", c.Endpoints.RoomCreate(), &resp,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create room")
+	}
+
+	if opts.Parent != "" {
+		_, err := c.Client.RoomStateSend(opts.Parent, api.RoomStateSendArg{
+			Type:     m.SpaceChildEventType,
+			StateKey: string(resp.RoomID),
+			Content:  m.SpaceChildEvent{Via: []string{server}},
+		})
+		if err != nil {
+			return resp.RoomID, errors.Wrap(err, "room created but failed to add it to the space")
+		}
+	}
+
+	if opts.Direct && len(opts.Invite) > 0 {
+		if err := c.markDirect(resp.RoomID, opts.Invite[0]); err != nil {
+			return resp.RoomID, errors.Wrap(err, "room created but failed to mark it as direct")
+		}
+	}
+
+	return resp.RoomID, nil
+}
+
+// markDirect adds the room into the user's m.direct event as a direct message
+// room with the given user.
+func (c *Client) markDirect(roomID matrix.RoomID, userID matrix.UserID) error {
+	var rooms map[matrix.UserID][]matrix.RoomID
+
+	// Fetch the latest list from the server, since the whole event is
+	// replaced. The content is read directly, since it has no type field to be
+	// parsed as an event with. The server has no list if it's not found.
+	err := c.ClientConfig(string(event.TypeDirect), &rooms)
+	if err != nil && matrix.StatusCode(err) != http.StatusNotFound {
+		return errors.Wrap(err, "failed to get direct rooms")
+	}
+
+	if rooms == nil {
+		rooms = make(map[matrix.UserID][]matrix.RoomID, 1)
+	}
+
+	rooms[userID] = append(rooms[userID], roomID)
+
+	if err := c.ClientConfigSet(string(event.TypeDirect), rooms); err != nil {
+		return errors.Wrap(err, "failed to set direct rooms")
+	}
+
+	c.State.UseDirectEvent(&event.DirectEvent{
+		EventInfo: event.EventInfo{Type: event.TypeDirect},
+		Rooms:     rooms,
+	})
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package gotktrix
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Device is a device, or session, that the user is logged in on.
+type Device struct {
+	ID          matrix.DeviceID `json:"device_id"`
+	DisplayName string          `json:"display_name,omitempty"`
+	LastSeenIP  string          `json:"last_seen_ip,omitempty"`
+	// LastSeenTS is the time in milliseconds. Use LastSeen instead.
+	LastSeenTS int64 `json:"last_seen_ts,omitempty"`
+}
+
+// LastSeen returns the time that the device was last seen. A zero time is
+// returned if it's unknown.
+func (d Device) LastSeen() time.Time {
+	if d.LastSeenTS == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(d.LastSeenTS)
+}
+
+func (c *Client) devicesEndpoint() string {
+	return c.Endpoints.Base() + "/devices"
+}
+
+// Devices returns all the devices that the user is logged in on.
+func (c *Client) Devices() ([]Device, error) {
+	var resp struct {
+		Devices []Device `json:"devices"`
+	}
+
+	err := c.Request("GET", c.devicesEndpoint(), &resp, httputil.WithToken())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get devices")
+	}
+
+	return resp.Devices, nil
+}
+
+// RenameDevice changes the display name of the given device.
+func (c *Client) RenameDevice(id matrix.DeviceID, name string) error {
+	req := struct {
+		DisplayName string `json:"display_name"`
+	}{
+		DisplayName: name,
+	}
+
+	err := c.Request(
+		"PUT", c.devicesEndpoint()+"/"+url.PathEscape(string(id)), nil,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to rename device")
+	}
+
+	return nil
+}
+
+// DeleteDevices deletes the given devices, logging them out. The server
+// usually requires the user to authenticate again, which must be done using
+// the returned InteractiveAuth until it's done.
+func (c *Client) DeleteDevices(ids []matrix.DeviceID) (*InteractiveAuth, error) {
+	var req struct {
+		Auth    interface{}       `json:"auth,omitempty"`
+		Devices []matrix.DeviceID `json:"devices"`
+	}
+
+	req.Devices = ids
+
+	a, err := newInteractiveAuth(func(auth, to interface{}) error {
+		req.Auth = auth
+		return c.Request(
+			"POST", c.Endpoints.Base()+"/delete_devices", to,
+			httputil.WithToken(), httputil.WithJSONBody(req),
+		)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete devices")
+	}
+
+	return a, nil
+}
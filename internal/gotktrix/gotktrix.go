@@ -19,6 +19,7 @@ import (
 	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/indexer"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/discovery"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/handler"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/httptrick"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
@@ -33,7 +34,8 @@ import (
 // EachBreak can be returned if the user wants to break out of an interation.
 var EachBreak = db.EachBreak
 
-// TimelimeLimit is the number of timeline events that the database keeps.
+// TimelimeLimit is the number of timeline events that the database keeps by
+// default. See RetentionPolicy.
 const TimelimeLimit = state.TimelineKeepLast
 
 // SyncOptions is used to sync.
@@ -160,7 +162,9 @@ type Client struct {
 	Index       *indexer.Indexer
 	Interceptor *httptrick.Interceptor
 
-	ctx context.Context
+	versions *versionsCache
+	outbox   *outbox
+	ctx    context.Context
 }
 
 // New wraps around gotrix.NewWithClient.
@@ -174,7 +178,7 @@ func New(serverName, token string, opts Opts) (*Client, error) {
 
 	c.AccessToken = token
 
-	return wrapClient(c, opts)
+	return wrapClient(c, opts, nil)
 }
 
 /*
@@ -186,16 +190,21 @@ var cachedRoutes = map[string]map[string]string{
 }
 */
 
-func wrapClient(c *gotrix.Client, opts Opts) (*Client, error) {
+// wrapClient wraps the logged in client. If v is nil, then the homeserver's
+// versions are fetched once they're needed.
+func wrapClient(c *gotrix.Client, opts Opts, v *Versions) (*Client, error) {
 	logInit()
 	opts.init()
 
 	if c.UserID == "" {
-		userID, _, err := c.Whoami()
+		userID, deviceID, err := c.Whoami()
 		if err != nil {
 			return nil, errors.Wrap(err, "invalid user account")
 		}
 		c.UserID = userID
+		if c.DeviceID == "" {
+			c.DeviceID = deviceID
+		}
 	}
 
 	// URLEncoding is path-safe; StdEncoding is not.
@@ -220,9 +229,19 @@ func wrapClient(c *gotrix.Client, opts Opts) (*Client, error) {
 		return nil, errors.Wrap(err, "failed to make indexer")
 	}
 
+	if idx.Outdated() {
+		// Rebuild the index in the background. The old index will keep
+		// serving queries until then.
+		go func() {
+			if err := idx.Rebuild(s); err != nil {
+				log.Println("failed to rebuild index:", err)
+			}
+		}()
+	}
+
 	registry := handler.New()
 	registry.OnSync(func(s *api.SyncResponse) {
-		for _, room := range s.Rooms.Joined {
+		for roomID, room := range s.Rooms.Joined {
 			for _, ev := range room.State.Events {
 				if state.GuessType(ev) != event.TypeRoomMember {
 					continue
@@ -235,9 +254,20 @@ func wrapClient(c *gotrix.Client, opts Opts) (*Client, error) {
 					b.Commit()
 				}
 			}
+
+			if len(room.Timeline.Events) > 0 {
+				b := idx.Begin()
+				b.IndexTimeline(sys.ParseAllTimeline(room.Timeline.Events, roomID))
+				b.Commit()
+			}
 		}
 	})
 
+	out := newOutbox()
+	// Try sending the queued events again every time we manage to sync, since
+	// that means we're back online.
+	registry.OnSync(func(*api.SyncResponse) { out.pokeSync() })
+
 	c.State = registry.Wrap(s)
 	c.SyncOpts = SyncOptions
 
@@ -247,9 +277,44 @@ func wrapClient(c *gotrix.Client, opts Opts) (*Client, error) {
 		State:       s,
 		Index:       idx,
 		Interceptor: interceptor,
+		versions:    &versionsCache{v: v},
+		outbox:      out,
 	}, nil
 }
 
+type versionsCache struct {
+	mu sync.Mutex
+	v  *Versions
+}
+
+// Versions returns the spec versions and unstable features that the
+// homeserver supports. If they weren't known when the client was created, then
+// they're fetched on the first call, so it may block. Empty versions are
+// returned if the homeserver can't be asked, in which case the next call tries
+// again.
+func (c *Client) Versions() *Versions {
+	c.versions.mu.Lock()
+	defer c.versions.mu.Unlock()
+
+	if c.versions.v != nil {
+		return c.versions.v
+	}
+
+	baseURL := &url.URL{
+		Scheme: c.HomeServerScheme,
+		Host:   c.HomeServer,
+	}
+
+	v, err := discovery.FetchVersions(c.Client.Client.Client, baseURL)
+	if err != nil {
+		log.Println("cannot fetch homeserver versions:", err)
+		return &Versions{}
+	}
+
+	c.versions.v = v
+	return v
+}
+
 // AddHandler will panic.
 //
 // Deprecated: Use c.On() instead.
@@ -257,22 +322,34 @@ func (c *Client) AddHandler(function interface{}) error {
 	panic("don't use AddHandler(); use On().")
 }
 
-// Open opens the client with the last next batch string.
+// Open opens the client with the last next batch string. It also starts
+// sending the events in the outbox.
 func (c *Client) Open() error {
 	next, _ := c.State.NextBatch()
-	return c.Client.OpenWithNext(next)
+	if err := c.Client.OpenWithNext(next); err != nil {
+		return err
+	}
+
+	c.outbox.start(c)
+	return nil
 }
 
 // Close closes the event loop and the internal database, as well as halting all
 // ongoing requests.
 func (c *Client) Close() error {
+	c.outbox.stop()
+
 	err1 := c.Client.Close()
 	err2 := c.State.Close()
+	err3 := c.Index.Close()
 
 	if err1 != nil {
 		return err1
 	}
-	return err2
+	if err2 != nil {
+		return err2
+	}
+	return err3
 }
 
 // Offline returns a Client that does not use the API.
@@ -441,17 +518,7 @@ func (c *Client) MessageMediaURL(msg *event.RoomMessageEvent) (string, error) {
 
 // RoomTimelineEvent fetches a single room timeline event by its ID.
 func (c *Client) RoomTimelineEvent(roomID matrix.RoomID, id matrix.EventID) (event.RoomEvent, error) {
-	var found event.RoomEvent
-
-	c.EachTimeline(roomID, func(ev event.RoomEvent) error {
-		if ev.RoomInfo().ID == id {
-			found = ev
-			return EachBreak
-		}
-		return nil
-	})
-
-	if found != nil {
+	if found := c.storedTimelineEvent(roomID, id); found != nil {
 		return found, nil
 	}
 
@@ -592,27 +659,13 @@ func (c *Client) hasSeenEvent(roomID matrix.RoomID, eventID matrix.EventID) (see
 		return fullyRead.EventID == eventID, true
 	}
 
-	u, err := c.Whoami()
+	// Query to see if the current user has read the latest message.
+	r, err := c.State.UserReceipt(roomID, c.UserID)
 	if err != nil {
-		// Can't get the current user, so just assume that the room is unread.
-		// This would be a bug, but whatever.
 		return false, false
 	}
 
-	// Query to see if the current user has read the latest message.
-	e, _ = c.RoomEvent(roomID, event.TypeReceipt)
-	if e, ok := e.(*event.ReceiptEvent); ok {
-		rc, ok := e.Events[eventID]
-		if !ok {
-			// Nobody has read the latest message, including the current user.
-			return false, true
-		}
-
-		_, read := rc.Read[u]
-		return read, true
-	}
-
-	return false, false
+	return r.EventID == eventID, true
 }
 
 // RoomLatestReadEvent gets the latest read eventID. The event ID is an empty
@@ -623,21 +676,9 @@ func (c *Client) RoomLatestReadEvent(roomID matrix.RoomID) matrix.EventID {
 		return e.(*m.FullyReadEvent).EventID
 	}
 
-	u, err := c.Whoami()
-	if err != nil {
-		return ""
-	}
-
-	e, err = c.RoomEvent(roomID, event.TypeReceipt)
+	r, err := c.State.UserReceipt(roomID, c.UserID)
 	if err == nil {
-		e := e.(*event.ReceiptEvent)
-
-		for eventID, receipt := range e.Events {
-			_, read := receipt.Read[u]
-			if read {
-				return eventID
-			}
-		}
+		return r.EventID
 	}
 
 	return ""
@@ -685,10 +726,22 @@ func (c *Client) MarkRoomAsRead(roomID matrix.RoomID, eventID matrix.EventID) er
 	request.FullyRead = eventID
 	request.Read = eventID
 
-	return c.Request(
+	err := c.Request(
 		"POST", c.Endpoints.Room(roomID)+"/read_markers",
 		nil, httputil.WithToken(), httputil.WithJSONBody(request),
 	)
+	if err != nil {
+		return err
+	}
+
+	// Update our own receipt now, since the server only echoes it back on the
+	// next sync.
+	c.State.SetUserReceipt(roomID, c.UserID, state.Receipt{
+		EventID:   eventID,
+		Timestamp: matrix.Timestamp(time.Now().UnixMilli()),
+	})
+
+	return nil
 }
 
 // RoomEnsureMembers ensures that the given room has all its members fetched.
@@ -732,192 +785,6 @@ func (c *Client) RoomEnsureMembers(roomID matrix.RoomID) error {
 	return nil
 }
 
-// RoomPaginator is used to fetch older messages from the API client.
-type RoomPaginator struct {
-	c      *Client
-	roomID matrix.RoomID
-	limit  int
-
-	// buffer holds all the unreturned events.
-	buffer []event.RoomEvent
-	// lastBatch keeps track of the pagination token.
-	lastBatch string
-	// skip counts the number of events to skip during the initial fetch using
-	// prev_batch, since those messages will have been in the state already.
-	skip int
-	// drained is true if the state cache is completely drained.
-	drained bool
-	// onTop is true if we're out of events.
-	onTop bool
-}
-
-// RoomPaginator returns a new paginator that can fetch messages from the bottom
-// up.
-func (c *Client) RoomPaginator(roomID matrix.RoomID, limit int) *RoomPaginator {
-	if limit < 1 {
-		log.Panicln("gotktrix: RoomPaginator limit must be non-zero")
-	}
-
-	return &RoomPaginator{
-		c:      c,
-		limit:  limit,
-		roomID: roomID,
-	}
-}
-
-// TODO: this API is broken because the room list will shift messages over time.
-// Paginate should take a message ID and repaginate if it cannot seek to the
-// right position in the buffer.
-
-// Paginate paginates from the client and the server if the database is drained.
-func (p *RoomPaginator) Paginate(ctx context.Context) ([]event.RoomEvent, error) {
-	if p.onTop {
-		return nil, nil
-	}
-
-	// Fill the paginator's buffer until we have enough events in the buffer.
-	if p.needFill() {
-		if err := p.fill(ctx); err != nil {
-			if len(p.buffer) == 0 {
-				// Buffer completely drained. We got nothing.
-				return nil, err
-			}
-			// If we get an error and a non-empty buffer, then that probably
-			// means we're out of events in the room. Mark it as such and slice
-			// the rest.
-			p.onTop = true
-			return p.buffer, nil
-		}
-	}
-
-	// Calculate the boundary to which we should slice the buffer. The boundary
-	// will be calculated starting from the end of buffer.
-	bound := len(p.buffer) - p.limit
-	if bound < 0 {
-		bound = 0
-	}
-
-	// Reslice the buffer to not have the region that we're about to split away.
-	new := p.buffer[:bound]
-	// Use all latest n=p.limit events.
-	old := p.buffer[bound:]
-
-	p.buffer = new
-	return old, nil
-}
-
-// fill fills the paginator's buffer.
-func (p *RoomPaginator) fill(ctx context.Context) error {
-	if p.lastBatch == "" {
-		// Acquire the latest known pagination token. This means we'll have to
-		// seek through our cached events, but that's just how it works.
-		b, err := p.c.State.RoomPreviousBatch(p.roomID)
-		if err != nil {
-			return errors.Wrap(err, "failed to get previous batch")
-		}
-		p.lastBatch = b
-	}
-
-	if !p.drained {
-		p.drained = true
-
-		events, err := p.c.State.RoomTimeline(p.roomID)
-		if err == nil {
-			p.skip = len(events)
-			p.prepend(events)
-
-			if !p.needFill() {
-				return nil
-			}
-		}
-	}
-
-	for p.needFill() {
-		// https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmessages
-		// Fill up the last batch from start.
-		r, err := p.c.WithContext(ctx).RoomMessages(p.roomID, api.RoomMessagesQuery{
-			From:      p.lastBatch,
-			Direction: api.RoomMessagesBackward,
-			Limit:     100,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "failed to query messages for room %q", p.roomID)
-		}
-
-		// If End is empty, then we can't go further.
-		if r.End == "" {
-			// log.Println("no more messages")
-			p.onTop = true
-		}
-
-		// Update the last batch.
-		// End is used to request earlier events if direction is backwards.
-		p.lastBatch = r.End
-
-		// log.Println("new  lastBatch =", p.lastBatch)
-		// log.Println("     start     =", r.Start)
-
-		// Only prepend known ones.
-		if p.skip > 0 {
-			new := p.skip
-			p.skip -= len(r.Chunk)
-
-			if new >= len(r.Chunk) {
-				continue
-			}
-			r.Chunk = r.Chunk[new:]
-		}
-
-		// Flip the message list. Code from SliceTricks.
-		for i, j := 0, len(r.Chunk)-1; i < j; i, j = i+1, j-1 {
-			r.Chunk[i], r.Chunk[j] = r.Chunk[j], r.Chunk[i]
-		}
-
-		// Seek until we stumble on the wanted events.
-		events := sys.ParseAllTimeline(r.Chunk, p.roomID)
-		p.prepend(events)
-	}
-
-	return nil
-}
-
-// needFill returns true if the paginator's buffer needs filling.
-func (p *RoomPaginator) needFill() bool {
-	return p.limit > len(p.buffer) && !p.onTop
-}
-
-// prepend prepends the given events into the paginator buffer.
-func (p *RoomPaginator) prepend(events []event.RoomEvent) {
-	if len(p.buffer)+len(events) == 0 {
-		p.buffer = nil
-		return
-	}
-
-	if len(events) == 0 {
-		return
-	}
-
-	// log.Println("current buffer state:")
-	// log.Printf("events | %s", events[0].RoomInfo().OriginServerTime.Time())
-	// log.Printf("       | ...")
-	// log.Printf("       | %s", events[len(events)-1].RoomInfo().OriginServerTime.Time())
-	// if len(p.buffer) > 0 {
-	// 	log.Printf("buffer | %s", p.buffer[0].RoomInfo().OriginServerTime.Time())
-	// 	log.Printf("       | ...")
-	// 	log.Printf("       | %s", p.buffer[len(p.buffer)-1].RoomInfo().OriginServerTime.Time())
-	// }
-	// log.Println("--------")
-
-	// TODO: optimize.
-	new := make([]event.RoomEvent, len(p.buffer)+len(events))
-
-	n := 0
-	n += copy(new[n:], events)
-	n += copy(new[n:], p.buffer)
-
-	p.buffer = new
-}
-
 // RoomTimeline queries the state cache for the timeline of the given room. If
 // it's not available, the API will be queried directly. The order of these
 // events is guaranteed to be latest last.
@@ -947,7 +814,13 @@ func (c *Client) RoomTimeline(roomID matrix.RoomID) ([]event.RoomEvent, error) {
 		return nil, errors.Wrapf(err, "failed to get messages for room %q", roomID)
 	}
 
-	return sys.ParseAllTimeline(r.Chunk, roomID), nil
+	events := sys.ParseAllTimeline(r.Chunk, roomID)
+
+	batch := c.Index.Begin()
+	batch.IndexTimeline(events)
+	batch.Commit()
+
+	return events, nil
 }
 
 // LatestMessage finds the latest room message event from the given list of
@@ -1070,13 +943,23 @@ func (c *Client) EachTimelineReverse(roomID matrix.RoomID, f func(event.RoomEven
 	return c.State.EachTimelineReverse(roomID, f)
 }
 
-// SendRoomEvent is a convenient function around RoomEventSend.
+// SendRoomEvent is a convenient function around RoomEventSend. State events
+// are sent using RoomStateSend instead.
 func (c *Client) SendRoomEvent(roomID matrix.RoomID, ev event.Event) error {
 	if ev.Info().Type == "" {
 		// bug
 		panic("SendRoomEvent: missing event type")
 	}
 
+	if state, ok := ev.(event.StateEvent); ok {
+		_, err := c.Client.RoomStateSend(roomID, api.RoomStateSendArg{
+			Type:     ev.Info().Type,
+			StateKey: state.StateInfo().StateKey,
+			Content:  ev,
+		})
+		return err
+	}
+
 	_, err := c.Client.RoomEventSend(roomID, ev.Info().Type, ev)
 	return err
 }
@@ -1099,54 +982,23 @@ const (
 )
 
 // HasPower checks if the current user can perform the given action inside the
-// given room.
+// given room. Use CanSendEvent and CanRedact for anything else.
 func (c *Client) HasPower(roomID matrix.RoomID, action PowerAction) bool {
-	e, err := c.RoomState(roomID, event.TypeRoomPowerLevels, "")
-	if err != nil {
-		// Theoretically, this means we have the power to override the room's
-		// power levels to be whatever we want, but we'll play nice and pretend
-		// that we don't have the power to do that, because that's just stupid.
-		return false
-	}
-
-	ev := e.(*event.RoomPowerLevelsEvent)
-
-	powerLevel := 50
+	pl := c.RoomPowerLevels(roomID)
+	ourLevel := pl.UserLevel(c.UserID)
 
 	switch action {
 	case BanAction:
-		if ev.BanRequirement != nil {
-			powerLevel = *ev.BanRequirement
-		}
+		return ourLevel >= pl.Ban
 	case InviteAction:
-		if ev.InviteRequirement != nil {
-			powerLevel = *ev.InviteRequirement
-		}
+		return ourLevel >= pl.Invite
 	case KickAction:
-		if ev.KickRequirement != nil {
-			powerLevel = *ev.KickRequirement
-		}
+		return ourLevel >= pl.Kick
 	case RedactAction:
-		if ev.RedactRequirement != nil {
-			powerLevel = *ev.RedactRequirement
-		}
-	}
-
-	ourLevel := ev.UserDefault
-	if level, ok := ev.UserLevel[c.UserID]; ok {
-		ourLevel = level
-	}
-
-	if ourLevel >= powerLevel {
-		return true
-	}
-
-	if c.IsRoomCreator(roomID) {
-		// User made this room, so they have full power.
-		return true
+		return ourLevel >= pl.Redact
+	default:
+		return false
 	}
-
-	return false
 }
 
 // IsRoomCreator returns true if the current user is the user who made this
@@ -1301,47 +1153,29 @@ const (
 
 // NotifyMessage returns true if msg should be notified with action. The
 // returned NotifyMessageAction contains enabled bits for the actions that the
-// found rule wants.
-//
-// Note that this isn't perfect: only a single rule is accounted for, which is
-// the first one that happens to match the message, so some conditions may be
-// missed.
+// matching push rule wants. The user's own messages are never notified.
 func (c *Client) NotifyMessage(msg *event.RoomMessageEvent, action NotifyMessageAction) NotifyMessageAction {
-	if action == 0 {
-		return 0
-	}
-
-	e, err := c.State.UserEvent(event.TypePushRules)
-	if err != nil {
+	if action == 0 || msg.Sender == c.UserID {
 		return 0
 	}
 
-	rules := e.(*event.PushRulesEvent)
-
-	rule, ok := event.PushNotifyMessage(rules.Global, msg)
+	result, ok := c.EvaluatePushRules(msg)
 	if !ok {
 		return 0
 	}
 
 	var enabled NotifyMessageAction
 
-	if (action & NotifyMessage) != 0 {
-		switch rule.Actions.Action {
-		case matrix.NotifyAction, matrix.CoalesceAction:
-			enabled |= NotifyMessage
-		}
+	if (action&NotifyMessage) != 0 && result.Notify() {
+		enabled |= NotifyMessage
 	}
 
-	if (action & NotifySoundMessage) != 0 {
-		if _, ok := rule.Actions.Tweaks[matrix.SoundActionTweak]; ok {
-			enabled |= NotifySoundMessage
-		}
+	if (action&NotifySoundMessage) != 0 && result.Notify() && result.Sound() != "" {
+		enabled |= NotifySoundMessage
 	}
 
-	if (action & HighlightMessage) != 0 {
-		if rule.Actions.Highlight() {
-			enabled |= HighlightMessage
-		}
+	if (action&HighlightMessage) != 0 && result.Highlight() {
+		enabled |= HighlightMessage
 	}
 
 	return enabled
@@ -1,6 +1,9 @@
 package indexer
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/blevesearch/bleve/v2"
 	"github.com/diamondburned/gotrix/event"
 	"github.com/diamondburned/gotrix/matrix"
@@ -37,3 +40,111 @@ func (m *IndexedRoomMember) Index(b *bleve.Batch) error {
 func (m *IndexedRoomMember) Type() string {
 	return "RoomMember"
 }
+
+// IndexedRoomMessage is the data structure representing an indexed room
+// message.
+type IndexedRoomMessage struct {
+	ID     matrix.EventID `json:"id"`
+	Room   matrix.RoomID  `json:"room_id"`
+	Sender matrix.UserID  `json:"sender"`
+	Body   string         `json:"body"`
+	Time   time.Time      `json:"time"`
+	// Edited is the time of the edit that Body is from in Unix milliseconds,
+	// or 0 if the message was never edited.
+	Edited int64 `json:"edited"`
+	// Redacted is true if the message was redacted. Redacted messages are kept
+	// in the index as tombstones with no body, so that neither the original
+	// message nor its edits can be indexed again once paginated.
+	Redacted bool `json:"redacted"`
+}
+
+// indexRoomMessage returns the indexed data of the given message. If the
+// message is an edit, then the returned data has the ID of the original
+// message, and edit is true.
+func indexRoomMessage(m *event.RoomMessageEvent) (idx IndexedRoomMessage, edit, ok bool) {
+	idx = IndexedRoomMessage{
+		ID:     m.ID,
+		Room:   m.RoomID,
+		Sender: m.Sender,
+		Body:   m.Body,
+		Time:   m.OriginServerTime.Time(),
+	}
+
+	if m.Raw == nil || m.RelatesTo == nil {
+		return idx, false, idx.Body != ""
+	}
+
+	var content struct {
+		Content struct {
+			NewContent struct {
+				Body string `json:"body"`
+			} `json:"m.new_content"`
+			RelatesTo struct {
+				RelType string         `json:"rel_type"`
+				EventID matrix.EventID `json:"event_id"`
+			} `json:"m.relates_to"`
+		} `json:"content"`
+	}
+
+	if err := json.Unmarshal(m.Raw, &content); err == nil {
+		if content.Content.RelatesTo.RelType == "m.replace" && content.Content.RelatesTo.EventID != "" {
+			// Index the edited content in place of the original message, so
+			// searching yields the latest body without the "* " prefix.
+			idx.ID = content.Content.RelatesTo.EventID
+			idx.Body = content.Content.NewContent.Body
+			idx.Edited = int64(m.OriginServerTime)
+			edit = true
+		}
+	}
+
+	return idx, edit, idx.Body != ""
+}
+
+// mergeRoomMessage merges the given message into the already indexed message
+// with the same ID, if any. False is returned if the indexed message should be
+// kept as-is.
+//
+// Events may be indexed in any order, since paginating backwards indexes the
+// newer events first. An edit only replaces the body if it's newer than the
+// last edit, and only the original sender's edits are accepted. If an edit is
+// indexed before its original message, then the original message only keeps
+// the edited body if the senders match. Nothing is merged into a redacted
+// message.
+func mergeRoomMessage(old *IndexedRoomMessage, msg IndexedRoomMessage, edit bool) (IndexedRoomMessage, bool) {
+	if old == nil {
+		return msg, true
+	}
+
+	if old.Redacted {
+		return *old, false
+	}
+
+	if !edit {
+		if old.Edited == 0 || old.Sender != msg.Sender {
+			return msg, true
+		}
+
+		msg.Body = old.Body
+		msg.Edited = old.Edited
+		return msg, true
+	}
+
+	if old.Sender != msg.Sender || old.Edited >= msg.Edited {
+		return *old, false
+	}
+
+	merged := *old
+	merged.Body = msg.Body
+	merged.Edited = msg.Edited
+	return merged, true
+}
+
+// Index indexes m into the given Bleve indexer.
+func (m *IndexedRoomMessage) Index(b *bleve.Batch) error {
+	return b.Index(string(m.ID), m)
+}
+
+// Type returns RoomMessage.
+func (m *IndexedRoomMessage) Type() string {
+	return "RoomMessage"
+}
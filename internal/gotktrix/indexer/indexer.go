@@ -3,9 +3,12 @@ package indexer
 import (
 	"context"
 	"log"
+	"os"
+	"strings"
 	"sync"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/registry"
 	"github.com/blevesearch/bleve/v2/search"
 	"github.com/blevesearch/bleve/v2/search/query"
 	"github.com/diamondburned/gotrix/event"
@@ -22,24 +25,33 @@ func doInit() {
 		// Set the number of background Bleve indexers to 1, because we don't do
 		// that much searching.
 		bleve.Config.SetAnalysisQueueSize(1)
-		// Not too sure what this does, but we don't need it to be set to
-		// "html".
-		bleve.Config.DefaultHighlighter = "simple"
+		// Use a highlighter that outputs Pango markup for message search
+		// results. The default "html" one uses <mark>, which Pango doesn't
+		// understand.
+		registry.RegisterHighlighter(pangoHighlighter, newPangoHighlighter)
+		bleve.Config.DefaultHighlighter = pangoHighlighter
 	})
 }
 
 // Indexer provides indexing of many types of Matrix data for querying.
 type Indexer struct {
-	idx bleve.Index
+	mu   sync.RWMutex
+	idx  bleve.Index
+	next bleve.Index // non-nil while rebuilding
+	path string
+
+	outdated bool
+	closed   bool
 }
 
-// Open opens an existing Indexer or create a new one if not available.
+// Open opens an existing Indexer or create a new one if not available. If the
+// existing index has an outdated version, then it is still opened for querying,
+// but Outdated will return true, and the caller should call Rebuild.
 func Open(path string) (*Indexer, error) {
 	doInit()
 
 	// Work around Bleve's inherent TOCTTOU racy API.
 	var idx bleve.Index
-	// TODO: index database versioning
 	for {
 		x, err := bleve.Open(path)
 		if err == nil {
@@ -47,7 +59,23 @@ func Open(path string) (*Indexer, error) {
 			break
 		}
 
-		x, err = bleve.New(path, bleve.NewIndexMapping())
+		if !errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+			if !isCorrupt(err) {
+				// The index might just be in use by another instance, so don't
+				// touch it.
+				return nil, errors.Wrap(err, "failed to open bleve index")
+			}
+
+			// The index is there, but it's unusable. Wipe it and start over;
+			// there's nothing in it that can't be rebuilt.
+			log.Printf("indexer: wiping unreadable index %q: %v", path, err)
+
+			if err := os.RemoveAll(path); err != nil {
+				return nil, errors.Wrap(err, "failed to wipe unreadable index")
+			}
+		}
+
+		x, err = newIndex(path)
 		if err == nil {
 			idx = x
 			break
@@ -61,27 +89,131 @@ func Open(path string) (*Indexer, error) {
 		return nil, errors.Wrap(err, "failed to initialize bleve")
 	}
 
-	return &Indexer{idx}, nil
+	return &Indexer{
+		idx:      idx,
+		path:     path,
+		outdated: indexVersion(idx) != Version,
+	}, nil
+}
+
+// corruptErrors are substrings of the errors that Bleve returns for indices
+// with unsupported versions or corrupted data. These errors aren't typed.
+var corruptErrors = []string{
+	"unsupported version",
+	"unsupported segment type",
+	"error parsing mapping JSON",
+}
+
+// isCorrupt returns true if the given error from bleve.Open means that the
+// index is corrupted or has an unsupported version, in which case it can only
+// be wiped.
+func isCorrupt(err error) bool {
+	if errors.Is(err, bleve.ErrorIndexMetaMissing) ||
+		errors.Is(err, bleve.ErrorIndexMetaCorrupt) ||
+		errors.Is(err, bleve.ErrorUnknownIndexType) {
+		return true
+	}
+
+	for _, substr := range corruptErrors {
+		if strings.Contains(err.Error(), substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close closes the indexer. If the indexer is being rebuilt, then the rebuild
+// is abandoned and will be restarted on the next Open.
+func (idx *Indexer) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.closed = true
+
+	if idx.next != nil {
+		idx.next.Close()
+		idx.next = nil
+	}
+
+	return idx.idx.Close()
 }
 
 // BatchIndexer wraps around a Bleve indexer for batch writing.
 type BatchIndexer struct {
+	idx *Indexer
+	// main is the batch for the index that's being queried. next is the batch
+	// for the index that's being rebuilt, if any.
+	main indexBatch
+	next indexBatch
+}
+
+type indexBatch struct {
 	idx bleve.Index
 	b   *bleve.Batch
+	// msgs contains the room messages written into b, since they can't be
+	// looked up until b is committed.
+	msgs map[matrix.EventID]*IndexedRoomMessage
+}
+
+func newIndexBatch(idx bleve.Index) indexBatch {
+	if idx == nil {
+		return indexBatch{}
+	}
+	return indexBatch{
+		idx:  idx,
+		b:    idx.NewBatch(),
+		msgs: make(map[matrix.EventID]*IndexedRoomMessage),
+	}
 }
 
-// Begin creates a new batch indexer.
+// roomMessage returns the indexed room message with the given ID, or nil if
+// there's none.
+func (b indexBatch) roomMessage(id matrix.EventID) *IndexedRoomMessage {
+	if msg, ok := b.msgs[id]; ok {
+		return msg
+	}
+
+	req := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{string(id)}))
+	req.Fields = messageFields
+
+	res, err := b.idx.Search(req)
+	if err != nil || len(res.Hits) == 0 {
+		return nil
+	}
+
+	msg := hitRoomMessage(res.Hits[0])
+	return &msg
+}
+
+// Begin creates a new batch indexer. If the indexer is being rebuilt, then the
+// writes also go into the new index, so that nothing is lost once it replaces
+// the old one.
 func (idx *Indexer) Begin() BatchIndexer {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
 	return BatchIndexer{
-		idx: idx.idx,
-		b:   idx.idx.NewBatch(),
+		idx:  idx,
+		main: newIndexBatch(idx.idx),
+		next: newIndexBatch(idx.next),
 	}
 }
 
 // Commit commits the batched writes.
 func (b BatchIndexer) Commit() {
-	if err := b.idx.Batch(b.b); err != nil {
-		log.Println("indexer error: while commiting:", err)
+	b.idx.mu.RLock()
+	defer b.idx.mu.RUnlock()
+
+	for _, batch := range []indexBatch{b.main, b.next} {
+		// Skip indices that have been closed since Begin was called.
+		if batch.idx == nil || (batch.idx != b.idx.idx && batch.idx != b.idx.next) {
+			continue
+		}
+
+		if err := batch.idx.Batch(batch.b); err != nil {
+			log.Println("indexer error: while commiting:", err)
+		}
 	}
 }
 
@@ -91,20 +223,91 @@ func (b BatchIndexer) IndexRoomMember(m *event.RoomMemberEvent) {
 	b.index(&data)
 }
 
+// IndexRoomMessage indexes the body of the given room message. Messages with
+// no body are ignored. Edits are indexed in place of the original message; see
+// mergeRoomMessage.
+func (b BatchIndexer) IndexRoomMessage(m *event.RoomMessageEvent) {
+	data, edit, ok := indexRoomMessage(m)
+	if !ok {
+		return
+	}
+
+	for _, batch := range []indexBatch{b.main, b.next} {
+		if batch.b == nil {
+			continue
+		}
+
+		merged, ok := mergeRoomMessage(batch.roomMessage(data.ID), data, edit)
+		if !ok {
+			continue
+		}
+
+		batch.msgs[merged.ID] = &merged
+
+		if err := merged.Index(batch.b); err != nil {
+			log.Println("indexer error:", err)
+		}
+	}
+}
+
+// redactRoomMessage replaces the room message with the given ID with a
+// tombstone that has no body.
+func (b BatchIndexer) redactRoomMessage(r *event.RoomRedactionEvent) {
+	tombstone := IndexedRoomMessage{
+		ID:       r.Redacts,
+		Room:     r.RoomID,
+		Redacted: true,
+	}
+
+	for _, batch := range []indexBatch{b.main, b.next} {
+		if batch.b == nil {
+			continue
+		}
+
+		batch.msgs[tombstone.ID] = &tombstone
+
+		if err := tombstone.Index(batch.b); err != nil {
+			log.Println("indexer error:", err)
+		}
+	}
+}
+
+// IndexTimeline indexes all room messages within the given list of timeline
+// events, and removes the redacted ones. Other events are ignored.
+//
+// Redactions may be indexed before the messages that they redact when
+// paginating backwards, so the redacted messages are kept as tombstones that
+// the messages and their edits can't replace.
+func (b BatchIndexer) IndexTimeline(events []event.RoomEvent) {
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case *event.RoomMessageEvent:
+			b.IndexRoomMessage(ev)
+		case *event.RoomRedactionEvent:
+			b.redactRoomMessage(ev)
+		}
+	}
+}
+
 type indexable interface {
 	Index(*bleve.Batch) error
 }
 
 func (b BatchIndexer) index(indexer indexable) {
-	if err := indexer.Index(b.b); err != nil {
-		log.Println("indexer error:", err)
+	for _, batch := range []indexBatch{b.main, b.next} {
+		if batch.b == nil {
+			continue
+		}
+		if err := indexer.Index(batch.b); err != nil {
+			log.Println("indexer error:", err)
+		}
 	}
 }
 
 type RoomMemberSearcher struct {
 	// constants
 	room matrix.RoomID
-	idx  bleve.Index
+	idx  *Indexer
 	size int
 
 	// state
@@ -116,11 +319,19 @@ type RoomMemberSearcher struct {
 
 const searchLimit = 25
 
+// search searches the index that's currently being queried.
+func (idx *Indexer) search(ctx context.Context, req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.idx.SearchInContext(ctx, req)
+}
+
 // SearchRoomMember returns a new instance of RoomMemberSearcher that the client
 // can use to search room members.
 func (idx *Indexer) SearchRoomMember(roomID matrix.RoomID, limit int) RoomMemberSearcher {
 	return RoomMemberSearcher{
-		idx:  idx.idx,
+		idx:  idx,
 		room: roomID,
 		size: limit,
 		res:  make([]IndexedRoomMember, 0, searchLimit),
@@ -171,7 +382,7 @@ func (s *RoomMemberSearcher) Search(ctx context.Context, str string) []IndexedRo
 		})
 	}
 
-	results, err := s.idx.SearchInContext(ctx, s.req)
+	results, err := s.idx.search(ctx, s.req)
 	if err != nil {
 		log.Println("indexer: query error:", err)
 		return nil
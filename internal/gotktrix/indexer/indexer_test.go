@@ -0,0 +1,396 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+const testRoom matrix.RoomID = "!room:example.com"
+
+func newMessage(id matrix.EventID, sender matrix.UserID, ts matrix.Timestamp, body string) *event.RoomMessageEvent {
+	return &event.RoomMessageEvent{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo:        event.EventInfo{Type: event.TypeRoomMessage},
+			ID:               id,
+			Sender:           sender,
+			OriginServerTime: ts,
+			RoomID:           testRoom,
+		},
+		Body:        body,
+		MessageType: event.RoomMessageText,
+	}
+}
+
+func newEdit(id, of matrix.EventID, sender matrix.UserID, ts matrix.Timestamp, body string) *event.RoomMessageEvent {
+	m := newMessage(id, sender, ts, "* "+body)
+
+	var content struct {
+		Content struct {
+			Body       string `json:"body"`
+			NewContent struct {
+				Body string `json:"body"`
+			} `json:"m.new_content"`
+			RelatesTo struct {
+				RelType string         `json:"rel_type"`
+				EventID matrix.EventID `json:"event_id"`
+			} `json:"m.relates_to"`
+		} `json:"content"`
+	}
+
+	content.Content.Body = m.Body
+	content.Content.NewContent.Body = body
+	content.Content.RelatesTo.RelType = "m.replace"
+	content.Content.RelatesTo.EventID = of
+
+	m.Raw, _ = json.Marshal(content)
+	m.RelatesTo, _ = json.Marshal(content.Content.RelatesTo)
+	return m
+}
+
+func newRedaction(id, of matrix.EventID) *event.RoomRedactionEvent {
+	return &event.RoomRedactionEvent{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo: event.EventInfo{Type: event.TypeRoomRedaction},
+			ID:        id,
+			RoomID:    testRoom,
+		},
+		Redacts: of,
+	}
+}
+
+func openTestIndexer(t *testing.T) *Indexer {
+	t.Helper()
+
+	idx, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal("cannot open indexer:", err)
+	}
+	t.Cleanup(func() { idx.Close() })
+
+	return idx
+}
+
+func TestSearchMessages(t *testing.T) {
+	const alice = "@alice:example.com"
+	const bob = "@bob:example.com"
+
+	idx := openTestIndexer(t)
+
+	other := newMessage("$3", bob, 3000, "hello from elsewhere")
+	other.RoomID = "!other:example.com"
+
+	b := idx.Begin()
+	b.IndexTimeline([]event.RoomEvent{
+		newMessage("$1", alice, 1000, "hello world"),
+		newMessage("$2", bob, 2000, "hello there"),
+		other,
+		newMessage("$4", alice, 4000, "typo"),
+		newEdit("$5", "$4", alice, 5000, "hello again"),
+		newMessage("$6", alice, 6000, "goodbye"),
+	})
+	b.Commit()
+
+	tests := []struct {
+		name   string
+		query  MessageQuery
+		expect map[matrix.EventID]string
+	}{
+		{
+			name:  "everything",
+			query: MessageQuery{Query: "hello"},
+			expect: map[matrix.EventID]string{
+				"$1": "hello world",
+				"$2": "hello there",
+				"$3": "hello from elsewhere",
+				"$4": "hello again",
+			},
+		},
+		{
+			name:  "room",
+			query: MessageQuery{Query: "hello", Room: testRoom},
+			expect: map[matrix.EventID]string{
+				"$1": "hello world",
+				"$2": "hello there",
+				"$4": "hello again",
+			},
+		},
+		{
+			name:  "sender",
+			query: MessageQuery{Query: "hello", Sender: bob},
+			expect: map[matrix.EventID]string{
+				"$2": "hello there",
+				"$3": "hello from elsewhere",
+			},
+		},
+		{
+			name: "time range",
+			query: MessageQuery{
+				Query:  "hello",
+				After:  matrix.Timestamp(1500).Time(),
+				Before: matrix.Timestamp(3500).Time(),
+			},
+			expect: map[matrix.EventID]string{
+				"$2": "hello there",
+				"$3": "hello from elsewhere",
+			},
+		},
+		{
+			name:   "edited away",
+			query:  MessageQuery{Query: "typo"},
+			expect: map[matrix.EventID]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hits, err := idx.SearchMessages(context.Background(), test.query)
+			if err != nil {
+				t.Fatal("cannot search:", err)
+			}
+
+			got := make(map[matrix.EventID]string, len(hits))
+			for _, hit := range hits {
+				got[hit.ID] = hit.Body
+				if hit.Time.IsZero() || hit.Time.After(time.Unix(10, 0)) {
+					t.Errorf("hit %s has unexpected time %v", hit.ID, hit.Time)
+				}
+			}
+
+			if len(got) != len(test.expect) {
+				t.Fatalf("expected %d hits, got %v", len(test.expect), got)
+			}
+
+			for id, body := range test.expect {
+				if got[id] != body {
+					t.Errorf("expected %s to have body %q, got %q", id, body, got[id])
+				}
+			}
+		})
+	}
+}
+
+func TestIndexRoomMessageEdits(t *testing.T) {
+	const alice = "@alice:example.com"
+	const mallory = "@mallory:example.com"
+
+	tests := []struct {
+		name    string
+		batches [][]event.RoomEvent
+		expect  map[matrix.EventID]string
+	}{
+		{
+			name: "forward",
+			batches: [][]event.RoomEvent{
+				{newMessage("$1", alice, 1, "hello")},
+				{newEdit("$2", "$1", alice, 2, "goodbye")},
+			},
+			expect: map[matrix.EventID]string{"$1": "goodbye"},
+		},
+		{
+			name: "backward",
+			batches: [][]event.RoomEvent{
+				{newEdit("$2", "$1", alice, 2, "goodbye")},
+				{newMessage("$1", alice, 1, "hello")},
+			},
+			expect: map[matrix.EventID]string{"$1": "goodbye"},
+		},
+		{
+			name: "same batch",
+			batches: [][]event.RoomEvent{
+				{
+					newEdit("$3", "$1", alice, 3, "third"),
+					newEdit("$2", "$1", alice, 2, "second"),
+					newMessage("$1", alice, 1, "first"),
+				},
+			},
+			expect: map[matrix.EventID]string{"$1": "third"},
+		},
+		{
+			name: "other sender",
+			batches: [][]event.RoomEvent{
+				{newMessage("$1", alice, 1, "hello")},
+				{newEdit("$2", "$1", mallory, 2, "pwned")},
+			},
+			expect: map[matrix.EventID]string{"$1": "hello"},
+		},
+		{
+			name: "other sender backward",
+			batches: [][]event.RoomEvent{
+				{newEdit("$2", "$1", mallory, 2, "pwned")},
+				{newMessage("$1", alice, 1, "hello")},
+			},
+			expect: map[matrix.EventID]string{"$1": "hello"},
+		},
+		{
+			name: "redacted",
+			batches: [][]event.RoomEvent{
+				{newMessage("$1", alice, 1, "hello"), newMessage("$2", alice, 2, "hello")},
+				{newRedaction("$3", "$1")},
+			},
+			expect: map[matrix.EventID]string{"$2": "hello"},
+		},
+		{
+			name: "redacted then edited",
+			batches: [][]event.RoomEvent{
+				{newRedaction("$3", "$1")},
+				{newEdit("$2", "$1", alice, 2, "goodbye")},
+				{newMessage("$1", alice, 1, "hello")},
+			},
+			expect: map[matrix.EventID]string{},
+		},
+		{
+			name: "redacted then edited same batch",
+			batches: [][]event.RoomEvent{
+				{
+					newRedaction("$3", "$1"),
+					newEdit("$2", "$1", alice, 2, "goodbye"),
+					newMessage("$1", alice, 1, "hello"),
+				},
+			},
+			expect: map[matrix.EventID]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idx := openTestIndexer(t)
+
+			for _, events := range test.batches {
+				b := idx.Begin()
+				b.IndexTimeline(events)
+				b.Commit()
+			}
+
+			// Every test message contains a word of its body, so search for
+			// all of them.
+			hits, err := idx.SearchMessages(context.Background(), MessageQuery{
+				Query: "hello goodbye first second third pwned",
+			})
+			if err != nil {
+				t.Fatal("cannot search:", err)
+			}
+
+			got := make(map[matrix.EventID]string, len(hits))
+			for _, hit := range hits {
+				got[hit.ID] = hit.Body
+			}
+
+			if len(got) != len(test.expect) {
+				t.Fatalf("expected %d hits, got %v", len(test.expect), got)
+			}
+
+			for id, body := range test.expect {
+				if got[id] != body {
+					t.Errorf("expected %s to have body %q, got %q", id, body, got[id])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenVersion(t *testing.T) {
+	path := t.TempDir()
+
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal("cannot open indexer:", err)
+	}
+	defer idx.Close()
+
+	if idx.Outdated() {
+		t.Fatal("new index is outdated")
+	}
+}
+
+// blockingSource is a Source that blocks until unblock is closed.
+type blockingSource struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (s blockingSource) Rooms() ([]matrix.RoomID, error) {
+	close(s.started)
+	<-s.unblock
+	return []matrix.RoomID{testRoom}, nil
+}
+
+func (s blockingSource) EachRoomStateLen(matrix.RoomID, event.Type, func(event.StateEvent, int) error) error {
+	return nil
+}
+
+func (s blockingSource) RoomTimeline(matrix.RoomID) ([]event.RoomEvent, error) {
+	return []event.RoomEvent{newMessage("$1", "@alice:example.com", 1, "hello")}, nil
+}
+
+func TestRebuildConcurrent(t *testing.T) {
+	idx := openTestIndexer(t)
+
+	src := blockingSource{
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+
+	done := make(chan error)
+	go func() { done <- idx.Rebuild(src) }()
+
+	<-src.started
+
+	if err := idx.Rebuild(src); err == nil {
+		t.Fatal("second Rebuild did not fail")
+	}
+
+	close(src.unblock)
+
+	if err := <-done; err != nil {
+		t.Fatal("cannot rebuild:", err)
+	}
+
+	hits, err := idx.SearchMessages(context.Background(), MessageQuery{Query: "hello"})
+	if err != nil {
+		t.Fatal("cannot search:", err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("expected 1 hit after rebuild, got %d", len(hits))
+	}
+}
+
+// failingSource is a Source whose Rooms always fails.
+type failingSource struct{}
+
+func (failingSource) Rooms() ([]matrix.RoomID, error) {
+	return nil, errors.New("database closed")
+}
+
+func (failingSource) EachRoomStateLen(matrix.RoomID, event.Type, func(event.StateEvent, int) error) error {
+	return nil
+}
+
+func (failingSource) RoomTimeline(matrix.RoomID) ([]event.RoomEvent, error) {
+	return nil, nil
+}
+
+func TestRebuildFailingSource(t *testing.T) {
+	idx := openTestIndexer(t)
+
+	b := idx.Begin()
+	b.IndexTimeline([]event.RoomEvent{newMessage("$1", "@alice:example.com", 1, "hello")})
+	b.Commit()
+
+	if err := idx.Rebuild(failingSource{}); err == nil {
+		t.Fatal("Rebuild did not fail")
+	}
+
+	hits, err := idx.SearchMessages(context.Background(), MessageQuery{Query: "hello"})
+	if err != nil {
+		t.Fatal("cannot search:", err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("expected the old index to be kept, got %d hits", len(hits))
+	}
+}
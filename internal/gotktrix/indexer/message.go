@@ -0,0 +1,159 @@
+package indexer
+
+import (
+	"context"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/registry"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/highlight"
+	htmlFormatter "github.com/blevesearch/bleve/v2/search/highlight/format/html"
+	simpleFragmenter "github.com/blevesearch/bleve/v2/search/highlight/fragmenter/simple"
+	simpleHighlighter "github.com/blevesearch/bleve/v2/search/highlight/highlighter/simple"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+const pangoHighlighter = "gotktrix-pango"
+
+// newPangoHighlighter creates a highlighter that wraps matched terms in Pango
+// bold tags. The rest of the fragment is escaped, so the output can be given
+// to a markup label directly.
+func newPangoHighlighter(config map[string]interface{}, cache *registry.Cache) (highlight.Highlighter, error) {
+	fragmenter, err := cache.FragmenterNamed(simpleFragmenter.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build fragmenter")
+	}
+
+	formatter := htmlFormatter.NewFragmentFormatter("<b>", "</b>")
+	return simpleHighlighter.NewHighlighter(fragmenter, formatter, " … "), nil
+}
+
+// MessageQuery describes a full-text search query for room messages. All
+// fields except for Query are optional.
+type MessageQuery struct {
+	// Query is the string to search for in the message bodies.
+	Query string
+	// Room, if not empty, restricts the search to the given room.
+	Room matrix.RoomID
+	// Sender, if not empty, restricts the search to messages sent by the
+	// given user.
+	Sender matrix.UserID
+	// After and Before, if not zero, restrict the search to messages sent
+	// within the given time range.
+	After  time.Time
+	Before time.Time
+	// Limit is the maximum number of hits to return. If 0, then 25 is used.
+	Limit int
+}
+
+// MessageHit is a single room message search result.
+type MessageHit struct {
+	IndexedRoomMessage
+	// Score is the relevance score of the hit. Hits are sorted by score in
+	// descending order.
+	Score float64
+	// Fragments contains parts of the message body with the matched terms
+	// highlighted. The fragments are in Pango markup.
+	Fragments []string
+}
+
+// SearchMessages searches the indexed room messages using the given query. The
+// returned hits are sorted with the most relevant hit first.
+func (idx *Indexer) SearchMessages(ctx context.Context, q MessageQuery) ([]MessageHit, error) {
+	if q.Query == "" {
+		return nil, errors.New("empty search query")
+	}
+
+	body := bleve.NewMatchQuery(q.Query)
+	body.SetField("body")
+
+	conjuncts := []query.Query{body}
+
+	if q.Room != "" {
+		conjuncts = append(conjuncts, matchExact("room_id", string(q.Room)))
+	}
+
+	if q.Sender != "" {
+		conjuncts = append(conjuncts, matchExact("sender", string(q.Sender)))
+	}
+
+	if !q.After.IsZero() || !q.Before.IsZero() {
+		timeRange := bleve.NewDateRangeQuery(q.After, q.Before)
+		timeRange.SetField("time")
+		conjuncts = append(conjuncts, timeRange)
+	}
+
+	limit := q.Limit
+	if limit < 1 {
+		limit = searchLimit
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), limit, 0, false)
+	req.Fields = messageFields
+	req.Highlight = bleve.NewHighlight()
+	req.Highlight.AddField("body")
+	req.SortByCustom(search.SortOrder{
+		// Highest-scored results first, then the latest messages first.
+		&search.SortScore{Desc: true},
+		&search.SortField{Field: "time", Desc: true},
+	})
+
+	results, err := idx.search(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search messages")
+	}
+
+	hits := make([]MessageHit, 0, len(results.Hits))
+
+	for _, res := range results.Hits {
+		hits = append(hits, MessageHit{
+			IndexedRoomMessage: hitRoomMessage(res),
+			Score:              res.Score,
+			Fragments:          res.Fragments["body"],
+		})
+	}
+
+	return hits, nil
+}
+
+// messageFields are the stored fields of IndexedRoomMessage.
+var messageFields = []string{"id", "room_id", "sender", "body", "time", "edited", "redacted"}
+
+// hitRoomMessage returns the room message of a search result that has
+// messageFields.
+func hitRoomMessage(res *search.DocumentMatch) IndexedRoomMessage {
+	msg := IndexedRoomMessage{
+		ID:     matrix.EventID(stringField(res, "id")),
+		Room:   matrix.RoomID(stringField(res, "room_id")),
+		Sender: matrix.UserID(stringField(res, "sender")),
+		Body:   stringField(res, "body"),
+	}
+
+	if t, err := time.Parse(time.RFC3339, stringField(res, "time")); err == nil {
+		msg.Time = t
+	}
+
+	if edited, ok := res.Fields["edited"].(float64); ok {
+		msg.Edited = int64(edited)
+	}
+
+	msg.Redacted, _ = res.Fields["redacted"].(bool)
+
+	return msg
+}
+
+// matchExact creates a query that matches the given field with the whole
+// value.
+func matchExact(field, value string) query.Query {
+	q := bleve.NewMatchPhraseQuery(value)
+	q.SetField(field)
+	return q
+}
+
+func stringField(res *search.DocumentMatch, field string) string {
+	s, _ := res.Fields[field].(string)
+	return s
+}
@@ -0,0 +1,228 @@
+package indexer
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Version is the incremental index version number. It must be incremented
+// every time the index mapping or the indexed data types are changed, which
+// will cause the index to be rebuilt.
+const Version = 3
+
+// versionKey is the internal Bleve key that stores the index version. The
+// version is written when a new index is created, or once a rebuilt index is
+// fully built, so an index with no version is always considered outdated.
+var versionKey = []byte("gotktrix_version")
+
+// newIndexMapping creates the index mapping for the current Version.
+func newIndexMapping() mapping.IndexMapping {
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+
+	member := bleve.NewDocumentMapping()
+	member.AddFieldMappingsAt("id", keywordField)
+	member.AddFieldMappingsAt("room_id", keywordField)
+	member.AddFieldMappingsAt("name", bleve.NewTextFieldMapping())
+
+	message := bleve.NewDocumentMapping()
+	message.AddFieldMappingsAt("id", keywordField)
+	message.AddFieldMappingsAt("room_id", keywordField)
+	message.AddFieldMappingsAt("sender", keywordField)
+	message.AddFieldMappingsAt("body", bleve.NewTextFieldMapping())
+	message.AddFieldMappingsAt("time", bleve.NewDateTimeFieldMapping())
+	message.AddFieldMappingsAt("edited", bleve.NewNumericFieldMapping())
+	message.AddFieldMappingsAt("redacted", bleve.NewBooleanFieldMapping())
+
+	m := bleve.NewIndexMapping()
+	m.AddDocumentMapping((*IndexedRoomMember)(nil).Type(), member)
+	m.AddDocumentMapping((*IndexedRoomMessage)(nil).Type(), message)
+
+	return m
+}
+
+// newIndex creates a new index with the current Version.
+func newIndex(path string) (bleve.Index, error) {
+	idx, err := bleve.New(path, newIndexMapping())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := idx.SetInternal(versionKey, []byte(strconv.Itoa(Version))); err != nil {
+		idx.Close()
+		return nil, errors.Wrap(err, "failed to write index version")
+	}
+
+	return idx, nil
+}
+
+// indexVersion returns the version of the given index, or 0 if it has none.
+func indexVersion(idx bleve.Index) int {
+	b, err := idx.GetInternal(versionKey)
+	if err != nil || b == nil {
+		return 0
+	}
+
+	v, _ := strconv.Atoi(string(b))
+	return v
+}
+
+// Source describes the data source that an index can be rebuilt from.
+// *state.State satisfies this interface.
+type Source interface {
+	// Rooms returns the list of rooms to rebuild the index for.
+	Rooms() ([]matrix.RoomID, error)
+	// EachRoomStateLen iterates over the state events of the given type.
+	EachRoomStateLen(matrix.RoomID, event.Type, func(event.StateEvent, int) error) error
+	// RoomTimeline returns the cached timeline of the given room.
+	RoomTimeline(matrix.RoomID) ([]event.RoomEvent, error)
+}
+
+// Outdated returns true if the index was built with an older Version and
+// should be rebuilt using Rebuild.
+func (idx *Indexer) Outdated() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.outdated
+}
+
+// Rebuild rebuilds the index from scratch using the given source. The old index
+// keeps serving queries until the new one is fully built, at which point the
+// old one is replaced. Rebuild blocks until it's done, so the caller should
+// call it in a goroutine.
+func (idx *Indexer) Rebuild(src Source) error {
+	rebuildPath := idx.path + ".rebuild"
+
+	idx.mu.Lock()
+	if idx.closed || idx.next != nil {
+		idx.mu.Unlock()
+		return errors.New("index is closed or is already being rebuilt")
+	}
+
+	// Wipe any leftover from a previous rebuild that was interrupted. This is
+	// done with the lock acquired, so a rebuild in progress is never wiped.
+	if err := os.RemoveAll(rebuildPath); err != nil {
+		idx.mu.Unlock()
+		return errors.Wrap(err, "failed to wipe old rebuild")
+	}
+
+	// Don't use newIndex, since the version must only be written once the
+	// index is fully built.
+	next, err := bleve.New(rebuildPath, newIndexMapping())
+	if err != nil {
+		idx.mu.Unlock()
+		return errors.Wrap(err, "failed to create new index")
+	}
+
+	idx.next = next
+	idx.mu.Unlock()
+
+	if err := rebuildFrom(next, src); err != nil {
+		idx.abandonRebuild(next)
+		return err
+	}
+
+	if err := next.SetInternal(versionKey, []byte(strconv.Itoa(Version))); err != nil {
+		idx.abandonRebuild(next)
+		return errors.Wrap(err, "failed to write index version")
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.next != next {
+		// Indexer was closed while we were rebuilding.
+		return errors.New("indexer closed while rebuilding")
+	}
+
+	idx.next = nil
+	next.Close()
+
+	if err := idx.idx.Close(); err != nil {
+		log.Println("indexer: failed to close old index:", err)
+	}
+
+	if err := os.RemoveAll(idx.path); err != nil {
+		return idx.reopen(idx.path, errors.Wrap(err, "failed to remove old index"))
+	}
+
+	if err := os.Rename(rebuildPath, idx.path); err != nil {
+		return idx.reopen(rebuildPath, errors.Wrap(err, "failed to move new index"))
+	}
+
+	return idx.reopen(idx.path, nil)
+}
+
+// reopen opens the index at the given path as the index to be queried. It must
+// be called with mu acquired. The given error is returned if there's no error
+// while opening. If the index can't be opened, then an empty in-memory index is
+// used until the next Open, and the error is returned.
+func (idx *Indexer) reopen(path string, err error) error {
+	x, openErr := bleve.Open(path)
+	if openErr != nil {
+		log.Printf("indexer: failed to reopen index %q, using an in-memory index: %v", path, openErr)
+
+		if err == nil {
+			err = errors.Wrap(openErr, "failed to reopen index")
+		}
+
+		// We've closed the old index, so we have to have something.
+		x, openErr = bleve.NewMemOnly(newIndexMapping())
+		if openErr != nil {
+			return errors.Wrap(openErr, "failed to create fallback index")
+		}
+	}
+
+	idx.idx = x
+	idx.outdated = indexVersion(x) != Version
+
+	return err
+}
+
+func (idx *Indexer) abandonRebuild(next bleve.Index) {
+	idx.mu.Lock()
+	if idx.next == next {
+		idx.next = nil
+	}
+	idx.mu.Unlock()
+
+	next.Close()
+}
+
+func rebuildFrom(idx bleve.Index, src Source) error {
+	rooms, err := src.Rooms()
+	if err != nil {
+		// Don't swap in an empty index.
+		return errors.Wrap(err, "failed to get rooms")
+	}
+
+	for _, roomID := range rooms {
+		b := BatchIndexer{main: newIndexBatch(idx)}
+
+		src.EachRoomStateLen(roomID, event.TypeRoomMember, func(ev event.StateEvent, _ int) error {
+			if member, ok := ev.(*event.RoomMemberEvent); ok {
+				b.IndexRoomMember(member)
+			}
+			return nil
+		})
+
+		if events, err := src.RoomTimeline(roomID); err == nil {
+			b.IndexTimeline(events)
+		}
+
+		if err := idx.Batch(b.main.b); err != nil {
+			return errors.Wrapf(err, "failed to index room %q", roomID)
+		}
+	}
+
+	return nil
+}
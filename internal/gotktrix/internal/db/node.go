@@ -351,6 +351,50 @@ func (n Node) DropExceptLast(last int) error {
 	})
 }
 
+// DropBefore drops all values with keys that sort before the given key, except
+// for the last few values, which are always kept. Like DropExceptLast, this
+// method relies on keyed values being sorted properly.
+func (n Node) DropBefore(k string, last int) error {
+	return n.TxUpdate(func(n Node) error {
+		var lastError error
+		var buckets [][]byte
+
+		b, err := n.bucket()
+		if err != nil {
+			return err
+		}
+
+		bound := []byte(k)
+		cursor := b.Cursor()
+
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			if last > 0 {
+				last--
+				continue
+			}
+
+			if string(k) >= string(bound) {
+				continue
+			}
+
+			if v == nil {
+				buckets = append(buckets, append([]byte(nil), k...))
+				continue
+			}
+
+			if err := cursor.Delete(); err != nil {
+				lastError = err
+			}
+		}
+
+		for _, k := range buckets {
+			b.DeleteBucket(k)
+		}
+
+		return lastError
+	})
+}
+
 // Length queries the number of keys within the node, similarly to running
 // AllKeys and taking the length of what was returned.
 func (n Node) Length(prefix string) (int, error) {
@@ -439,6 +483,67 @@ func (n Node) each(rev bool, fn func(k string, b []byte, len int) error) error {
 	})
 }
 
+// EachAfter is like Each, except the iteration starts at the first key that
+// sorts after the given key. The length of the node is not given.
+func (n Node) EachAfter(k string, fn func(k string, b []byte) error) error {
+	return n.eachFrom(k, false, fn)
+}
+
+// EachBefore is like EachReverse, except the iteration starts at the last key
+// that sorts before the given key. The length of the node is not given.
+func (n Node) EachBefore(k string, fn func(k string, b []byte) error) error {
+	return n.eachFrom(k, true, fn)
+}
+
+func (n Node) eachFrom(from string, rev bool, fn func(k string, b []byte) error) error {
+	return n.TxView(func(n Node) error {
+		b, err := n.bucket()
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				// Ignore ErrKeyNotFound and just don't iterate.
+				return nil
+			}
+			return err
+		}
+
+		cursor := b.Cursor()
+
+		var k, v []byte
+		if rev {
+			// Seek to the first key that's at least from, then go back once.
+			// If there's no such key, then every key sorts before from.
+			if k, v = cursor.Seek([]byte(from)); k == nil {
+				k, v = cursor.Last()
+			} else {
+				k, v = cursor.Prev()
+			}
+		} else {
+			k, v = cursor.Seek([]byte(from))
+			if k != nil && string(k) == from {
+				k, v = cursor.Next()
+			}
+		}
+
+		for ; k != nil; k, v = step(cursor, rev) {
+			if err := fn(string(k), v); err != nil {
+				if errors.Is(err, EachBreak) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func step(c *bbolt.Cursor, rev bool) (k, v []byte) {
+	if rev {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
 func eachBucket(c *bbolt.Cursor, rev bool, fn func(k, v []byte) error) error {
 	var err error
 
@@ -0,0 +1,203 @@
+// Package discovery resolves homeservers using /.well-known/matrix/client and
+// validates them using /versions. It works around gotrix's discovery, which
+// fails on servers without a .well-known file and ignores servers that aren't
+// reachable.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/pkg/errors"
+)
+
+// Errors that HomeserverError unwraps to.
+var (
+	// ErrUnreachable is returned if the homeserver cannot be connected to.
+	ErrUnreachable = errors.New("homeserver is unreachable")
+	// ErrNotMatrix is returned if the server doesn't speak the Matrix
+	// client-server API.
+	ErrNotMatrix = errors.New("not a Matrix homeserver")
+)
+
+// HomeserverError is returned when the homeserver at BaseURL cannot be used.
+type HomeserverError struct {
+	BaseURL string
+	// Reason is either ErrUnreachable or ErrNotMatrix.
+	Reason error
+	// Err is the underlying error. It may be nil.
+	Err error
+}
+
+// Error implements error.
+func (err *HomeserverError) Error() string {
+	var msg string
+	if err.Reason == ErrUnreachable {
+		msg = "cannot connect to " + err.BaseURL
+	} else {
+		msg = err.BaseURL + " is not a Matrix homeserver"
+	}
+
+	if err.Err != nil {
+		msg += ": " + err.Err.Error()
+	}
+
+	return msg
+}
+
+// Unwrap returns the reason.
+func (err *HomeserverError) Unwrap() error {
+	return err.Reason
+}
+
+// Versions is the response of /versions. It describes the spec versions and
+// the unstable features that the homeserver supports.
+type Versions struct {
+	Versions         []string        `json:"versions"`
+	UnstableFeatures map[string]bool `json:"unstable_features,omitempty"`
+}
+
+// Supports returns true if the homeserver supports the given spec version,
+// such as "v1.1" or "r0.6.1".
+func (v *Versions) Supports(version string) bool {
+	for _, supported := range v.Versions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFeature returns true if the homeserver enables the given unstable
+// feature, such as "org.matrix.msc2432".
+func (v *Versions) HasFeature(feature string) bool {
+	return v.UnstableFeatures[feature]
+}
+
+// ParseServerName parses the user-provided server name or homeserver address
+// into a URL with only the scheme and host. HTTPS is assumed if the scheme is
+// missing. The path is dropped, since gotrix only talks to homeservers at the
+// root of their host.
+func ParseServerName(serverName string) (*url.URL, error) {
+	serverName = strings.TrimSpace(serverName)
+	if !strings.Contains(serverName, "://") {
+		serverName = "https://" + serverName
+	}
+
+	u, err := url.Parse(serverName)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid homeserver address")
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.Errorf("invalid homeserver address: unknown scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("invalid homeserver address: missing host")
+	}
+
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+func clientFor(client httputil.Client, u *url.URL) *httputil.Client {
+	client.HomeServer = u.Host
+	client.HomeServerScheme = u.Scheme
+	return &client
+}
+
+// ResolveWellKnown resolves the server name into the base URL of its homeserver
+// using /.well-known/matrix/client. The server name is used as the base URL if
+// it has no valid .well-known file.
+func ResolveWellKnown(client httputil.Client, serverName string) (*url.URL, error) {
+	server, err := ParseServerName(serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	var info api.DiscoveryInfoResponse
+
+	err = clientFor(client, server).Request("GET", ".well-known/matrix/client", &info)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		// The server name might already be the homeserver's address, so it's
+		// validated later.
+		return server, nil
+	}
+
+	if info.HomeServer.BaseURL == "" {
+		return server, nil
+	}
+
+	baseURL, err := ParseServerName(info.HomeServer.BaseURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s has an invalid .well-known file", server.Host)
+	}
+
+	return baseURL, nil
+}
+
+// FetchVersions fetches /versions from the homeserver at the given base URL.
+// A *HomeserverError is returned if it's unreachable or not a Matrix
+// homeserver.
+func FetchVersions(client httputil.Client, baseURL *url.URL) (*Versions, error) {
+	var v Versions
+
+	err := clientFor(client, baseURL).Request("GET", api.EndpointSupportedVersions, &v)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+
+		hsErr := &HomeserverError{
+			BaseURL: baseURL.String(),
+			Reason:  ErrNotMatrix,
+			Err:     err,
+		}
+
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			hsErr.Reason = ErrUnreachable
+			hsErr.Err = urlErr.Err
+		}
+
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			// The server replied with something else, like a web page.
+			hsErr.Err = nil
+		}
+
+		return nil, hsErr
+	}
+
+	if len(v.Versions) == 0 {
+		return nil, &HomeserverError{
+			BaseURL: baseURL.String(),
+			Reason:  ErrNotMatrix,
+		}
+	}
+
+	return &v, nil
+}
+
+// Resolve resolves the server name into its homeserver's base URL and checks
+// that the homeserver is usable.
+func Resolve(client httputil.Client, serverName string) (*url.URL, *Versions, error) {
+	baseURL, err := ResolveWellKnown(client, serverName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v, err := FetchVersions(client, baseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return baseURL, v, nil
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/pkg/errors"
+)
+
+const versionsJSON = `{
+	"versions": ["r0.6.1", "v1.1", "v1.2"],
+	"unstable_features": {"org.matrix.msc2432": true, "org.matrix.e2e_cross_signing": false}
+}`
+
+func newServer(t *testing.T, routes map[string]string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestResolve(t *testing.T) {
+	client := httputil.NewClient()
+
+	homeserver := newServer(t, map[string]string{
+		"/_matrix/client/versions": versionsJSON,
+	})
+	delegated := newServer(t, map[string]string{
+		"/.well-known/matrix/client": fmt.Sprintf(`{"m.homeserver": {"base_url": %q}}`, homeserver.URL+"/"),
+	})
+
+	tests := []struct {
+		name       string
+		serverName string
+		baseURL    string
+	}{
+		{"well-known", delegated.URL, homeserver.URL},
+		{"no well-known", homeserver.URL, homeserver.URL},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseURL, v, err := Resolve(client, test.serverName)
+			if err != nil {
+				t.Fatal("cannot resolve:", err)
+			}
+
+			if baseURL.String() != test.baseURL {
+				t.Errorf("expected base URL %q, got %q", test.baseURL, baseURL)
+			}
+
+			if !v.Supports("v1.1") || v.Supports("v1.0") {
+				t.Errorf("unexpected supported versions %q", v.Versions)
+			}
+			if !v.HasFeature("org.matrix.msc2432") || v.HasFeature("org.matrix.e2e_cross_signing") {
+				t.Errorf("unexpected unstable features %v", v.UnstableFeatures)
+			}
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	client := httputil.NewClient()
+
+	website := newServer(t, map[string]string{
+		"/_matrix/client/versions": "<html>Hello!</html>",
+	})
+	empty := newServer(t, map[string]string{
+		"/_matrix/client/versions": `{"versions": []}`,
+	})
+	badWellKnown := newServer(t, map[string]string{
+		"/.well-known/matrix/client": `{"m.homeserver": {"base_url": "ftp://example.com"}}`,
+	})
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		serverName string
+		reason     error
+	}{
+		{"not found", newServer(t, nil).URL, ErrNotMatrix},
+		{"website", website.URL, ErrNotMatrix},
+		{"no versions", empty.URL, ErrNotMatrix},
+		{"unreachable", closed.URL, ErrUnreachable},
+		{"bad well-known", badWellKnown.URL, nil},
+		{"bad scheme", "ftp://example.com", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Resolve(client, test.serverName)
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+
+			var hsErr *HomeserverError
+			isHomeserverErr := errors.As(err, &hsErr)
+
+			if test.reason == nil {
+				if isHomeserverErr {
+					t.Fatal("unexpected homeserver error:", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, test.reason) {
+				t.Fatalf("expected %v, got %v", test.reason, err)
+			}
+			if !isHomeserverErr {
+				t.Fatal("expected a *HomeserverError, got", err)
+			}
+		})
+	}
+}
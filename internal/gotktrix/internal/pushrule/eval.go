@@ -0,0 +1,242 @@
+package pushrule
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// defaultNotificationLevel is the power level required for notification keys
+// that are missing from the power levels event.
+const defaultNotificationLevel = 50
+
+// Env is the environment that an event is evaluated in.
+type Env struct {
+	// Event is the raw event JSON.
+	Event json.RawMessage
+	// RoomID is the room that the event is in. It's used if the event has no
+	// room_id field, which is the case for events from the sync.
+	RoomID matrix.RoomID
+	// DisplayName is the user's display name in the room. An empty display
+	// name is never contained in any event.
+	DisplayName string
+	// MemberCount is the number of joined members in the room.
+	MemberCount int
+	// SenderLevel is the power level of the event's sender.
+	SenderLevel int
+	// NotificationLevels maps notification keys, such as "room", to the power
+	// level required to trigger them. Missing keys require a level of 50.
+	NotificationLevels map[string]int
+}
+
+// Result is the rule that matched an event.
+type Result struct {
+	Kind Kind
+	Rule Rule
+}
+
+// Notify returns true if the event should notify.
+func (r Result) Notify() bool { return r.Rule.Actions.Notify }
+
+// Highlight returns true if the event should be highlighted.
+func (r Result) Highlight() bool { return r.Rule.Actions.Highlight() }
+
+// Sound returns the sound that the notification should play, or an empty
+// string if it should be silent.
+func (r Result) Sound() string { return r.Rule.Actions.Sound() }
+
+// Evaluate finds the first enabled rule that matches the event in env. Rules
+// are tried in the order of Kinds. False is returned if no rules match, in
+// which case the event shouldn't notify.
+func (r *Ruleset) Evaluate(env Env) (Result, bool) {
+	fields := flatten(env.Event)
+	if _, ok := fields["room_id"]; !ok && env.RoomID != "" {
+		fields["room_id"] = string(env.RoomID)
+	}
+
+	for _, kind := range Kinds {
+		for _, rule := range r.Rules(kind) {
+			if rule.Enabled && rule.matches(kind, env, fields) {
+				return Result{Kind: kind, Rule: rule}, true
+			}
+		}
+	}
+
+	return Result{}, false
+}
+
+func (rule Rule) matches(kind Kind, env Env, fields map[string]string) bool {
+	switch kind {
+	case Content:
+		body, ok := fields["content.body"]
+		return ok && rule.Pattern != "" && globMatch(rule.Pattern, body, true)
+	case Room:
+		return fields["room_id"] == rule.RuleID
+	case Sender:
+		return fields["sender"] == rule.RuleID
+	}
+
+	for _, cond := range rule.Conditions {
+		if !cond.matches(env, fields) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (cond Condition) matches(env Env, fields map[string]string) bool {
+	switch cond.Kind {
+	case EventMatch:
+		v, ok := fields[cond.Key]
+		// Only the body is matched by words, since it's the only field that
+		// isn't an identifier.
+		return ok && globMatch(cond.Pattern, v, cond.Key == "content.body")
+
+	case ContainsDisplayName:
+		body, ok := fields["content.body"]
+		return ok && env.DisplayName != "" && containsWords(body, env.DisplayName)
+
+	case RoomMemberCount:
+		return compareIs(cond.Is, env.MemberCount)
+
+	case SenderNotificationPermission:
+		level, ok := env.NotificationLevels[cond.Key]
+		if !ok {
+			level = defaultNotificationLevel
+		}
+		return env.SenderLevel >= level
+
+	default:
+		// Unknown conditions never match, as required by the specification.
+		return false
+	}
+}
+
+// compareIs compares n using the Is field of a room_member_count condition.
+func compareIs(is string, n int) bool {
+	// Two-character prefixes must come first.
+	for _, prefix := range []string{"==", "<=", ">=", "<", ">"} {
+		if !strings.HasPrefix(is, prefix) {
+			continue
+		}
+
+		v, err := strconv.Atoi(strings.TrimPrefix(is, prefix))
+		if err != nil {
+			return false
+		}
+
+		switch prefix {
+		case "==":
+			return n == v
+		case "<=":
+			return n <= v
+		case ">=":
+			return n >= v
+		case "<":
+			return n < v
+		case ">":
+			return n > v
+		}
+	}
+
+	v, err := strconv.Atoi(is)
+	return err == nil && n == v
+}
+
+// flatten flattens the string fields of the given JSON object into a map of
+// dot-separated keys.
+func flatten(raw json.RawMessage) map[string]string {
+	fields := make(map[string]string)
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fields
+	}
+
+	var walk func(prefix string, obj map[string]interface{})
+	walk = func(prefix string, obj map[string]interface{}) {
+		for k, v := range obj {
+			switch v := v.(type) {
+			case string:
+				fields[prefix+k] = v
+			case map[string]interface{}:
+				walk(prefix+k+".", v)
+			}
+		}
+	}
+	walk("", obj)
+
+	return fields
+}
+
+// globCache caches the regular expressions compiled from patterns, since the
+// same few rules are evaluated for every event.
+var (
+	globMutex sync.Mutex
+	globCache = map[string]*regexp.Regexp{}
+)
+
+// globMatch matches the glob pattern case-insensitively. If words is true, then
+// the pattern may match any part of str between word boundaries; otherwise, it
+// must match the whole string.
+func globMatch(pattern, str string, words bool) bool {
+	key := "f:" + pattern
+	if words {
+		key = "w:" + pattern
+	}
+
+	re := cachedRegexp(key, func() *regexp.Regexp {
+		var expr strings.Builder
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				expr.WriteString(".*")
+			case '?':
+				expr.WriteString(".")
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+
+		if words {
+			return wordRegexp(expr.String())
+		}
+		return regexp.MustCompile(`(?is)^` + expr.String() + `$`)
+	})
+
+	return re.MatchString(str)
+}
+
+// containsWords returns true if str contains words between word boundaries,
+// ignoring case.
+func containsWords(str, words string) bool {
+	re := cachedRegexp("l:"+words, func() *regexp.Regexp {
+		return wordRegexp(regexp.QuoteMeta(words))
+	})
+	return re.MatchString(str)
+}
+
+func cachedRegexp(key string, compile func() *regexp.Regexp) *regexp.Regexp {
+	globMutex.Lock()
+	defer globMutex.Unlock()
+
+	re, ok := globCache[key]
+	if !ok {
+		re = compile()
+		globCache[key] = re
+	}
+
+	return re
+}
+
+// wordRegexp compiles a case-insensitive regular expression that matches expr
+// between word boundaries.
+func wordRegexp(expr string) *regexp.Regexp {
+	const boundary = `[^\p{L}\p{N}_]`
+	return regexp.MustCompile(`(?is)(?:^|` + boundary + `)` + expr + `(?:` + boundary + `|$)`)
+}
@@ -0,0 +1,297 @@
+// Package pushrule implements the push rules of the Matrix specification. It
+// works around gotrix's push rule types, which only look at the first action
+// and fail to parse rules without any.
+package pushrule
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of a push rule. Rules are evaluated in the order of Kinds.
+type Kind string
+
+const (
+	Override  Kind = "override"
+	Content   Kind = "content"
+	Room      Kind = "room"
+	Sender    Kind = "sender"
+	Underride Kind = "underride"
+)
+
+// Kinds contains all rule kinds in their evaluation order.
+var Kinds = []Kind{Override, Content, Room, Sender, Underride}
+
+// Ruleset is the global push ruleset of a user.
+type Ruleset struct {
+	Override  []Rule `json:"override,omitempty"`
+	Content   []Rule `json:"content,omitempty"`
+	Room      []Rule `json:"room,omitempty"`
+	Sender    []Rule `json:"sender,omitempty"`
+	Underride []Rule `json:"underride,omitempty"`
+}
+
+// Parse parses the ruleset from the given m.push_rules event.
+func Parse(raw json.RawMessage) (*Ruleset, error) {
+	var ev struct {
+		Content struct {
+			Global Ruleset `json:"global"`
+		} `json:"content"`
+	}
+
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return nil, errors.Wrap(err, "failed to parse push rules")
+	}
+
+	return &ev.Content.Global, nil
+}
+
+// Rules returns the rules of the given kind.
+func (r *Ruleset) Rules(kind Kind) []Rule {
+	if rules := r.rules(kind); rules != nil {
+		return *rules
+	}
+	return nil
+}
+
+// Rule returns the rule with the given kind and ID.
+func (r *Ruleset) Rule(kind Kind, id string) (Rule, bool) {
+	for _, rule := range r.Rules(kind) {
+		if rule.RuleID == id {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Rule is a single push rule.
+type Rule struct {
+	RuleID  string `json:"rule_id"`
+	Default bool   `json:"default"`
+	Enabled bool   `json:"enabled"`
+	// Pattern is the glob pattern matched against content.body. It's only
+	// used by content rules.
+	Pattern string `json:"pattern,omitempty"`
+	// Conditions must all match for the rule to match. They're only used by
+	// override and underride rules.
+	Conditions []Condition `json:"conditions,omitempty"`
+	Actions    Actions     `json:"actions"`
+}
+
+// ConditionKind is the kind of a push condition.
+type ConditionKind string
+
+const (
+	// EventMatch matches Pattern against the event field named by Key.
+	EventMatch ConditionKind = "event_match"
+	// ContainsDisplayName matches events whose content.body contains the
+	// user's display name in the room.
+	ContainsDisplayName ConditionKind = "contains_display_name"
+	// RoomMemberCount compares the number of joined members with Is.
+	RoomMemberCount ConditionKind = "room_member_count"
+	// SenderNotificationPermission matches events whose sender has the power
+	// level required by the notifications field named by Key.
+	SenderNotificationPermission ConditionKind = "sender_notification_permission"
+)
+
+// Condition is a condition of a push rule.
+type Condition struct {
+	Kind    ConditionKind `json:"kind"`
+	Key     string        `json:"key,omitempty"`
+	Pattern string        `json:"pattern,omitempty"`
+	// Is is a decimal integer optionally prefixed by one of ==, <, >, >= or
+	// <=. A missing prefix means ==.
+	Is string `json:"is,omitempty"`
+}
+
+// Tweak is the name of an action tweak.
+type Tweak string
+
+const (
+	SoundTweak     Tweak = "sound"
+	HighlightTweak Tweak = "highlight"
+)
+
+// Actions is the list of actions of a push rule. Tweaks set later in the list
+// override earlier ones.
+type Actions struct {
+	Notify bool
+	Tweaks map[Tweak]json.RawMessage
+}
+
+type actionTweak struct {
+	SetTweak Tweak           `json:"set_tweak"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+// UnmarshalJSON unmarshals the actions array. Unknown actions are ignored.
+func (a *Actions) UnmarshalJSON(b []byte) error {
+	*a = Actions{}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		var action string
+		if json.Unmarshal(value, &action) == nil {
+			switch action {
+			case "notify", "coalesce":
+				a.Notify = true
+			case "dont_notify":
+				a.Notify = false
+			}
+			continue
+		}
+
+		var tweak actionTweak
+		if err := json.Unmarshal(value, &tweak); err != nil {
+			return errors.Wrap(err, "invalid action")
+		}
+
+		if a.Tweaks == nil {
+			a.Tweaks = make(map[Tweak]json.RawMessage, 2)
+		}
+		a.Tweaks[tweak.SetTweak] = tweak.Value
+	}
+
+	return nil
+}
+
+// MarshalJSON marshals the actions into an array. Tweaks are sorted by name.
+func (a Actions) MarshalJSON() ([]byte, error) {
+	values := make([]interface{}, 0, 1+len(a.Tweaks))
+
+	if a.Notify {
+		values = append(values, "notify")
+	} else {
+		values = append(values, "dont_notify")
+	}
+
+	tweaks := make([]actionTweak, 0, len(a.Tweaks))
+	for name, value := range a.Tweaks {
+		tweaks = append(tweaks, actionTweak{name, value})
+	}
+
+	sort.Slice(tweaks, func(i, j int) bool {
+		return tweaks[i].SetTweak < tweaks[j].SetTweak
+	})
+
+	for _, tweak := range tweaks {
+		values = append(values, tweak)
+	}
+
+	return json.Marshal(values)
+}
+
+// Highlight returns true if the highlight tweak is set. A highlight tweak
+// without a value means true.
+func (a Actions) Highlight() bool {
+	raw, ok := a.Tweaks[HighlightTweak]
+	if !ok {
+		return false
+	}
+	if len(raw) == 0 {
+		return true
+	}
+
+	var hl bool
+	json.Unmarshal(raw, &hl)
+	return hl
+}
+
+// Sound returns the sound tweak, or an empty string if there's none.
+func (a Actions) Sound() string {
+	var sound string
+	json.Unmarshal(a.Tweaks[SoundTweak], &sound)
+	return sound
+}
+
+// MasterRuleID is the ID of the override rule that mutes everything when it's
+// enabled. It's always evaluated first.
+const MasterRuleID = ".m.rule.master"
+
+// IsServerDefault returns true if the rule is a server-default rule, which can
+// only be enabled or disabled.
+func (rule Rule) IsServerDefault() bool {
+	return strings.HasPrefix(rule.RuleID, ".")
+}
+
+func (r *Ruleset) rules(kind Kind) *[]Rule {
+	switch kind {
+	case Override:
+		return &r.Override
+	case Content:
+		return &r.Content
+	case Room:
+		return &r.Room
+	case Sender:
+		return &r.Sender
+	case Underride:
+		return &r.Underride
+	default:
+		return nil
+	}
+}
+
+// Put adds the given rule, replacing the one with the same ID. A new rule is
+// given the highest priority among the user's rules of its kind, which is how
+// the server orders it.
+func (r *Ruleset) Put(kind Kind, rule Rule) {
+	rules := r.rules(kind)
+	if rules == nil {
+		return
+	}
+
+	r.Delete(kind, rule.RuleID)
+
+	// Only the master rule may come before user rules.
+	var i int
+	if len(*rules) > 0 && (*rules)[0].RuleID == MasterRuleID {
+		i = 1
+	}
+
+	*rules = append(*rules, Rule{})
+	copy((*rules)[i+1:], (*rules)[i:])
+	(*rules)[i] = rule
+}
+
+// Delete deletes the rule with the given kind and ID. False is returned if
+// there's no such rule.
+func (r *Ruleset) Delete(kind Kind, id string) bool {
+	rules := r.rules(kind)
+	if rules == nil {
+		return false
+	}
+
+	for i, rule := range *rules {
+		if rule.RuleID == id {
+			*rules = append((*rules)[:i], (*rules)[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetEnabled enables or disables the rule with the given kind and ID. False is
+// returned if there's no such rule.
+func (r *Ruleset) SetEnabled(kind Kind, id string, enabled bool) bool {
+	rules := r.rules(kind)
+	if rules == nil {
+		return false
+	}
+
+	for i := range *rules {
+		if (*rules)[i].RuleID == id {
+			(*rules)[i].Enabled = enabled
+			return true
+		}
+	}
+
+	return false
+}
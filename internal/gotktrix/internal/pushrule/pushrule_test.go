@@ -0,0 +1,355 @@
+package pushrule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// defaultRules is a trimmed down version of Synapse's default ruleset with a
+// few user rules added on top.
+const defaultRules = `{
+	"type": "m.push_rules",
+	"content": {"global": {
+		"override": [
+			{"rule_id": ".m.rule.master", "default": true, "enabled": false,
+			 "conditions": [], "actions": ["dont_notify"]},
+			{"rule_id": "!muted:example.com", "default": false, "enabled": true,
+			 "conditions": [{"kind": "event_match", "key": "room_id", "pattern": "!muted:example.com"}],
+			 "actions": ["dont_notify"]},
+			{"rule_id": ".m.rule.suppress_notices", "default": true, "enabled": true,
+			 "conditions": [{"kind": "event_match", "key": "content.msgtype", "pattern": "m.notice"}],
+			 "actions": ["dont_notify"]},
+			{"rule_id": ".m.rule.contains_display_name", "default": true, "enabled": true,
+			 "conditions": [{"kind": "contains_display_name"}],
+			 "actions": ["notify", {"set_tweak": "sound", "value": "default"}, {"set_tweak": "highlight"}]},
+			{"rule_id": ".m.rule.roomnotif", "default": true, "enabled": true,
+			 "conditions": [
+				{"kind": "event_match", "key": "content.body", "pattern": "@room"},
+				{"kind": "sender_notification_permission", "key": "room"}
+			 ],
+			 "actions": ["notify", {"set_tweak": "highlight", "value": true}]}
+		],
+		"content": [
+			{"rule_id": ".m.rule.contains_user_name", "default": true, "enabled": true,
+			 "pattern": "alice",
+			 "actions": ["notify", {"set_tweak": "sound", "value": "default"}, {"set_tweak": "highlight"}]},
+			{"rule_id": "gotk*", "default": false, "enabled": true,
+			 "pattern": "gotk*",
+			 "actions": ["notify", {"set_tweak": "highlight", "value": false}, {"set_tweak": "highlight", "value": true}]}
+		],
+		"room": [
+			{"rule_id": "!mentions:example.com", "default": false, "enabled": true,
+			 "actions": ["dont_notify"]}
+		],
+		"sender": [
+			{"rule_id": "@loud:example.com", "default": false, "enabled": true,
+			 "actions": ["notify", {"set_tweak": "sound", "value": "ping"}]}
+		],
+		"underride": [
+			{"rule_id": ".m.rule.room_one_to_one", "default": true, "enabled": true,
+			 "conditions": [
+				{"kind": "room_member_count", "is": "2"},
+				{"kind": "event_match", "key": "type", "pattern": "m.room.message"}
+			 ],
+			 "actions": ["notify", {"set_tweak": "sound", "value": "default"}, {"set_tweak": "highlight", "value": false}]},
+			{"rule_id": ".m.rule.message", "default": true, "enabled": true,
+			 "conditions": [{"kind": "event_match", "key": "type", "pattern": "m.room.message"}],
+			 "actions": ["notify"]}
+		]
+	}}
+}`
+
+func TestEvaluate(t *testing.T) {
+	rules, err := Parse(json.RawMessage(defaultRules))
+	if err != nil {
+		t.Fatal("failed to parse rules:", err)
+	}
+
+	type expect struct {
+		match     bool
+		ruleID    string
+		kind      Kind
+		notify    bool
+		highlight bool
+		sound     string
+	}
+
+	tests := []struct {
+		name   string
+		roomID string
+		sender string
+		body   string
+		// msgtype defaults to m.text.
+		msgtype     string
+		members     int
+		senderLevel int
+		expect      expect
+	}{
+		{
+			name:   "plain message",
+			body:   "hello world",
+			expect: expect{true, ".m.rule.message", Underride, true, false, ""},
+		},
+		{
+			name:    "direct message",
+			body:    "hello",
+			members: 2,
+			expect:  expect{true, ".m.rule.room_one_to_one", Underride, true, false, "default"},
+		},
+		{
+			name:    "member count mismatch",
+			body:    "hello",
+			members: 3,
+			expect:  expect{true, ".m.rule.message", Underride, true, false, ""},
+		},
+		{
+			name:   "display name",
+			body:   "hey Alice Liddell, you there?",
+			expect: expect{true, ".m.rule.contains_display_name", Override, true, true, "default"},
+		},
+		{
+			name:   "display name within word",
+			body:   "xAlice Liddellx",
+			expect: expect{true, ".m.rule.message", Underride, true, false, ""},
+		},
+		{
+			name:   "user name",
+			body:   "ALICE: ping",
+			expect: expect{true, ".m.rule.contains_user_name", Content, true, true, "default"},
+		},
+		{
+			name:   "user name within word",
+			body:   "malice",
+			expect: expect{true, ".m.rule.message", Underride, true, false, ""},
+		},
+		{
+			name:   "content glob with merged tweaks",
+			body:   "is gotktrix good",
+			expect: expect{true, "gotk*", Content, true, true, ""},
+		},
+		{
+			name:    "suppressed notice",
+			body:    "alice",
+			msgtype: "m.notice",
+			expect:  expect{true, ".m.rule.suppress_notices", Override, false, false, ""},
+		},
+		{
+			name:        "room ping with permission",
+			body:        "@room hi",
+			senderLevel: 50,
+			expect:      expect{true, ".m.rule.roomnotif", Override, true, true, ""},
+		},
+		{
+			name:        "room ping without permission",
+			body:        "@room hi",
+			senderLevel: 0,
+			expect:      expect{true, ".m.rule.message", Underride, true, false, ""},
+		},
+		{
+			name:   "muted room",
+			roomID: "!muted:example.com",
+			body:   "alice!",
+			expect: expect{true, "!muted:example.com", Override, false, false, ""},
+		},
+		{
+			name:   "mentions-only room",
+			roomID: "!mentions:example.com",
+			body:   "hello",
+			expect: expect{true, "!mentions:example.com", Room, false, false, ""},
+		},
+		{
+			name:   "mentions-only room with mention",
+			roomID: "!mentions:example.com",
+			body:   "alice, hello",
+			expect: expect{true, ".m.rule.contains_user_name", Content, true, true, "default"},
+		},
+		{
+			name:   "sender rule",
+			sender: "@loud:example.com",
+			body:   "hello",
+			expect: expect{true, "@loud:example.com", Sender, true, false, "ping"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.roomID == "" {
+				test.roomID = "!room:example.com"
+			}
+			if test.sender == "" {
+				test.sender = "@bob:example.com"
+			}
+			if test.msgtype == "" {
+				test.msgtype = "m.text"
+			}
+
+			ev, _ := json.Marshal(map[string]interface{}{
+				"type":    "m.room.message",
+				"sender":  test.sender,
+				"content": map[string]string{"msgtype": test.msgtype, "body": test.body},
+			})
+
+			result, ok := rules.Evaluate(Env{
+				Event:              ev,
+				RoomID:             matrix.RoomID(test.roomID),
+				DisplayName:        "Alice Liddell",
+				MemberCount:        test.members,
+				SenderLevel:        test.senderLevel,
+				NotificationLevels: map[string]int{"room": 50},
+			})
+
+			got := expect{
+				match:     ok,
+				ruleID:    result.Rule.RuleID,
+				kind:      result.Kind,
+				notify:    result.Notify(),
+				highlight: result.Highlight(),
+				sound:     result.Sound(),
+			}
+
+			if got != test.expect {
+				t.Fatalf("unexpected result:\nexpected %+v\ngot      %+v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestEvaluateMaster(t *testing.T) {
+	rules, err := Parse(json.RawMessage(defaultRules))
+	if err != nil {
+		t.Fatal("failed to parse rules:", err)
+	}
+
+	rules.Override[0].Enabled = true
+
+	result, ok := rules.Evaluate(Env{
+		Event:       json.RawMessage(`{"type":"m.room.message","content":{"body":"alice"}}`),
+		DisplayName: "alice",
+	})
+	if !ok || result.Rule.RuleID != ".m.rule.master" || result.Notify() {
+		t.Fatalf("master rule didn't mute, got %+v", result)
+	}
+}
+
+func TestCompareIs(t *testing.T) {
+	tests := []struct {
+		is     string
+		n      int
+		expect bool
+	}{
+		{"2", 2, true},
+		{"2", 3, false},
+		{"==2", 2, true},
+		{"<2", 1, true},
+		{"<2", 2, false},
+		{"<=2", 2, true},
+		{"<=2", 3, false},
+		{">2", 3, true},
+		{">2", 2, false},
+		{">=2", 2, true},
+		{">=2", 1, false},
+		{"", 0, false},
+		{"two", 2, false},
+	}
+
+	for _, test := range tests {
+		if got := compareIs(test.is, test.n); got != test.expect {
+			t.Errorf("compareIs(%q, %d) = %v, expected %v", test.is, test.n, got, test.expect)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		words   bool
+		expect  bool
+	}{
+		{"m.room.message", "m.room.message", false, true},
+		{"m.room.*", "m.room.message", false, true},
+		{"m.room.*", "m.room", false, false},
+		{"m.notice", "m.notice.extra", false, false},
+		{"!a:example.com/*", "!a:example.com/b/c", false, true},
+		{"m.?ext", "m.text", false, true},
+		{"M.TEXT", "m.text", false, true},
+		{"cake*lie", "the cake is a lie", true, true},
+		{"lie", "the cake is a lie.", true, true},
+		{"lie", "believe", true, false},
+		{"ünïcode", "ÜNÏCODE!", true, true},
+	}
+
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.str, test.words); got != test.expect {
+			t.Errorf("globMatch(%q, %q, %v) = %v, expected %v",
+				test.pattern, test.str, test.words, got, test.expect)
+		}
+	}
+}
+
+func TestActionsJSON(t *testing.T) {
+	var actions Actions
+	raw := `["notify",{"set_tweak":"sound","value":"default"},{"set_tweak":"highlight"}]`
+
+	if err := json.Unmarshal([]byte(raw), &actions); err != nil {
+		t.Fatal("failed to unmarshal actions:", err)
+	}
+
+	if !actions.Notify || !actions.Highlight() || actions.Sound() != "default" {
+		t.Fatalf("unexpected actions %+v", actions)
+	}
+
+	b, err := json.Marshal(actions)
+	if err != nil {
+		t.Fatal("failed to marshal actions:", err)
+	}
+
+	if expect := `["notify",{"set_tweak":"highlight"},{"set_tweak":"sound","value":"default"}]`; string(b) != expect {
+		t.Fatalf("unexpected actions JSON:\nexpected %s\ngot      %s", expect, b)
+	}
+
+	// Newer servers send no actions at all instead of dont_notify.
+	if err := json.Unmarshal([]byte(`[]`), &actions); err != nil || actions.Notify {
+		t.Fatalf("empty actions should not notify, got %+v (%v)", actions, err)
+	}
+}
+
+func TestRulesetEdit(t *testing.T) {
+	rules, err := Parse(json.RawMessage(defaultRules))
+	if err != nil {
+		t.Fatal("failed to parse rules:", err)
+	}
+
+	env := Env{
+		Event:  json.RawMessage(`{"type":"m.room.message","sender":"@bob:example.com","content":{"body":"hi"}}`),
+		RoomID: "!room:example.com",
+	}
+
+	rules.Put(Override, Rule{
+		RuleID:     "!room:example.com",
+		Enabled:    true,
+		Conditions: []Condition{{Kind: EventMatch, Key: "room_id", Pattern: "!room:example.com"}},
+	})
+
+	if rules.Override[0].RuleID != MasterRuleID || rules.Override[1].RuleID != "!room:example.com" {
+		t.Fatalf("new rule not put after the master rule: %+v", rules.Override[:2])
+	}
+
+	if result, _ := rules.Evaluate(env); result.Rule.RuleID != "!room:example.com" || result.Notify() {
+		t.Fatalf("room not muted, got %+v", result)
+	}
+
+	if !rules.Delete(Override, "!room:example.com") {
+		t.Fatal("failed to delete rule")
+	}
+
+	if !rules.SetEnabled(Underride, ".m.rule.message", false) {
+		t.Fatal("failed to disable rule")
+	}
+
+	if result, ok := rules.Evaluate(env); ok {
+		t.Fatalf("unexpected match %+v", result)
+	}
+}
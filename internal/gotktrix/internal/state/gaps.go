@@ -0,0 +1,342 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// TimelineGap describes a hole in the stored timeline of a room. Gaps are made
+// by limited syncs, which skip over the events between the latest stored event
+// and the events in the sync.
+type TimelineGap struct {
+	// Before is the latest stored event before the gap.
+	Before matrix.EventID `json:"before"`
+	// After is the earliest stored event after the gap.
+	After matrix.EventID `json:"after"`
+	// Token is the pagination token to paginate backwards from to fill the
+	// gap.
+	Token string `json:"token"`
+}
+
+// gapEntry is a TimelineGap as stored in the database. It's keyed by the
+// timeline key of the After event.
+type gapEntry struct {
+	TimelineGap
+	BeforeKey string `json:"before_key"`
+}
+
+func (p *dbPaths) timelineGapsNode(n db.Node, roomID matrix.RoomID) db.Node {
+	return p.timelineNode(n, roomID).Node("gaps")
+}
+
+// setGap records a gap between the latest stored event and the given limited
+// timeline. It must be called before the timeline is stored.
+func (p *dbPaths) setGap(n db.Node, roomID matrix.RoomID, tl api.SyncTimeline) {
+	if tl.PreviousBatch == "" || len(tl.Events) == 0 {
+		return
+	}
+
+	var gap gapEntry
+
+	p.timelineEventsNode(n, roomID).EachReverse(func(k string, b []byte, _ int) error {
+		var base timelineEventBase
+		json.Unmarshal(b, &base)
+
+		gap.Before = base.ID
+		gap.BeforeKey = k
+		return db.EachBreak
+	})
+
+	if gap.BeforeKey == "" {
+		// Nothing is stored, so there's nothing to have a gap with.
+		return
+	}
+
+	var after timelineEventBase
+	json.Unmarshal(tl.Events[0], &after)
+
+	afterKey := eventKey(after.OriginServerTime, after.ID)
+	if afterKey <= gap.BeforeKey {
+		// The timeline overlaps with what we have.
+		return
+	}
+
+	gap.After = after.ID
+	gap.Token = tl.PreviousBatch
+
+	if err := p.timelineGapsNode(n, roomID).SetAny(afterKey, gap); err != nil {
+		log.Printf("failed to save timeline gap for room %q: %v", roomID, err)
+	}
+}
+
+// trimGaps removes the gaps whose Before event is no longer stored. The events
+// before such a gap are also removed, since they can no longer be reached.
+func (p *dbPaths) trimGaps(n db.Node, roomID matrix.RoomID) error {
+	events := p.timelineEventsNode(n, roomID)
+
+	var firstKey string
+	events.Each(func(k string, _ []byte, _ int) error {
+		firstKey = k
+		return db.EachBreak
+	})
+
+	gaps := p.timelineGapsNode(n, roomID)
+
+	var trimmed []string
+	var dropBefore string
+
+	gaps.Each(func(k string, b []byte, _ int) error {
+		var gap gapEntry
+		if err := json.Unmarshal(b, &gap); err != nil || firstKey == "" || gap.BeforeKey < firstKey {
+			trimmed = append(trimmed, k)
+			dropBefore = k
+			return nil
+		}
+		return db.EachBreak
+	})
+
+	if len(trimmed) == 0 {
+		return nil
+	}
+
+	for _, k := range trimmed {
+		if err := gaps.Delete(k); err != nil {
+			return errors.Wrap(err, "failed to delete gap")
+		}
+	}
+
+	if err := events.DropBefore(dropBefore, 0); err != nil {
+		return errors.Wrap(err, "failed to drop events before gap")
+	}
+
+	return nil
+}
+
+// roomGaps returns the gaps of the given room keyed by their After event key.
+func (p *dbPaths) roomGaps(n db.Node, roomID matrix.RoomID) map[string]gapEntry {
+	var gaps map[string]gapEntry
+
+	p.timelineGapsNode(n, roomID).Each(func(k string, b []byte, l int) error {
+		var gap gapEntry
+		if err := json.Unmarshal(b, &gap); err != nil {
+			return nil
+		}
+		if gaps == nil {
+			gaps = make(map[string]gapEntry, l)
+		}
+		gaps[k] = gap
+		return nil
+	})
+
+	return gaps
+}
+
+// RoomGaps returns the gaps in the stored timeline of the given room. The
+// earliest gap is first.
+func (s *State) RoomGaps(roomID matrix.RoomID) []TimelineGap {
+	var gaps []TimelineGap
+
+	s.paths.timelineGapsNode(s.top, roomID).Each(func(_ string, b []byte, l int) error {
+		var gap gapEntry
+		if err := json.Unmarshal(b, &gap); err != nil {
+			return nil
+		}
+		if gaps == nil {
+			gaps = make([]TimelineGap, 0, l)
+		}
+		gaps = append(gaps, gap.TimelineGap)
+		return nil
+	})
+
+	return gaps
+}
+
+// RoomTimelineHas returns true if the given event is in the stored timeline.
+func (s *State) RoomTimelineHas(roomID matrix.RoomID, ev event.RoomEvent) bool {
+	return s.paths.timelineEventsNode(s.top, roomID).Exists(roomEventKey(ev))
+}
+
+// RoomTimelineBefore returns at most limit stored timeline events that are
+// older than the given event, latest first. If before is nil, then the latest
+// events are returned. If the returned events run into a gap, then the gap is
+// returned as well; the last returned event is then the gap's After event, or
+// no events are returned if before is the After event.
+func (s *State) RoomTimelineBefore(
+	roomID matrix.RoomID, before event.RoomEvent, limit int) ([]event.RoomEvent, *TimelineGap) {
+
+	var events []event.RoomEvent
+	var gap *TimelineGap
+
+	s.top.TxView(func(n db.Node) error {
+		gaps := s.paths.roomGaps(n, roomID)
+		tnode := s.paths.timelineEventsNode(n, roomID)
+
+		fn := func(k string, b []byte) error {
+			if len(events) >= limit {
+				return db.EachBreak
+			}
+
+			events = append(events, sys.ParseTimeline(b, roomID))
+
+			if g, ok := gaps[k]; ok {
+				gap = &g.TimelineGap
+				return db.EachBreak
+			}
+
+			return nil
+		}
+
+		if before == nil {
+			return tnode.EachReverse(func(k string, b []byte, _ int) error { return fn(k, b) })
+		}
+
+		key := roomEventKey(before)
+		if g, ok := gaps[key]; ok {
+			gap = &g.TimelineGap
+			return nil
+		}
+
+		return tnode.EachBefore(key, fn)
+	})
+
+	return events, gap
+}
+
+// RoomTimelineAfter returns at most limit stored timeline events that are
+// newer than the given event, earliest first. If the returned events run into a
+// gap, then the gap is returned as well, and the last returned event is the
+// gap's Before event.
+func (s *State) RoomTimelineAfter(
+	roomID matrix.RoomID, after event.RoomEvent, limit int) ([]event.RoomEvent, *TimelineGap) {
+
+	var events []event.RoomEvent
+	var gap *TimelineGap
+
+	s.top.TxView(func(n db.Node) error {
+		gaps := s.paths.roomGaps(n, roomID)
+		tnode := s.paths.timelineEventsNode(n, roomID)
+
+		return tnode.EachAfter(roomEventKey(after), func(k string, b []byte) error {
+			if g, ok := gaps[k]; ok {
+				gap = &g.TimelineGap
+				return db.EachBreak
+			}
+
+			if len(events) >= limit {
+				return db.EachBreak
+			}
+
+			events = append(events, sys.ParseTimeline(b, roomID))
+			return nil
+		})
+	})
+
+	return events, gap
+}
+
+// FillRoomGap stores the events fetched by paginating backwards from the given
+// gap's token. The events that were missing from the gap are returned latest
+// first, along with the updated gap if it isn't completely filled yet.
+func (s *State) FillRoomGap(
+	roomID matrix.RoomID, gap TimelineGap,
+	resp *api.RoomMessagesResponse) ([]event.RoomEvent, *TimelineGap, error) {
+
+	var events []event.RoomEvent
+	var next *TimelineGap
+
+	err := s.top.TxUpdate(func(n db.Node) error {
+		gapsNode := s.paths.timelineGapsNode(n, roomID)
+
+		var entry gapEntry
+		var entryKey string
+
+		gapsNode.Each(func(k string, b []byte, _ int) error {
+			if json.Unmarshal(b, &entry) == nil && entry.After == gap.After {
+				entryKey = k
+				return db.EachBreak
+			}
+			return nil
+		})
+
+		if entryKey == "" {
+			return errors.New("gap not found")
+		}
+
+		s.paths.setRaws(n, roomID, resp.State, false)
+
+		tnode := s.paths.timelineEventsNode(n, roomID)
+
+		filled := resp.End == "" || len(resp.Chunk) == 0
+		oldestKey := entryKey
+
+		var oldest matrix.EventID
+
+		for _, raw := range resp.Chunk {
+			key := timelineEventKey(raw)
+			if key <= entry.BeforeKey {
+				// We've reached the other side of the gap.
+				filled = true
+				break
+			}
+
+			if key >= entryKey {
+				// Already stored.
+				continue
+			}
+
+			if err := tnode.Set(key, raw); err != nil {
+				return errors.Wrap(err, "failed to save event")
+			}
+
+			events = append(events, sys.ParseTimeline(raw, roomID))
+
+			if key < oldestKey {
+				oldestKey = key
+				oldest = events[len(events)-1].RoomInfo().ID
+			}
+		}
+
+		if err := gapsNode.Delete(entryKey); err != nil {
+			return errors.Wrap(err, "failed to delete old gap")
+		}
+
+		if !filled && oldest != "" {
+			entry.After = oldest
+			entry.Token = resp.End
+
+			if err := gapsNode.SetAny(oldestKey, entry); err != nil {
+				return errors.Wrap(err, "failed to save new gap")
+			}
+
+			next = &entry.TimelineGap
+		} else if !filled {
+			// Nothing new but not filled either, so just move the token on.
+			entry.Token = resp.End
+
+			if err := gapsNode.SetAny(entryKey, entry); err != nil {
+				return errors.Wrap(err, "failed to save new gap")
+			}
+
+			next = &entry.TimelineGap
+		}
+
+		if err := s.paths.trimTimeline(n, roomID, time.Now()); err != nil {
+			log.Printf("failed to clean up Matrix timeline for room %q: %v", roomID, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return events, next, nil
+}
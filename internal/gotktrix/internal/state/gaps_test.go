@@ -0,0 +1,294 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// gapMessage returns a message with ID $i sent at timestamp i.
+func gapMessage(i int) event.RawEvent {
+	return testMessage(matrix.EventID(fmt.Sprintf("$%d", i)), matrix.Timestamp(i))
+}
+
+func gapMessages(from, to int) []event.RawEvent {
+	var events []event.RawEvent
+	if from <= to {
+		for i := from; i <= to; i++ {
+			events = append(events, gapMessage(i))
+		}
+	} else {
+		for i := from; i >= to; i-- {
+			events = append(events, gapMessage(i))
+		}
+	}
+	return events
+}
+
+func gapEvent(i int) event.RoomEvent {
+	return sys.ParseTimeline(gapMessage(i), testRoomID)
+}
+
+func roomEventIDs(events []event.RoomEvent) []matrix.EventID {
+	ids := make([]matrix.EventID, len(events))
+	for i, ev := range events {
+		ids[i] = ev.RoomInfo().ID
+	}
+	return ids
+}
+
+func equalGaps(a, b []TimelineGap) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newGappedState returns a state with events $1 and $2, then a gap, then
+// events $8 and $9.
+func newGappedState(t *testing.T) *State {
+	s := newTestState(t)
+	syncTimeline(t, s, api.SyncTimeline{Events: gapMessages(1, 2)})
+	syncTimeline(t, s, api.SyncTimeline{
+		Events:        gapMessages(8, 9),
+		Limited:       true,
+		PreviousBatch: "t1",
+	})
+	return s
+}
+
+var testGap = TimelineGap{Before: "$2", After: "$8", Token: "t1"}
+
+func TestSetGap(t *testing.T) {
+	tests := []struct {
+		name   string
+		syncs  []api.SyncTimeline
+		expect []TimelineGap
+	}{
+		{
+			name: "limited",
+			syncs: []api.SyncTimeline{
+				{Events: gapMessages(1, 2)},
+				{Events: gapMessages(8, 9), Limited: true, PreviousBatch: "t1"},
+			},
+			expect: []TimelineGap{testGap},
+		},
+		{
+			name: "not limited",
+			syncs: []api.SyncTimeline{
+				{Events: gapMessages(1, 2)},
+				{Events: gapMessages(3, 4), PreviousBatch: "t1"},
+			},
+		},
+		{
+			name: "first sync",
+			syncs: []api.SyncTimeline{
+				{Events: gapMessages(8, 9), Limited: true, PreviousBatch: "t1"},
+			},
+		},
+		{
+			name: "overlapping",
+			syncs: []api.SyncTimeline{
+				{Events: gapMessages(1, 3)},
+				{Events: gapMessages(2, 4), Limited: true, PreviousBatch: "t1"},
+			},
+		},
+		{
+			name: "multiple",
+			syncs: []api.SyncTimeline{
+				{Events: gapMessages(1, 2)},
+				{Events: gapMessages(5, 6), Limited: true, PreviousBatch: "t1"},
+				{Events: gapMessages(8, 9), Limited: true, PreviousBatch: "t2"},
+			},
+			expect: []TimelineGap{
+				{Before: "$2", After: "$5", Token: "t1"},
+				{Before: "$6", After: "$8", Token: "t2"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestState(t)
+			for _, tl := range test.syncs {
+				syncTimeline(t, s, tl)
+			}
+
+			if gaps := s.RoomGaps(testRoomID); !equalGaps(gaps, test.expect) {
+				t.Errorf("expected gaps %+v, got %+v", test.expect, gaps)
+			}
+		})
+	}
+}
+
+func TestRoomTimelineBeforeAfter(t *testing.T) {
+	s := newGappedState(t)
+
+	tests := []struct {
+		name   string
+		after  bool
+		event  event.RoomEvent
+		limit  int
+		expect []matrix.EventID
+		gap    *TimelineGap
+	}{
+		{"latest", false, nil, 10, []matrix.EventID{"$9", "$8"}, &testGap},
+		{"latest limited", false, nil, 1, []matrix.EventID{"$9"}, nil},
+		{"before gap", false, gapEvent(8), 10, nil, &testGap},
+		{"before", false, gapEvent(2), 10, []matrix.EventID{"$1"}, nil},
+		{"after", true, gapEvent(1), 10, []matrix.EventID{"$2"}, &testGap},
+		{"after gap", true, gapEvent(8), 10, []matrix.EventID{"$9"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var events []event.RoomEvent
+			var gap *TimelineGap
+
+			if test.after {
+				events, gap = s.RoomTimelineAfter(testRoomID, test.event, test.limit)
+			} else {
+				events, gap = s.RoomTimelineBefore(testRoomID, test.event, test.limit)
+			}
+
+			if ids := roomEventIDs(events); !equalIDs(ids, test.expect) {
+				t.Errorf("expected events %q, got %q", test.expect, ids)
+			}
+
+			switch {
+			case gap == nil && test.gap == nil:
+			case gap == nil || test.gap == nil || *gap != *test.gap:
+				t.Errorf("expected gap %+v, got %+v", test.gap, gap)
+			}
+		})
+	}
+}
+
+func TestFillRoomGap(t *testing.T) {
+	tests := []struct {
+		name   string
+		gap    TimelineGap
+		resp   api.RoomMessagesResponse
+		expect []matrix.EventID
+		next   *TimelineGap
+		err    bool
+	}{
+		{
+			name:   "partial",
+			gap:    testGap,
+			resp:   api.RoomMessagesResponse{Chunk: gapMessages(7, 6), End: "t2"},
+			expect: []matrix.EventID{"$7", "$6"},
+			next:   &TimelineGap{Before: "$2", After: "$6", Token: "t2"},
+		},
+		{
+			name:   "overlapping",
+			gap:    testGap,
+			resp:   api.RoomMessagesResponse{Chunk: gapMessages(9, 1), End: "t2"},
+			expect: []matrix.EventID{"$7", "$6", "$5", "$4", "$3"},
+		},
+		{
+			name:   "start of room",
+			gap:    testGap,
+			resp:   api.RoomMessagesResponse{Chunk: gapMessages(7, 7)},
+			expect: []matrix.EventID{"$7"},
+		},
+		{
+			name: "already stored",
+			gap:  testGap,
+			resp: api.RoomMessagesResponse{Chunk: gapMessages(9, 8), End: "t2"},
+			next: &TimelineGap{Before: "$2", After: "$8", Token: "t2"},
+		},
+		{
+			name: "unknown gap",
+			gap:  TimelineGap{Before: "$2", After: "$5", Token: "t1"},
+			resp: api.RoomMessagesResponse{Chunk: gapMessages(4, 3), End: "t2"},
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newGappedState(t)
+
+			events, next, err := s.FillRoomGap(testRoomID, test.gap, &test.resp)
+			if err != nil {
+				if !test.err {
+					t.Fatal("cannot fill gap:", err)
+				}
+				return
+			}
+			if test.err {
+				t.Fatal("expected error")
+			}
+
+			if ids := roomEventIDs(events); !equalIDs(ids, test.expect) {
+				t.Errorf("expected events %q, got %q", test.expect, ids)
+			}
+
+			var expectGaps []TimelineGap
+			switch {
+			case next == nil && test.next == nil:
+			case next == nil || test.next == nil || *next != *test.next:
+				t.Fatalf("expected next gap %+v, got %+v", test.next, next)
+			default:
+				expectGaps = []TimelineGap{*next}
+			}
+
+			if gaps := s.RoomGaps(testRoomID); !equalGaps(gaps, expectGaps) {
+				t.Errorf("expected gaps %+v, got %+v", expectGaps, gaps)
+			}
+
+			// The filled events should now be reachable from the latest
+			// event, stopping at the remaining gap if any.
+			latest, gap := s.RoomTimelineBefore(testRoomID, nil, 20)
+
+			expect := append([]matrix.EventID{"$9", "$8"}, test.expect...)
+			if next == nil {
+				expect = append(expect, "$2", "$1")
+			}
+
+			if ids := roomEventIDs(latest); !equalIDs(ids, expect) {
+				t.Errorf("expected timeline %q, got %q", expect, ids)
+			}
+
+			if (gap == nil) != (next == nil) {
+				t.Errorf("expected gap %+v, got %+v", next, gap)
+			}
+		})
+	}
+}
+
+func TestTrimGaps(t *testing.T) {
+	s := newGappedState(t)
+
+	if err := s.SetRoomRetentionPolicy(testRoomID, RetentionPolicy{KeepLast: 3}); err != nil {
+		t.Fatal("cannot set policy:", err)
+	}
+
+	// $2 is kept, so the gap is still reachable.
+	if gaps := s.RoomGaps(testRoomID); !equalGaps(gaps, []TimelineGap{testGap}) {
+		t.Fatalf("gap was removed while its events are kept, got %+v", gaps)
+	}
+
+	if err := s.SetRoomRetentionPolicy(testRoomID, RetentionPolicy{KeepLast: 2}); err != nil {
+		t.Fatal("cannot set policy:", err)
+	}
+
+	if gaps := s.RoomGaps(testRoomID); len(gaps) != 0 {
+		t.Errorf("expected no gaps after trimming, got %+v", gaps)
+	}
+
+	expect := []matrix.EventID{"$8", "$9"}
+	if ids := timelineIDs(s); !equalIDs(ids, expect) {
+		t.Errorf("expected timeline %q, got %q", expect, ids)
+	}
+}
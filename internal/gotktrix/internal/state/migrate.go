@@ -0,0 +1,179 @@
+package state
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// migrationFunc migrates the database from one version to the next. The given
+// node is the top-level gotktrix node, and it is within a writable transaction
+// that is shared by all steps, so any error will roll back the whole migration.
+// The user ID is the owner of the database.
+type migrationFunc func(top db.Node, paths dbPaths, userID matrix.UserID) error
+
+// migrations maps each version to the function that migrates the database from
+// that version to the next one. A version without an entry cannot be migrated
+// from, so the database will be wiped instead.
+var migrations = map[int]migrationFunc{}
+
+// registerMigration registers a migration step from the given version to the
+// version right after it. It must only be called in init.
+func registerMigration(from int, f migrationFunc) {
+	if _, ok := migrations[from]; ok {
+		panic(fmt.Sprintf("state: duplicate migration from version %d", from))
+	}
+	migrations[from] = f
+}
+
+// canMigrate returns true if there's a migration path from the given version to
+// the current Version.
+func canMigrate(from int) bool {
+	if from < 1 || from > Version {
+		return false
+	}
+
+	for v := from; v < Version; v++ {
+		if _, ok := migrations[v]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// migrate migrates the database at the given top node from the given version
+// to the current Version. All steps are done in a single transaction, and the
+// new version is written at the end of it.
+func migrate(top db.Node, paths dbPaths, userID matrix.UserID, from int) error {
+	return top.TxUpdate(func(n db.Node) error {
+		for v := from; v < Version; v++ {
+			if err := migrations[v](n, paths, userID); err != nil {
+				return errors.Wrapf(err, "failed to migrate from version %d to %d", v, v+1)
+			}
+			log.Printf("state: migrated database from version %d to %d", v, v+1)
+		}
+
+		return n.SetAny("version", Version)
+	})
+}
+
+func init() {
+	registerMigration(6, migrateTimelines)
+	registerMigration(7, migratePresences)
+	registerMigration(8, migrateInvites)
+	registerMigration(9, migrateReceipts)
+}
+
+// migrateTimelines drops the stored timeline events, keeping the previous
+// batch tokens. Version 6 didn't track gaps, so the stored timelines may have
+// holes that can't be found anymore. The timelines are fetched again from the
+// previous batch tokens when they're needed, which is much cheaper than a full
+// sync.
+func migrateTimelines(top db.Node, paths dbPaths, _ matrix.UserID) error {
+	timelines := top.FromPath(paths.timelines)
+
+	var roomIDs []matrix.RoomID
+	timelines.Each(func(k string, _ []byte, _ int) error {
+		roomIDs = append(roomIDs, matrix.RoomID(k))
+		return nil
+	})
+
+	for _, roomID := range roomIDs {
+		if err := paths.timelineEventsNode(top, roomID).Drop(); err != nil {
+			return errors.Wrapf(err, "failed to drop timeline of room %q", roomID)
+		}
+	}
+
+	return nil
+}
+
+// migratePresences moves the presence event out of the user events into the
+// presences bucket. Version 7 stored presences like user events, so only the
+// latest presence of anyone was kept.
+func migratePresences(top db.Node, paths dbPaths, _ matrix.UserID) error {
+	user := top.FromPath(paths.user).Node(string(event.TypePresence))
+
+	var raw event.RawEvent
+
+	err := user.Get("", func(b []byte) error {
+		raw = append(event.RawEvent(nil), b...)
+		return nil
+	})
+	if err == nil {
+		paths.setPresences(top, []event.RawEvent{raw})
+	}
+
+	return user.Drop()
+}
+
+// migrateInvites builds the list of invites from the stored room states, since
+// version 8 didn't keep one. Rooms that the user is invited to have the user's
+// member event with the invite membership.
+func migrateInvites(top db.Node, paths dbPaths, userID matrix.UserID) error {
+	rooms := top.FromPath(paths.rooms)
+
+	var roomIDs []matrix.RoomID
+	rooms.Each(func(k string, _ []byte, _ int) error {
+		roomIDs = append(roomIDs, matrix.RoomID(k))
+		return nil
+	})
+
+	for _, roomID := range roomIDs {
+		var member struct {
+			Content struct {
+				Membership event.MemberType `json:"membership"`
+			} `json:"content"`
+		}
+
+		n := rooms.Node(string(roomID), string(event.TypeRoomMember))
+		if err := n.GetAny(string(userID), &member); err != nil {
+			continue
+		}
+
+		if member.Content.Membership == event.MemberInvited {
+			paths.setInvite(top, roomID, true)
+		}
+	}
+
+	return nil
+}
+
+// migrateReceipts moves the m.receipt events stored like room events into the
+// receipts bucket. Version 9 kept them as a single event per room, so only the
+// receipts in the latest event were known.
+func migrateReceipts(top db.Node, paths dbPaths, _ matrix.UserID) error {
+	rooms := top.FromPath(paths.rooms)
+
+	var roomIDs []matrix.RoomID
+	rooms.Each(func(k string, _ []byte, _ int) error {
+		roomIDs = append(roomIDs, matrix.RoomID(k))
+		return nil
+	})
+
+	for _, roomID := range roomIDs {
+		n := rooms.Node(string(roomID), string(event.TypeReceipt))
+
+		var raw event.RawEvent
+
+		err := n.Get("", func(b []byte) error {
+			raw = append(event.RawEvent(nil), b...)
+			return nil
+		})
+		if err != nil {
+			continue
+		}
+
+		paths.setReceipts(top, roomID, []event.RawEvent{raw})
+
+		if err := n.Drop(); err != nil {
+			return errors.Wrapf(err, "failed to drop receipts of room %q", roomID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package state
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+const testUserID matrix.UserID = "@me:example.com"
+
+// writeFixture writes a database in the layout of version 6, which is the
+// oldest version that can be migrated from, with the given version number.
+// Later versions share the parts that their migrations look at.
+func writeFixture(t *testing.T, path string, version int) {
+	t.Helper()
+
+	kv, err := db.NewKVFile(path)
+	if err != nil {
+		t.Fatal("cannot create fixture:", err)
+	}
+	defer kv.Close()
+
+	top := kv.Node("gotktrix")
+
+	err = top.TxUpdate(func(top db.Node) error {
+		values := []struct {
+			node []string
+			key  string
+			val  string
+		}{
+			{nil, "version", strconv.Itoa(version)},
+			{nil, "next_batch", `s1234`},
+			{[]string{"user", "m.presence"}, "", `{
+				"type": "m.presence",
+				"sender": "@friend:example.com",
+				"content": {"presence": "online"}
+			}`},
+			{[]string{"rooms", "!invited:example.com", "m.room.member"}, string(testUserID), `{
+				"type": "m.room.member",
+				"state_key": "@me:example.com",
+				"content": {"membership": "invite"}
+			}`},
+			{[]string{"rooms", "!joined:example.com", "m.room.member"}, string(testUserID), `{
+				"type": "m.room.member",
+				"state_key": "@me:example.com",
+				"content": {"membership": "join"}
+			}`},
+			{[]string{"rooms", "!joined:example.com", "m.receipt"}, "", `{
+				"type": "m.receipt",
+				"content": {"$1": {"m.read": {"@me:example.com": {"ts": 10}}}}
+			}`},
+			{[]string{"timelines", "!joined:example.com"}, "previous_batch", `t5678`},
+			{[]string{"timelines", "!joined:example.com", "events"}, eventKey(1, "$1"), `{
+				"type": "m.room.message",
+				"event_id": "$1",
+				"sender": "@friend:example.com",
+				"origin_server_ts": 1,
+				"content": {"msgtype": "m.text", "body": "hi"}
+			}`},
+		}
+
+		for _, v := range values {
+			n := top
+			if v.node != nil {
+				n = top.Node(v.node...)
+			}
+			if err := n.Set(v.key, []byte(v.val)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal("cannot write fixture:", err)
+	}
+}
+
+// setMigration replaces the migration from the given version for the rest of
+// the test.
+func setMigration(t *testing.T, from int, f migrationFunc) {
+	old, ok := migrations[from]
+	migrations[from] = f
+
+	t.Cleanup(func() {
+		if ok {
+			migrations[from] = old
+		} else {
+			delete(migrations, from)
+		}
+	})
+}
+
+func TestMigrateSteps(t *testing.T) {
+	// step returns a migration that appends its version to the steps key.
+	step := func(from int) migrationFunc {
+		return func(top db.Node, _ dbPaths, userID matrix.UserID) error {
+			if userID != testUserID {
+				return errors.Errorf("unexpected user ID %q", userID)
+			}
+			var steps []int
+			top.GetAny("steps", &steps)
+			return top.SetAny("steps", append(steps, from))
+		}
+	}
+
+	fail := func(db.Node, dbPaths, matrix.UserID) error {
+		return errors.New("nope")
+	}
+
+	tests := []struct {
+		name    string
+		version int
+		steps   map[int]migrationFunc
+		kept    bool
+		expect  []int
+	}{
+		{
+			name:    "current",
+			version: Version,
+			kept:    true,
+		},
+		{
+			name:    "one step",
+			version: Version - 1,
+			steps:   map[int]migrationFunc{Version - 1: step(Version - 1)},
+			kept:    true,
+			expect:  []int{Version - 1},
+		},
+		{
+			name:    "two steps",
+			version: Version - 2,
+			steps: map[int]migrationFunc{
+				Version - 2: step(Version - 2),
+				Version - 1: step(Version - 1),
+			},
+			kept:   true,
+			expect: []int{Version - 2, Version - 1},
+		},
+		{
+			name:    "failing step",
+			version: Version - 2,
+			steps: map[int]migrationFunc{
+				Version - 2: step(Version - 2),
+				Version - 1: fail,
+			},
+		},
+		{
+			name:    "missing step",
+			version: Version - 2,
+			steps: map[int]migrationFunc{
+				Version - 2: step(Version - 2),
+				Version - 1: nil,
+			},
+		},
+		{
+			name:    "too new",
+			version: Version + 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for from, f := range test.steps {
+				setMigration(t, from, f)
+				if f == nil {
+					delete(migrations, from)
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), "state.db")
+			writeFixture(t, path, test.version)
+
+			s, err := New(path, testUserID)
+			if err != nil {
+				t.Fatal("cannot open state:", err)
+			}
+			defer s.Close()
+
+			var version int
+			if err := s.top.GetAny("version", &version); err != nil || version != Version {
+				t.Fatalf("expected version %d, got %d (%v)", Version, version, err)
+			}
+
+			if _, ok := s.NextBatch(); ok != test.kept {
+				t.Fatalf("expected kept state = %v, got %v", test.kept, ok)
+			}
+
+			var steps []int
+			s.top.GetAny("steps", &steps)
+
+			if len(steps) != len(test.expect) {
+				t.Fatalf("expected steps %v, got %v", test.expect, steps)
+			}
+			for i := range steps {
+				if steps[i] != test.expect[i] {
+					t.Fatalf("expected steps %v, got %v", test.expect, steps)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	// Versions older than 6 can't be migrated from.
+	for version := 5; version <= Version+1; version++ {
+		version := version
+		migrated := version >= 6 && version <= Version
+
+		t.Run(strconv.Itoa(version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.db")
+			writeFixture(t, path, version)
+
+			s, err := New(path, testUserID)
+			if err != nil {
+				t.Fatal("cannot open state:", err)
+			}
+			defer s.Close()
+
+			if _, ok := s.NextBatch(); ok != migrated {
+				t.Fatalf("expected kept state = %v, got %v", migrated, ok)
+			}
+
+			if !migrated {
+				return
+			}
+
+			_, err = s.RoomTimeline("!joined:example.com")
+			if version <= 6 && err == nil {
+				t.Error("timeline without gaps was not dropped")
+			}
+			if version > 6 && err != nil {
+				t.Error("timeline was dropped:", err)
+			}
+
+			_, err = s.UserPresence("@friend:example.com")
+			if version <= 7 && err != nil {
+				t.Error("presence was not migrated:", err)
+			}
+
+			if version <= 8 {
+				invites := s.Invites()
+				if len(invites) != 1 || invites[0] != "!invited:example.com" {
+					t.Errorf("expected only !invited:example.com to be invited, got %q", invites)
+				}
+			}
+
+			if version <= 9 {
+				r, err := s.UserReceipt("!joined:example.com", testUserID)
+				if err != nil || r.EventID != "$1" || r.Timestamp != 10 {
+					t.Errorf("receipt was not migrated, got %+v (%v)", r, err)
+				}
+			}
+
+			if prev, err := s.RoomPreviousBatch("!joined:example.com"); prev != "t5678" {
+				t.Errorf("previous batch was not kept, got %q (%v)", prev, err)
+			}
+		})
+	}
+}
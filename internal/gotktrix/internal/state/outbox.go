@@ -0,0 +1,136 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// OutboxStatus describes the sending status of an event in the outbox.
+type OutboxStatus uint8
+
+const (
+	// OutboxQueued means the event is waiting to be sent.
+	OutboxQueued OutboxStatus = iota
+	// OutboxSending means the event is being sent. Events that are being sent
+	// cannot be canceled.
+	OutboxSending
+	// OutboxSent means the event has been sent. Sent events are removed from
+	// the outbox, so this status is never stored.
+	OutboxSent
+	// OutboxFailed means the event cannot be sent, and it won't be retried
+	// unless explicitly asked to.
+	OutboxFailed
+)
+
+// String returns the status in English.
+func (s OutboxStatus) String() string {
+	switch s {
+	case OutboxQueued:
+		return "queued"
+	case OutboxSending:
+		return "sending"
+	case OutboxSent:
+		return "sent"
+	case OutboxFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// OutboxEvent is an event that is waiting in the outbox to be sent.
+type OutboxEvent struct {
+	// TxnID is the transaction ID used for sending the event. It is also the
+	// key of the event in the outbox, and the outbox is sorted by it.
+	TxnID   string          `json:"txn_id"`
+	RoomID  matrix.RoomID   `json:"room_id"`
+	Type    event.Type      `json:"type"`
+	Content json.RawMessage `json:"content"`
+	// Time is the time that the event was queued.
+	Time matrix.Timestamp `json:"time"`
+
+	Status   OutboxStatus `json:"status"`
+	Attempts int          `json:"attempts,omitempty"`
+	// Error is the last error encountered while sending, if any.
+	Error string `json:"error,omitempty"`
+	// EventID is the ID of the sent event. It's only set if Status is
+	// OutboxSent.
+	EventID matrix.EventID `json:"-"`
+}
+
+// AddOutbox adds the given event into the outbox.
+func (s *State) AddOutbox(ev OutboxEvent) error {
+	if ev.TxnID == "" {
+		return errors.New("outbox event has no transaction ID")
+	}
+
+	return s.top.FromPath(s.paths.outbox).SetAny(ev.TxnID, ev)
+}
+
+// Outbox returns all events in the outbox in the order that they were queued.
+// If roomID is not empty, then only events in that room are returned.
+func (s *State) Outbox(roomID matrix.RoomID) []OutboxEvent {
+	var events []OutboxEvent
+
+	n := s.top.FromPath(s.paths.outbox)
+	n.Each(func(k string, b []byte, _ int) error {
+		var ev OutboxEvent
+		if err := json.Unmarshal(b, &ev); err != nil {
+			log.Printf("invalid outbox event %q: %v", k, err)
+			return nil
+		}
+
+		if roomID == "" || ev.RoomID == roomID {
+			events = append(events, ev)
+		}
+
+		return nil
+	})
+
+	return events
+}
+
+// UpdateOutbox updates the outbox event with the given transaction ID using f.
+// The updated event is returned.
+func (s *State) UpdateOutbox(txnID string, f func(*OutboxEvent)) (OutboxEvent, error) {
+	var ev OutboxEvent
+
+	err := s.top.FromPath(s.paths.outbox).TxUpdate(func(n db.Node) error {
+		if err := n.GetAny(txnID, &ev); err != nil {
+			return err
+		}
+
+		f(&ev)
+		return n.SetAny(txnID, ev)
+	})
+
+	return ev, err
+}
+
+// DeleteOutbox deletes the event with the given transaction ID from the
+// outbox.
+func (s *State) DeleteOutbox(txnID string) error {
+	return s.top.FromPath(s.paths.outbox).Delete(txnID)
+}
+
+// CancelOutbox deletes the event with the given transaction ID from the outbox
+// unless it's being sent, in which case an error is returned.
+func (s *State) CancelOutbox(txnID string) error {
+	return s.top.FromPath(s.paths.outbox).TxUpdate(func(n db.Node) error {
+		var ev OutboxEvent
+		if err := n.GetAny(txnID, &ev); err != nil {
+			return err
+		}
+
+		if ev.Status == OutboxSending {
+			return errors.New("event is already being sent")
+		}
+
+		return n.Delete(txnID)
+	})
+}
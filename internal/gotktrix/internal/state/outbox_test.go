@@ -0,0 +1,117 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func outboxTxnIDs(events []OutboxEvent) []string {
+	ids := make([]string, len(events))
+	for i, ev := range events {
+		ids[i] = ev.TxnID
+	}
+	return ids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOutbox(t *testing.T) {
+	const otherRoomID matrix.RoomID = "!other:example.com"
+
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	s, err := New(path, testUserID)
+	if err != nil {
+		t.Fatal("cannot open state:", err)
+	}
+
+	if err := s.AddOutbox(OutboxEvent{RoomID: testRoomID}); err == nil {
+		t.Error("event without transaction ID was added")
+	}
+
+	// Add out of order, since the outbox is sorted by transaction ID.
+	for _, ev := range []OutboxEvent{
+		{TxnID: "txn.2", RoomID: otherRoomID},
+		{TxnID: "txn.1", RoomID: testRoomID},
+		{TxnID: "txn.3", RoomID: testRoomID},
+	} {
+		ev.Type = event.TypeRoomMessage
+		ev.Content = []byte(`{"msgtype":"m.text","body":"hi"}`)
+		if err := s.AddOutbox(ev); err != nil {
+			t.Fatal("cannot add outbox event:", err)
+		}
+	}
+
+	_, err = s.UpdateOutbox("txn.1", func(ev *OutboxEvent) {
+		ev.Status = OutboxFailed
+		ev.Attempts = 3
+		ev.Error = "nope"
+	})
+	if err != nil {
+		t.Fatal("cannot update outbox event:", err)
+	}
+
+	if _, err := s.UpdateOutbox("txn.4", func(*OutboxEvent) {}); err == nil {
+		t.Error("unknown outbox event was updated")
+	}
+
+	// Reopen the state to ensure that the outbox is persisted.
+	s.Close()
+
+	s, err = New(path, testUserID)
+	if err != nil {
+		t.Fatal("cannot reopen state:", err)
+	}
+	defer s.Close()
+
+	all := s.Outbox("")
+	if ids := outboxTxnIDs(all); !equalStrings(ids, []string{"txn.1", "txn.2", "txn.3"}) {
+		t.Fatalf("unexpected outbox %q", ids)
+	}
+
+	if ev := all[0]; ev.Status != OutboxFailed || ev.Attempts != 3 || ev.Error != "nope" {
+		t.Errorf("update was not persisted, got %+v", ev)
+	}
+
+	if string(all[1].Content) != `{"msgtype":"m.text","body":"hi"}` {
+		t.Errorf("unexpected content %s", all[1].Content)
+	}
+
+	if ids := outboxTxnIDs(s.Outbox(testRoomID)); !equalStrings(ids, []string{"txn.1", "txn.3"}) {
+		t.Errorf("unexpected room outbox %q", ids)
+	}
+
+	_, err = s.UpdateOutbox("txn.2", func(ev *OutboxEvent) { ev.Status = OutboxSending })
+	if err != nil {
+		t.Fatal("cannot update outbox event:", err)
+	}
+
+	if err := s.CancelOutbox("txn.2"); err == nil {
+		t.Error("event being sent was canceled")
+	}
+
+	if err := s.CancelOutbox("txn.3"); err != nil {
+		t.Error("cannot cancel queued event:", err)
+	}
+
+	if err := s.DeleteOutbox("txn.2"); err != nil {
+		t.Error("cannot delete sent event:", err)
+	}
+
+	if ids := outboxTxnIDs(s.Outbox("")); !equalStrings(ids, []string{"txn.1"}) {
+		t.Errorf("unexpected outbox after removals %q", ids)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
@@ -20,6 +21,11 @@ type dbPaths struct {
 	directs   db.NodePath
 	summaries db.NodePath
 	timelines db.NodePath
+	retention db.NodePath
+	outbox    db.NodePath
+	presences db.NodePath
+	invites   db.NodePath
+	receipts  db.NodePath
 }
 
 func newDBPaths(topPath db.NodePath) dbPaths {
@@ -29,6 +35,11 @@ func newDBPaths(topPath db.NodePath) dbPaths {
 		directs:   topPath.Tail("directs"),
 		summaries: topPath.Tail("summaries"),
 		timelines: topPath.Tail("timelines"),
+		retention: topPath.Tail("retention"),
+		outbox:    topPath.Tail("outbox"),
+		presences: topPath.Tail("presences"),
+		invites:   topPath.Tail("invites"),
+		receipts:  topPath.Tail("receipts"),
 	}
 }
 
@@ -129,22 +140,44 @@ func timelineEventKey(ev event.RawEvent) string {
 	var base timelineEventBase
 	json.Unmarshal(ev, &base)
 
-	str := strconv.FormatInt(int64(base.OriginServerTime), 32)
+	return eventKey(base.OriginServerTime, base.ID)
+}
+
+// roomEventKey is like timelineEventKey, except it takes a parsed event.
+func roomEventKey(ev event.RoomEvent) string {
+	info := ev.RoomInfo()
+	return eventKey(info.OriginServerTime, info.ID)
+}
+
+func eventKey(ts matrix.Timestamp, id matrix.EventID) string {
+	// use \x01 to avoid colliding delimiter
+	return timestampKey(ts) + "\x01" + string(id)
+}
+
+// timestampKey formats the timestamp part of a timeline event key. All keys of
+// events sent before the given timestamp sort before the returned key.
+func timestampKey(ts matrix.Timestamp) string {
+	str := strconv.FormatInt(int64(ts), 32)
 	// Pad the timestamp with zeroes to validate sorting.
-	if base.OriginServerTime >= 0 {
+	if ts >= 0 {
 		str = i64ZeroPadding[len(i64ZeroPadding)-len(str):] + str
 	} else {
 		// Account for negative number.
 		str = "-" + i64ZeroPadding[len(i64ZeroPadding)-len(str)-2:] + str[1:]
 	}
 
-	// use \x01 to avoid colliding delimiter
-	return str + "\x01" + string(base.ID)
+	return str
 }
 
 func (p *dbPaths) setTimeline(n db.Node, roomID matrix.RoomID, tl api.SyncTimeline) {
 	tnode := p.timelineEventsNode(n, roomID)
 
+	// Check for a gap before adding the new events, since we need to know
+	// what the latest stored event was.
+	if tl.Limited {
+		p.setGap(n, roomID, tl)
+	}
+
 	for _, raw := range tl.Events {
 		key := timelineEventKey(raw)
 		if err := tnode.Set(key, raw); err != nil {
@@ -153,7 +186,7 @@ func (p *dbPaths) setTimeline(n db.Node, roomID matrix.RoomID, tl api.SyncTimeli
 	}
 
 	// Clean up the timeline events.
-	if err := tnode.DropExceptLast(TimelineKeepLast); err != nil {
+	if err := p.trimTimeline(n, roomID, time.Now()); err != nil {
 		log.Printf("failed to clean up Matrix timeline for room %q: %v", roomID, err)
 	}
 
@@ -190,6 +223,94 @@ func (p *dbPaths) setDirect(n db.Node, roomID matrix.RoomID, direct bool) {
 	}
 }
 
+// setInvite adds the room into the list of invites or removes it from there.
+func (p *dbPaths) setInvite(n db.Node, roomID matrix.RoomID, invited bool) {
+	n = n.FromPath(p.invites)
+
+	var err error
+	if invited {
+		err = n.Set(string(roomID), nil)
+	} else {
+		err = n.Delete(string(roomID))
+	}
+
+	if err != nil {
+		log.Printf("failed to save invite for room %q: %v", roomID, err)
+	}
+}
+
+// setPresences saves the given presence events by their senders, since they
+// have no state keys and would otherwise overwrite each other.
+func (p *dbPaths) setPresences(n db.Node, raws []event.RawEvent) {
+	n = n.FromPath(p.presences)
+
+	for _, raw := range raws {
+		var base struct {
+			Sender matrix.UserID `json:"sender"`
+		}
+		if err := json.Unmarshal(raw, &base); err != nil || base.Sender == "" {
+			continue
+		}
+
+		if err := n.Set(string(base.Sender), raw); err != nil {
+			log.Printf("failed to set presence for user %q: %v", base.Sender, err)
+		}
+	}
+}
+
+// receiptContent is the content of an m.receipt event, which maps event IDs to
+// receipt types to users.
+type receiptContent map[matrix.EventID]map[string]map[matrix.UserID]struct {
+	Timestamp matrix.Timestamp `json:"ts"`
+	ThreadID  string           `json:"thread_id,omitempty"`
+}
+
+// setReceipts saves the m.read receipts within the given ephemeral events. Only
+// the latest receipt of each user is kept; see setReceipt. Receipts for threads
+// are ignored, since they don't say how far the user has read the main
+// timeline.
+func (p *dbPaths) setReceipts(n db.Node, roomID matrix.RoomID, raws []event.RawEvent) {
+	n = n.FromPath(p.receipts).Node(string(roomID))
+
+	for _, raw := range raws {
+		if GuessType(raw) != event.TypeReceipt {
+			continue
+		}
+
+		var ev struct {
+			Content receiptContent `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			continue
+		}
+
+		for eventID, types := range ev.Content {
+			for userID, read := range types["m.read"] {
+				if read.ThreadID != "" && read.ThreadID != "main" {
+					continue
+				}
+				setReceipt(n, userID, Receipt{
+					EventID:   eventID,
+					Timestamp: read.Timestamp,
+				})
+			}
+		}
+	}
+}
+
+// setReceipt sets the receipt of the given user, unless the stored receipt is
+// newer.
+func setReceipt(n db.Node, userID matrix.UserID, r Receipt) {
+	var old Receipt
+	if err := n.GetAny(string(userID), &old); err == nil && old.Timestamp > r.Timestamp {
+		return
+	}
+
+	if err := n.SetAny(string(userID), r); err != nil {
+		log.Printf("failed to set receipt for user %q: %v", userID, err)
+	}
+}
+
 func getEvent(n db.Node, k string, expect event.Type) (event.Event, error) {
 	var ev event.Event
 	err := n.Get(k, eventFunc(&ev, expect))
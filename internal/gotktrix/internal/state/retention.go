@@ -0,0 +1,202 @@
+package state
+
+import (
+	"log"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// RetentionPolicy describes how many timeline events of a room are kept in the
+// state. An event is kept if it satisfies either KeepLast or KeepDays.
+type RetentionPolicy struct {
+	// KeepAll, if true, keeps every single event. The other fields are then
+	// ignored.
+	KeepAll bool `json:"keep_all,omitempty"`
+	// KeepLast is the number of latest events to keep.
+	KeepLast int `json:"keep_last,omitempty"`
+	// KeepDays is the number of days that events are kept for.
+	KeepDays int `json:"keep_days,omitempty"`
+}
+
+// DefaultRetentionPolicy is the retention policy used for rooms that have no
+// policy set for either the room or its tags, unless it's overridden using
+// SetDefaultRetentionPolicy.
+var DefaultRetentionPolicy = RetentionPolicy{KeepLast: TimelineKeepLast}
+
+// DefaultTagRetentionPolicies are the retention policies used for tags that
+// have no policy set using SetTagRetentionPolicy. Favourite rooms are kept in
+// full, so they can be read offline.
+var DefaultTagRetentionPolicies = map[matrix.TagName]RetentionPolicy{
+	matrix.TagFavourite: {KeepAll: true},
+}
+
+// IsZero returns true if the policy has nothing set. A zero policy is invalid
+// and is treated as no policy.
+func (p RetentionPolicy) IsZero() bool {
+	return p == RetentionPolicy{}
+}
+
+// merge returns the more permissive policy of the two.
+func (p RetentionPolicy) merge(other RetentionPolicy) RetentionPolicy {
+	if p.KeepAll || other.KeepAll {
+		return RetentionPolicy{KeepAll: true}
+	}
+	if other.KeepLast > p.KeepLast {
+		p.KeepLast = other.KeepLast
+	}
+	if other.KeepDays > p.KeepDays {
+		p.KeepDays = other.KeepDays
+	}
+	return p
+}
+
+// trim drops the timeline events in the given events node that the policy
+// doesn't keep.
+func (p RetentionPolicy) trim(events db.Node, now time.Time) error {
+	switch {
+	case p.KeepAll:
+		return nil
+	case p.KeepDays > 0:
+		cutoff := now.AddDate(0, 0, -p.KeepDays)
+		ts := matrix.Timestamp(cutoff.UnixNano() / int64(time.Millisecond))
+		return events.DropBefore(timestampKey(ts), p.KeepLast)
+	default:
+		return events.DropExceptLast(p.KeepLast)
+	}
+}
+
+// trimTimeline trims the timeline of the given room using its retention policy.
+// Gaps whose older side has been trimmed away are removed along with the events
+// older than them.
+func (p *dbPaths) trimTimeline(n db.Node, roomID matrix.RoomID, now time.Time) error {
+	policy := p.retentionPolicy(n, roomID)
+	if err := policy.trim(p.timelineEventsNode(n, roomID), now); err != nil {
+		return err
+	}
+	return p.trimGaps(n, roomID)
+}
+
+func (p *dbPaths) retentionRooms(n db.Node) db.Node {
+	return n.FromPath(p.retention).Node("rooms")
+}
+
+func (p *dbPaths) retentionTags(n db.Node) db.Node {
+	return n.FromPath(p.retention).Node("tags")
+}
+
+// retentionPolicy resolves the retention policy of the given room. The room's
+// own policy is used first, then the merged policies of its tags, then the
+// default policy.
+func (p *dbPaths) retentionPolicy(n db.Node, roomID matrix.RoomID) RetentionPolicy {
+	var policy RetentionPolicy
+
+	if err := p.retentionRooms(n).GetAny(string(roomID), &policy); err == nil {
+		return policy
+	}
+
+	var tags struct {
+		Content event.TagEvent `json:"content"`
+	}
+
+	tagNode := n.FromPath(p.rooms).Node(string(roomID), string(event.TypeTag))
+	if err := tagNode.GetAny("", &tags); err == nil {
+		tagsNode := p.retentionTags(n)
+
+		for name := range tags.Content.Tags {
+			var tagPolicy RetentionPolicy
+			if err := tagsNode.GetAny(string(name), &tagPolicy); err == nil {
+				policy = policy.merge(tagPolicy)
+			} else if tagPolicy, ok := DefaultTagRetentionPolicies[name]; ok {
+				policy = policy.merge(tagPolicy)
+			}
+		}
+
+		if !policy.IsZero() {
+			return policy
+		}
+	}
+
+	if err := n.FromPath(p.retention).GetAny("default", &policy); err == nil {
+		return policy
+	}
+
+	return DefaultRetentionPolicy
+}
+
+// RoomRetentionPolicy returns the retention policy that applies to the given
+// room.
+func (s *State) RoomRetentionPolicy(roomID matrix.RoomID) RetentionPolicy {
+	var policy RetentionPolicy
+
+	s.top.TxView(func(n db.Node) error {
+		policy = s.paths.retentionPolicy(n, roomID)
+		return nil
+	})
+
+	return policy
+}
+
+// SetRoomRetentionPolicy sets the retention policy of the given room. A zero
+// policy removes it, so the room's tags or the default policy will be used
+// instead. The room's timeline is trimmed right away.
+func (s *State) SetRoomRetentionPolicy(roomID matrix.RoomID, policy RetentionPolicy) error {
+	return s.setRetentionPolicy(func(n db.Node) error {
+		return setOrDeletePolicy(s.paths.retentionRooms(n), string(roomID), policy)
+	})
+}
+
+// SetTagRetentionPolicy sets the retention policy of all rooms with the given
+// tag. If a room has multiple tags with a policy, then the most permissive
+// one is used. A zero policy resets it to the tag's policy in
+// DefaultTagRetentionPolicies, if any.
+func (s *State) SetTagRetentionPolicy(tag matrix.TagName, policy RetentionPolicy) error {
+	return s.setRetentionPolicy(func(n db.Node) error {
+		return setOrDeletePolicy(s.paths.retentionTags(n), string(tag), policy)
+	})
+}
+
+// SetDefaultRetentionPolicy sets the retention policy for rooms that have no
+// policy set for either the room or its tags. A zero policy resets it to
+// DefaultRetentionPolicy.
+func (s *State) SetDefaultRetentionPolicy(policy RetentionPolicy) error {
+	return s.setRetentionPolicy(func(n db.Node) error {
+		return setOrDeletePolicy(n.FromPath(s.paths.retention), "default", policy)
+	})
+}
+
+func setOrDeletePolicy(n db.Node, k string, policy RetentionPolicy) error {
+	if policy.IsZero() {
+		return n.Delete(k)
+	}
+	return n.SetAny(k, policy)
+}
+
+// setRetentionPolicy calls f to update a policy, then trims all timelines with
+// the new policies.
+func (s *State) setRetentionPolicy(f func(n db.Node) error) error {
+	return s.top.TxUpdate(func(n db.Node) error {
+		if err := f(n); err != nil {
+			return errors.Wrap(err, "failed to save retention policy")
+		}
+
+		now := time.Now()
+
+		var roomIDs []matrix.RoomID
+		n.FromPath(s.paths.timelines).Each(func(k string, _ []byte, _ int) error {
+			roomIDs = append(roomIDs, matrix.RoomID(k))
+			return nil
+		})
+
+		for _, roomID := range roomIDs {
+			if err := s.paths.trimTimeline(n, roomID, now); err != nil {
+				log.Printf("failed to trim Matrix timeline for room %q: %v", roomID, err)
+			}
+		}
+
+		return nil
+	})
+}
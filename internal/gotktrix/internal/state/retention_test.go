@@ -0,0 +1,222 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	now := time.Now()
+	daysAgo := func(days int) matrix.Timestamp {
+		return matrix.Timestamp(now.AddDate(0, 0, -days).UnixMilli())
+	}
+
+	// Events from 5 days ago up to now, oldest first.
+	events := []event.RawEvent{
+		testMessage("$5", daysAgo(5)),
+		testMessage("$4", daysAgo(4)),
+		testMessage("$3", daysAgo(3)),
+		testMessage("$1", daysAgo(1)),
+		testMessage("$0", daysAgo(0)),
+	}
+
+	tags := event.RawEvent(`{
+		"type": "m.tag",
+		"content": {"tags": {"u.work": {}, "u.friends": {}}}
+	}`)
+
+	type policies struct {
+		room  RetentionPolicy
+		tags  map[matrix.TagName]RetentionPolicy
+		deflt RetentionPolicy
+	}
+
+	tests := []struct {
+		name     string
+		policies policies
+		expect   []matrix.EventID
+		expectP  RetentionPolicy
+	}{
+		{
+			name:    "default",
+			expect:  []matrix.EventID{"$5", "$4", "$3", "$1", "$0"},
+			expectP: DefaultRetentionPolicy,
+		},
+		{
+			name:     "keep last",
+			policies: policies{room: RetentionPolicy{KeepLast: 2}},
+			expect:   []matrix.EventID{"$1", "$0"},
+			expectP:  RetentionPolicy{KeepLast: 2},
+		},
+		{
+			name:     "keep days",
+			policies: policies{room: RetentionPolicy{KeepDays: 2}},
+			expect:   []matrix.EventID{"$1", "$0"},
+			expectP:  RetentionPolicy{KeepDays: 2},
+		},
+		{
+			name:     "keep days or last",
+			policies: policies{room: RetentionPolicy{KeepDays: 2, KeepLast: 4}},
+			expect:   []matrix.EventID{"$4", "$3", "$1", "$0"},
+			expectP:  RetentionPolicy{KeepDays: 2, KeepLast: 4},
+		},
+		{
+			name: "keep all",
+			policies: policies{
+				room:  RetentionPolicy{KeepAll: true},
+				deflt: RetentionPolicy{KeepLast: 1},
+			},
+			expect:  []matrix.EventID{"$5", "$4", "$3", "$1", "$0"},
+			expectP: RetentionPolicy{KeepAll: true},
+		},
+		{
+			name:     "default override",
+			policies: policies{deflt: RetentionPolicy{KeepLast: 1}},
+			expect:   []matrix.EventID{"$0"},
+			expectP:  RetentionPolicy{KeepLast: 1},
+		},
+		{
+			name: "merged tags",
+			policies: policies{
+				tags: map[matrix.TagName]RetentionPolicy{
+					"u.work":    {KeepLast: 1},
+					"u.friends": {KeepDays: 2},
+					"u.unused":  {KeepAll: true},
+				},
+				deflt: RetentionPolicy{KeepLast: 4},
+			},
+			expect:  []matrix.EventID{"$1", "$0"},
+			expectP: RetentionPolicy{KeepLast: 1, KeepDays: 2},
+		},
+		{
+			name: "room over tags",
+			policies: policies{
+				room: RetentionPolicy{KeepLast: 3},
+				tags: map[matrix.TagName]RetentionPolicy{
+					"u.work": {KeepAll: true},
+				},
+			},
+			expect:  []matrix.EventID{"$3", "$1", "$0"},
+			expectP: RetentionPolicy{KeepLast: 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestState(t)
+			syncTimeline(t, s, api.SyncTimeline{}, tags)
+
+			set := func(err error) {
+				t.Helper()
+				if err != nil {
+					t.Fatal("cannot set policy:", err)
+				}
+			}
+
+			set(s.SetDefaultRetentionPolicy(test.policies.deflt))
+			for tag, policy := range test.policies.tags {
+				set(s.SetTagRetentionPolicy(tag, policy))
+			}
+			set(s.SetRoomRetentionPolicy(testRoomID, test.policies.room))
+
+			// Setting a policy trims right away, so only add the events once
+			// all policies are set.
+			syncTimeline(t, s, api.SyncTimeline{Events: events})
+
+			if p := s.RoomRetentionPolicy(testRoomID); p != test.expectP {
+				t.Errorf("expected policy %+v, got %+v", test.expectP, p)
+			}
+
+			if ids := timelineIDs(s); !equalIDs(ids, test.expect) {
+				t.Errorf("expected timeline %q, got %q", test.expect, ids)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicySync(t *testing.T) {
+	s := newTestState(t)
+
+	if err := s.SetRoomRetentionPolicy(testRoomID, RetentionPolicy{KeepLast: 3}); err != nil {
+		t.Fatal("cannot set policy:", err)
+	}
+
+	var expect []matrix.EventID
+
+	// New events from syncs are trimmed as they come in.
+	for i := 1; i <= 5; i++ {
+		id := matrix.EventID(fmt.Sprintf("$%d", i))
+		syncTimeline(t, s, api.SyncTimeline{
+			Events: []event.RawEvent{testMessage(id, matrix.Timestamp(i))},
+		})
+
+		expect = append(expect, id)
+		if len(expect) > 3 {
+			expect = expect[1:]
+		}
+
+		if ids := timelineIDs(s); !equalIDs(ids, expect) {
+			t.Fatalf("after sync %d: expected timeline %q, got %q", i, expect, ids)
+		}
+	}
+}
+
+func TestSetRetentionPolicyTrims(t *testing.T) {
+	s := newTestState(t)
+	syncTimeline(t, s, api.SyncTimeline{
+		Events: []event.RawEvent{
+			testMessage("$1", 1),
+			testMessage("$2", 2),
+			testMessage("$3", 3),
+		},
+	})
+
+	if err := s.SetRoomRetentionPolicy(testRoomID, RetentionPolicy{KeepLast: 1}); err != nil {
+		t.Fatal("cannot set policy:", err)
+	}
+
+	expect := []matrix.EventID{"$3"}
+	if ids := timelineIDs(s); !equalIDs(ids, expect) {
+		t.Fatalf("expected timeline %q, got %q", expect, ids)
+	}
+}
+
+func TestRetentionPolicyFavourite(t *testing.T) {
+	s := newTestState(t)
+	syncTimeline(t, s, api.SyncTimeline{}, event.RawEvent(`{
+		"type": "m.tag",
+		"content": {"tags": {"m.favourite": {}}}
+	}`))
+
+	if err := s.SetDefaultRetentionPolicy(RetentionPolicy{KeepLast: 1}); err != nil {
+		t.Fatal("cannot set policy:", err)
+	}
+
+	syncTimeline(t, s, api.SyncTimeline{
+		Events: []event.RawEvent{
+			testMessage("$1", 1),
+			testMessage("$2", 2),
+			testMessage("$3", 3),
+		},
+	})
+
+	// Favourites are kept in full by default.
+	expect := []matrix.EventID{"$1", "$2", "$3"}
+	if ids := timelineIDs(s); !equalIDs(ids, expect) {
+		t.Fatalf("expected timeline %q, got %q", expect, ids)
+	}
+
+	if err := s.SetTagRetentionPolicy(matrix.TagFavourite, RetentionPolicy{KeepLast: 2}); err != nil {
+		t.Fatal("cannot set policy:", err)
+	}
+
+	expect = []matrix.EventID{"$2", "$3"}
+	if ids := timelineIDs(s); !equalIDs(ids, expect) {
+		t.Fatalf("expected overridden timeline %q, got %q", expect, ids)
+	}
+}
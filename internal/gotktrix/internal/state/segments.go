@@ -0,0 +1,107 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// maxSegments is the maximum number of detached timeline segments kept for
+// each room. Older segments are dropped first.
+const maxSegments = 5
+
+// TimelineSegment is a piece of a room's timeline that is detached from the
+// stored timeline, such as the context around an event that's too old to be
+// stored.
+type TimelineSegment struct {
+	// EventID is the ID of the event that the segment was fetched around.
+	EventID matrix.EventID `json:"event_id"`
+	// Event is the event that the segment was fetched around.
+	Event event.RawEvent `json:"event"`
+	// Before and After contain the events around Event, latest last.
+	Before []event.RawEvent `json:"before,omitempty"`
+	After  []event.RawEvent `json:"after,omitempty"`
+	// Start and End are the pagination tokens to paginate backwards from the
+	// start of the segment and forwards from the end of it.
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Has returns true if the given event is in the segment.
+func (seg TimelineSegment) Has(eventID matrix.EventID) bool {
+	if seg.EventID == eventID {
+		return true
+	}
+
+	for _, raws := range [][]event.RawEvent{seg.Before, seg.After} {
+		for _, raw := range raws {
+			var base timelineEventBase
+			if json.Unmarshal(raw, &base) == nil && base.ID == eventID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *dbPaths) timelineSegmentsNode(n db.Node, roomID matrix.RoomID) db.Node {
+	return p.timelineNode(n, roomID).Node("segments")
+}
+
+// AddRoomSegment stores the given detached timeline segment. Only the latest
+// few segments of each room are kept, and a segment replaces any older one
+// fetched around the same event.
+func (s *State) AddRoomSegment(roomID matrix.RoomID, seg TimelineSegment) error {
+	return s.top.TxUpdate(func(n db.Node) error {
+		segments := s.paths.timelineSegmentsNode(n, roomID)
+
+		var old []string
+		segments.Each(func(k string, b []byte, _ int) error {
+			var other TimelineSegment
+			if json.Unmarshal(b, &other) == nil && other.EventID == seg.EventID {
+				old = append(old, k)
+			}
+			return nil
+		})
+
+		for _, k := range old {
+			if err := segments.Delete(k); err != nil {
+				return errors.Wrap(err, "failed to delete old segment")
+			}
+		}
+
+		key := eventKey(matrix.Timestamp(time.Now().UnixMilli()), seg.EventID)
+		if err := segments.SetAny(key, seg); err != nil {
+			return errors.Wrap(err, "failed to save segment")
+		}
+
+		return segments.DropExceptLast(maxSegments)
+	})
+}
+
+// RoomSegment returns the latest stored segment that has the given event.
+func (s *State) RoomSegment(roomID matrix.RoomID, eventID matrix.EventID) (TimelineSegment, error) {
+	var seg TimelineSegment
+	var found bool
+
+	s.paths.timelineSegmentsNode(s.top, roomID).EachReverse(func(_ string, b []byte, _ int) error {
+		var other TimelineSegment
+		if json.Unmarshal(b, &other) == nil && other.Has(eventID) {
+			seg = other
+			found = true
+			return db.EachBreak
+		}
+		return nil
+	})
+
+	if !found {
+		return TimelineSegment{}, errors.New("no segment has the event")
+	}
+
+	return seg, nil
+}
@@ -18,11 +18,14 @@ import (
 
 const (
 	// TimelineKeepLast determines that, when it's time to clean up, the
-	// database should only keep the last 50 events.
+	// database should only keep the last 100 events, unless the room has a
+	// different RetentionPolicy.
 	TimelineKeepLast = 100
 	// Version is the incremental database version number. It is incremented
 	// when a breaking change is made in the database that breaks old databases.
-	Version = 6
+	// A migration from the previous version should be registered using
+	// registerMigration, otherwise old databases will be wiped.
+	Version = 10
 )
 
 // State is a disk-based database of the Matrix state. Note that methods that
@@ -47,27 +50,41 @@ func New(path string, userID matrix.UserID) (*State, error) {
 
 	topPath := db.NewNodePath("gotktrix")
 	topNode := kv.NodeFromPath(topPath)
+	paths := newDBPaths(topPath)
 
 	// Confirm version.
 	var version int
 
 	// Version is provided, so old database.
-	if err := topNode.GetAny("version", &version); err != nil || version != Version {
+	if err := topNode.GetAny("version", &version); err == nil && version != Version {
+		// Try to migrate the database to the current version first. Only wipe
+		// it if we can't.
+		if canMigrate(version) {
+			err = migrate(topNode, paths, userID, version)
+			if err == nil {
+				version = Version
+			} else {
+				log.Println("state: migration failed, wiping:", err)
+			}
+		}
+	}
+
+	if version != Version {
 		// Database is too outdated; wipe it.
 		if err := kv.DropPrefix(topPath); err != nil {
 			return nil, errors.Wrap(err, "failed to wipe old state")
 		}
-	}
 
-	// Write the new version.
-	if err := topNode.SetAny("version", Version); err != nil {
-		return nil, errors.Wrap(err, "failed to write version")
+		// Write the new version.
+		if err := topNode.SetAny("version", Version); err != nil {
+			return nil, errors.Wrap(err, "failed to write version")
+		}
 	}
 
 	return &State{
 		db:     kv,
 		top:    kv.NodeFromPath(topPath),
-		paths:  newDBPaths(topPath),
+		paths:  paths,
 		userID: userID,
 	}, nil
 }
@@ -354,6 +371,28 @@ func (s *State) Rooms() ([]matrix.RoomID, error) {
 	return roomIDs, err
 }
 
+// Invites returns the IDs of the rooms that the user is invited to. Only the
+// stripped state of these rooms is known.
+func (s *State) Invites() []matrix.RoomID {
+	var roomIDs []matrix.RoomID
+
+	s.db.NodeFromPath(s.paths.invites).Each(func(k string, _ []byte, l int) error {
+		if roomIDs == nil {
+			roomIDs = make([]matrix.RoomID, 0, l)
+		}
+		roomIDs = append(roomIDs, matrix.RoomID(k))
+		return nil
+	})
+
+	return roomIDs
+}
+
+// RemoveInvite removes the room from the list of invites, which is useful
+// after the invite is accepted or declined but before the server says so.
+func (s *State) RemoveInvite(roomID matrix.RoomID) {
+	s.paths.setInvite(s.top, roomID, false)
+}
+
 // RoomPreviousBatch gets the previous batch string for the given room.
 func (s *State) RoomPreviousBatch(roomID matrix.RoomID) (prev string, err error) {
 	n := s.paths.timelineNode(s.top, roomID)
@@ -455,6 +494,77 @@ func (s *State) UserEvent(typ event.Type) (event.Event, error) {
 	return ev, nil
 }
 
+// UserEventRaw gets the raw user event from the given type. It's useful for
+// events that gotrix can't fully parse.
+func (s *State) UserEventRaw(typ event.Type) (event.RawEvent, error) {
+	var raw event.RawEvent
+
+	n := s.db.NodeFromPath(s.paths.user).Node(string(typ))
+
+	err := n.Get("", func(b []byte) error {
+		raw = append(event.RawEvent(nil), b...)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "event not found in state")
+	}
+
+	return raw, nil
+}
+
+// UserPresence returns the latest presence event of the given user.
+func (s *State) UserPresence(userID matrix.UserID) (*event.PresenceEvent, error) {
+	n := s.db.NodeFromPath(s.paths.presences)
+
+	ev, err := getEvent(n, string(userID), event.TypePresence)
+	if err != nil {
+		return nil, errors.Wrap(err, "presence not found in state")
+	}
+
+	return ev.(*event.PresenceEvent), nil
+}
+
+// Receipt is a read receipt of a user in a room.
+type Receipt struct {
+	EventID   matrix.EventID   `json:"event_id"`
+	Timestamp matrix.Timestamp `json:"ts"`
+}
+
+// RoomReceipts returns the latest read receipt of each user in the room.
+func (s *State) RoomReceipts(roomID matrix.RoomID) map[matrix.UserID]Receipt {
+	receipts := make(map[matrix.UserID]Receipt)
+
+	n := s.db.NodeFromPath(s.paths.receipts).Node(string(roomID))
+	n.Each(func(k string, b []byte, _ int) error {
+		var r Receipt
+		if err := n.Unmarshal(b, &r); err == nil {
+			receipts[matrix.UserID(k)] = r
+		}
+		return nil
+	})
+
+	return receipts
+}
+
+// UserReceipt returns the latest read receipt of the given user in the room.
+func (s *State) UserReceipt(roomID matrix.RoomID, userID matrix.UserID) (Receipt, error) {
+	var r Receipt
+
+	n := s.db.NodeFromPath(s.paths.receipts).Node(string(roomID))
+	if err := n.GetAny(string(userID), &r); err != nil {
+		return r, errors.Wrap(err, "receipt not found in state")
+	}
+
+	return r, nil
+}
+
+// SetUserReceipt updates the read receipt of the given user in the room. It's
+// useful for updating the current user's receipt before the server echoes it
+// back.
+func (s *State) SetUserReceipt(roomID matrix.RoomID, userID matrix.UserID, r Receipt) {
+	setReceipt(s.db.NodeFromPath(s.paths.receipts).Node(string(roomID)), userID, r)
+}
+
 // SetUserEvent updates the user event inside the state. Error checking is not
 // needed, because this function shouldn't be relied on.
 func (s *State) SetUserEvent(ev event.Event) {
@@ -475,6 +585,12 @@ func (s *State) SetUserEvent(ev event.Event) {
 	setRawEvent(s.db.NodeFromPath(s.paths.user), "", raw, false)
 }
 
+// SetUserEventRaw overrides the raw user event inside the state. The event is
+// replaced by the next one from the sync.
+func (s *State) SetUserEventRaw(raw event.RawEvent) {
+	setRawEvent(s.db.NodeFromPath(s.paths.user), "", raw, true)
+}
+
 // NextBatch returns the next batch string with true if the database contains
 // the next batch event. Otherwise, an empty string with false is returned.
 func (s *State) NextBatch() (next string, ok bool) {
@@ -482,8 +598,9 @@ func (s *State) NextBatch() (next string, ok bool) {
 	return next, err == nil
 }
 
-// AddRoomStates adds the given room state events. Note that values set here
-// will never override values from /sync.
+// AddRoomMessages adds the given room state events and timeline events fetched
+// using /messages. Note that state events set here will never override values
+// from /sync. The timeline is trimmed according to the room's retention policy.
 func (s *State) AddRoomMessages(roomID matrix.RoomID, resp *api.RoomMessagesResponse) {
 	err := s.top.TxUpdate(func(n db.Node) error {
 		s.paths.setRaws(n, roomID, resp.State, false)
@@ -545,7 +662,7 @@ func (s *State) RoomNotificationCount(roomID matrix.RoomID) m.NotificationCount
 func (s *State) AddEvents(sync *api.SyncResponse) error {
 	return s.top.TxUpdate(func(n db.Node) error {
 		s.paths.setRaws(n, "", sync.AccountData.Events, true)
-		s.paths.setRaws(n, "", sync.Presence.Events, true)
+		s.paths.setPresences(n, sync.Presence.Events)
 		s.paths.setRaws(n, "", sync.ToDevice.Events, true)
 
 		for _, ev := range sync.AccountData.Events {
@@ -568,13 +685,17 @@ func (s *State) AddEvents(sync *api.SyncResponse) error {
 			s.paths.setSummary(n, k, v.Summary)
 			s.paths.setTimeline(n, k, v.Timeline)
 			s.paths.setRoomAny(n, k, "__unread_count", v.UnreadCount)
+			s.paths.setReceipts(n, k, v.Ephemeral.Events)
+			s.paths.setInvite(n, k, false)
 		}
 
 		for k, v := range sync.Rooms.Invited {
 			s.paths.setStrippeds(n, k, v.State.Events, true)
+			s.paths.setInvite(n, k, true)
 		}
 
 		for k, v := range sync.Rooms.Left {
+			s.paths.setInvite(n, k, false)
 			s.paths.setRaws(n, k, v.State.Events, true)
 			s.paths.setRaws(n, k, v.AccountData.Events, true)
 			s.paths.deleteTimeline(n, k)
@@ -0,0 +1,140 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+const testRoomID matrix.RoomID = "!room:example.com"
+
+func newTestState(t *testing.T) *State {
+	t.Helper()
+
+	s, err := New(filepath.Join(t.TempDir(), "state.db"), testUserID)
+	if err != nil {
+		t.Fatal("cannot open state:", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func testMessage(id matrix.EventID, ts matrix.Timestamp) event.RawEvent {
+	return event.RawEvent(fmt.Sprintf(`{
+		"type": "m.room.message",
+		"event_id": %q,
+		"sender": "@friend:example.com",
+		"origin_server_ts": %d,
+		"content": {"msgtype": "m.text", "body": "hi"}
+	}`, id, ts))
+}
+
+// syncTimeline adds the given timeline into the test room as if it came from
+// a sync.
+func syncTimeline(t *testing.T, s *State, tl api.SyncTimeline, extra ...event.RawEvent) {
+	t.Helper()
+
+	err := s.AddEvents(&api.SyncResponse{
+		Rooms: api.SyncRoomEvents{
+			Joined: map[matrix.RoomID]api.SyncJoinedRoomEvents{
+				testRoomID: {
+					Timeline:    tl,
+					AccountData: api.SyncEvents{Events: extra},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("cannot add events:", err)
+	}
+}
+
+// timelineIDs returns the IDs of the stored timeline events of the test room.
+func timelineIDs(s *State) []matrix.EventID {
+	var ids []matrix.EventID
+	s.EachTimeline(testRoomID, func(ev event.RoomEvent) error {
+		ids = append(ids, ev.RoomInfo().ID)
+		return nil
+	})
+	return ids
+}
+
+func equalIDs(a, b []matrix.EventID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSetReceipts(t *testing.T) {
+	receipt := func(eventID matrix.EventID, ts matrix.Timestamp, threadID string) event.RawEvent {
+		return event.RawEvent(fmt.Sprintf(`{
+			"type": "m.receipt",
+			"content": {%q: {"m.read": {"@friend:example.com": {"ts": %d, "thread_id": %q}}}}
+		}`, eventID, ts, threadID))
+	}
+
+	tests := []struct {
+		name     string
+		receipts []event.RawEvent
+		expect   Receipt
+	}{
+		{
+			name:     "newer",
+			receipts: []event.RawEvent{receipt("$1", 1, ""), receipt("$2", 2, "")},
+			expect:   Receipt{EventID: "$2", Timestamp: 2},
+		},
+		{
+			name:     "older",
+			receipts: []event.RawEvent{receipt("$2", 2, ""), receipt("$1", 1, "")},
+			expect:   Receipt{EventID: "$2", Timestamp: 2},
+		},
+		{
+			name:     "main thread",
+			receipts: []event.RawEvent{receipt("$1", 1, ""), receipt("$2", 2, "main")},
+			expect:   Receipt{EventID: "$2", Timestamp: 2},
+		},
+		{
+			name:     "other thread",
+			receipts: []event.RawEvent{receipt("$1", 1, ""), receipt("$2", 2, "$thread")},
+			expect:   Receipt{EventID: "$1", Timestamp: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestState(t)
+
+			for _, raw := range test.receipts {
+				err := s.AddEvents(&api.SyncResponse{
+					Rooms: api.SyncRoomEvents{
+						Joined: map[matrix.RoomID]api.SyncJoinedRoomEvents{
+							testRoomID: {Ephemeral: api.SyncEvents{Events: []event.RawEvent{raw}}},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal("cannot add events:", err)
+				}
+			}
+
+			r, err := s.UserReceipt(testRoomID, "@friend:example.com")
+			if err != nil {
+				t.Fatal("cannot get receipt:", err)
+			}
+			if r != test.expect {
+				t.Errorf("expected receipt %+v, got %+v", test.expect, r)
+			}
+		})
+	}
+}
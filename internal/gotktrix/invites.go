@@ -0,0 +1,59 @@
+package gotktrix
+
+import (
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Invites returns the IDs of the rooms that the user is invited to. Only the
+// stripped state of these rooms is available, which is enough for the name,
+// avatar and topic.
+func (c *Client) Invites() []matrix.RoomID {
+	return c.State.Invites()
+}
+
+// RoomInviter returns the user who invited the current user into the room, or
+// an empty string if it's not known.
+func (c *Client) RoomInviter(roomID matrix.RoomID) matrix.UserID {
+	e, err := c.State.RoomState(roomID, event.TypeRoomMember, string(c.UserID))
+	if err != nil {
+		return ""
+	}
+
+	member := e.(*event.RoomMemberEvent)
+	if member.NewState != event.MemberInvited {
+		return ""
+	}
+
+	return member.Sender
+}
+
+// AcceptInvite joins the room that the user is invited to.
+func (c *Client) AcceptInvite(roomID matrix.RoomID) error {
+	if err := c.RoomJoin(roomID, ""); err != nil {
+		return errors.Wrap(err, "failed to join room")
+	}
+
+	c.State.RemoveInvite(roomID)
+	return nil
+}
+
+// DeclineInvite rejects the invite to the given room.
+func (c *Client) DeclineInvite(roomID matrix.RoomID) error {
+	if err := c.RoomLeave(roomID, ""); err != nil {
+		return errors.Wrap(err, "failed to reject invite")
+	}
+
+	c.State.RemoveInvite(roomID)
+	return nil
+}
+
+// InviteUser invites the given user into the room. The current user must have
+// the InviteAction power.
+func (c *Client) InviteUser(roomID matrix.RoomID, userID matrix.UserID) error {
+	if err := c.Invite(roomID, userID, ""); err != nil {
+		return errors.Wrapf(err, "failed to invite %s", userID)
+	}
+	return nil
+}
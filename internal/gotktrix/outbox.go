@@ -0,0 +1,372 @@
+package gotktrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotktrix/internal/registry"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// OutboxStatus describes the sending status of an event in the outbox.
+type OutboxStatus = state.OutboxStatus
+
+const (
+	OutboxQueued  = state.OutboxQueued
+	OutboxSending = state.OutboxSending
+	OutboxSent    = state.OutboxSent
+	OutboxFailed  = state.OutboxFailed
+)
+
+// OutboxEvent is an event that is waiting in the outbox to be sent.
+type OutboxEvent = state.OutboxEvent
+
+const (
+	outboxMinBackoff = 2 * time.Second
+	outboxMaxBackoff = 2 * time.Minute
+)
+
+// NewTransactionID creates a new transaction ID for sending an event. IDs
+// created later sort after earlier ones.
+func NewTransactionID() string {
+	return fmt.Sprintf("gotktrix.%016x.%08x", time.Now().UnixNano(), rand.Uint32())
+}
+
+// outbox sends events in the state's outbox in the background.
+type outbox struct {
+	mu     sync.Mutex
+	fns    registry.Registry
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the worker returns
+	// retryAt is the time that the worker is backing off until, or zero if
+	// it's not backing off.
+	retryAt time.Time
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		fns:  registry.New(2),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// poke wakes the outbox worker up if it's sleeping.
+func (o *outbox) poke() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pokeSync wakes the outbox worker up after a sync, unless it's backing off.
+// A sync succeeding doesn't mean that the server will take the event, so the
+// backoff is still respected.
+func (o *outbox) pokeSync() {
+	o.mu.Lock()
+	retryAt := o.retryAt
+	o.mu.Unlock()
+
+	if retryAt.IsZero() || !time.Now().Before(retryAt) {
+		o.poke()
+	}
+}
+
+func (o *outbox) start(c *Client) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel = cancel
+	o.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		o.loop(c.WithContext(ctx))
+		close(done)
+	}(o.done)
+}
+
+// stop stops the outbox worker and waits for it to return, so the state isn't
+// used by the worker after stop returns.
+func (o *outbox) stop() {
+	o.mu.Lock()
+	cancel := o.cancel
+	done := o.done
+	o.cancel = nil
+	o.done = nil
+	o.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// emit calls all subscribers with the given event. The subscribers are called
+// outside the lock, so they may unsubscribe themselves.
+func (o *outbox) emit(ev OutboxEvent) {
+	o.mu.Lock()
+	var fns []func(OutboxEvent)
+	o.fns.Each(func(f, _ interface{}) {
+		fns = append(fns, f.(func(OutboxEvent)))
+	})
+	o.mu.Unlock()
+
+	for _, f := range fns {
+		f(ev)
+	}
+}
+
+func (o *outbox) loop(c *Client) {
+	for {
+		var retry <-chan time.Time
+		var retryAt time.Time
+
+		if wait := o.flush(c); wait > 0 {
+			retry = time.After(wait)
+			retryAt = time.Now().Add(wait)
+		}
+
+		o.mu.Lock()
+		o.retryAt = retryAt
+		o.mu.Unlock()
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-o.wake:
+		case <-retry:
+		}
+	}
+}
+
+// flush sends all queued events in order. If an event cannot be sent yet, then
+// flush stops so that the order is kept, and the duration to wait before
+// trying again is returned.
+func (o *outbox) flush(c *Client) time.Duration {
+	for _, ev := range c.State.Outbox("") {
+		if ev.Status == OutboxFailed {
+			continue
+		}
+
+		if c.ctx.Err() != nil {
+			return 0
+		}
+
+		// Mark the event as being sent in the state, so that it can no longer
+		// be canceled. This fails if it has already been canceled.
+		sending, err := c.State.UpdateOutbox(ev.TxnID, func(ev *OutboxEvent) {
+			ev.Status = OutboxSending
+		})
+		if err != nil {
+			continue
+		}
+		ev = sending
+		o.emit(ev)
+
+		eventID, err := c.sendOutboxEvent(ev)
+		if err == nil {
+			if err := c.State.DeleteOutbox(ev.TxnID); err != nil {
+				log.Printf("failed to delete sent outbox event %q: %v", ev.TxnID, err)
+			}
+
+			ev.Status = OutboxSent
+			ev.EventID = eventID
+			o.emit(ev)
+			continue
+		}
+
+		failed, updateErr := c.State.UpdateOutbox(ev.TxnID, func(ev *OutboxEvent) {
+			ev.Attempts++
+			ev.Error = err.Error()
+			ev.Status = OutboxQueued
+			if !isTransientError(err) {
+				ev.Status = OutboxFailed
+			}
+		})
+		if updateErr != nil {
+			log.Printf("failed to update outbox event %q: %v", ev.TxnID, updateErr)
+			continue
+		}
+
+		ev = failed
+		o.emit(ev)
+
+		if ev.Status == OutboxFailed {
+			continue
+		}
+
+		return outboxBackoff(ev.Attempts, err)
+	}
+
+	return 0
+}
+
+// isTransientError returns true if the error is worth retrying, which is the
+// case for network errors, rate limits and server errors.
+func isTransientError(err error) bool {
+	code := matrix.StatusCode(err)
+	return code == -1 || code == http.StatusTooManyRequests || code >= 500
+}
+
+func outboxBackoff(attempts int, err error) time.Duration {
+	var apiErr matrix.APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfterMillisecond > 0 {
+		return time.Duration(apiErr.RetryAfterMillisecond) * time.Millisecond
+	}
+
+	backoff := outboxMinBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+
+	return backoff
+}
+
+func (c *Client) sendOutboxEvent(ev OutboxEvent) (matrix.EventID, error) {
+	var resp struct {
+		EventID matrix.EventID `json:"event_id"`
+	}
+
+	err := c.Request(
+		"PUT", c.Endpoints.RoomSend(ev.RoomID, ev.Type, ev.TxnID), &resp,
+		httputil.WithToken(), httputil.WithJSONBody(ev.Content),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to send event")
+	}
+
+	return resp.EventID, nil
+}
+
+// QueueRoomEvent puts the given event into the outbox to be sent in the
+// background. The outbox is persisted, so the event is retried until it's sent,
+// even across restarts. The transaction ID of the queued event is returned.
+//
+// If the event is a room event with a transaction ID in its unsigned data,
+// then that ID is used, which allows callers to match the event up with its
+// outbox status. Otherwise, a new one is made.
+func (c *Client) QueueRoomEvent(roomID matrix.RoomID, ev event.Event) (string, error) {
+	if ev.Info().Type == "" {
+		// bug
+		panic("QueueRoomEvent: missing event type")
+	}
+
+	content, err := json.Marshal(ev)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal event")
+	}
+
+	var txnID string
+	if roomEv, ok := ev.(event.RoomEvent); ok {
+		txnID = roomEv.RoomInfo().Unsigned.TransactionID
+	}
+	if txnID == "" {
+		txnID = NewTransactionID()
+	}
+
+	outEv := OutboxEvent{
+		TxnID:   txnID,
+		RoomID:  roomID,
+		Type:    ev.Info().Type,
+		Content: content,
+		Time:    matrix.Timestamp(time.Now().UnixMilli()),
+		Status:  OutboxQueued,
+	}
+
+	if err := c.State.AddOutbox(outEv); err != nil {
+		return "", errors.Wrap(err, "failed to queue event")
+	}
+
+	c.outbox.emit(outEv)
+	c.outbox.poke()
+
+	return txnID, nil
+}
+
+// Outbox returns the events in the outbox that haven't been sent yet. If roomID
+// is not empty, then only events in that room are returned.
+func (c *Client) Outbox(roomID matrix.RoomID) []OutboxEvent {
+	return c.State.Outbox(roomID)
+}
+
+// OutboxRoomEvent parses the given outbox event into a room event sent by the
+// current user, so that it can be displayed while it's still being sent. The
+// event's transaction ID is kept in its unsigned data.
+func (c *Client) OutboxRoomEvent(ev OutboxEvent) event.RoomEvent {
+	raw, _ := json.Marshal(struct {
+		Type     event.Type         `json:"type"`
+		Content  json.RawMessage    `json:"content"`
+		Sender   matrix.UserID      `json:"sender"`
+		Time     matrix.Timestamp   `json:"origin_server_ts"`
+		Unsigned event.UnsignedData `json:"unsigned"`
+	}{
+		Type:     ev.Type,
+		Content:  ev.Content,
+		Sender:   c.UserID,
+		Time:     ev.Time,
+		Unsigned: event.UnsignedData{TransactionID: ev.TxnID},
+	})
+
+	return sys.ParseTimeline(raw, ev.RoomID)
+}
+
+// OnOutbox subscribes f to be called every time the status of an event in the
+// outbox changes. f might be called in a different goroutine. Call the
+// returned callback to unsubscribe.
+func (c *Client) OnOutbox(f func(OutboxEvent)) func() {
+	c.outbox.mu.Lock()
+	defer c.outbox.mu.Unlock()
+
+	v := c.outbox.fns.Add(f, nil)
+
+	return func() {
+		c.outbox.mu.Lock()
+		v.Delete()
+		c.outbox.mu.Unlock()
+	}
+}
+
+// RetryOutbox queues a failed event in the outbox to be sent again.
+func (c *Client) RetryOutbox(txnID string) error {
+	ev, err := c.State.UpdateOutbox(txnID, func(ev *OutboxEvent) {
+		ev.Status = OutboxQueued
+		ev.Attempts = 0
+		ev.Error = ""
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to retry event")
+	}
+
+	c.outbox.emit(ev)
+	c.outbox.poke()
+	return nil
+}
+
+// CancelOutbox removes the event with the given transaction ID from the
+// outbox. An error is returned if the event is already being sent.
+func (c *Client) CancelOutbox(txnID string) error {
+	if err := c.State.CancelOutbox(txnID); err != nil {
+		return errors.Wrap(err, "failed to cancel event")
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package gotktrix
+
+import (
+	"context"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// TimelineGap describes a hole in the stored timeline of a room, which is left
+// by a limited sync. The paginator fills gaps as it runs into them.
+type TimelineGap = state.TimelineGap
+
+// paginateFetch is the number of events to fetch per API request.
+const paginateFetch = 100
+
+// RoomPaginator paginates the timeline of a room in both directions. Events are
+// read from the stored timeline first. Gaps in it are filled using the API as
+// the paginator runs into them, and the API is also used once the paginator
+// runs past either end of the stored timeline.
+type RoomPaginator struct {
+	c      *Client
+	roomID matrix.RoomID
+	limit  int
+
+	// anchor is the event ID that the paginator starts at. If it's empty, then
+	// the paginator starts at the latest event.
+	anchor   matrix.EventID
+	anchorEv event.RoomEvent
+	started  bool
+
+	back pageCursor
+	fwd  pageCursor
+
+	// seen contains the IDs of the returned events, so that they're never
+	// returned twice.
+	seen map[matrix.EventID]struct{}
+}
+
+// pageCursor is the position of the paginator in one direction.
+type pageCursor struct {
+	// edge is the last event returned in this direction, or nil if none.
+	edge event.RoomEvent
+	// gap is the gap that's being filled, if any.
+	gap *TimelineGap
+	// token is the pagination token to continue from using the API.
+	token string
+	// api is true if the cursor is outside the stored timeline.
+	api bool
+	// stores is true if the events fetched from the API are adjacent to the
+	// stored timeline, so they can be stored as well.
+	stores bool
+	// done is true if there are no more events in this direction.
+	done bool
+}
+
+// RoomPaginator returns a new paginator that starts at the latest event of the
+// room. Only Paginate is useful, since there's nothing newer.
+func (c *Client) RoomPaginator(roomID matrix.RoomID, limit int) *RoomPaginator {
+	if limit < 1 {
+		log.Panicln("gotktrix: RoomPaginator limit must be non-zero")
+	}
+
+	return &RoomPaginator{
+		c:       c,
+		limit:   limit,
+		roomID:  roomID,
+		started: true,
+		fwd:     pageCursor{done: true},
+		seen:    make(map[matrix.EventID]struct{}),
+	}
+}
+
+// RoomPaginatorAt returns a new paginator that starts at the given event. The
+// first call to Paginate returns the event itself along with the events
+// before it, and PaginateForward returns the events after it.
+func (c *Client) RoomPaginatorAt(roomID matrix.RoomID, eventID matrix.EventID, limit int) *RoomPaginator {
+	p := c.RoomPaginator(roomID, limit)
+	p.anchor = eventID
+	p.started = false
+	p.fwd.done = false
+	return p
+}
+
+// RoomPaginatorContext returns a new paginator that continues from the given
+// event context in both directions. The events in the context are never
+// returned.
+func (c *Client) RoomPaginatorContext(ec *EventContext, limit int) *RoomPaginator {
+	p := c.RoomPaginator(ec.RoomID, limit)
+	p.fwd.done = false
+	p.setContext(ec)
+	return p
+}
+
+// AtLatest returns true if PaginateForward has reached the latest event in the
+// stored timeline. Newer events will arrive through the sync.
+func (p *RoomPaginator) AtLatest() bool {
+	return p.fwd.done
+}
+
+// start finds the anchor event if it hasn't been found yet.
+func (p *RoomPaginator) start(ctx context.Context) error {
+	if p.started {
+		return nil
+	}
+
+	ec, err := p.c.WithContext(ctx).RoomEventContext(p.roomID, p.anchor, 0)
+	if err != nil {
+		return err
+	}
+
+	p.setContext(ec)
+	p.anchorEv = ec.Event
+	delete(p.seen, ec.Event.RoomInfo().ID)
+
+	return nil
+}
+
+// setContext sets the paginator's position to be around the given context.
+func (p *RoomPaginator) setContext(ec *EventContext) {
+	events := ec.Events()
+	for _, ev := range events {
+		p.seen[ev.RoomInfo().ID] = struct{}{}
+	}
+
+	p.back = pageCursor{edge: events[0]}
+	p.fwd = pageCursor{edge: events[len(events)-1]}
+
+	if !ec.stored {
+		p.back.api = true
+		p.back.token = ec.start
+		p.fwd.api = true
+		p.fwd.token = ec.end
+	}
+
+	p.started = true
+}
+
+// Paginate returns the events before the previously returned ones, latest
+// last. It returns no events and no error once the start of the room is
+// reached.
+func (p *RoomPaginator) Paginate(ctx context.Context) ([]event.RoomEvent, error) {
+	if err := p.start(ctx); err != nil {
+		return nil, err
+	}
+
+	var events []event.RoomEvent // latest first
+
+	if p.anchorEv != nil {
+		events = p.appendUnseen(events, []event.RoomEvent{p.anchorEv})
+		p.anchorEv = nil
+	}
+
+	for len(events) < p.limit && !p.back.done {
+		evs, err := p.stepBackward(ctx, p.limit-len(events))
+		if err != nil {
+			if len(events) > 0 {
+				// Return what we have. The next call will try again.
+				break
+			}
+			return nil, err
+		}
+
+		if len(evs) > 0 {
+			p.back.edge = evs[len(evs)-1]
+			events = p.appendUnseen(events, evs)
+		}
+	}
+
+	// Flip the events so that the latest is last. Code from SliceTricks.
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	return events, nil
+}
+
+// PaginateForward returns the events after the previously returned ones,
+// latest last. It returns no events and no error once the latest stored event
+// is reached. It's only useful for paginators created with RoomPaginatorAt.
+func (p *RoomPaginator) PaginateForward(ctx context.Context) ([]event.RoomEvent, error) {
+	if err := p.start(ctx); err != nil {
+		return nil, err
+	}
+
+	var events []event.RoomEvent // latest last
+
+	for len(events) < p.limit && !p.fwd.done {
+		evs, err := p.stepForward(ctx, p.limit-len(events))
+		if err != nil {
+			if len(events) > 0 {
+				break
+			}
+			return nil, err
+		}
+
+		if len(evs) > 0 {
+			p.fwd.edge = evs[len(evs)-1]
+			events = p.appendUnseen(events, evs)
+		}
+	}
+
+	return events, nil
+}
+
+func (p *RoomPaginator) appendUnseen(dst, events []event.RoomEvent) []event.RoomEvent {
+	for _, ev := range events {
+		id := ev.RoomInfo().ID
+		if _, ok := p.seen[id]; ok {
+			continue
+		}
+		p.seen[id] = struct{}{}
+		dst = append(dst, ev)
+	}
+	return dst
+}
+
+// stepBackward fetches the next few events before the backward cursor, latest
+// first. It may return no events if the cursor only changed its state.
+func (p *RoomPaginator) stepBackward(ctx context.Context, want int) ([]event.RoomEvent, error) {
+	b := &p.back
+
+	switch {
+	case b.gap != nil:
+		r, err := p.roomMessages(ctx, b.gap.Token, api.RoomMessagesBackward)
+		if err != nil {
+			return nil, err
+		}
+
+		events, next, err := p.c.State.FillRoomGap(p.roomID, *b.gap, &r)
+		if err != nil {
+			// The gap is gone, so just continue on with the API.
+			log.Printf("cannot fill gap in room %q: %v", p.roomID, err)
+			b.gap = nil
+			b.api = true
+			b.token = r.End
+			b.done = r.End == ""
+			return sys.ParseAllTimeline(r.Chunk, p.roomID), nil
+		}
+
+		p.index(events)
+		b.gap = next
+		b.token = r.End
+		return events, nil
+
+	case b.api:
+		r, err := p.roomMessages(ctx, b.token, api.RoomMessagesBackward)
+		if err != nil {
+			return nil, err
+		}
+
+		b.token = r.End
+		b.done = r.End == "" || len(r.Chunk) == 0
+
+		if b.stores {
+			p.c.State.AddRoomMessages(p.roomID, &r)
+		}
+
+		events := sys.ParseAllTimeline(r.Chunk, p.roomID)
+
+		if !b.stores {
+			for i, ev := range events {
+				if p.c.State.RoomTimelineHas(p.roomID, ev) {
+					// We've reached the stored timeline, so continue from it.
+					events = events[:i+1]
+					b.api = false
+					b.done = false
+					break
+				}
+			}
+		}
+
+		p.index(events)
+		return events, nil
+
+	default:
+		events, gap := p.c.State.RoomTimelineBefore(p.roomID, b.edge, want)
+		b.gap = gap
+
+		if len(events) > 0 || gap != nil {
+			// The token is no longer valid once we've moved on.
+			if len(events) > 0 {
+				b.token = ""
+			}
+			return events, nil
+		}
+
+		// We've run out of stored events, so continue on with the API.
+		b.api = true
+		b.stores = true
+
+		if b.token != "" {
+			return nil, nil
+		}
+
+		if b.edge == nil {
+			prev, err := p.c.State.RoomPreviousBatch(p.roomID)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get previous batch")
+			}
+			b.token = prev
+			return nil, nil
+		}
+
+		r, err := p.c.WithContext(ctx).roomContext(p.roomID, b.edge.RoomInfo().ID, 0)
+		if err != nil {
+			b.api = false
+			return nil, err
+		}
+
+		b.token = r.Start
+		return nil, nil
+	}
+}
+
+// stepForward fetches the next few events after the forward cursor, latest
+// last. It may return no events if the cursor only changed its state.
+func (p *RoomPaginator) stepForward(ctx context.Context, want int) ([]event.RoomEvent, error) {
+	f := &p.fwd
+
+	if !f.api {
+		events, gap := p.c.State.RoomTimelineAfter(p.roomID, f.edge, want)
+		if len(events) > 0 {
+			return events, nil
+		}
+
+		if gap == nil {
+			// We're at the latest stored event.
+			f.done = true
+			return nil, nil
+		}
+
+		// We're right before a gap, so go over it using the API.
+		f.api = true
+		f.token = ""
+		return nil, nil
+	}
+
+	if f.token == "" {
+		r, err := p.c.WithContext(ctx).roomContext(p.roomID, f.edge.RoomInfo().ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		f.token = r.End
+	}
+
+	r, err := p.roomMessages(ctx, f.token, api.RoomMessagesForward)
+	if err != nil {
+		return nil, err
+	}
+
+	f.token = r.End
+	f.done = r.End == "" || len(r.Chunk) == 0
+
+	events := sys.ParseAllTimeline(r.Chunk, p.roomID)
+
+	for i, ev := range events {
+		if p.c.State.RoomTimelineHas(p.roomID, ev) {
+			// We've caught up with the stored timeline, so continue from it.
+			// Keep the stored event so that it becomes the new edge.
+			events = events[:i+1]
+			f.api = false
+			f.done = false
+			break
+		}
+	}
+
+	p.index(events)
+	return events, nil
+}
+
+func (p *RoomPaginator) roomMessages(
+	ctx context.Context, from string, dir api.RoomMessagesDirection) (api.RoomMessagesResponse, error) {
+
+	// https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmessages
+	r, err := p.c.WithContext(ctx).RoomMessages(p.roomID, api.RoomMessagesQuery{
+		From:      from,
+		Direction: dir,
+		Limit:     paginateFetch,
+	})
+	if err != nil {
+		return r, errors.Wrapf(err, "failed to query messages for room %q", p.roomID)
+	}
+
+	return r, nil
+}
+
+// index indexes the given fetched events so that they're searchable.
+func (p *RoomPaginator) index(events []event.RoomEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	batch := p.c.Index.Begin()
+	batch.IndexTimeline(events)
+	batch.Commit()
+}
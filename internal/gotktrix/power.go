@@ -0,0 +1,200 @@
+package gotktrix
+
+import (
+	"encoding/json"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// PowerLevels is the power level model of a room. Use Client.RoomPowerLevels to
+// get one.
+type PowerLevels struct {
+	Ban    int
+	Invite int
+	Kick   int
+	Redact int
+
+	// Events maps event types to the level required to send them. Events that
+	// aren't in it require EventsDefault or StateDefault.
+	Events        map[event.Type]int
+	EventsDefault int
+	StateDefault  int
+
+	// Users maps users to their level. Users that aren't in it have
+	// UsersDefault.
+	Users        map[matrix.UserID]int
+	UsersDefault int
+
+	// Notifications maps notification keys, such as "room", to the level
+	// required to trigger them.
+	Notifications map[string]int
+}
+
+// powerLevelsContent is the content of m.room.power_levels. Unlike gotrix's
+// event, missing fields can be told apart from zeroes, which matters for the
+// defaults.
+type powerLevelsContent struct {
+	Ban           *int                  `json:"ban"`
+	Invite        *int                  `json:"invite"`
+	Kick          *int                  `json:"kick"`
+	Redact        *int                  `json:"redact"`
+	Events        map[event.Type]int    `json:"events"`
+	EventsDefault *int                  `json:"events_default"`
+	StateDefault  *int                  `json:"state_default"`
+	Users         map[matrix.UserID]int `json:"users"`
+	UsersDefault  *int                  `json:"users_default"`
+	Notifications map[string]int        `json:"notifications"`
+}
+
+func intOr(v *int, or int) int {
+	if v != nil {
+		return *v
+	}
+	return or
+}
+
+// parsePowerLevels parses the given m.room.power_levels event with the
+// defaults from the specification.
+func parsePowerLevels(raw event.RawEvent) (*PowerLevels, error) {
+	var ev struct {
+		Content powerLevelsContent `json:"content"`
+	}
+
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return nil, err
+	}
+
+	c := ev.Content
+
+	return &PowerLevels{
+		Ban:           intOr(c.Ban, 50),
+		Invite:        intOr(c.Invite, 0),
+		Kick:          intOr(c.Kick, 50),
+		Redact:        intOr(c.Redact, 50),
+		Events:        c.Events,
+		EventsDefault: intOr(c.EventsDefault, 0),
+		StateDefault:  intOr(c.StateDefault, 50),
+		Users:         c.Users,
+		UsersDefault:  intOr(c.UsersDefault, 0),
+		Notifications: c.Notifications,
+	}, nil
+}
+
+// creatorPowerLevels returns the power levels of a room without a power levels
+// event, where the creator has a level of 100 and everyone can do anything
+// else.
+func creatorPowerLevels(creator matrix.UserID) *PowerLevels {
+	pl := &PowerLevels{
+		Ban:    50,
+		Kick:   50,
+		Redact: 50,
+	}
+	if creator != "" {
+		pl.Users = map[matrix.UserID]int{creator: 100}
+	}
+	return pl
+}
+
+// UserLevel returns the power level of the given user.
+func (pl *PowerLevels) UserLevel(userID matrix.UserID) int {
+	if level, ok := pl.Users[userID]; ok {
+		return level
+	}
+	return pl.UsersDefault
+}
+
+// EventLevel returns the power level required to send an event of the given
+// type.
+func (pl *PowerLevels) EventLevel(typ event.Type, isState bool) int {
+	if level, ok := pl.Events[typ]; ok {
+		return level
+	}
+	if isState {
+		return pl.StateDefault
+	}
+	return pl.EventsDefault
+}
+
+// NotificationLevel returns the power level required to trigger the given
+// notification key, such as "room" for @room.
+func (pl *PowerLevels) NotificationLevel(key string) int {
+	if level, ok := pl.Notifications[key]; ok {
+		return level
+	}
+	return 50
+}
+
+// CanSendEvent returns true if the user can send an event of the given type.
+func (pl *PowerLevels) CanSendEvent(userID matrix.UserID, typ event.Type, isState bool) bool {
+	return pl.UserLevel(userID) >= pl.EventLevel(typ, isState)
+}
+
+// CanRedact returns true if the user can redact an event sent by sender. Users
+// can always redact their own events if they can send redactions, but they
+// need the redact level for everyone else's.
+func (pl *PowerLevels) CanRedact(userID, sender matrix.UserID) bool {
+	if !pl.CanSendEvent(userID, event.TypeRoomRedaction, false) {
+		return false
+	}
+	return userID == sender || pl.UserLevel(userID) >= pl.Redact
+}
+
+// CanChangePower returns true if the user can set target's power level to the
+// given level. The user must be able to change the power levels event, can't
+// give out more than their own level, and can only change the level of users
+// below them, except for demoting themselves.
+func (pl *PowerLevels) CanChangePower(userID, target matrix.UserID, level int) bool {
+	if !pl.CanSendEvent(userID, event.TypeRoomPowerLevels, true) {
+		return false
+	}
+
+	own := pl.UserLevel(userID)
+	if level > own {
+		return false
+	}
+
+	return userID == target || pl.UserLevel(target) < own
+}
+
+// roomCreator returns the creator of the room from the state, or an empty
+// string if it's not known.
+func (c *Client) roomCreator(roomID matrix.RoomID) matrix.UserID {
+	e, err := c.State.RoomState(roomID, event.TypeRoomCreate, "")
+	if err != nil {
+		return ""
+	}
+	return e.(*event.RoomCreateEvent).Creator
+}
+
+// RoomPowerLevels returns the power levels of the given room. If the room has
+// no power levels event, then the defaults of the specification are used.
+func (c *Client) RoomPowerLevels(roomID matrix.RoomID) *PowerLevels {
+	e, err := c.RoomState(roomID, event.TypeRoomPowerLevels, "")
+	if err == nil {
+		pl, err := parsePowerLevels(e.Info().Raw)
+		if err == nil {
+			return pl
+		}
+	}
+
+	return creatorPowerLevels(c.roomCreator(roomID))
+}
+
+// CanSendEvent returns true if the current user can send an event of the given
+// type into the room. isState must be true for state events.
+func (c *Client) CanSendEvent(roomID matrix.RoomID, typ event.Type, isState bool) bool {
+	return c.RoomPowerLevels(roomID).CanSendEvent(c.UserID, typ, isState)
+}
+
+// CanRedact returns true if the current user can redact an event in the room
+// sent by the given sender.
+func (c *Client) CanRedact(roomID matrix.RoomID, sender matrix.UserID) bool {
+	return c.RoomPowerLevels(roomID).CanRedact(c.UserID, sender)
+}
+
+// CanChangePower returns true if the current user can set the given user's
+// power level in the room to level.
+func (c *Client) CanChangePower(roomID matrix.RoomID, userID matrix.UserID, level int) bool {
+	return c.RoomPowerLevels(roomID).CanChangePower(c.UserID, userID, level)
+}
@@ -0,0 +1,348 @@
+package gotktrix
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/pushrule"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// PushRuleResult is the push rule that matched an event.
+type PushRuleResult = pushrule.Result
+
+// PushRuleKind is the kind of a push rule.
+type PushRuleKind = pushrule.Kind
+
+const (
+	OverridePushRule  = pushrule.Override
+	ContentPushRule   = pushrule.Content
+	RoomPushRule      = pushrule.Room
+	SenderPushRule    = pushrule.Sender
+	UnderridePushRule = pushrule.Underride
+)
+
+// RoomNotifyLevel is how much a room notifies the user.
+type RoomNotifyLevel uint8
+
+const (
+	// RoomNotifyDefault follows the user's default rules.
+	RoomNotifyDefault RoomNotifyLevel = iota
+	// RoomNotifyAll notifies with a sound for all messages.
+	RoomNotifyAll
+	// RoomNotifyMentions only notifies for mentions and keywords.
+	RoomNotifyMentions
+	// RoomNotifyMute never notifies.
+	RoomNotifyMute
+)
+
+// PushRules returns the user's global push ruleset.
+func (c *Client) PushRules() (*pushrule.Ruleset, error) {
+	raw, err := c.State.UserEventRaw(event.TypePushRules)
+	if err != nil {
+		return nil, err
+	}
+
+	return pushrule.Parse(json.RawMessage(raw))
+}
+
+// EvaluatePushRules finds the push rule that matches the given event. False is
+// returned if none matches, in which case the event shouldn't notify.
+func (c *Client) EvaluatePushRules(ev event.RoomEvent) (PushRuleResult, bool) {
+	rules, err := c.PushRules()
+	if err != nil {
+		return PushRuleResult{}, false
+	}
+
+	env, err := c.pushRuleEnv(ev)
+	if err != nil {
+		log.Printf("cannot evaluate push rules for event %q: %v", ev.RoomInfo().ID, err)
+		return PushRuleResult{}, false
+	}
+
+	return rules.Evaluate(env)
+}
+
+func (c *Client) pushRuleEnv(ev event.RoomEvent) (pushrule.Env, error) {
+	info := ev.RoomInfo()
+
+	if len(info.Raw) == 0 {
+		return pushrule.Env{}, errors.New("event has no raw JSON")
+	}
+
+	env := pushrule.Env{
+		Event:       json.RawMessage(info.Raw),
+		RoomID:      info.RoomID,
+		MemberCount: c.roomJoinedCount(info.RoomID),
+	}
+
+	if e, err := c.State.RoomState(info.RoomID, event.TypeRoomMember, string(c.UserID)); err == nil {
+		if member := e.(*event.RoomMemberEvent); member.DisplayName != nil {
+			env.DisplayName = *member.DisplayName
+		}
+	}
+
+	pl := c.RoomPowerLevels(info.RoomID)
+	env.SenderLevel = pl.UserLevel(info.Sender)
+	env.NotificationLevels = pl.Notifications
+
+	return env, nil
+}
+
+// roomJoinedCount returns the number of joined members in the room. Only the
+// state is used, so the count may be off if the members aren't loaded yet.
+func (c *Client) roomJoinedCount(roomID matrix.RoomID) int {
+	if summary, err := c.State.RoomSummary(roomID); err == nil && summary.JoinedCount > 0 {
+		return summary.JoinedCount
+	}
+
+	var count int
+
+	c.State.EachRoomStateLen(roomID, event.TypeRoomMember, func(e event.StateEvent, _ int) error {
+		if member, ok := e.(*event.RoomMemberEvent); ok && member.NewState == event.MemberJoined {
+			count++
+		}
+		return nil
+	})
+
+	return count
+}
+
+// RoomNotifyLevel returns the notification level of the given room.
+func (c *Client) RoomNotifyLevel(roomID matrix.RoomID) RoomNotifyLevel {
+	rules, err := c.PushRules()
+	if err != nil {
+		return RoomNotifyDefault
+	}
+
+	if rule, ok := rules.Rule(pushrule.Override, string(roomID)); ok && rule.Enabled && !rule.Actions.Notify {
+		return RoomNotifyMute
+	}
+
+	if rule, ok := rules.Rule(pushrule.Room, string(roomID)); ok && rule.Enabled {
+		if rule.Actions.Notify {
+			return RoomNotifyAll
+		}
+		return RoomNotifyMentions
+	}
+
+	return RoomNotifyDefault
+}
+
+// SetRoomNotifyLevel sets the notification level of the given room. The rules
+// are set the same way other clients do, so the level shows up in them too.
+//
+// The new rule is put before the old ones are deleted, so the room never goes
+// without a rule if a request fails halfway.
+func (c *Client) SetRoomNotifyLevel(roomID matrix.RoomID, level RoomNotifyLevel) error {
+	rules, err := c.PushRules()
+	if err != nil {
+		return err
+	}
+
+	id := string(roomID)
+
+	var kind pushrule.Kind
+	var rule pushrule.Rule
+
+	switch level {
+	case RoomNotifyDefault:
+		// No rule; only delete the old ones.
+	case RoomNotifyAll:
+		kind = pushrule.Room
+		rule.Actions.Notify = true
+		rule.Actions.Tweaks = map[pushrule.Tweak]json.RawMessage{
+			pushrule.SoundTweak: json.RawMessage(`"default"`),
+		}
+	case RoomNotifyMentions:
+		kind = pushrule.Room
+	case RoomNotifyMute:
+		kind = pushrule.Override
+		rule.Conditions = []pushrule.Condition{
+			{Kind: pushrule.EventMatch, Key: "room_id", Pattern: id},
+		}
+	default:
+		return errors.New("unknown notify level")
+	}
+
+	if kind != "" {
+		rule.RuleID = id
+		rule.Enabled = true
+
+		if err := c.putPushRule(kind, rule); err != nil {
+			return err
+		}
+
+		rules.Put(kind, rule)
+	}
+
+	for _, old := range []pushrule.Kind{pushrule.Override, pushrule.Room} {
+		if old == kind {
+			// Already replaced by the new rule.
+			continue
+		}
+		if _, ok := rules.Rule(old, id); !ok {
+			continue
+		}
+		if err := c.deletePushRule(old, id); err != nil {
+			c.setLocalPushRules(rules)
+			return err
+		}
+		rules.Delete(old, id)
+	}
+
+	c.setLocalPushRules(rules)
+	return nil
+}
+
+// PushKeywords returns the keywords that the user wants to be notified for.
+func (c *Client) PushKeywords() []string {
+	rules, err := c.PushRules()
+	if err != nil {
+		return nil
+	}
+
+	var keywords []string
+	for _, rule := range rules.Content {
+		if !rule.IsServerDefault() {
+			keywords = append(keywords, rule.Pattern)
+		}
+	}
+
+	return keywords
+}
+
+// AddPushKeyword adds a rule that notifies and highlights messages containing
+// the given keyword.
+func (c *Client) AddPushKeyword(keyword string) error {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return errors.New("empty keyword")
+	}
+
+	rules, err := c.PushRules()
+	if err != nil {
+		return err
+	}
+
+	rule := pushrule.Rule{
+		RuleID:  keyword,
+		Enabled: true,
+		Pattern: keyword,
+		Actions: pushrule.Actions{
+			Notify: true,
+			Tweaks: map[pushrule.Tweak]json.RawMessage{
+				pushrule.SoundTweak:     json.RawMessage(`"default"`),
+				pushrule.HighlightTweak: nil,
+			},
+		},
+	}
+
+	if err := c.putPushRule(pushrule.Content, rule); err != nil {
+		return err
+	}
+
+	rules.Put(pushrule.Content, rule)
+	c.setLocalPushRules(rules)
+
+	return nil
+}
+
+// RemovePushKeyword removes the rule added by AddPushKeyword.
+func (c *Client) RemovePushKeyword(keyword string) error {
+	rules, err := c.PushRules()
+	if err != nil {
+		return err
+	}
+
+	if err := c.deletePushRule(pushrule.Content, keyword); err != nil {
+		return err
+	}
+
+	rules.Delete(pushrule.Content, keyword)
+	c.setLocalPushRules(rules)
+
+	return nil
+}
+
+// SetPushRuleEnabled enables or disables the given push rule. It's mostly
+// useful for toggling the server-default rules, which can't be changed
+// otherwise.
+func (c *Client) SetPushRuleEnabled(kind PushRuleKind, ruleID string, enabled bool) error {
+	rules, err := c.PushRules()
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	body.Enabled = enabled
+
+	err = c.Request(
+		"PUT", c.pushRuleEndpoint(kind, ruleID)+"/enabled", nil,
+		httputil.WithToken(), httputil.WithJSONBody(body),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to toggle push rule %q", ruleID)
+	}
+
+	rules.SetEnabled(kind, ruleID, enabled)
+	c.setLocalPushRules(rules)
+
+	return nil
+}
+
+func (c *Client) pushRuleEndpoint(kind pushrule.Kind, ruleID string) string {
+	return c.Endpoints.Base() + "/pushrules/global/" + string(kind) + "/" + url.PathEscape(ruleID)
+}
+
+func (c *Client) putPushRule(kind pushrule.Kind, rule pushrule.Rule) error {
+	body := struct {
+		Actions    pushrule.Actions     `json:"actions"`
+		Conditions []pushrule.Condition `json:"conditions,omitempty"`
+		Pattern    string               `json:"pattern,omitempty"`
+	}{
+		Actions:    rule.Actions,
+		Conditions: rule.Conditions,
+		Pattern:    rule.Pattern,
+	}
+
+	err := c.Request(
+		"PUT", c.pushRuleEndpoint(kind, rule.RuleID), nil,
+		httputil.WithToken(), httputil.WithJSONBody(body),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to set push rule %q", rule.RuleID)
+	}
+
+	return nil
+}
+
+func (c *Client) deletePushRule(kind pushrule.Kind, ruleID string) error {
+	err := c.Request("DELETE", c.pushRuleEndpoint(kind, ruleID), nil, httputil.WithToken())
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete push rule %q", ruleID)
+	}
+	return nil
+}
+
+// setLocalPushRules saves the given rules into the state, so that they take
+// effect before the server sends them back through the sync.
+func (c *Client) setLocalPushRules(rules *pushrule.Ruleset) {
+	content, err := json.Marshal(struct {
+		Global *pushrule.Ruleset `json:"global"`
+	}{rules})
+	if err != nil {
+		log.Println("failed to marshal push rules:", err)
+		return
+	}
+
+	c.State.SetUserEventRaw(sys.MarshalUserEvent(event.TypePushRules, content))
+}
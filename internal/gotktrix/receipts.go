@@ -0,0 +1,37 @@
+package gotktrix
+
+import (
+	"sort"
+
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// RoomReadReceipts returns the users who have read up to each event in the
+// room, not including the current user. The users of each event are sorted so
+// that the one who read it first comes first.
+func (c *Client) RoomReadReceipts(roomID matrix.RoomID) map[matrix.EventID][]matrix.UserID {
+	receipts := c.State.RoomReceipts(roomID)
+	delete(receipts, c.UserID)
+
+	userIDs := make([]matrix.UserID, 0, len(receipts))
+	for userID := range receipts {
+		userIDs = append(userIDs, userID)
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool {
+		ri := receipts[userIDs[i]]
+		rj := receipts[userIDs[j]]
+		if ri.Timestamp != rj.Timestamp {
+			return ri.Timestamp < rj.Timestamp
+		}
+		return userIDs[i] < userIDs[j]
+	})
+
+	events := make(map[matrix.EventID][]matrix.UserID)
+	for _, userID := range userIDs {
+		eventID := receipts[userID].EventID
+		events[eventID] = append(events[eventID], userID)
+	}
+
+	return events
+}
@@ -0,0 +1,236 @@
+package gotktrix
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sort"
+
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// LoginTerms is the stage for accepting the homeserver's policies, such as its
+// terms of service.
+const LoginTerms matrix.LoginMethod = "m.login.terms"
+
+// Registration is an account registration that requires user-interactive
+// auth. Once it's done, Client logs into the new account.
+type Registration struct {
+	*InteractiveAuth
+	a *ClientAuth
+}
+
+// Register starts registering an account with the given username and password.
+// The homeserver's flows are queried by the first request.
+func (a *ClientAuth) Register(username, password string) (*Registration, error) {
+	var req struct {
+		Auth                     interface{} `json:"auth,omitempty"`
+		Username                 string      `json:"username,omitempty"`
+		Password                 string      `json:"password"`
+		InitialDeviceDisplayName string      `json:"initial_device_display_name"`
+	}
+
+	req.Username = username
+	req.Password = password
+	req.InitialDeviceDisplayName = deviceName
+
+	uia, err := newInteractiveAuth(func(auth, to interface{}) error {
+		req.Auth = auth
+		return a.c.Request(
+			"POST", a.c.Endpoints.Register(), to,
+			httputil.WithJSONBody(req),
+			httputil.WithQuery(map[string]string{"kind": "user"}),
+		)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to register")
+	}
+
+	return &Registration{
+		InteractiveAuth: uia,
+		a:               a,
+	}, nil
+}
+
+// Client logs into the registered account. It must only be called once the
+// registration is done.
+func (r *Registration) Client() (*Client, error) {
+	if !r.Done() {
+		return nil, errors.New("registration is not done")
+	}
+
+	var resp struct {
+		UserID      matrix.UserID   `json:"user_id"`
+		AccessToken string          `json:"access_token"`
+		DeviceID    matrix.DeviceID `json:"device_id"`
+	}
+
+	if err := json.Unmarshal(r.Result(), &resp); err != nil {
+		return nil, errors.Wrap(err, "invalid register response")
+	}
+
+	if resp.AccessToken == "" {
+		return nil, errors.New("homeserver did not log into the new account")
+	}
+
+	// Copy the client so that the ClientAuth stays logged out.
+	c := *r.a.c
+	apiClient := *c.Client
+	c.Client = &apiClient
+	c.UserID = resp.UserID
+	c.AccessToken = resp.AccessToken
+	c.DeviceID = resp.DeviceID
+
+	return wrapClient(&c, r.a.o, r.a.v)
+}
+
+// Policy is a policy that must be accepted in the m.login.terms stage.
+type Policy struct {
+	Name string
+	URL  string
+}
+
+// Policies returns the policies of the m.login.terms stage in the given
+// language, falling back to English or any other language.
+func (a *InteractiveAuth) Policies(lang string) []Policy {
+	var params struct {
+		Policies map[string]map[string]json.RawMessage `json:"policies"`
+	}
+
+	if err := json.Unmarshal(a.Params[LoginTerms], &params); err != nil {
+		return nil
+	}
+
+	type translation struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+
+	ids := make([]string, 0, len(params.Policies))
+	for id := range params.Policies {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	policies := make([]Policy, 0, len(ids))
+
+	for _, id := range ids {
+		var t translation
+
+		// Each policy also has a version field, which isn't a translation, so
+		// languages that fail to decode are skipped.
+		langs := params.Policies[id]
+		if !decodeTranslation(langs[lang], &t) && !decodeTranslation(langs["en"], &t) {
+			for _, raw := range langs {
+				if decodeTranslation(raw, &t) {
+					break
+				}
+			}
+		}
+
+		if t.URL == "" {
+			continue
+		}
+		if t.Name == "" {
+			t.Name = id
+		}
+
+		policies = append(policies, Policy{Name: t.Name, URL: t.URL})
+	}
+
+	return policies
+}
+
+func decodeTranslation(raw json.RawMessage, v interface{}) bool {
+	return raw != nil && json.Unmarshal(raw, v) == nil
+}
+
+// AuthTerms completes the m.login.terms stage, accepting all of the policies.
+func (a *InteractiveAuth) AuthTerms() error {
+	return a.Auth(uiaAuth{
+		Type:    LoginTerms,
+		Session: a.Session,
+	})
+}
+
+// FallbackURL returns the absolute address of the web page that completes the
+// given stage, such as a reCAPTCHA. Once the user is done with the page,
+// AuthFallback must be called.
+func (r *Registration) FallbackURL(stage matrix.LoginMethod) string {
+	c := r.a.c
+	u := url.URL{
+		Scheme:   c.HomeServerScheme,
+		Host:     c.HomeServer,
+		Path:     "/" + c.Endpoints.Base() + "/auth/" + string(stage) + "/fallback/web",
+		RawQuery: url.Values{"session": {r.Session}}.Encode(),
+	}
+	return u.String()
+}
+
+// AuthFallback completes the stage that was done on its fallback web page.
+func (a *InteractiveAuth) AuthFallback() error {
+	return a.Auth(uiaAuth{Session: a.Session})
+}
+
+// EmailValidation is a pending validation of an email address for the
+// m.login.email.identity stage.
+type EmailValidation struct {
+	Email        string
+	ClientSecret string
+	SessionID    string
+}
+
+// RequestEmailToken asks the homeserver to send a validation link to the email
+// address.
+func (r *Registration) RequestEmailToken(email string) (*EmailValidation, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "failed to generate client secret")
+	}
+
+	v := EmailValidation{
+		Email:        email,
+		ClientSecret: base64.RawURLEncoding.EncodeToString(secret),
+	}
+
+	req := struct {
+		ClientSecret string `json:"client_secret"`
+		Email        string `json:"email"`
+		SendAttempt  int    `json:"send_attempt"`
+	}{
+		ClientSecret: v.ClientSecret,
+		Email:        email,
+		SendAttempt:  1,
+	}
+
+	var resp struct {
+		SID string `json:"sid"`
+	}
+
+	err := r.a.c.Request(
+		"POST", r.a.c.Endpoints.RegisterRequestToken("email"), &resp,
+		httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request email validation")
+	}
+
+	v.SessionID = resp.SID
+	return &v, nil
+}
+
+// AuthEmail completes the m.login.email.identity stage once the user has
+// opened the validation link.
+func (a *InteractiveAuth) AuthEmail(v *EmailValidation) error {
+	return a.Auth(uiaAuth{
+		Type:    matrix.LoginEmail,
+		Session: a.Session,
+		ThreePIDCreds: &threePIDCreds{
+			SID:          v.SessionID,
+			ClientSecret: v.ClientSecret,
+		},
+	})
+}
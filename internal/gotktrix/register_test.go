@@ -0,0 +1,41 @@
+package gotktrix
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func TestRegistrationFallbackURL(t *testing.T) {
+	var opts Opts
+	opts.init()
+
+	a, err := newAuth("https://matrix.example.com", &Versions{}, opts)
+	if err != nil {
+		t.Fatal("cannot create auth:", err)
+	}
+
+	r := Registration{
+		InteractiveAuth: &InteractiveAuth{Session: "abc"},
+		a:               a,
+	}
+
+	u, err := url.Parse(r.FallbackURL(matrix.LoginMethod("m.login.recaptcha")))
+	if err != nil {
+		t.Fatal("invalid fallback URL:", err)
+	}
+
+	if !u.IsAbs() || u.Host != "matrix.example.com" {
+		t.Fatalf("fallback URL %q isn't absolute on the homeserver", u)
+	}
+
+	expect := "/_matrix/client/" + a.c.Endpoints.Version + "/auth/m.login.recaptcha/fallback/web"
+	if u.Path != expect {
+		t.Errorf("expected path %q, got %q", expect, u.Path)
+	}
+
+	if session := u.Query().Get("session"); session != "abc" {
+		t.Errorf("expected session %q, got %q", "abc", session)
+	}
+}
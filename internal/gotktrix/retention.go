@@ -0,0 +1,45 @@
+package gotktrix
+
+import (
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// RetentionPolicy describes how many timeline events of a room are kept in the
+// state. An event is kept if it satisfies either KeepLast or KeepDays.
+type RetentionPolicy = state.RetentionPolicy
+
+// RoomRetentionPolicy returns the retention policy that applies to the given
+// room, which may come from the room itself, its tags or the default policy.
+func (c *Client) RoomRetentionPolicy(roomID matrix.RoomID) RetentionPolicy {
+	return c.State.RoomRetentionPolicy(roomID)
+}
+
+// SetRoomRetentionPolicy sets the retention policy of the given room. A zero
+// policy removes it.
+func (c *Client) SetRoomRetentionPolicy(roomID matrix.RoomID, policy RetentionPolicy) error {
+	if err := c.State.SetRoomRetentionPolicy(roomID, policy); err != nil {
+		return errors.Wrap(err, "failed to set room retention policy")
+	}
+	return nil
+}
+
+// SetTagRetentionPolicy sets the retention policy of all rooms with the given
+// tag. A zero policy removes it. Favourite rooms are kept in full unless their
+// policy is set.
+func (c *Client) SetTagRetentionPolicy(tag matrix.TagName, policy RetentionPolicy) error {
+	if err := c.State.SetTagRetentionPolicy(tag, policy); err != nil {
+		return errors.Wrap(err, "failed to set tag retention policy")
+	}
+	return nil
+}
+
+// SetDefaultRetentionPolicy sets the retention policy of rooms that have no
+// policy set for either the room or its tags. A zero policy resets it.
+func (c *Client) SetDefaultRetentionPolicy(policy RetentionPolicy) error {
+	if err := c.State.SetDefaultRetentionPolicy(policy); err != nil {
+		return errors.Wrap(err, "failed to set default retention policy")
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package gotktrix
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// InteractiveAuth is the state of a request that requires user-interactive
+// authentication. The request is repeated with each stage's auth data until
+// the server accepts it.
+type InteractiveAuth struct {
+	// Flows lists the stages of every way that the server accepts.
+	Flows []struct {
+		Stages []matrix.LoginMethod `json:"stages"`
+	} `json:"flows"`
+	// Params contains the parameters of each stage, such as the public key of
+	// a reCAPTCHA.
+	Params    map[matrix.LoginMethod]json.RawMessage `json:"params"`
+	Session   string                                 `json:"session"`
+	Completed []matrix.LoginMethod                   `json:"completed"`
+
+	request func(auth, to interface{}) error
+	result  json.RawMessage
+	done    bool
+}
+
+// newInteractiveAuth makes the request without any auth data. The request
+// function must write the response body into to even if the request fails.
+func newInteractiveAuth(request func(auth, to interface{}) error) (*InteractiveAuth, error) {
+	a := &InteractiveAuth{request: request}
+	if err := a.Auth(nil); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// uiaResponse is the body of a response that requires more authentication.
+type uiaResponse struct {
+	InteractiveAuth
+	ErrCode matrix.ErrorCode `json:"errcode"`
+	Error   string           `json:"error"`
+}
+
+// Auth repeats the request with the given auth data. An error is returned if
+// the server rejects the auth data, such as for an incorrect password, but
+// the state is still updated so that the stage can be retried.
+func (a *InteractiveAuth) Auth(auth interface{}) error {
+	var raw json.RawMessage
+
+	err := a.request(auth, &raw)
+	if err == nil {
+		a.done = true
+		a.result = raw
+		return nil
+	}
+
+	if matrix.StatusCode(err) != http.StatusUnauthorized {
+		return err
+	}
+
+	var resp uiaResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return errors.Wrap(err, "invalid interactive auth response")
+	}
+
+	a.Flows = resp.Flows
+	a.Params = resp.Params
+	a.Session = resp.Session
+	a.Completed = resp.Completed
+
+	if resp.ErrCode != "" {
+		return matrix.APIError{Code: resp.ErrCode, Message: resp.Error}
+	}
+
+	return nil
+}
+
+// Done returns true if the server has accepted the request.
+func (a *InteractiveAuth) Done() bool {
+	return a.done
+}
+
+// Result returns the response body of the accepted request.
+func (a *InteractiveAuth) Result() json.RawMessage {
+	return a.result
+}
+
+// IsCompleted returns true if the given stage is completed.
+func (a *InteractiveAuth) IsCompleted(stage matrix.LoginMethod) bool {
+	for _, completed := range a.Completed {
+		if completed == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStages returns the stages left in the first flow that only has the given
+// supported stages. Nil is returned if there's no such flow.
+func (a *InteractiveAuth) NextStages(supported ...matrix.LoginMethod) []matrix.LoginMethod {
+	isSupported := func(stage matrix.LoginMethod) bool {
+		for _, s := range supported {
+			if s == stage {
+				return true
+			}
+		}
+		return false
+	}
+
+flowLoop:
+	for _, flow := range a.Flows {
+		var next []matrix.LoginMethod
+
+		for _, stage := range flow.Stages {
+			if !isSupported(stage) {
+				continue flowLoop
+			}
+			if !a.IsCompleted(stage) {
+				next = append(next, stage)
+			}
+		}
+
+		return next
+	}
+
+	return nil
+}
+
+type uiaAuth struct {
+	Type    matrix.LoginMethod `json:"type,omitempty"`
+	Session string             `json:"session,omitempty"`
+
+	Identifier    *matrix.Identifier `json:"identifier,omitempty"`
+	Password      string             `json:"password,omitempty"`
+	ThreePIDCreds *threePIDCreds     `json:"threepid_creds,omitempty"`
+}
+
+type threePIDCreds struct {
+	SID          string `json:"sid"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// AuthPassword completes the m.login.password stage.
+func (a *InteractiveAuth) AuthPassword(userID matrix.UserID, password string) error {
+	return a.Auth(uiaAuth{
+		Type:    matrix.LoginPassword,
+		Session: a.Session,
+		Identifier: &matrix.Identifier{
+			Type: matrix.IdentifierUser,
+			User: string(userID),
+		},
+		Password: password,
+	})
+}
+
+// AuthDummy completes the m.login.dummy stage.
+func (a *InteractiveAuth) AuthDummy() error {
+	return a.Auth(uiaAuth{
+		Type:    matrix.LoginDummy,
+		Session: a.Session,
+	})
+}
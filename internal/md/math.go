@@ -0,0 +1,255 @@
+package md
+
+import (
+	"bytes"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	markutil "github.com/yuin/goldmark/util"
+)
+
+// Math is an inline node for TeX wrapped in "$" or "$$".
+type Math struct {
+	ast.BaseInline
+	// Source is the segment of the TeX source without the dollar signs.
+	Source text.Segment
+}
+
+// KindMath is the node kind of Math.
+var KindMath = ast.NewNodeKind("Math")
+
+// Kind implements ast.Node.
+func (n *Math) Kind() ast.NodeKind { return KindMath }
+
+// Dump implements ast.Node.
+func (n *Math) Dump(src []byte, level int) {
+	ast.DumpHelper(n, src, level, nil, nil)
+}
+
+// MathBlock is a block node for TeX wrapped in "$$" that starts on its own
+// line. Its lines contain the TeX source.
+type MathBlock struct {
+	ast.BaseBlock
+	closed bool
+}
+
+// KindMathBlock is the node kind of MathBlock.
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// Kind implements ast.Node.
+func (n *MathBlock) Kind() ast.NodeKind { return KindMathBlock }
+
+// IsRaw implements ast.Node.
+func (n *MathBlock) IsRaw() bool { return true }
+
+// Dump implements ast.Node.
+func (n *MathBlock) Dump(src []byte, level int) {
+	ast.DumpHelper(n, src, level, nil, nil)
+}
+
+var mathDelim = []byte("$$")
+
+type mathParser struct{}
+
+// NewMathParser creates a new inline parser that parses "$math$" and
+// "$$math$$".
+func NewMathParser() parser.InlineParser {
+	return mathParser{}
+}
+
+func (p mathParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+
+	delim := 1
+	if bytes.HasPrefix(line, mathDelim) {
+		delim = 2
+	}
+
+	body := line[delim:]
+
+	var end int
+	if delim == 2 {
+		end = bytes.Index(body, mathDelim)
+	} else {
+		end = inlineMathEnd(body)
+	}
+
+	if end < 1 {
+		return nil
+	}
+
+	// Require the math to hug the dollar signs, so that prices like "$5 and
+	// $10" aren't parsed as math.
+	if isSpace(body[0]) || isSpace(body[end-1]) {
+		return nil
+	}
+
+	node := &Math{
+		Source: text.NewSegment(segment.Start+delim, segment.Start+delim+end),
+	}
+
+	block.Advance(delim + end + delim)
+	return node
+}
+
+// inlineMathEnd returns the index of the closing "$" or -1 if there's none.
+func inlineMathEnd(body []byte) int {
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case '$':
+			// The closing sign must not be followed by a digit.
+			if i+1 < len(body) && unicode.IsDigit(rune(body[i+1])) {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(b byte) bool {
+	return unicode.IsSpace(rune(b))
+}
+
+type mathBlockParser struct{}
+
+// NewMathBlockParser creates a new block parser that parses "$$" blocks.
+func NewMathBlockParser() parser.BlockParser {
+	return mathBlockParser{}
+}
+
+func (p mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], mathDelim) {
+		return nil, parser.NoChildren
+	}
+
+	node := &MathBlock{}
+
+	start := pos + len(mathDelim)
+	rest := bytes.TrimRightFunc(line[start:], unicode.IsSpace)
+
+	// Handle the block being on a single line, like "$$math$$".
+	if end := bytes.Index(rest, mathDelim); end > -1 {
+		if !markutil.IsBlank(rest[end+len(mathDelim):]) {
+			// Trailing text; treat it as inline math instead.
+			return nil, parser.NoChildren
+		}
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Start+start+end))
+		node.closed = true
+		return node, parser.NoChildren
+	}
+
+	// Only open the block if it's closed later, so that a stray "$$" doesn't
+	// swallow the rest of the message.
+	if !bytes.Contains(reader.Source()[segment.Stop:], mathDelim) {
+		return nil, parser.NoChildren
+	}
+
+	if !markutil.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Start+start+len(rest)))
+	}
+
+	return node, parser.NoChildren
+}
+
+func (p mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	block := node.(*MathBlock)
+	if block.closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	trimmed := bytes.TrimRightFunc(line, unicode.IsSpace)
+
+	if end := bytes.Index(trimmed, mathDelim); end > -1 {
+		if !markutil.IsBlank(trimmed[:end]) {
+			node.Lines().Append(text.NewSegment(segment.Start, segment.Start+end))
+		}
+		reader.Advance(len(trimmed))
+		block.closed = true
+		return parser.Close
+	}
+
+	node.Lines().Append(segment)
+	reader.Advance(len(trimmed))
+	return parser.Continue | parser.NoChildren
+}
+
+func (p mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p mathBlockParser) CanInterruptParagraph() bool { return true }
+
+func (p mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+type mathRenderer struct{}
+
+// NewMathRenderer creates a new node renderer that renders Math and MathBlock
+// nodes into elements with the data-mx-maths attribute. The TeX source is kept
+// inside as a fallback for clients that don't render it.
+func NewMathRenderer() renderer.NodeRenderer {
+	return mathRenderer{}
+}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (r mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMath, r.renderInline)
+	reg.Register(KindMathBlock, r.renderBlock)
+}
+
+func (r mathRenderer) renderInline(
+	w markutil.BufWriter, src []byte, n ast.Node, enter bool) (ast.WalkStatus, error) {
+
+	if enter {
+		math := n.(*Math)
+		writeMath(w, "span", math.Source.Value(src))
+	}
+
+	return ast.WalkSkipChildren, nil
+}
+
+func (r mathRenderer) renderBlock(
+	w markutil.BufWriter, src []byte, n ast.Node, enter bool) (ast.WalkStatus, error) {
+
+	if enter {
+		var tex bytes.Buffer
+
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			if i > 0 {
+				tex.WriteByte('\n')
+			}
+			line := lines.At(i)
+			tex.Write(bytes.TrimRight(line.Value(src), "\n"))
+		}
+
+		writeMath(w, "div", bytes.TrimSpace(tex.Bytes()))
+		w.WriteByte('\n')
+	}
+
+	return ast.WalkSkipChildren, nil
+}
+
+func writeMath(w markutil.BufWriter, tag string, tex []byte) {
+	escaped := markutil.EscapeHTML(tex)
+
+	w.WriteString("<" + tag + ` data-mx-maths="`)
+	w.Write(escaped)
+	w.WriteString(`"><code>`)
+	w.Write(escaped)
+	w.WriteString("</code></" + tag + ">")
+}
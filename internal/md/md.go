@@ -12,6 +12,7 @@ import (
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
@@ -27,6 +28,9 @@ var Parser = parser.NewParser(
 		markutil.Prioritized(parser.NewEmphasisParser(), 2),
 		markutil.Prioritized(parser.NewCodeSpanParser(), 3),
 		markutil.Prioritized(parser.NewRawHTMLParser(), 4),
+		markutil.Prioritized(extension.NewStrikethroughParser(), 5),
+		markutil.Prioritized(NewSpoilerParser(), 6),
+		markutil.Prioritized(NewMathParser(), 7),
 	),
 	parser.WithBlockParsers(
 		markutil.Prioritized(parser.NewParagraphParser(), 0),
@@ -34,6 +38,17 @@ var Parser = parser.NewParser(
 		markutil.Prioritized(parser.NewATXHeadingParser(), 2),
 		markutil.Prioritized(parser.NewFencedCodeBlockParser(), 3),
 		markutil.Prioritized(parser.NewThematicBreakParser(), 4), // <hr>
+		// The list parsers must come after the thematic break parser, since
+		// "- - -" is a thematic break.
+		markutil.Prioritized(parser.NewListParser(), 5),
+		markutil.Prioritized(parser.NewListItemParser(), 6),
+		markutil.Prioritized(NewMathBlockParser(), 7),
+	),
+	parser.WithParagraphTransformers(
+		markutil.Prioritized(extension.NewTableParagraphTransformer(), 0),
+	),
+	parser.WithASTTransformers(
+		markutil.Prioritized(extension.NewTableASTTransformer(), 0),
 	),
 )
 
@@ -49,6 +64,13 @@ var Converter = goldmark.New(
 		renderer.NewRenderer(
 			renderer.WithNodeRenderers(
 				markutil.Prioritized(Renderer, 1000),
+				markutil.Prioritized(extension.NewTableHTMLRenderer(
+					// Matrix doesn't allow the style attribute.
+					extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute),
+				), 500),
+				markutil.Prioritized(extension.NewStrikethroughHTMLRenderer(), 500),
+				markutil.Prioritized(NewSpoilerRenderer(), 500),
+				markutil.Prioritized(NewMathRenderer(), 500),
 			),
 		),
 	),
@@ -78,6 +100,7 @@ var TextTags = textutil.TextTagsMap{
 	"strong": {"weight": pango.WeightBold},
 	"b":      {"weight": pango.WeightBold},
 	"u":      {"underline": pango.UnderlineSingle},
+	"s":      {"strikethrough": true},
 	"strike": {"strikethrough": true},
 	"del":    {"strikethrough": true},
 	"sup":    {"rise": +6000, "scale": 0.7},
@@ -110,6 +133,7 @@ var TextTags = textutil.TextTagsMap{
 	"_emoji":     {"scale": EmojiScale},
 	"_image":     {"rise": -2 * pango.SCALE},
 	"_nohyphens": {"insert-hyphens": false},
+	"_spoiler":   {"background": "rgba(128, 128, 128, 0.35)"},
 }
 
 func htag(scale float64) textutil.TextTag {
@@ -0,0 +1,106 @@
+package md
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConverter(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "spoiler",
+			in:   "||secret||",
+			out:  "<p><span data-mx-spoiler>secret</span></p>\n",
+		},
+		{
+			name: "strikethrough",
+			in:   "~~gone~~",
+			out:  "<p><del>gone</del></p>\n",
+		},
+		{
+			name: "table",
+			in:   "| a | b |\n| - | :-: |\n| 1 | 2 |",
+			out: "<table>\n" +
+				"<thead>\n<tr>\n<th>a</th>\n<th align=\"center\">b</th>\n</tr>\n</thead>\n" +
+				"<tbody>\n<tr>\n<td>1</td>\n<td align=\"center\">2</td>\n</tr>\n</tbody>\n" +
+				"</table>\n",
+		},
+		{
+			name: "unordered list",
+			in:   "- one\n- two",
+			out:  "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n",
+		},
+		{
+			name: "ordered list",
+			in:   "1. one\n2. two",
+			out:  "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n",
+		},
+		{
+			name: "thematic break",
+			in:   "- - -",
+			out:  "<hr>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Converter.Convert([]byte(test.in), &out); err != nil {
+				t.Fatal("cannot convert:", err)
+			}
+			if out.String() != test.out {
+				t.Errorf("expected %q, got %q", test.out, out.String())
+			}
+		})
+	}
+}
+
+func TestConverterMath(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "inline",
+			in:   "text $x$ text",
+			out:  "<p>text <span data-mx-maths=\"x\"><code>x</code></span> text</p>\n",
+		},
+		{
+			name: "block",
+			in:   "$$\nx^2\n$$\nafter",
+			out:  "<div data-mx-maths=\"x^2\"><code>x^2</code></div>\n<p>after</p>\n",
+		},
+		{
+			name: "single line block",
+			in:   "$$x$$",
+			out:  "<div data-mx-maths=\"x\"><code>x</code></div>\n",
+		},
+		{
+			name: "block after paragraph",
+			in:   "para\n$$\nx\n$$",
+			out:  "<p>para</p>\n<div data-mx-maths=\"x\"><code>x</code></div>\n",
+		},
+		{
+			name: "unclosed block",
+			in:   "$$$ saved\nnext line",
+			out:  "<p>$$$ saved<br>\nnext line</p>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Converter.Convert([]byte(test.in), &out); err != nil {
+				t.Fatal("cannot convert:", err)
+			}
+			if out.String() != test.out {
+				t.Errorf("expected %q, got %q", test.out, out.String())
+			}
+		})
+	}
+}
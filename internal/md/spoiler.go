@@ -0,0 +1,94 @@
+package md
+
+import (
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	markutil "github.com/yuin/goldmark/util"
+)
+
+// Spoiler is an inline node for text wrapped in "||", which is rendered as a
+// Matrix spoiler.
+type Spoiler struct {
+	ast.BaseInline
+}
+
+// KindSpoiler is the node kind of Spoiler.
+var KindSpoiler = ast.NewNodeKind("Spoiler")
+
+// Kind implements ast.Node.
+func (n *Spoiler) Kind() ast.NodeKind { return KindSpoiler }
+
+// Dump implements ast.Node.
+func (n *Spoiler) Dump(src []byte, level int) {
+	ast.DumpHelper(n, src, level, nil, nil)
+}
+
+type spoilerDelimiterProcessor struct{}
+
+func (p spoilerDelimiterProcessor) IsDelimiter(b byte) bool {
+	return b == '|'
+}
+
+func (p spoilerDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+func (p spoilerDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	return &Spoiler{}
+}
+
+type spoilerParser struct{}
+
+// NewSpoilerParser creates a new inline parser that parses "||spoiler||".
+func NewSpoilerParser() parser.InlineParser {
+	return spoilerParser{}
+}
+
+func (s spoilerParser) Trigger() []byte {
+	return []byte{'|'}
+}
+
+func (s spoilerParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+
+	node := parser.ScanDelimiter(line, before, 2, spoilerDelimiterProcessor{})
+	if node == nil {
+		return nil
+	}
+
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+
+	return node
+}
+
+func (s spoilerParser) CloseBlock(parent ast.Node, pc parser.Context) {}
+
+type spoilerRenderer struct{}
+
+// NewSpoilerRenderer creates a new node renderer that renders Spoiler nodes
+// into spans with the data-mx-spoiler attribute.
+func NewSpoilerRenderer() renderer.NodeRenderer {
+	return spoilerRenderer{}
+}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (r spoilerRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindSpoiler, r.render)
+}
+
+func (r spoilerRenderer) render(
+	w markutil.BufWriter, src []byte, n ast.Node, enter bool) (ast.WalkStatus, error) {
+
+	if enter {
+		w.WriteString("<span data-mx-spoiler>")
+	} else {
+		w.WriteString("</span>")
+	}
+
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,680 @@
+// Package tex renders a subset of TeX math into Pango markup. It covers what
+// is commonly written in chat messages, such as Greek letters, operators,
+// scripts, fractions and roots. Anything else is reported as an error, so the
+// caller can fall back to showing the source.
+package tex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+type class uint8
+
+const (
+	ord class = iota
+	bin       // binary operators, spaced unless unary
+	rel       // relations, always spaced
+)
+
+type symbol struct {
+	str   string
+	class class
+}
+
+var symbols = map[string]symbol{
+	// Lowercase Greek.
+	"alpha": {"α", ord}, "beta": {"β", ord}, "gamma": {"γ", ord},
+	"delta": {"δ", ord}, "epsilon": {"ϵ", ord}, "varepsilon": {"ε", ord},
+	"zeta": {"ζ", ord}, "eta": {"η", ord}, "theta": {"θ", ord},
+	"vartheta": {"ϑ", ord}, "iota": {"ι", ord}, "kappa": {"κ", ord},
+	"lambda": {"λ", ord}, "mu": {"μ", ord}, "nu": {"ν", ord},
+	"xi": {"ξ", ord}, "pi": {"π", ord}, "varpi": {"ϖ", ord},
+	"rho": {"ρ", ord}, "varrho": {"ϱ", ord}, "sigma": {"σ", ord},
+	"varsigma": {"ς", ord}, "tau": {"τ", ord}, "upsilon": {"υ", ord},
+	"phi": {"ϕ", ord}, "varphi": {"φ", ord}, "chi": {"χ", ord},
+	"psi": {"ψ", ord}, "omega": {"ω", ord},
+	// Uppercase Greek.
+	"Gamma": {"Γ", ord}, "Delta": {"Δ", ord}, "Theta": {"Θ", ord},
+	"Lambda": {"Λ", ord}, "Xi": {"Ξ", ord}, "Pi": {"Π", ord},
+	"Sigma": {"Σ", ord}, "Upsilon": {"Υ", ord}, "Phi": {"Φ", ord},
+	"Psi": {"Ψ", ord}, "Omega": {"Ω", ord},
+	// Other letters.
+	"hbar": {"ℏ", ord}, "ell": {"ℓ", ord}, "Re": {"ℜ", ord},
+	"Im": {"ℑ", ord}, "aleph": {"ℵ", ord}, "wp": {"℘", ord},
+	"partial": {"∂", ord}, "nabla": {"∇", ord}, "infty": {"∞", ord},
+	"emptyset": {"∅", ord}, "varnothing": {"∅", ord}, "forall": {"∀", ord},
+	"exists": {"∃", ord}, "nexists": {"∄", ord}, "neg": {"¬", ord},
+	"lnot": {"¬", ord}, "angle": {"∠", ord}, "prime": {"′", ord},
+	"top": {"⊤", ord}, "bot": {"⊥", ord}, "triangle": {"△", ord},
+	// Large operators.
+	"sum": {"∑", ord}, "prod": {"∏", ord}, "coprod": {"∐", ord},
+	"int": {"∫", ord}, "iint": {"∬", ord}, "iiint": {"∭", ord},
+	"oint": {"∮", ord}, "bigcup": {"⋃", ord}, "bigcap": {"⋂", ord},
+	"bigoplus": {"⨁", ord}, "bigotimes": {"⨂", ord},
+	// Binary operators.
+	"pm": {"±", bin}, "mp": {"∓", bin}, "times": {"×", bin},
+	"div": {"÷", bin}, "cdot": {"⋅", bin}, "ast": {"∗", bin},
+	"star": {"⋆", bin}, "circ": {"∘", bin}, "bullet": {"∙", bin},
+	"oplus": {"⊕", bin}, "ominus": {"⊖", bin}, "otimes": {"⊗", bin},
+	"cup": {"∪", bin}, "cap": {"∩", bin}, "setminus": {"∖", bin},
+	"wedge": {"∧", bin}, "land": {"∧", bin}, "vee": {"∨", bin},
+	"lor": {"∨", bin}, "bmod": {"mod", bin},
+	// Relations.
+	"leq": {"≤", rel}, "le": {"≤", rel}, "geq": {"≥", rel},
+	"ge": {"≥", rel}, "neq": {"≠", rel}, "ne": {"≠", rel},
+	"approx": {"≈", rel}, "equiv": {"≡", rel}, "sim": {"∼", rel},
+	"simeq": {"≃", rel}, "cong": {"≅", rel}, "propto": {"∝", rel},
+	"ll": {"≪", rel}, "gg": {"≫", rel}, "in": {"∈", rel},
+	"notin": {"∉", rel}, "ni": {"∋", rel}, "subset": {"⊂", rel},
+	"subseteq": {"⊆", rel}, "supset": {"⊃", rel}, "supseteq": {"⊇", rel},
+	"mid": {"∣", rel}, "parallel": {"∥", rel}, "perp": {"⊥", rel},
+	"vdash": {"⊢", rel}, "models": {"⊨", rel}, "to": {"→", rel},
+	"rightarrow": {"→", rel}, "leftarrow": {"←", rel}, "gets": {"←", rel},
+	"leftrightarrow": {"↔", rel}, "Rightarrow": {"⇒", rel},
+	"Leftarrow": {"⇐", rel}, "Leftrightarrow": {"⇔", rel},
+	"implies": {"⟹", rel}, "impliedby": {"⟸", rel}, "iff": {"⟺", rel},
+	"mapsto": {"↦", rel}, "uparrow": {"↑", rel}, "downarrow": {"↓", rel},
+	"colon": {":", rel},
+	// Delimiters and punctuation.
+	"langle": {"⟨", ord}, "rangle": {"⟩", ord}, "lfloor": {"⌊", ord},
+	"rfloor": {"⌋", ord}, "lceil": {"⌈", ord}, "rceil": {"⌉", ord},
+	"vert": {"|", ord}, "Vert": {"‖", ord}, "lvert": {"|", ord},
+	"rvert": {"|", ord}, "lVert": {"‖", ord}, "rVert": {"‖", ord},
+	"ldots": {"…", ord}, "dots": {"…", ord}, "cdots": {"⋯", ord},
+	"vdots": {"⋮", ord}, "ddots": {"⋱", ord},
+	"{": {"{", ord}, "}": {"}", ord}, "|": {"‖", ord},
+	"%": {"%", ord}, "$": {"$", ord}, "&": {"&amp;", ord},
+	"#": {"#", ord}, "_": {"_", ord}, "\\": {"\n", ord},
+	// Spacing.
+	",": {"\u2009", ord}, ":": {"\u205f", ord}, ";": {"\u2004", ord},
+	" ": {" ", ord}, "!": {"", ord}, "quad": {"\u2003", ord},
+	"qquad": {"\u2003\u2003", ord},
+}
+
+// functions are named operators that are drawn upright.
+var functions = map[string]struct{}{
+	"sin": {}, "cos": {}, "tan": {}, "cot": {}, "sec": {}, "csc": {},
+	"arcsin": {}, "arccos": {}, "arctan": {}, "sinh": {}, "cosh": {},
+	"tanh": {}, "log": {}, "ln": {}, "lg": {}, "exp": {}, "lim": {},
+	"liminf": {}, "limsup": {}, "max": {}, "min": {}, "sup": {}, "inf": {},
+	"det": {}, "dim": {}, "ker": {}, "deg": {}, "gcd": {}, "arg": {},
+	"hom": {}, "Pr": {},
+}
+
+// accents maps accent commands to their combining characters.
+var accents = map[string]string{
+	"hat":   "\u0302",
+	"bar":   "\u0304",
+	"tilde": "\u0303",
+	"dot":   "\u0307",
+	"ddot":  "\u0308",
+	"vec":   "\u20d7",
+	"check": "\u030c",
+	"acute": "\u0301",
+	"grave": "\u0300",
+	"not":   "\u0338",
+}
+
+// ignored are commands that only change sizing or spacing, which is done by
+// Pango anyway.
+var ignored = map[string]struct{}{
+	"displaystyle": {}, "textstyle": {}, "scriptstyle": {},
+	"limits": {}, "nolimits": {}, "big": {}, "Big": {}, "bigg": {},
+	"Bigg": {}, "bigl": {}, "bigr": {}, "Bigl": {}, "Bigr": {},
+	"left": {}, "right": {},
+}
+
+const (
+	supOpen  = `<span rise="5000" size="smaller">`
+	subOpen  = `<span rise="-2000" size="smaller">`
+	spanEnd  = `</span>`
+	italOpen = `<i>`
+	italEnd  = `</i>`
+)
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+const (
+	// MaxLength is the maximum length of the source in bytes. Longer sources
+	// are rejected.
+	MaxLength = 4096
+	// MaxDepth is the maximum nesting depth of groups and arguments. Each
+	// level copies the output of the levels below it, so the cost grows with
+	// the depth.
+	MaxDepth = 16
+)
+
+// Markup renders the given TeX math source into Pango markup. Sources that are
+// longer than MaxLength or nested deeper than MaxDepth are rejected.
+func Markup(src string) (string, error) {
+	if len(src) > MaxLength {
+		return "", errors.New("source too long")
+	}
+
+	p := parser{src: src}
+	return p.list(false)
+}
+
+type parser struct {
+	src string
+	pos int
+	// script is the nesting level of super- and subscripts. Operators aren't
+	// spaced inside them.
+	script int
+	// upright is non-zero if letters should not be italicized.
+	upright int
+	// depth is the nesting level of groups and arguments.
+	depth int
+}
+
+// list accumulates the output of a sequence of atoms.
+type list struct {
+	strings.Builder
+	// afterOp is true if the last atom is an operator or if there is none,
+	// in which case a binary operator is unary.
+	afterOp bool
+}
+
+func (p *parser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *parser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+
+func (p *parser) next() rune {
+	r, sz := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += sz
+	return r
+}
+
+// enter increases the nesting depth. It fails if MaxDepth is exceeded. Call
+// leave once done.
+func (p *parser) enter() error {
+	if p.depth >= MaxDepth {
+		return errors.New("nested too deeply")
+	}
+	p.depth++
+	return nil
+}
+
+func (p *parser) leave() { p.depth-- }
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.next()
+	}
+}
+
+func (p *parser) ord(l *list, s string) {
+	l.WriteString(s)
+	l.afterOp = false
+}
+
+func (p *parser) op(l *list, s string, c class) {
+	if p.script > 0 || (c == bin && l.afterOp) {
+		l.WriteString(s)
+	} else {
+		l.WriteString(" " + s + " ")
+	}
+	l.afterOp = true
+}
+
+// list parses atoms until the end of the source or, if group is true, until
+// the closing brace.
+func (p *parser) list(group bool) (string, error) {
+	if group {
+		if err := p.enter(); err != nil {
+			return "", err
+		}
+		defer p.leave()
+	}
+
+	l := list{afterOp: true}
+
+	for {
+		p.skipSpaces()
+
+		if p.eof() {
+			if group {
+				return "", errors.New("missing closing brace")
+			}
+			return l.String(), nil
+		}
+
+		if p.peek() == '}' {
+			if !group {
+				return "", errors.New("unexpected closing brace")
+			}
+			p.pos++
+			return l.String(), nil
+		}
+
+		if err := p.atom(&l); err != nil {
+			return "", err
+		}
+	}
+}
+
+// arg parses a single argument, which is either a group or a single atom.
+func (p *parser) arg() (string, error) {
+	p.skipSpaces()
+
+	if p.eof() || p.peek() == '}' {
+		return "", errors.New("missing argument")
+	}
+
+	if p.peek() == '{' {
+		p.pos++
+		return p.list(true)
+	}
+
+	// A single atom can still nest, such as in x^^^y or \hat\hat x.
+	if err := p.enter(); err != nil {
+		return "", err
+	}
+	defer p.leave()
+
+	l := list{afterOp: true}
+	if err := p.atom(&l); err != nil {
+		return "", err
+	}
+
+	return l.String(), nil
+}
+
+// rawArg parses a single argument as plain text.
+func (p *parser) rawArg() (string, error) {
+	p.skipSpaces()
+
+	if p.eof() {
+		return "", errors.New("missing argument")
+	}
+
+	if p.peek() != '{' {
+		return string(p.next()), nil
+	}
+
+	start := p.pos + 1
+	depth := 0
+
+	for !p.eof() {
+		switch p.next() {
+		case '\\':
+			if !p.eof() {
+				p.next()
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return p.src[start : p.pos-1], nil
+			}
+		}
+	}
+
+	return "", errors.New("missing closing brace")
+}
+
+// optArg parses an optional argument in square brackets. An empty string is
+// returned if there's none.
+func (p *parser) optArg() (string, error) {
+	p.skipSpaces()
+
+	if p.eof() || p.peek() != '[' {
+		return "", nil
+	}
+
+	end := strings.IndexByte(p.src[p.pos:], ']')
+	if end == -1 {
+		return "", errors.New("missing closing bracket")
+	}
+
+	sub := parser{
+		src:    p.src[p.pos+1 : p.pos+end],
+		script: p.script + 1,
+		depth:  p.depth + 1,
+	}
+	p.pos += end + 1
+
+	return sub.list(false)
+}
+
+func (p *parser) atom(l *list) error {
+	switch r := p.next(); r {
+	case '{':
+		s, err := p.list(true)
+		if err != nil {
+			return err
+		}
+		p.ord(l, s)
+
+	case '^', '_':
+		p.script++
+		s, err := p.arg()
+		p.script--
+		if err != nil {
+			return err
+		}
+
+		if r == '^' {
+			l.WriteString(supOpen + s + spanEnd)
+		} else {
+			l.WriteString(subOpen + s + spanEnd)
+		}
+		l.afterOp = false
+
+	case '\\':
+		return p.command(l)
+
+	case '+':
+		p.op(l, "+", bin)
+	case '-':
+		p.op(l, "−", bin)
+	case '*':
+		p.op(l, "∗", bin)
+	case '=':
+		p.op(l, "=", rel)
+	case '<':
+		p.op(l, "&lt;", rel)
+	case '>':
+		p.op(l, "&gt;", rel)
+	case '\'':
+		p.ord(l, "′")
+	case '~':
+		p.ord(l, "\u00a0")
+
+	case '&', '#', '$', '%':
+		return errors.Errorf("unsupported character %q", r)
+
+	default:
+		s := escaper.Replace(string(r))
+		if p.upright == 0 && r < unicode.MaxASCII && unicode.IsLetter(r) {
+			s = italOpen + s + italEnd
+		}
+		p.ord(l, s)
+	}
+
+	return nil
+}
+
+func (p *parser) command(l *list) error {
+	name := p.commandName()
+	if name == "" {
+		return errors.New("trailing backslash")
+	}
+
+	if sym, ok := symbols[name]; ok {
+		if sym.class == ord {
+			p.ord(l, sym.str)
+		} else {
+			p.op(l, sym.str, sym.class)
+		}
+		return nil
+	}
+
+	if _, ok := functions[name]; ok {
+		// Pad the function name from its argument with a thin space.
+		p.ord(l, name+"\u2009")
+		return nil
+	}
+
+	if _, ok := ignored[name]; ok {
+		// Ignore the empty delimiter in \left. and \right.
+		if (name == "left" || name == "right") && !p.eof() && p.peek() == '.' {
+			p.pos++
+		}
+		return nil
+	}
+
+	if mark, ok := accents[name]; ok {
+		s, err := p.arg()
+		if err != nil {
+			return err
+		}
+		// Keep the mark inside the italics so it combines with the letter.
+		if strings.HasSuffix(s, italEnd) {
+			s = strings.TrimSuffix(s, italEnd) + mark + italEnd
+		} else {
+			s += mark
+		}
+		p.ord(l, s)
+		return nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num, err := p.arg()
+		if err != nil {
+			return err
+		}
+		den, err := p.arg()
+		if err != nil {
+			return err
+		}
+		p.ord(l, paren(num)+"/"+paren(den))
+
+	case "sqrt":
+		index, err := p.optArg()
+		if err != nil {
+			return err
+		}
+		s, err := p.arg()
+		if err != nil {
+			return err
+		}
+		if index != "" {
+			index = supOpen + index + spanEnd
+		}
+		p.ord(l, index+"√"+paren(s))
+
+	case "mathbf", "boldsymbol", "bm":
+		s, err := p.arg()
+		if err != nil {
+			return err
+		}
+		p.ord(l, "<b>"+s+"</b>")
+
+	case "mathit":
+		s, err := p.arg()
+		if err != nil {
+			return err
+		}
+		p.ord(l, "<i>"+s+"</i>")
+
+	case "mathrm", "mathsf", "mathtt", "operatorname":
+		p.upright++
+		s, err := p.arg()
+		p.upright--
+		if err != nil {
+			return err
+		}
+		if name == "mathtt" {
+			s = "<tt>" + s + "</tt>"
+		}
+		p.ord(l, s)
+
+	case "text", "textrm", "textnormal", "mbox", "textbf", "textit", "texttt", "emph":
+		s, err := p.rawArg()
+		if err != nil {
+			return err
+		}
+		s = escaper.Replace(s)
+		switch name {
+		case "textbf":
+			s = "<b>" + s + "</b>"
+		case "textit", "emph":
+			s = "<i>" + s + "</i>"
+		case "texttt":
+			s = "<tt>" + s + "</tt>"
+		}
+		p.ord(l, s)
+
+	case "mathbb", "mathcal", "mathscr", "mathfrak":
+		s, err := p.rawArg()
+		if err != nil {
+			return err
+		}
+		p.ord(l, escaper.Replace(mapLetters(name, s)))
+
+	case "overline":
+		s, err := p.arg()
+		if err != nil {
+			return err
+		}
+		p.ord(l, `<span overline="single">`+s+spanEnd)
+
+	case "underline":
+		s, err := p.arg()
+		if err != nil {
+			return err
+		}
+		p.ord(l, "<u>"+s+"</u>")
+
+	case "pmod":
+		s, err := p.arg()
+		if err != nil {
+			return err
+		}
+		p.ord(l, " (mod "+s+")")
+
+	default:
+		return errors.Errorf("unsupported command \\%s", name)
+	}
+
+	return nil
+}
+
+// commandName parses the name of the command after the backslash. Commands
+// are either a sequence of letters or a single other character.
+func (p *parser) commandName() string {
+	if p.eof() {
+		return ""
+	}
+
+	start := p.pos
+	if !isASCIILetter(p.peek()) {
+		p.next()
+		return p.src[start:p.pos]
+	}
+
+	for !p.eof() && isASCIILetter(p.peek()) {
+		p.pos++
+	}
+
+	return p.src[start:p.pos]
+}
+
+func isASCIILetter(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// paren wraps the markup in parentheses unless it's a single number or
+// letter.
+func paren(markup string) string {
+	plain := stripTags(markup)
+	if plain == "" {
+		return markup
+	}
+
+	for _, r := range plain {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r) {
+			return "(" + markup + ")"
+		}
+	}
+
+	// Multiple letters are a product, so they need parentheses as well.
+	if !isNumber(plain) && utf8.RuneCountInString(strings.Map(dropMarks, plain)) > 1 {
+		return "(" + markup + ")"
+	}
+
+	return markup
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func dropMarks(r rune) rune {
+	if unicode.Is(unicode.Mn, r) {
+		return -1
+	}
+	return r
+}
+
+// stripTags removes all markup tags from the given string.
+func stripTags(markup string) string {
+	var b strings.Builder
+	inTag := false
+
+	for _, r := range markup {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// letterRanges contains the first letter of each math alphabet in Unicode's
+// Mathematical Alphanumeric Symbols block. Letters missing from that block are
+// in letterHoles.
+var letterRanges = map[string][2]rune{
+	// {uppercase, lowercase}
+	"mathbb":   {0x1D538, 0x1D552},
+	"mathcal":  {0x1D49C, 0x1D4B6},
+	"mathscr":  {0x1D49C, 0x1D4B6},
+	"mathfrak": {0x1D504, 0x1D51E},
+}
+
+var letterHoles = map[string]map[rune]rune{
+	"mathbb": {
+		'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ',
+	},
+	"mathcal": {
+		'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ',
+		'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ',
+	},
+	"mathscr": {
+		'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ',
+		'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ',
+	},
+	"mathfrak": {
+		'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ',
+	},
+}
+
+// mapLetters maps the ASCII letters in s to the given math alphabet.
+func mapLetters(alphabet, s string) string {
+	ranges := letterRanges[alphabet]
+	holes := letterHoles[alphabet]
+
+	return strings.Map(func(r rune) rune {
+		if hole, ok := holes[r]; ok {
+			return hole
+		}
+		switch {
+		case 'A' <= r && r <= 'Z':
+			return ranges[0] + (r - 'A')
+		case 'a' <= r && r <= 'z':
+			return ranges[1] + (r - 'a')
+		default:
+			return r
+		}
+	}, s)
+}
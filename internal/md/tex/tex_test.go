@@ -0,0 +1,109 @@
+package tex
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkup(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+		fail   bool
+	}{
+		{
+			name:   "operators",
+			src:    `a+b=-c`,
+			expect: `<i>a</i> + <i>b</i> = −<i>c</i>`,
+		},
+		{
+			name:   "scripts",
+			src:    `x_i^{2n}`,
+			expect: `<i>x</i>` + subOpen + `<i>i</i>` + spanEnd + supOpen + `2<i>n</i>` + spanEnd,
+		},
+		{
+			name:   "no spacing in scripts",
+			src:    `e^{-x}`,
+			expect: `<i>e</i>` + supOpen + `−<i>x</i>` + spanEnd,
+		},
+		{
+			name:   "greek and relations",
+			src:    `\alpha \leq \pi`,
+			expect: `α ≤ π`,
+		},
+		{
+			name:   "fraction",
+			src:    `\frac{1}{2x}`,
+			expect: `1/(2<i>x</i>)`,
+		},
+		{
+			name:   "root with index",
+			src:    `\sqrt[3]{8}`,
+			expect: supOpen + `3` + spanEnd + `√8`,
+		},
+		{
+			name:   "function",
+			src:    `\sin\theta`,
+			expect: "sin\u2009θ",
+		},
+		{
+			name:   "text",
+			src:    `\text{if } x<0`,
+			expect: `if <i>x</i> &lt; 0`,
+		},
+		{
+			name:   "blackboard",
+			src:    `x \in \mathbb{R}`,
+			expect: `<i>x</i> ∈ ℝ`,
+		},
+		{
+			name:   "accent",
+			src:    `\hat{x}`,
+			expect: "<i>x̂</i>",
+		},
+		{
+			name:   "left right",
+			src:    `\left( x \right.`,
+			expect: `(<i>x</i>`,
+		},
+		{name: "unknown command", src: `\begin{pmatrix}`, fail: true},
+		{name: "alignment", src: `a & b`, fail: true},
+		{name: "unclosed group", src: `\frac{1}{2`, fail: true},
+		{name: "unopened group", src: `x}`, fail: true},
+		{name: "missing argument", src: `x^`, fail: true},
+		{
+			name:   "nested within limit",
+			src:    strings.Repeat("{", MaxDepth) + "x" + strings.Repeat("}", MaxDepth),
+			expect: "<i>x</i>",
+		},
+		{
+			name: "nested groups",
+			src:  strings.Repeat("{", MaxDepth+1) + "x" + strings.Repeat("}", MaxDepth+1),
+			fail: true,
+		},
+		{name: "nested scripts", src: "x" + strings.Repeat("^", MaxDepth+1) + "y", fail: true},
+		{name: "nested fractions", src: strings.Repeat(`\frac1`, MaxDepth+1) + "2", fail: true},
+		{name: "too long", src: strings.Repeat("x", MaxLength+1), fail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			markup, err := Markup(test.src)
+			if test.fail {
+				if err == nil {
+					t.Fatalf("expected error, got markup %q", markup)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			if markup != test.expect {
+				t.Errorf("unexpected markup:\nexpected %q\ngot      %q", test.expect, markup)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/diamondburned/gotkit/gtkutil/textutil"
 	"github.com/diamondburned/gotktrix/internal/md/hl"
 	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
 )
 
 const wysiwygPrefix = "_wysiwyg_"
@@ -123,6 +124,33 @@ func (w *wysiwyg) enter(n ast.Node) ast.WalkStatus {
 		w.markText(n, "code")
 		return ast.WalkSkipChildren
 
+	case *extast.Strikethrough:
+		w.markText(n, "del")
+
+	case *Spoiler:
+		w.markText(n, "_spoiler")
+
+	case *Math:
+		w.markBounds(n.Source.Start, n.Source.Stop, "code")
+		return ast.WalkSkipChildren
+
+	case *MathBlock:
+		lines := n.Lines()
+		if lines.Len() > 0 {
+			w.markBounds(lines.At(0).Start, lines.At(lines.Len()-1).Stop, "code")
+		}
+		return ast.WalkSkipChildren
+
+	case *ast.ListItem:
+		w.markTextFunc(n, []string{"li"}, func(head, tail *gtk.TextIter) {
+			// Seek head to the start of the line to account for the list
+			// marker, since the margin only applies to whole paragraphs.
+			head.SetLineOffset(0)
+		})
+
+	case *extast.TableHeader:
+		w.markText(n, "b")
+
 	case *ast.RawHTML:
 		segments := n.Segments.Sliced(0, n.Segments.Len())
 		for _, seg := range segments {
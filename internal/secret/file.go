@@ -7,8 +7,10 @@ import (
 	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/base64"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -36,13 +38,19 @@ type EncryptedFile struct {
 
 	pass string
 	enc  bool
+
+	// files guards the files in path from being replaced by Rekey.
+	files sync.RWMutex
 }
 
+var _ Driver = (*EncryptedFile)(nil)
+
 // SaltedFileDriver creates a new encrypted file driver with a generated
 // passphrase. The .salt file is solely used as the hashing input, so the
 // algorithm will trip without it. One way to completely lock out accounts
 // encrypted with it is to move the file somewhere else.
 func SaltedFileDriver(path string) *EncryptedFile {
+	recoverRekeyLog(path)
 	return &EncryptedFile{path: path}
 }
 
@@ -50,6 +58,7 @@ func SaltedFileDriver(path string) *EncryptedFile {
 // passphrase. The passphrase is hashed and compared with an existing one, or it
 // will be used if there is none.
 func EncryptedFileDriver(passphrase, path string) *EncryptedFile {
+	recoverRekeyLog(path)
 	return &EncryptedFile{path: path, pass: passphrase, enc: true}
 }
 
@@ -61,6 +70,8 @@ func EncryptedFileDriver(passphrase, path string) *EncryptedFile {
 // determined. In this case, when EncryptedFileDriver is used, storing will be
 // errored out.
 func PathIsEncrypted(path string) bool {
+	recoverRekeyLog(path)
+
 	hashPath := filepath.Join(path, hashFile)
 
 	f, err := os.Stat(hashPath)
@@ -71,6 +82,48 @@ func PathIsEncrypted(path string) bool {
 	return true
 }
 
+// rekeyPaths returns the paths of the directories that Rekey writes the new
+// entries into and moves the old ones into.
+func rekeyPaths(path string) (tmpPath, oldPath string) {
+	return path + ".rekey", path + ".old"
+}
+
+// recoverRekey cleans up after a Rekey that was interrupted, such as by a
+// crash. If the old entries were already moved away but the new ones weren't
+// moved in yet, then the old entries are put back, since Rekey never finished.
+func recoverRekey(path string) error {
+	tmpPath, oldPath := rekeyPaths(path)
+
+	if _, err := os.Stat(oldPath); err == nil {
+		_, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Rename(oldPath, path); err != nil {
+				return errors.Wrap(err, "failed to restore old directory")
+			}
+		case err != nil:
+			return errors.Wrap(err, "failed to stat directory")
+		default:
+			// The new entries are in place, so the old ones are stale.
+			if err := os.RemoveAll(oldPath); err != nil {
+				return errors.Wrap(err, "failed to remove old directory")
+			}
+		}
+	}
+
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return errors.Wrap(err, "failed to remove stale directory")
+	}
+
+	return nil
+}
+
+func recoverRekeyLog(path string) {
+	if err := recoverRekey(path); err != nil {
+		log.Println("cannot recover from interrupted rekey:", err)
+	}
+}
+
 // mksalt makes the salt once or reads from a file if not.
 func (s *EncryptedFile) getAEAD() (cipher.AEAD, error) {
 	s.mu.RLock()
@@ -95,7 +148,18 @@ func (s *EncryptedFile) getAEAD() (cipher.AEAD, error) {
 		return nil, errors.Wrap(err, "failed to make/get salt")
 	}
 
-	c, err := aes.NewCipher(pass)
+	gcm, err := newAEAD(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	s.pass = "" // no longer needed
+	s.aead = gcm
+	return gcm, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create AES cipher")
 	}
@@ -105,8 +169,6 @@ func (s *EncryptedFile) getAEAD() (cipher.AEAD, error) {
 		return nil, errors.Wrap(err, "failed to create GCM cipherer")
 	}
 
-	s.pass = "" // no longer needed
-	s.aead = gcm
 	return gcm, nil
 }
 
@@ -187,22 +249,20 @@ func (s *EncryptedFile) getPass() ([]byte, error) {
 }
 
 func (s *EncryptedFile) Set(key string, value []byte) error {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
 	aead, err := s.getAEAD()
 	if err != nil {
 		return errors.Wrap(err, "failed to get cipher")
 	}
 
-	nonce := make([]byte, aead.NonceSize())
-
-	if _, err := rand.Read(nonce); err != nil {
-		return errors.Wrap(err, "failed to read nonce")
+	data, err := seal(aead, value)
+	if err != nil {
+		return err
 	}
 
-	// Append the encrypted data into the nonce for this key.
-	data := aead.Seal(nonce, nonce, value, nil)
-	file := base64.RawStdEncoding.EncodeToString([]byte(key))
-
-	if err := os.WriteFile(filepath.Join(s.path, file), data, 0600); err != nil {
+	if err := os.WriteFile(filepath.Join(s.path, keyFile(key)), data, 0600); err != nil {
 		return errors.Wrap(err, "failed to write value to file")
 	}
 
@@ -210,9 +270,10 @@ func (s *EncryptedFile) Set(key string, value []byte) error {
 }
 
 func (s *EncryptedFile) Get(key string) ([]byte, error) {
-	file := base64.RawStdEncoding.EncodeToString([]byte(key))
+	s.files.RLock()
+	defer s.files.RUnlock()
 
-	b, err := os.ReadFile(filepath.Join(s.path, file))
+	b, err := os.ReadFile(filepath.Join(s.path, keyFile(key)))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrNotFound
@@ -225,14 +286,235 @@ func (s *EncryptedFile) Get(key string) ([]byte, error) {
 		return nil, errors.Wrap(err, "failed to get cipher")
 	}
 
-	if len(b) < aead.NonceSize() {
-		return nil, errors.Wrap(err, "invalid file content")
+	return open(aead, b)
+}
+
+func keyFile(key string) string {
+	return base64.RawStdEncoding.EncodeToString([]byte(key))
+}
+
+// seal encrypts the given value with a random nonce prepended.
+func seal(aead cipher.AEAD, value []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to read nonce")
 	}
 
-	value, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	// Append the encrypted data into the nonce for this key.
+	return aead.Seal(nonce, nonce, value, nil), nil
+}
+
+// open decrypts the given data from seal.
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid file content")
+	}
+
+	value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "decryption error")
 	}
 
 	return value, nil
 }
+
+// Delete deletes the key. ErrNotFound is returned if there's no such key.
+func (s *EncryptedFile) Delete(key string) error {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
+	if err := os.Remove(filepath.Join(s.path, keyFile(key))); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "failed to delete key")
+	}
+
+	return nil
+}
+
+// Keys returns all keys stored in the path in sorted order. The passphrase is
+// not needed to list the keys.
+func (s *EncryptedFile) Keys() ([]string, error) {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
+	return s.keys()
+}
+
+func (s *EncryptedFile) keys() ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read directory")
+	}
+
+	keys := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == saltFile || name == hashFile {
+			continue
+		}
+
+		key, err := base64.RawStdEncoding.DecodeString(name)
+		if err != nil {
+			// Not ours.
+			continue
+		}
+
+		keys = append(keys, string(key))
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Rekey re-encrypts every entry with a new passphrase and a newly generated
+// salt. oldPass must match the current passphrase, unless the driver was
+// created using SaltedFileDriver, in which case it's ignored. If newPass is
+// empty, then the entries are only salted.
+//
+// The entries are written into a new directory which then replaces the old
+// one, so a failure never leaves a mix of old and new entries behind. If the
+// process dies halfway, then the old entries are restored the next time the
+// path is used.
+func (s *EncryptedFile) Rekey(oldPass, newPass string) error {
+	s.files.Lock()
+	defer s.files.Unlock()
+
+	s.mu.RLock()
+	enc := s.enc
+	s.mu.RUnlock()
+
+	salt, err := os.ReadFile(filepath.Join(s.path, saltFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to read old salt")
+		}
+
+		// Nothing was ever stored, so there's nothing to re-encrypt.
+		s.mu.Lock()
+		s.aead = nil
+		s.pass = newPass
+		s.enc = newPass != ""
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	hash, err := os.ReadFile(filepath.Join(s.path, hashFile))
+	if err != nil {
+		return errors.Wrap(err, "failed to read old hash")
+	}
+
+	password := salt
+	if enc {
+		password = []byte(oldPass)
+	}
+
+	oldKey := hashAESKey(password, salt)
+	if subtle.ConstantTimeCompare(oldKey, hash) != 1 {
+		return ErrIncorrectPassword
+	}
+
+	oldAEAD, err := newAEAD(oldKey)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.keys()
+	if err != nil {
+		return err
+	}
+
+	values := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		b, err := os.ReadFile(filepath.Join(s.path, keyFile(key)))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %q", key)
+		}
+
+		values[i], err = open(oldAEAD, b)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt %q", key)
+		}
+	}
+
+	newSalt := make([]byte, saltSize)
+	if _, err := rand.Read(newSalt); err != nil {
+		return errors.Wrap(err, "failed to generate salt")
+	}
+
+	password = newSalt
+	if newPass != "" {
+		password = []byte(newPass)
+	}
+
+	newKey := hashAESKey(password, newSalt)
+
+	aead, err := newAEAD(newKey)
+	if err != nil {
+		return err
+	}
+
+	tmpPath, oldPath := rekeyPaths(s.path)
+
+	// Clean up after a failed Rekey, if any.
+	if err := recoverRekey(s.path); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(tmpPath, 0700); err != nil {
+		return errors.Wrap(err, "failed to mkdir")
+	}
+
+	files := map[string][]byte{
+		saltFile: newSalt,
+		hashFile: newKey,
+	}
+
+	for i, key := range keys {
+		data, err := seal(aead, values[i])
+		if err != nil {
+			os.RemoveAll(tmpPath)
+			return err
+		}
+		files[keyFile(key)] = data
+	}
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmpPath, name), data, 0600); err != nil {
+			os.RemoveAll(tmpPath)
+			return errors.Wrap(err, "failed to write new file")
+		}
+	}
+
+	if err := os.Rename(s.path, oldPath); err != nil {
+		os.RemoveAll(tmpPath)
+		return errors.Wrap(err, "failed to move old directory")
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		// Put the old entries back.
+		os.Rename(oldPath, s.path)
+		os.RemoveAll(tmpPath)
+		return errors.Wrap(err, "failed to move new directory")
+	}
+
+	if err := os.RemoveAll(oldPath); err != nil {
+		log.Println("cannot remove old secrets:", err)
+	}
+
+	s.mu.Lock()
+	s.aead = aead
+	s.pass = ""
+	s.enc = newPass != ""
+	s.mu.Unlock()
+
+	return nil
+}
@@ -1,7 +1,11 @@
 package secret
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/pkg/errors"
 )
 
 func BenchmarkHashAESKey(b *testing.B) {
@@ -74,3 +78,129 @@ func doEncryptedFileTest(t *testing.T, encFunc func() *EncryptedFile) {
 		}
 	})
 }
+
+func TestEncryptedFileRekey(t *testing.T) {
+	path := t.TempDir()
+
+	driver := EncryptedFileDriver("old", path)
+	if err := driver.Set("key", []byte("value")); err != nil {
+		t.Fatal("cannot set:", err)
+	}
+
+	if err := driver.Rekey("wrong", "new"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Fatal("expected ErrIncorrectPassword, got", err)
+	}
+
+	if err := driver.Rekey("old", "new"); err != nil {
+		t.Fatal("cannot rekey:", err)
+	}
+
+	expect := func(d *EncryptedFile) {
+		t.Helper()
+		v, err := d.Get("key")
+		if err != nil {
+			t.Fatal("cannot get:", err)
+		}
+		if string(v) != "value" {
+			t.Fatalf("expected value, got %q", v)
+		}
+	}
+
+	expect(driver)
+	expect(EncryptedFileDriver("new", path))
+
+	if _, err := EncryptedFileDriver("old", path).Get("key"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Fatal("expected old password to fail, got", err)
+	}
+
+	if err := driver.Delete("key"); err != nil {
+		t.Fatal("cannot delete:", err)
+	}
+
+	keys, err := driver.Keys()
+	if err != nil {
+		t.Fatal("cannot list keys:", err)
+	}
+	if len(keys) > 0 {
+		t.Fatalf("unexpected keys %q", keys)
+	}
+}
+
+func TestEncryptedFileRekeyInterrupted(t *testing.T) {
+	tests := []struct {
+		name string
+		// interrupt leaves the path in the state of a Rekey from "old" to
+		// "new" that died halfway.
+		interrupt func(t *testing.T, path string)
+		pass      string
+	}{
+		{
+			name: "writing new entries",
+			interrupt: func(t *testing.T, path string) {
+				tmpPath, _ := rekeyPaths(path)
+				mustMkdir(t, tmpPath)
+			},
+			pass: "old",
+		},
+		{
+			name: "moved old entries",
+			interrupt: func(t *testing.T, path string) {
+				tmpPath, oldPath := rekeyPaths(path)
+				mustMkdir(t, tmpPath)
+				if err := os.Rename(path, oldPath); err != nil {
+					t.Fatal("cannot move:", err)
+				}
+			},
+			pass: "old",
+		},
+		{
+			name: "moved new entries",
+			interrupt: func(t *testing.T, path string) {
+				if err := EncryptedFileDriver("old", path).Rekey("old", "new"); err != nil {
+					t.Fatal("cannot rekey:", err)
+				}
+				_, oldPath := rekeyPaths(path)
+				mustMkdir(t, oldPath)
+			},
+			pass: "new",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "secrets")
+
+			if err := EncryptedFileDriver("old", path).Set("key", []byte("value")); err != nil {
+				t.Fatal("cannot set:", err)
+			}
+
+			test.interrupt(t, path)
+
+			if !PathIsEncrypted(path) {
+				t.Fatal("path is not encrypted after recovery")
+			}
+
+			v, err := EncryptedFileDriver(test.pass, path).Get("key")
+			if err != nil {
+				t.Fatal("cannot get:", err)
+			}
+			if string(v) != "value" {
+				t.Fatalf("expected value, got %q", v)
+			}
+
+			tmpPath, oldPath := rekeyPaths(path)
+			for _, p := range []string{tmpPath, oldPath} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("%s was not cleaned up: %v", p, err)
+				}
+			}
+		})
+	}
+}
+
+func mustMkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatal("cannot mkdir:", err)
+	}
+}
@@ -1,29 +1,64 @@
 package secret
 
 import (
+	"encoding/json"
 	"errors"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/zalando/go-keyring"
 )
 
+// keyringIndex is the key that the list of keys is stored in, since keyrings
+// can't be enumerated.
+const keyringIndex = ".keys"
+
 // Keyring is an implementation of a secret driver using the system's keyring
-// driver.
+// driver. Keys set before the driver kept an index aren't listed by Keys.
 type Keyring struct {
 	id string
+	mu sync.Mutex // index
 }
 
 var ErrUnsupportedPlatform = keyring.ErrUnsupportedPlatform
 
 var _ Driver = (*Keyring)(nil)
 
+// SystemDriver creates the driver for the system's keyring. The Secret Service
+// driver is used on systems that have it, and the keyring driver is used
+// everywhere else.
+func SystemDriver(appID string) Driver {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return KeyringDriver(appID)
+	default:
+		return SecretServiceDriver(appID)
+	}
+}
+
 // KeyringDriver creates a new keyring driver.
 func KeyringDriver(appID string) *Keyring {
-	return &Keyring{appID}
+	return &Keyring{id: appID}
 }
 
 // Set sets the key.
 func (k *Keyring) Set(key string, value []byte) error {
-	return keyring.Set(k.id, key, string(value))
+	if err := keyring.Set(k.id, key, string(value)); err != nil {
+		return err
+	}
+
+	return k.updateIndex(func(keys []string) []string {
+		i := sort.SearchStrings(keys, key)
+		if i < len(keys) && keys[i] == key {
+			return keys
+		}
+
+		keys = append(keys, "")
+		copy(keys[i+1:], keys[i:])
+		keys[i] = key
+		return keys
+	})
 }
 
 // Get gets the key.
@@ -37,3 +72,63 @@ func (k *Keyring) Get(key string) ([]byte, error) {
 	}
 	return []byte(v), nil
 }
+
+// Delete deletes the key.
+func (k *Keyring) Delete(key string) error {
+	if err := keyring.Delete(k.id, key); err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return k.updateIndex(func(keys []string) []string {
+		i := sort.SearchStrings(keys, key)
+		if i < len(keys) && keys[i] == key {
+			keys = append(keys[:i], keys[i+1:]...)
+		}
+		return keys
+	})
+}
+
+// Keys returns all keys in the index.
+func (k *Keyring) Keys() ([]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.index()
+}
+
+func (k *Keyring) index() ([]string, error) {
+	v, err := keyring.Get(k.id, keyringIndex)
+	if err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	if err := json.Unmarshal([]byte(v), &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (k *Keyring) updateIndex(f func([]string) []string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys, err := k.index()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(f(keys))
+	if err != nil {
+		return err
+	}
+
+	return keyring.Set(k.id, keyringIndex, string(b))
+}
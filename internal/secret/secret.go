@@ -9,10 +9,13 @@ import "github.com/pkg/errors"
 // ErrNotFound is returned for unknown keys.
 var ErrNotFound = errors.New("key not found")
 
-// Driver is a basic getter-setter interface that describes a secret driver.
+// Driver is a basic interface that describes a secret driver. Delete returns
+// ErrNotFound for unknown keys, and Keys returns all keys in sorted order.
 type Driver interface {
 	Get(string) ([]byte, error)
 	Set(string, []byte) error
+	Delete(string) error
+	Keys() ([]string, error)
 }
 
 // Service wraps multiple drivers to provide fallbacks.
@@ -68,3 +71,84 @@ func (s Service) Set(k string, v []byte) error {
 
 	return firstErr
 }
+
+// Keys returns the keys of the first driver that can list them. The first
+// error is returned.
+func (s Service) Keys() ([]string, error) {
+	var firstErr error
+
+	for _, driver := range s.drivers {
+		keys, err := driver.Keys()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		return keys, nil
+	}
+
+	return nil, firstErr
+}
+
+// Delete deletes the given key from all drivers. ErrNotFound is returned if
+// none of them had the key.
+func (s Service) Delete(k string) error {
+	var firstErr error
+	var deleted bool
+
+	for _, driver := range s.drivers {
+		if err := driver.Delete(k); err != nil {
+			// Ignore not found errors, since other ones are more informative.
+			if firstErr == nil && !errors.Is(err, ErrNotFound) {
+				firstErr = err
+			}
+			continue
+		}
+
+		deleted = true
+	}
+
+	if deleted {
+		return nil
+	}
+
+	if firstErr == nil {
+		return ErrNotFound
+	}
+
+	return firstErr
+}
+
+// Migrate moves all secrets from one driver to another. Everything is copied
+// before anything is deleted, so no secret is lost if copying fails halfway.
+// Existing keys in the new driver are overridden.
+func Migrate(from, to Driver) error {
+	keys, err := from.Keys()
+	if err != nil {
+		return errors.Wrap(err, "failed to list keys")
+	}
+
+	for _, k := range keys {
+		v, err := from.Get(k)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return errors.Wrapf(err, "failed to get %q", k)
+		}
+
+		if err := to.Set(k, v); err != nil {
+			return errors.Wrapf(err, "failed to set %q", k)
+		}
+	}
+
+	for _, k := range keys {
+		if err := from.Delete(k); err != nil && !errors.Is(err, ErrNotFound) {
+			return errors.Wrapf(err, "failed to delete %q", k)
+		}
+	}
+
+	return nil
+}
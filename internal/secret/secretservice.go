@@ -0,0 +1,458 @@
+package secret
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+)
+
+// Reference: https://specifications.freedesktop.org/secret-service/latest/
+
+const (
+	ssDest = "org.freedesktop.secrets"
+	ssPath = dbus.ObjectPath("/org/freedesktop/secrets")
+
+	ssService    = "org.freedesktop.Secret.Service"
+	ssCollection = "org.freedesktop.Secret.Collection"
+	ssItem       = "org.freedesktop.Secret.Item"
+	ssPrompt     = "org.freedesktop.Secret.Prompt"
+
+	// noPrompt is the path returned in place of a prompt if none is needed.
+	noPrompt = dbus.ObjectPath("/")
+)
+
+// ErrPromptDismissed is returned if the user dismisses a prompt to unlock the
+// keyring.
+var ErrPromptDismissed = errors.New("secret service prompt dismissed")
+
+// busConn describes the parts of a D-Bus connection used by the SecretService
+// driver. It's satisfied by *dbus.Conn.
+type busConn interface {
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+	AddMatchSignal(options ...dbus.MatchOption) error
+	RemoveMatchSignal(options ...dbus.MatchOption) error
+	Signal(ch chan<- *dbus.Signal)
+	RemoveSignal(ch chan<- *dbus.Signal)
+}
+
+var _ busConn = (*dbus.Conn)(nil)
+
+// ssSecret is the Secret struct in the specification.
+type ssSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// SecretService is an implementation of a secret driver that talks to the
+// freedesktop Secret Service over the D-Bus session bus. Items are stored with
+// the same attributes and label as the keyring driver on Linux, so secrets
+// stored by either driver can be read by the other.
+type SecretService struct {
+	id    string
+	alias string
+	attrs map[string]string
+
+	mu         sync.Mutex
+	conn       busConn
+	session    dbus.ObjectPath
+	collection dbus.ObjectPath
+}
+
+var _ Driver = (*SecretService)(nil)
+
+// SecretServiceDriver creates a new Secret Service driver that stores secrets
+// in the default collection. The session bus is only connected to once the
+// driver is used; if that fails, or if there's no Secret Service running, an
+// error wrapping ErrUnsupportedPlatform is returned.
+func SecretServiceDriver(appID string) *SecretService {
+	return newSecretService(appID, nil)
+}
+
+func newSecretService(appID string, conn busConn) *SecretService {
+	return &SecretService{
+		id:    appID,
+		alias: "default",
+		conn:  conn,
+	}
+}
+
+// WithCollection returns a copy of the driver that stores secrets in the
+// collection with the given alias instead. The collection is created if it
+// doesn't exist yet.
+func (s *SecretService) WithCollection(alias string) *SecretService {
+	return &SecretService{
+		id:    s.id,
+		alias: alias,
+		attrs: s.attrs,
+		conn:  s.conn,
+	}
+}
+
+// WithAttributes returns a copy of the driver that adds the given attributes
+// to its items. Only items with these attributes are visible to the copy.
+func (s *SecretService) WithAttributes(attrs map[string]string) *SecretService {
+	merged := make(map[string]string, len(s.attrs)+len(attrs))
+	for k, v := range s.attrs {
+		merged[k] = v
+	}
+	for k, v := range attrs {
+		merged[k] = v
+	}
+
+	return &SecretService{
+		id:    s.id,
+		alias: s.alias,
+		attrs: merged,
+		conn:  s.conn,
+	}
+}
+
+// attributes returns the lookup attributes for the given key. If key is empty,
+// then the attributes match all of the driver's items.
+func (s *SecretService) attributes(key string) map[string]string {
+	attrs := make(map[string]string, len(s.attrs)+2)
+	for k, v := range s.attrs {
+		attrs[k] = v
+	}
+
+	attrs["service"] = s.id
+	if key != "" {
+		attrs["username"] = key
+	}
+
+	return attrs
+}
+
+// connect connects to the session bus and opens a session with the service if
+// it hasn't been done yet.
+func (s *SecretService) connect() (busConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := dbus.SessionBus()
+		if err != nil {
+			return nil, unsupported(err)
+		}
+		s.conn = conn
+	}
+
+	if s.session == "" {
+		var output dbus.Variant
+		var session dbus.ObjectPath
+
+		err := s.conn.Object(ssDest, ssPath).
+			Call(ssService+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+			Store(&output, &session)
+		if err != nil {
+			// Most likely, there's no Secret Service on this system.
+			return nil, unsupported(err)
+		}
+
+		s.session = session
+	}
+
+	if s.collection == "" {
+		collection, err := s.openCollection(s.conn)
+		if err != nil {
+			return nil, err
+		}
+		s.collection = collection
+	}
+
+	return s.conn, nil
+}
+
+func unsupported(err error) error {
+	return errors.Wrap(ErrUnsupportedPlatform, err.Error())
+}
+
+// openCollection resolves the driver's collection alias, creating the
+// collection if there's none.
+func (s *SecretService) openCollection(conn busConn) (dbus.ObjectPath, error) {
+	service := conn.Object(ssDest, ssPath)
+
+	var collection dbus.ObjectPath
+	if err := service.Call(ssService+".ReadAlias", 0, s.alias).Store(&collection); err != nil {
+		return "", errors.Wrapf(err, "failed to read collection alias %q", s.alias)
+	}
+
+	if collection != noPrompt {
+		return collection, nil
+	}
+
+	props := map[string]dbus.Variant{
+		ssCollection + ".Label": dbus.MakeVariant(s.alias),
+	}
+
+	var prompt dbus.ObjectPath
+	err := service.Call(ssService+".CreateCollection", 0, props, s.alias).Store(&collection, &prompt)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create collection %q", s.alias)
+	}
+
+	if prompt != noPrompt {
+		result, err := doPrompt(conn, prompt)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to create collection")
+		}
+		if err := dbus.Store([]interface{}{result}, &collection); err != nil {
+			return "", errors.Wrap(err, "invalid prompt result")
+		}
+	}
+
+	return collection, nil
+}
+
+// doPrompt shows the given prompt and waits for it to complete. The result of
+// the prompt is returned.
+func doPrompt(conn busConn, prompt dbus.ObjectPath) (dbus.Variant, error) {
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(ssPrompt),
+		dbus.WithMatchMember("Completed"),
+	}
+
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return dbus.Variant{}, errors.Wrap(err, "failed to subscribe to prompt")
+	}
+	defer conn.RemoveMatchSignal(match...)
+
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	if err := conn.Object(ssDest, prompt).Call(ssPrompt+".Prompt", 0, "").Err; err != nil {
+		return dbus.Variant{}, errors.Wrap(err, "failed to prompt")
+	}
+
+	for signal := range signals {
+		if signal.Path != prompt || signal.Name != ssPrompt+".Completed" {
+			continue
+		}
+
+		var dismissed bool
+		var result dbus.Variant
+
+		if err := dbus.Store(signal.Body, &dismissed, &result); err != nil {
+			return dbus.Variant{}, errors.Wrap(err, "invalid Completed signal")
+		}
+
+		if dismissed {
+			return dbus.Variant{}, ErrPromptDismissed
+		}
+
+		return result, nil
+	}
+
+	return dbus.Variant{}, errors.New("connection closed while prompting")
+}
+
+// unlock unlocks the given objects, prompting if needed. The unlocked objects
+// are returned.
+func unlock(conn busConn, objects []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+
+	err := conn.Object(ssDest, ssPath).
+		Call(ssService+".Unlock", 0, objects).
+		Store(&unlocked, &prompt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unlock")
+	}
+
+	if prompt != noPrompt {
+		result, err := doPrompt(conn, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if err := dbus.Store([]interface{}{result}, &unlocked); err != nil {
+			return nil, errors.Wrap(err, "invalid prompt result")
+		}
+	}
+
+	return unlocked, nil
+}
+
+// search searches for unlocked items in the driver's collection that have the
+// given attributes. Locked items are unlocked.
+func (s *SecretService) search(conn busConn, attrs map[string]string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+
+	err := conn.Object(ssDest, ssPath).
+		Call(ssService+".SearchItems", 0, attrs).
+		Store(&unlocked, &locked)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search items")
+	}
+
+	// Don't unlock items from other collections.
+	unlocked = s.filterCollection(unlocked)
+	locked = s.filterCollection(locked)
+
+	if len(locked) > 0 {
+		items, err := unlock(conn, locked)
+		if err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, items...)
+	}
+
+	return unlocked, nil
+}
+
+func (s *SecretService) filterCollection(items []dbus.ObjectPath) []dbus.ObjectPath {
+	filtered := items[:0]
+	for _, item := range items {
+		if strings.HasPrefix(string(item), string(s.collection)+"/") {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// Set sets the key, replacing the old value if there's one.
+func (s *SecretService) Set(key string, value []byte) error {
+	conn, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	if _, err := unlock(conn, []dbus.ObjectPath{s.collection}); err != nil {
+		return errors.Wrap(err, "failed to unlock collection")
+	}
+
+	props := map[string]dbus.Variant{
+		ssItem + ".Label":      dbus.MakeVariant(fmt.Sprintf("Password for '%s' on '%s'", key, s.id)),
+		ssItem + ".Attributes": dbus.MakeVariant(s.attributes(key)),
+	}
+
+	secret := ssSecret{
+		Session:     s.session,
+		Value:       value,
+		ContentType: "text/plain; charset=utf8",
+	}
+
+	var item, prompt dbus.ObjectPath
+
+	err = conn.Object(ssDest, s.collection).
+		Call(ssCollection+".CreateItem", 0, props, secret, true).
+		Store(&item, &prompt)
+	if err != nil {
+		return errors.Wrap(err, "failed to create item")
+	}
+
+	if prompt != noPrompt {
+		if _, err := doPrompt(conn, prompt); err != nil {
+			return errors.Wrap(err, "failed to create item")
+		}
+	}
+
+	return nil
+}
+
+// Get gets the key.
+func (s *SecretService) Get(key string) ([]byte, error) {
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.search(conn, s.attributes(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var secret ssSecret
+
+	err = conn.Object(ssDest, items[0]).
+		Call(ssItem+".GetSecret", 0, s.session).
+		Store(&secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get secret")
+	}
+
+	return secret.Value, nil
+}
+
+// Delete deletes the key. ErrNotFound is returned if there's no such key.
+func (s *SecretService) Delete(key string) error {
+	conn, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	items, err := s.search(conn, s.attributes(key))
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return ErrNotFound
+	}
+
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+
+		if err := conn.Object(ssDest, item).Call(ssItem+".Delete", 0).Store(&prompt); err != nil {
+			return errors.Wrap(err, "failed to delete item")
+		}
+
+		if prompt != noPrompt {
+			if _, err := doPrompt(conn, prompt); err != nil {
+				return errors.Wrap(err, "failed to delete item")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Keys returns all keys stored by the driver in sorted order.
+func (s *SecretService) Keys() ([]string, error) {
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.search(conn, s.attributes(""))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(items))
+	seen := make(map[string]bool, len(items))
+
+	for _, item := range items {
+		v, err := conn.Object(ssDest, item).GetProperty(ssItem + ".Attributes")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get item attributes")
+		}
+
+		attrs, ok := v.Value().(map[string]string)
+		if !ok {
+			return nil, errors.Errorf("unexpected item attributes type %T", v.Value())
+		}
+
+		key, ok := attrs["username"]
+		if !ok || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
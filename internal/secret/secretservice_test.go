@@ -0,0 +1,337 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+)
+
+// fakeBus is an in-memory stand-in for a session bus with a Secret Service.
+// Items in locked collections need a prompt to be unlocked.
+type fakeBus struct {
+	mu      sync.Mutex
+	items   map[dbus.ObjectPath]*fakeItem
+	locked  map[dbus.ObjectPath]bool // collections
+	aliases map[string]dbus.ObjectPath
+	signals []chan<- *dbus.Signal
+	serial  int
+	prompts int
+	// unlocking contains the objects to be unlocked by the pending prompt.
+	unlocking []dbus.ObjectPath
+}
+
+type fakeItem struct {
+	attrs  map[string]string
+	secret []byte
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{
+		items:   make(map[dbus.ObjectPath]*fakeItem),
+		locked:  make(map[dbus.ObjectPath]bool),
+		aliases: map[string]dbus.ObjectPath{"default": "/org/freedesktop/secrets/collection/login"},
+	}
+}
+
+func (b *fakeBus) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &fakeObject{bus: b, path: path}
+}
+
+func (b *fakeBus) AddMatchSignal(...dbus.MatchOption) error    { return nil }
+func (b *fakeBus) RemoveMatchSignal(...dbus.MatchOption) error { return nil }
+
+func (b *fakeBus) Signal(ch chan<- *dbus.Signal) {
+	b.mu.Lock()
+	b.signals = append(b.signals, ch)
+	b.mu.Unlock()
+}
+
+func (b *fakeBus) RemoveSignal(ch chan<- *dbus.Signal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, signal := range b.signals {
+		if signal == ch {
+			b.signals = append(b.signals[:i], b.signals[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *fakeBus) collectionOf(item dbus.ObjectPath) dbus.ObjectPath {
+	return item[:strings.LastIndexByte(string(item), '/')]
+}
+
+func (b *fakeBus) call(path dbus.ObjectPath, method string, args []interface{}) ([]interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch method {
+	case ssService + ".OpenSession":
+		return []interface{}{dbus.MakeVariant(""), dbus.ObjectPath("/org/freedesktop/secrets/session/1")}, nil
+
+	case ssService + ".ReadAlias":
+		collection, ok := b.aliases[args[0].(string)]
+		if !ok {
+			collection = noPrompt
+		}
+		return []interface{}{collection}, nil
+
+	case ssService + ".CreateCollection":
+		alias := args[1].(string)
+		collection := dbus.ObjectPath("/org/freedesktop/secrets/collection/" + alias)
+		b.aliases[alias] = collection
+		return []interface{}{collection, noPrompt}, nil
+
+	case ssService + ".SearchItems":
+		var unlocked, locked []dbus.ObjectPath
+		for path, item := range b.items {
+			if !hasAttrs(item.attrs, args[0].(map[string]string)) {
+				continue
+			}
+			if b.locked[b.collectionOf(path)] {
+				locked = append(locked, path)
+			} else {
+				unlocked = append(unlocked, path)
+			}
+		}
+		return []interface{}{unlocked, locked}, nil
+
+	case ssService + ".Unlock":
+		objects := args[0].([]dbus.ObjectPath)
+		for _, object := range objects {
+			if b.locked[object] || b.locked[b.collectionOf(object)] {
+				b.serial++
+				b.unlocking = objects
+				return []interface{}{[]dbus.ObjectPath{}, b.promptPath()}, nil
+			}
+		}
+		return []interface{}{objects, noPrompt}, nil
+
+	case ssPrompt + ".Prompt":
+		b.prompts++
+		for collection := range b.locked {
+			delete(b.locked, collection)
+		}
+		unlocked := b.unlocking
+		b.unlocking = nil
+		signal := &dbus.Signal{
+			Path: path,
+			Name: ssPrompt + ".Completed",
+			Body: []interface{}{false, dbus.MakeVariant(unlocked)},
+		}
+		for _, ch := range b.signals {
+			ch <- signal
+		}
+		return nil, nil
+
+	case ssCollection + ".CreateItem":
+		if b.locked[path] {
+			return nil, errors.New("collection is locked")
+		}
+		props := args[0].(map[string]dbus.Variant)
+		attrs := props[ssItem+".Attributes"].Value().(map[string]string)
+		secret := args[1].(ssSecret)
+
+		for itemPath, item := range b.items {
+			if b.collectionOf(itemPath) == path && reflect.DeepEqual(item.attrs, attrs) {
+				item.secret = secret.Value
+				return []interface{}{itemPath, noPrompt}, nil
+			}
+		}
+
+		b.serial++
+		itemPath := dbus.ObjectPath(fmt.Sprintf("%s/%d", path, b.serial))
+		b.items[itemPath] = &fakeItem{attrs: attrs, secret: secret.Value}
+		return []interface{}{itemPath, noPrompt}, nil
+
+	case ssItem + ".GetSecret":
+		item, ok := b.items[path]
+		if !ok || b.locked[b.collectionOf(path)] {
+			return nil, errors.New("no such unlocked item")
+		}
+		return []interface{}{ssSecret{Session: args[0].(dbus.ObjectPath), Value: item.secret}}, nil
+
+	case ssItem + ".Delete":
+		delete(b.items, path)
+		return []interface{}{noPrompt}, nil
+	}
+
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+func (b *fakeBus) promptPath() dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/prompt/%d", b.serial))
+}
+
+func hasAttrs(attrs, want map[string]string) bool {
+	for k, v := range want {
+		if attrs[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type fakeObject struct {
+	bus  *fakeBus
+	path dbus.ObjectPath
+}
+
+func (o *fakeObject) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	body, err := o.bus.call(o.path, method, args)
+	return &dbus.Call{Path: o.path, Method: method, Args: args, Body: body, Err: err}
+}
+
+func (o *fakeObject) CallWithContext(ctx context.Context, method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	return o.Call(method, flags, args...)
+}
+
+func (o *fakeObject) Go(method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	panic("not implemented")
+}
+
+func (o *fakeObject) GoWithContext(ctx context.Context, method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	panic("not implemented")
+}
+
+func (o *fakeObject) AddMatchSignal(iface, member string, options ...dbus.MatchOption) *dbus.Call {
+	panic("not implemented")
+}
+
+func (o *fakeObject) RemoveMatchSignal(iface, member string, options ...dbus.MatchOption) *dbus.Call {
+	panic("not implemented")
+}
+
+func (o *fakeObject) GetProperty(p string) (dbus.Variant, error) {
+	o.bus.mu.Lock()
+	defer o.bus.mu.Unlock()
+
+	item, ok := o.bus.items[o.path]
+	if !ok || p != ssItem+".Attributes" {
+		return dbus.Variant{}, errors.New("unknown property")
+	}
+
+	return dbus.MakeVariant(item.attrs), nil
+}
+
+func (o *fakeObject) SetProperty(p string, v interface{}) error { panic("not implemented") }
+func (o *fakeObject) Destination() string                       { return ssDest }
+func (o *fakeObject) Path() dbus.ObjectPath                     { return o.path }
+
+func TestSecretService(t *testing.T) {
+	bus := newFakeBus()
+	driver := newSecretService("com.example.test", bus)
+	other := newSecretService("com.example.other", bus)
+
+	if _, err := driver.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+
+	set := func(d Driver, k, v string) {
+		t.Helper()
+		if err := d.Set(k, []byte(v)); err != nil {
+			t.Fatalf("cannot set %q: %v", k, err)
+		}
+	}
+
+	expectGet := func(d Driver, k, v string) {
+		t.Helper()
+		b, err := d.Get(k)
+		if err != nil {
+			t.Fatalf("cannot get %q: %v", k, err)
+		}
+		if string(b) != v {
+			t.Fatalf("%q: expected %q, got %q", k, v, b)
+		}
+	}
+
+	expectKeys := func(d Driver, keys ...string) {
+		t.Helper()
+		got, err := d.Keys()
+		if err != nil {
+			t.Fatal("cannot list keys:", err)
+		}
+		if len(got) != len(keys) || (len(keys) > 0 && !reflect.DeepEqual(got, keys)) {
+			t.Fatalf("expected keys %q, got %q", keys, got)
+		}
+	}
+
+	set(driver, "b", "1")
+	set(driver, "a", "2")
+	set(driver, "b", "3") // replace
+	set(other, "a", "other")
+
+	expectGet(driver, "a", "2")
+	expectGet(driver, "b", "3")
+	expectGet(other, "a", "other")
+	expectKeys(driver, "a", "b")
+	expectKeys(other, "a")
+
+	// Items in other collections or with other attributes aren't visible.
+	expectKeys(driver.WithCollection("gotktrix"))
+	expectKeys(driver.WithAttributes(map[string]string{"profile": "work"}))
+
+	if err := driver.Delete("a"); err != nil {
+		t.Fatal("cannot delete:", err)
+	}
+	if err := driver.Delete("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound deleting twice, got", err)
+	}
+	expectKeys(driver, "b")
+	expectKeys(other, "a")
+
+	// Lock the collection. A new driver must prompt to unlock it.
+	bus.locked["/org/freedesktop/secrets/collection/login"] = true
+
+	driver = newSecretService("com.example.test", bus)
+	expectGet(driver, "b", "3")
+
+	if bus.prompts != 1 {
+		t.Fatalf("expected 1 prompt, got %d", bus.prompts)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	from := SaltedFileDriver(t.TempDir())
+	to := newSecretService("com.example.test", newFakeBus())
+
+	values := map[string]string{
+		"accounts":         `["@a:example.com"]`,
+		"account:@a:a.com": `{}`,
+	}
+
+	for k, v := range values {
+		if err := from.Set(k, []byte(v)); err != nil {
+			t.Fatal("cannot set:", err)
+		}
+	}
+
+	if err := Migrate(from, to); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	for k, v := range values {
+		b, err := to.Get(k)
+		if err != nil {
+			t.Fatalf("cannot get migrated %q: %v", k, err)
+		}
+		if string(b) != v {
+			t.Fatalf("migrated %q: expected %q, got %q", k, v, b)
+		}
+	}
+
+	keys, err := from.Keys()
+	if err != nil {
+		t.Fatal("cannot list old keys:", err)
+	}
+	if len(keys) > 0 {
+		t.Fatalf("old keys %q weren't deleted", keys)
+	}
+}
@@ -66,6 +66,26 @@ func openRoom(cmd msgnotify.OpenRoomCommand) {
 	manager.OpenRoom(cmd.RoomID)
 }
 
+// markRoomRead marks the room as read using the manager with the given user ID.
+func markRoomRead(cmd msgnotify.MessageCommand) {
+	manager, ok := managers[cmd.UserID]
+	if !ok {
+		log.Println("user ID", cmd.UserID, "not found")
+		return
+	}
+	msgnotify.MarkRead(manager.ctx, cmd)
+}
+
+// replyRoom shows the reply dialog using the manager with the given user ID.
+func replyRoom(cmd msgnotify.MessageCommand) {
+	manager, ok := managers[cmd.UserID]
+	if !ok {
+		log.Println("user ID", cmd.UserID, "not found")
+		return
+	}
+	msgnotify.Reply(manager.ctx, cmd)
+}
+
 func activate(ctx context.Context) {
 	a := app.FromContext(ctx)
 
@@ -97,7 +117,9 @@ func activate(ctx context.Context) {
 		})
 
 		a.AddActionCallbacks(map[string]gtkutil.ActionCallback{
-			"app.open-room": gtkutil.NewJSONActionCallback(openRoom),
+			"app.open-room":      gtkutil.NewJSONActionCallback(openRoom),
+			"app.mark-room-read": gtkutil.NewJSONActionCallback(markRoomRead),
+			"app.reply-room":     gtkutil.NewJSONActionCallback(replyRoom),
 		})
 	}
 
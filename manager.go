@@ -12,11 +12,14 @@ import (
 	"github.com/diamondburned/gotkit/components/title"
 	"github.com/diamondburned/gotkit/gtkutil"
 	"github.com/diamondburned/gotktrix/internal/app/blinker"
+	"github.com/diamondburned/gotktrix/internal/app/createroom"
 	"github.com/diamondburned/gotktrix/internal/app/emojiview"
 	"github.com/diamondburned/gotktrix/internal/app/messageview"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/msgnotify"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist/room"
+	"github.com/diamondburned/gotktrix/internal/app/roomsettings"
+	"github.com/diamondburned/gotktrix/internal/app/sessions"
 	"github.com/diamondburned/gotktrix/internal/app/userbutton"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/matrix"
@@ -116,6 +119,8 @@ func (m *manager) ready() {
 		return []gtkutil.PopoverMenuItem{
 			gtkutil.MenuSeparator(locale.S(m.ctx, "Me")),
 			gtkutil.MenuItem(locale.S(m.ctx, "Custom _Emojis"), "win.user-emojis"),
+			gtkutil.MenuItem(locale.S(m.ctx, "New _Room"), "win.create-room"),
+			gtkutil.MenuItem(locale.S(m.ctx, "_Sessions"), "win.sessions"),
 			gtkutil.MenuSeparator(""),
 			gtkutil.MenuItem(locale.S(m.ctx, "_Preferences"), "app.preferences"),
 			gtkutil.MenuItem(locale.S(m.ctx, "_About"), "app.about"),
@@ -141,12 +146,29 @@ func (m *manager) ready() {
 	m.header.rtext.SetXAlign(0)
 	m.header.rtext.SetHExpand(true)
 
+	members := gtk.NewToggleButton()
+	members.SetIconName("system-users-symbolic")
+	members.SetTooltipText(locale.S(m.ctx, "Members"))
+	members.SetVAlign(gtk.AlignCenter)
+	members.ConnectClicked(func() { m.msgView.SetMembersRevealed(members.Active()) })
+
+	settings := gtk.NewButtonFromIconName("emblem-system-symbolic")
+	settings.SetTooltipText(locale.S(m.ctx, "Room Settings"))
+	settings.SetVAlign(gtk.AlignCenter)
+	settings.ConnectClicked(func() {
+		if current := m.msgView.Current(); current != nil {
+			roomsettings.Show(m.ctx, current.RoomID())
+		}
+	})
+
 	m.header.right = gtk.NewBox(gtk.OrientationHorizontal, 0)
 	m.header.right.AddCSSClass("right-header")
 	m.header.right.AddCSSClass("titlebar")
 	m.header.right.Append(unfold)
 	m.header.right.Append(m.header.rtext)
 	m.header.right.Append(m.header.blinker)
+	m.header.right.Append(members)
+	m.header.right.Append(settings)
 	m.header.right.Append(gtk.NewWindowControls(gtk.PackEnd))
 
 	m.header.fold = adaptive.NewFold(gtk.PosLeft)
@@ -173,10 +195,16 @@ func (m *manager) ready() {
 
 	gtkutil.BindActionMap(w, map[string]func(){
 		"win.user-emojis": func() { emojiview.ForUser(m.ctx) },
+		"win.create-room": func() { createroom.Show(m.ctx, m.roomList.JoinedRoom) },
+		"win.sessions":    func() { sessions.Show(m.ctx) },
 	})
 
 	gtkutil.BindSubscribe(w, func() func() {
-		return msgnotify.StartNotify(m.ctx, "app.open-room")
+		return msgnotify.StartNotify(m.ctx, msgnotify.Actions{
+			OpenRoom: "app.open-room",
+			MarkRead: "app.mark-room-read",
+			Reply:    "app.reply-room",
+		})
 	})
 }
 